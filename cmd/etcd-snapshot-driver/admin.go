@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/util"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// adminOptions holds the flags shared by the admin subcommands
+type adminOptions struct {
+	kubeconfig        string
+	kubeContext       string
+	driverName        string
+	metadataNamespace string
+	output            string
+	// impersonate runs requests as another user, e.g. the driver's ServiceAccount
+	impersonate string
+
+	// k8sClient and dynamicClient override the clients built from the kubeconfig; used by tests
+	k8sClient     kubernetes.Interface
+	dynamicClient dynamic.Interface
+}
+
+func (o *adminOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to a kubeconfig file (empty uses $KUBECONFIG, ~/.kube/config or the in-cluster config)")
+	flags.StringVar(&o.kubeContext, "context", "", "Kubeconfig context to use (empty uses the current context)")
+	flags.StringVar(&o.driverName, "driver-name", config.DefaultDriverName, "CSI driver name of the instance whose snapshots to manage")
+	flags.StringVar(&o.metadataNamespace, "metadata-namespace", "kube-system", "Namespace holding the snapshot metadata ConfigMaps")
+	flags.StringVarP(&o.output, "output", "o", "table", "Output format (table, json, yaml)")
+}
+
+func (o *adminOptions) client() (kubernetes.Interface, error) {
+	if o.k8sClient != nil {
+		return o.k8sClient, nil
+	}
+
+	k8sConfig, err := loadKubeConfig(o.kubeconfig, o.kubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("loading Kubernetes client config: %w", err)
+	}
+	k8sConfig.Impersonate.UserName = o.impersonate
+	k8sClient, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	o.k8sClient = k8sClient
+	return k8sClient, nil
+}
+
+func (o *adminOptions) dynamic() (dynamic.Interface, error) {
+	if o.dynamicClient != nil {
+		return o.dynamicClient, nil
+	}
+
+	k8sConfig, err := loadKubeConfig(o.kubeconfig, o.kubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("loading Kubernetes client config: %w", err)
+	}
+	k8sConfig.Impersonate.UserName = o.impersonate
+	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes dynamic client: %w", err)
+	}
+
+	o.dynamicClient = dynamicClient
+	return dynamicClient, nil
+}
+
+func (o *adminOptions) manager() (*snapshot.Manager, error) {
+	k8sClient, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.NewManager(k8sClient, logger, o.metadataNamespace, config.NewNames(o.driverName)), nil
+}
+
+// print writes v as JSON or YAML, or calls table for table output
+func (o *adminOptions) print(w io.Writer, v interface{}, table func(*tabwriter.Writer)) error {
+	switch o.output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q (supported: table, json, yaml)", o.output)
+	}
+}
+
+// signatureOptions selects how the subcommands that restore or verify a snapshot check
+// its signature
+type signatureOptions struct {
+	secretName      string
+	secretNamespace string
+	strict          bool
+}
+
+func (o *signatureOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&o.secretName, "signing-key-secret-name", "etcd-snapshot-signing-key", "Secret holding the keys snapshot signatures are checked against")
+	flags.StringVar(&o.secretNamespace, "signing-key-secret-namespace", "etcd-snapshot-driver", "Namespace of the signing key Secret")
+	flags.BoolVar(&o.strict, "signing-strict", false, "Refuse snapshots that are unsigned or whose signature does not match, rather than only warning")
+}
+
+// policy returns the signature policy the flags select, checking signatures against the
+// keys in the signing key Secret
+func (o *signatureOptions) policy(k8sClient kubernetes.Interface) *snapshot.SignaturePolicy {
+	return &snapshot.SignaturePolicy{
+		Verifier: signing.NewSecretKeys(k8sClient, o.secretNamespace, o.secretName),
+		Strict:   o.strict,
+	}
+}
+
+// encryptionOptions select the key encryption keys the subcommands that decrypt a
+// snapshot's contents unwrap its data key with; they match the driver's own flags
+type encryptionOptions struct {
+	provider encryption.ProviderConfig
+}
+
+func (o *encryptionOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&o.provider.Provider, "encryption-key-provider", "secret", "Where the key encryption keys are kept (secret, file)")
+	flags.StringVar(&o.provider.SecretName, "encryption-key-secret-name", "etcd-snapshot-encryption-keys", "Secret holding the key encryption keys")
+	flags.StringVar(&o.provider.SecretNamespace, "encryption-key-secret-namespace", "etcd-snapshot-driver", "Namespace of the key encryption key Secret")
+	flags.StringVar(&o.provider.Dir, "encryption-key-dir", "/etc/etcd-snapshot/keys", "Directory holding the key encryption keys, one file per key ID")
+}
+
+// dataKey unwraps the data key of an encrypted snapshot; unencrypted snapshots have none
+func (o *encryptionOptions) dataKey(ctx context.Context, k8sClient kubernetes.Interface, s *snapshot.SnapshotMetadata) ([]byte, error) {
+	if s.Encryption == nil {
+		return nil, nil
+	}
+
+	provider, err := o.provider.New(k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, s.Encryption.KeyID, s.Encryption.WrappedDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping the data key of snapshot %s: %w", s.SnapshotID, err)
+	}
+	return dataKey, nil
+}
+
+// listFilter selects snapshots by cluster, namespace and age
+type listFilter struct {
+	cluster   string
+	namespace string
+	olderThan time.Duration
+	newerThan time.Duration
+}
+
+func (f *listFilter) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&f.cluster, "cluster", "", "Only show snapshots of this etcd cluster")
+	flags.StringVar(&f.namespace, "namespace", "", "Only show snapshots stored in this namespace")
+	flags.DurationVar(&f.olderThan, "older-than", 0, "Only show snapshots older than this age, e.g. 168h")
+	flags.DurationVar(&f.newerThan, "newer-than", 0, "Only show snapshots newer than this age, e.g. 24h")
+}
+
+func (f *listFilter) matches(cluster, namespace string, created, now time.Time) bool {
+	if f.cluster != "" && cluster != f.cluster {
+		return false
+	}
+	if f.namespace != "" && namespace != f.namespace {
+		return false
+	}
+
+	age := now.Sub(created)
+	if f.olderThan > 0 && age <= f.olderThan {
+		return false
+	}
+	if f.newerThan > 0 && age >= f.newerThan {
+		return false
+	}
+	return true
+}
+
+func humanAge(created, now time.Time) string {
+	if created.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(now.Sub(created))
+}
+
+// humanSize formats a byte count for tables; unknown sizes show as "-"
+func humanSize(bytes int64) string {
+	if bytes <= 0 {
+		return "-"
+	}
+	return util.HumanBytes(bytes)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestAdminOptions(t *testing.T) *adminOptions {
+	t.Helper()
+	logger = zap.NewNop().Sugar()
+
+	opts := &adminOptions{
+		driverName:        config.DefaultDriverName,
+		metadataNamespace: "kube-system",
+		output:            "table",
+		k8sClient:         fake.NewSimpleClientset(),
+	}
+	manager, err := opts.manager()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, s := range []*snapshot.SnapshotMetadata{
+		{SnapshotID: "snap-old", ClusterName: "etcd-a", Namespace: "ns-a", PVCName: "etcd-snapshots", CreationTime: now.Add(-48 * time.Hour), Size: 2048},
+		{SnapshotID: "snap-new", ClusterName: "etcd-a", Namespace: "ns-a", PVCName: "etcd-snapshots", CreationTime: now.Add(-time.Hour), Compression: snapshot.CodecGzip},
+		{SnapshotID: "snap-other", ClusterName: "etcd-b", Namespace: "ns-b", PVCName: "etcd-snapshots", CreationTime: now.Add(-time.Hour)},
+	} {
+		require.NoError(t, manager.StoreSnapshotMetadata(ctx, s))
+	}
+	require.NoError(t, manager.StoreGroupSnapshotMetadata(ctx, &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID:      "group-old",
+		SnapshotID:           "snap-old",
+		SourceVolumeIDs:      []string{"vol-1", "vol-2"},
+		ClusterName:          "etcd-a",
+		SnapshotPVCName:      "etcd-snapshots",
+		SnapshotPVCNamespace: "ns-a",
+		CreationTime:         now.Add(-48 * time.Hour),
+		ReadyToUse:           true,
+	}))
+
+	return opts
+}
+
+func executeAdmin(t *testing.T, cmd *cobra.Command, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSnapshotList(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: "all", expected: []string{"snap-new", "snap-old", "snap-other"}},
+		{name: "cluster", args: []string{"--cluster", "etcd-a"}, expected: []string{"snap-new", "snap-old"}},
+		{name: "namespace", args: []string{"--namespace", "ns-b"}, expected: []string{"snap-other"}},
+		{name: "older than", args: []string{"--older-than", "24h"}, expected: []string{"snap-old"}},
+		{name: "newer than", args: []string{"--cluster", "etcd-a", "--newer-than", "24h"}, expected: []string{"snap-new"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := newTestAdminOptions(t)
+			opts.output = "json"
+
+			out, err := executeAdmin(t, newSnapshotListCommand(opts), tt.args...)
+			require.NoError(t, err)
+
+			var listed []snapshot.SnapshotMetadata
+			require.NoError(t, json.Unmarshal([]byte(out), &listed))
+
+			ids := make([]string, 0, len(listed))
+			for _, s := range listed {
+				ids = append(ids, s.SnapshotID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
+
+func TestSnapshotListOutputFormats(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	out, err := executeAdmin(t, newSnapshotListCommand(opts), "--cluster", "etcd-a")
+	require.NoError(t, err)
+	assert.Contains(t, out, "SNAPSHOT ID")
+	assert.Contains(t, out, "snap-old")
+	assert.Contains(t, out, "2.0Ki")
+	assert.Contains(t, out, "gzip")
+	assert.NotContains(t, out, "snap-other")
+
+	opts.output = "yaml"
+	out, err = executeAdmin(t, newSnapshotListCommand(opts), "--namespace", "ns-b")
+	require.NoError(t, err)
+	var listed []snapshot.SnapshotMetadata
+	require.NoError(t, yaml.Unmarshal([]byte(out), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "snap-other", listed[0].SnapshotID)
+
+	opts.output = "xml"
+	_, err = executeAdmin(t, newSnapshotListCommand(opts))
+	assert.ErrorContains(t, err, "unsupported output format")
+}
+
+func TestSnapshotDescribe(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	out, err := executeAdmin(t, newSnapshotDescribeCommand(opts), "snap-new")
+	require.NoError(t, err)
+	assert.Contains(t, out, "snap-new.db.gz")
+	assert.Contains(t, out, "Restore Drill:")
+
+	_, err = executeAdmin(t, newSnapshotDescribeCommand(opts), "missing")
+	assert.Error(t, err)
+}
+
+func TestSnapshotDescribeBundle(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	require.NoError(t, manager.StoreSnapshotMetadata(context.Background(), &snapshot.SnapshotMetadata{
+		SnapshotID: "snap-bundle",
+		Namespace:  "ns-a",
+		PVCName:    "etcd-snapshots",
+		Bundle: &snapshot.BundleInfo{
+			Version: snapshot.BundleVersion,
+			Files: []snapshot.BundleFile{
+				{Name: "snapshot.db", Kind: snapshot.BundleFileDatabase, Size: 2048},
+				{Name: "secrets/etcd-ca/ca.crt", Kind: snapshot.BundleFileSecret, Size: 512},
+			},
+		},
+	}))
+
+	out, err := executeAdmin(t, newSnapshotDescribeCommand(opts), "snap-bundle")
+	require.NoError(t, err)
+	assert.Contains(t, out, "snap-bundle.bundle.tar")
+	assert.Contains(t, out, "version 1")
+	assert.Contains(t, out, "secrets/etcd-ca/ca.crt:")
+}
+
+func TestSnapshotDeleteDryRun(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	out, err := executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-old", "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Would delete metadata of snapshot snap-old")
+	assert.Contains(t, out, "Would delete metadata of group snapshot group-old")
+
+	// Nothing was deleted and no job was created
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	_, err = manager.RetrieveSnapshotMetadata(context.Background(), "snap-old")
+	assert.NoError(t, err)
+	_, err = manager.RetrieveGroupSnapshotMetadata(context.Background(), "group-old")
+	assert.NoError(t, err)
+
+	jobs, err := opts.k8sClient.BatchV1().Jobs("ns-a").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items)
+}
+
+func TestSnapshotDeleteRefusesReferenced(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:  "snap-shared",
+		ClusterName: "etcd-a",
+		Namespace:   "ns-a",
+		PVCName:     "etcd-snapshots",
+		References:  []string{"member-1"},
+	}))
+
+	_, err = executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-shared")
+	assert.ErrorContains(t, err, "still referenced by member snapshots member-1")
+	_, err = manager.RetrieveSnapshotMetadata(ctx, "snap-shared")
+	assert.NoError(t, err)
+
+	out, err := executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-shared", "--force", "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Would delete metadata of snapshot snap-shared")
+}
+
+func TestSnapshotHoldBlocksDelete(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	out, err := executeAdmin(t, newSnapshotHoldCommand(opts), "snap-old")
+	require.NoError(t, err)
+	assert.Contains(t, out, "under legal hold")
+
+	_, err = executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-old", "--dry-run")
+	assert.ErrorIs(t, err, snapshot.ErrSnapshotHeld)
+
+	out, err = executeAdmin(t, newSnapshotLockCommand(opts), "snap-old", "--until", "2099-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Contains(t, out, "under legal hold")
+
+	// Releasing the legal hold leaves the lock in force, and the lock cannot be shortened
+	out, err = executeAdmin(t, newSnapshotReleaseCommand(opts), "snap-old")
+	require.NoError(t, err)
+	assert.Contains(t, out, "locked until 2099-01-01T00:00:00Z")
+	_, err = executeAdmin(t, newSnapshotLockCommand(opts), "snap-old", "--for", "24h")
+	assert.ErrorContains(t, err, "cannot be shortened")
+	_, err = executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-old", "--dry-run")
+	assert.ErrorIs(t, err, snapshot.ErrSnapshotHeld)
+
+	out, err = executeAdmin(t, newSnapshotDescribeCommand(opts), "snap-old")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Locked Until:")
+	assert.NotContains(t, out, "Legal Hold:")
+}
+
+func TestSnapshotVerifyRejectsEncrypted(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	require.NoError(t, manager.StoreSnapshotMetadata(context.Background(), &snapshot.SnapshotMetadata{
+		SnapshotID: "snap-enc",
+		Namespace:  "ns-a",
+		PVCName:    "etcd-snapshots",
+		Encryption: &snapshot.EncryptionInfo{KeyID: "kek-1"},
+	}))
+
+	_, err = executeAdmin(t, newSnapshotVerifyCommand(opts), "snap-enc")
+	assert.ErrorContains(t, err, "encrypted")
+}
+
+func TestSnapshotExportCompanions(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	for _, s := range []*snapshot.SnapshotMetadata{
+		{
+			SnapshotID: "snap-bare",
+			Namespace:  "ns-a",
+			PVCName:    "etcd-snapshots",
+			Bundle: &snapshot.BundleInfo{
+				Version: snapshot.BundleVersion,
+				Files:   []snapshot.BundleFile{{Name: "snapshot.db", Kind: snapshot.BundleFileDatabase}},
+			},
+		},
+		{
+			SnapshotID: "snap-bundle",
+			Namespace:  "ns-a",
+			PVCName:    "etcd-snapshots",
+			Encryption: &snapshot.EncryptionInfo{KeyID: "kek-1"},
+			Bundle: &snapshot.BundleInfo{
+				Version: snapshot.BundleVersion,
+				Files: []snapshot.BundleFile{
+					{Name: "snapshot.db.enc", Kind: snapshot.BundleFileDatabase},
+					{Name: "secrets/etcd-ca/ca.crt.enc", Kind: snapshot.BundleFileSecret},
+				},
+			},
+		},
+	} {
+		require.NoError(t, manager.StoreSnapshotMetadata(context.Background(), s))
+	}
+
+	dir := t.TempDir()
+	_, err = executeAdmin(t, newSnapshotExportCompanionsCommand(opts), "snap-old", "--output-dir", dir)
+	assert.ErrorContains(t, err, "is not a bundle")
+
+	_, err = executeAdmin(t, newSnapshotExportCompanionsCommand(opts), "snap-bare", "--output-dir", dir)
+	assert.ErrorContains(t, err, "holds no companion Secrets")
+
+	// The data key is unwrapped before any job is started
+	_, err = executeAdmin(t, newSnapshotExportCompanionsCommand(opts), "snap-bundle", "--output-dir", dir)
+	assert.ErrorContains(t, err, "unwrapping the data key of snapshot snap-bundle")
+	jobs, err := opts.k8sClient.BatchV1().Jobs("ns-a").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items)
+}
+
+func TestSnapshotVerifyRejectsUnsignedInStrictMode(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	_, err := executeAdmin(t, newSnapshotVerifyCommand(opts), "snap-old", "--signing-strict")
+	assert.ErrorIs(t, err, snapshot.ErrSnapshotUnsigned)
+}
+
+func TestGroupListAndDescribe(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	out, err := executeAdmin(t, newGroupListCommand(opts), "--cluster", "etcd-a")
+	require.NoError(t, err)
+	assert.Contains(t, out, "group-old")
+
+	out, err = executeAdmin(t, newGroupListCommand(opts), "--cluster", "etcd-b")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "group-old")
+
+	opts.output = "json"
+	out, err = executeAdmin(t, newGroupDescribeCommand(opts), "group-old")
+	require.NoError(t, err)
+
+	var described struct {
+		GroupSnapshotID string                       `json:"group_snapshot_id"`
+		Snapshots       []*snapshot.SnapshotMetadata `json:"snapshots"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &described))
+	assert.Equal(t, "group-old", described.GroupSnapshotID)
+	require.Len(t, described.Snapshots, 1)
+	assert.Equal(t, "snap-old", described.Snapshots[0].SnapshotID)
+}
+
+func TestGroupListAndDescribeAcrossClusters(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	require.NoError(t, manager.StoreGroupSnapshotMetadata(context.Background(), &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID: "group-both",
+		SourceVolumeIDs: []string{"ns-a/vol-1", "ns-b/vol-2"},
+		SnapshotID:      "snap-new",
+		ClusterName:     "etcd-a",
+		Clusters: []snapshot.GroupClusterSnapshot{
+			{SnapshotID: "snap-new", ClusterName: "etcd-a", SourceVolumeIDs: []string{"ns-a/vol-1"}, SnapshotPVCName: "etcd-snapshots", SnapshotPVCNamespace: "ns-a"},
+			{SnapshotID: "snap-other", ClusterName: "etcd-b", SourceVolumeIDs: []string{"ns-b/vol-2"}, SnapshotPVCName: "etcd-snapshots", SnapshotPVCNamespace: "ns-b"},
+		},
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+	}))
+
+	// The group matches a filter on either of its clusters
+	out, err := executeAdmin(t, newGroupListCommand(opts), "--cluster", "etcd-b")
+	require.NoError(t, err)
+	assert.Contains(t, out, "group-both")
+	assert.Contains(t, out, "snap-new,snap-other")
+
+	out, err = executeAdmin(t, newGroupDescribeCommand(opts), "group-both")
+	require.NoError(t, err)
+	assert.Contains(t, out, "etcd-a")
+	assert.Contains(t, out, "ns-b/etcd-snapshots")
+
+	// Deleting either snapshot drops the group that references it
+	out, err = executeAdmin(t, newSnapshotDeleteCommand(opts), "snap-other", "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Would delete metadata of group snapshot group-both")
+}
+
+// createTestStatefulSet creates a one-member etcd StatefulSet of cluster etcd-a in ns-a
+func createTestStatefulSet(t *testing.T, opts *adminOptions) {
+	t.Helper()
+	ctx := context.Background()
+
+	replicas := int32(1)
+	_, err := opts.k8sClient.AppsV1().StatefulSets("ns-a").Create(ctx, &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "ns-a"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             &replicas,
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = opts.k8sClient.CoreV1().PersistentVolumeClaims("ns-a").Create(ctx, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "data-etcd-0", Namespace: "ns-a", Labels: map[string]string{"etcd.io/cluster": "etcd-a"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = opts.k8sClient.CoreV1().Pods("ns-a").Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "etcd-0", Namespace: "ns-a", Labels: map[string]string{"etcd.io/cluster": "etcd-a"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestSnapshotRestoreDryRun(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	ctx := context.Background()
+	createTestStatefulSet(t, opts)
+
+	out, err := executeAdmin(t, newSnapshotRestoreCommand(opts), "snap-old", "--statefulset", "etcd", "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "data-etcd-0")
+	assert.Contains(t, out, "data.pre-restore-snap-old")
+
+	// Nothing was scaled down
+	sts, err := opts.k8sClient.AppsV1().StatefulSets("ns-a").Get(ctx, "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), *sts.Spec.Replicas)
+}
+
+func TestMemberReplaceValidatesArgs(t *testing.T) {
+	opts := newTestAdminOptions(t)
+
+	_, err := executeAdmin(t, newMemberReplaceCommand(opts), "etcd", "1")
+	assert.ErrorContains(t, err, "--namespace is required")
+
+	_, err = executeAdmin(t, newMemberReplaceCommand(opts), "etcd", "one", "-n", "ns-a")
+	assert.ErrorContains(t, err, `invalid ordinal "one"`)
+
+	_, err = executeAdmin(t, newMemberReplaceCommand(opts), "missing", "0", "-n", "ns-a")
+	assert.ErrorContains(t, err, "failed to get StatefulSet ns-a/missing")
+}
+
+func TestStorageMigrateDryRun(t *testing.T) {
+	opts := newTestAdminOptions(t)
+	ctx := context.Background()
+	createTestStatefulSet(t, opts)
+	_, err := opts.k8sClient.StorageV1().StorageClasses().Create(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = executeAdmin(t, newStorageMigrateCommand(opts), "etcd", "--snapshot-id", "snap-new", "--storage-class", "fast", "--size", "20Gi", "--dry-run")
+	assert.ErrorContains(t, err, "has not passed a restore drill")
+
+	manager, err := opts.manager()
+	require.NoError(t, err)
+	s, err := manager.RetrieveSnapshotMetadata(ctx, "snap-new")
+	require.NoError(t, err)
+	s.RestoreDrill = &snapshot.RestoreDrillStatus{Passed: true}
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, s))
+
+	out, err := executeAdmin(t, newStorageMigrateCommand(opts), "etcd", "--snapshot-id", "snap-new", "--storage-class", "fast", "--size", "20Gi", "--max-snapshot-age", "2h", "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "data -> data-fast")
+	assert.Contains(t, out, "data-fast-etcd-0")
+
+	// Nothing was created
+	_, err = opts.k8sClient.CoreV1().PersistentVolumeClaims("ns-a").Get(ctx, "data-fast-etcd-0", metav1.GetOptions{})
+	assert.Error(t, err)
+}
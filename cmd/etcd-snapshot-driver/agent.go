@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newAgentCommand groups the helpers the driver runs inside its own Job pods.
+// They are not meant to be invoked by hand.
+func newAgentCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "agent",
+		Short:  "Helpers run inside snapshot Job pods",
+		Hidden: true,
+	}
+	cmd.AddCommand(newAgentBundleCompanionsCommand())
+	cmd.AddCommand(newAgentDownloadCommand())
+	cmd.AddCommand(newAgentDrillCommand())
+	cmd.AddCommand(newAgentEncodeCommand())
+	cmd.AddCommand(newAgentRestoreMemberCommand())
+	cmd.AddCommand(newAgentRollbackMemberCommand())
+	cmd.AddCommand(newAgentSeedMemberCommand())
+	cmd.AddCommand(newAgentUploadCommand())
+
+	return cmd
+}
+
+func newAgentDrillCommand() *cobra.Command {
+	var (
+		snapshotPath string
+		codecName    string
+		keys         dataKeyFlags
+		checksum     string
+		bundle       bool
+		scratchDir   string
+		spec         etcd.DrillSpec
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drill",
+		Short: "Restore a snapshot into a throwaway etcd member and check its keyspace",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := snapshot.ParseCodec(codecName)
+			if err != nil {
+				return err
+			}
+
+			// The file must match its recorded checksum, which the snapshot's signature covers
+			if err := snapshot.VerifyChecksum(snapshotPath, checksum); err != nil {
+				return err
+			}
+
+			format := snapshot.Format{Codec: codec, Encrypted: keys.encrypted(), Bundle: bundle}
+			dataKey, err := keys.dataKey(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			// Encoded snapshots are decoded into the scratch dir before restoring
+			if !format.IsRaw() {
+				rawPath := filepath.Join(scratchDir, "snapshot.db")
+				if err := snapshot.ReadArtifact(snapshotPath, rawPath, format, dataKey); err != nil {
+					return err
+				}
+				defer os.Remove(rawPath)
+				snapshotPath = rawPath
+			}
+
+			drill := etcd.NewRestoreDrill(logger, scratchDir)
+
+			result, err := drill.Run(cmd.Context(), snapshotPath, spec)
+			if err != nil {
+				return fmt.Errorf("running restore drill: %w", err)
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&snapshotPath, "snapshot", "", "Path to the snapshot file to restore")
+	flags.StringVar(&codecName, "codec", "none", "Compression of the snapshot file (none, gzip, zstd)")
+	keys.addFlags(cmd)
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the snapshot file must match (empty skips the check)")
+	flags.BoolVar(&bundle, "bundle", false, "The snapshot file is a bundle; its database is unpacked and every entry checked")
+	flags.StringVar(&scratchDir, "scratch-dir", os.TempDir(), "Directory for the temporary data dir")
+	flags.Int64Var(&spec.MinKeys, "min-keys", 1, "Minimum number of keys the restored keyspace must hold")
+	flags.StringSliceVar(&spec.ExpectedPrefixes, "expect-prefix", nil, "Key prefix that must be present (repeatable)")
+	flags.DurationVar(&spec.Timeout, "timeout", time.Minute, "Time allowed for the restored member to become ready")
+	_ = cmd.MarkFlagRequired("snapshot")
+
+	return cmd
+}
+
+func newAgentBundleCompanionsCommand() *cobra.Command {
+	var (
+		bundlePath string
+		checksum   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bundle-companions",
+		Short: "Print the companion Secrets of a bundle, still encrypted, for the admin CLI to decrypt",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The file must match its recorded checksum, which the snapshot's signature covers
+			if err := snapshot.VerifyChecksum(bundlePath, checksum); err != nil {
+				return err
+			}
+
+			companions, err := snapshot.ReadBundleCompanions(bundlePath)
+			if err != nil {
+				return err
+			}
+			// The entries end up in the pod logs, so only ciphertext is printed
+			if !companions.Manifest.Encrypted && len(companions.Entries) > 0 {
+				return fmt.Errorf("bundle %s is not encrypted; refusing to print its companion Secrets", companions.Manifest.SnapshotID)
+			}
+
+			// The result is the final line of output so the CLI can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(companions)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&bundlePath, "bundle", "", "Path to the bundle")
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the bundle must match (empty skips the check)")
+	_ = cmd.MarkFlagRequired("bundle")
+
+	return cmd
+}
+
+func newAgentEncodeCommand() *cobra.Command {
+	var (
+		input     string
+		output    string
+		codecName string
+		keys      dataKeyFlags
+		bundle    bool
+		source    snapshot.BundleSource
+	)
+
+	cmd := &cobra.Command{
+		Use:   "encode",
+		Short: "Compress and encrypt a raw snapshot, or pack it into a bundle, onto the snapshot volume",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := snapshot.ParseCodec(codecName)
+			if err != nil {
+				return err
+			}
+
+			dataKey, err := keys.dataKey(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			format := snapshot.Format{Codec: codec, Encrypted: keys.encrypted(), Bundle: bundle}
+			var info *snapshot.ArtifactInfo
+			if format.Bundle {
+				info, err = snapshot.WriteBundle(input, output, format, dataKey, source)
+			} else {
+				info, err = snapshot.WriteArtifact(input, output, format, dataKey)
+			}
+			if err != nil {
+				return err
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(info)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&input, "input", "", "Path to the raw snapshot file")
+	flags.StringVar(&output, "output", "", "Path to write the encoded snapshot to")
+	flags.StringVar(&codecName, "codec", "none", "Compression codec (none, gzip, zstd)")
+	keys.addFlags(cmd)
+	flags.BoolVar(&bundle, "bundle", false, "Write a bundle of the snapshot, its manifest and the companion Secrets")
+	flags.StringVar(&source.SnapshotID, "snapshot-id", "", "ID of the snapshot, recorded in the bundle's manifest")
+	flags.StringVar(&source.ClusterName, "cluster-name", "", "Cluster the snapshot was taken of, recorded in the bundle's manifest")
+	flags.StringVar(&source.MemberID, "member-id", "", "Member the snapshot was streamed from, recorded in the bundle's manifest")
+	flags.Int64Var(&source.Revision, "revision", 0, "Revision of the member, recorded in the bundle's manifest")
+	flags.StringVar(&source.CompanionsDir, "companions-dir", "", "Directory holding the companion Secrets to bundle, one directory each")
+	_ = cmd.MarkFlagRequired("input")
+	_ = cmd.MarkFlagRequired("output")
+
+	return cmd
+}
+
+func newAgentRestoreMemberCommand() *cobra.Command {
+	var (
+		snapshotPath string
+		codecName    string
+		keys         dataKeyFlags
+		checksum     string
+		bundle       bool
+		scratchDir   string
+		peerURLs     string
+		spec         etcd.MemberRestoreSpec
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore-member",
+		Short: "Restore a snapshot into an etcd member's data dir, keeping the old one for rollback",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := snapshot.ParseCodec(codecName)
+			if err != nil {
+				return err
+			}
+
+			// Only a file matching its recorded checksum is restored
+			if err := snapshot.VerifyChecksum(snapshotPath, checksum); err != nil {
+				return err
+			}
+
+			format := snapshot.Format{Codec: codec, Encrypted: keys.encrypted(), Bundle: bundle}
+			dataKey, err := keys.dataKey(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			// Encoded snapshots are decoded into the scratch dir before restoring
+			if !format.IsRaw() {
+				rawPath := filepath.Join(scratchDir, "snapshot.db")
+				if err := snapshot.ReadArtifact(snapshotPath, rawPath, format, dataKey); err != nil {
+					return err
+				}
+				defer os.Remove(rawPath)
+				snapshotPath = rawPath
+			}
+
+			spec.PeerURLs = strings.Split(peerURLs, ",")
+			result, err := etcd.RestoreMember(logger, snapshotPath, spec)
+			if err != nil {
+				return err
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&snapshotPath, "snapshot", "", "Path to the snapshot file to restore")
+	flags.StringVar(&codecName, "codec", "none", "Compression of the snapshot file (none, gzip, zstd)")
+	keys.addFlags(cmd)
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the snapshot file must match (empty skips the check)")
+	flags.BoolVar(&bundle, "bundle", false, "The snapshot file is a bundle; its database is unpacked and every entry checked")
+	flags.StringVar(&scratchDir, "scratch-dir", os.TempDir(), "Directory for the decoded snapshot")
+	flags.StringVar(&spec.Name, "name", "", "Name of the etcd member")
+	flags.StringVar(&spec.InitialCluster, "initial-cluster", "", "Every member of the restored cluster as name=peerURL,...")
+	flags.StringVar(&peerURLs, "initial-advertise-peer-urls", "", "Peer URLs of the member, comma separated")
+	flags.StringVar(&spec.InitialClusterToken, "initial-cluster-token", "", "Token shared by every member of the restored cluster")
+	flags.StringVar(&spec.DataDir, "data-dir", "", "The member's data dir")
+	flags.StringVar(&spec.BackupDir, "backup-dir", "", "Where to keep the member's previous data dir")
+	flags.StringVar(&spec.RestoreID, "restore-id", "", "ID shared by every attempt at the same restore")
+	for _, name := range []string{"snapshot", "name", "initial-cluster", "initial-advertise-peer-urls", "initial-cluster-token", "data-dir", "backup-dir", "restore-id"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+
+	return cmd
+}
+
+func newAgentSeedMemberCommand() *cobra.Command {
+	var (
+		snapshotPath string
+		codecName    string
+		keys         dataKeyFlags
+		checksum     string
+		bundle       bool
+		scratchDir   string
+		dataDir      string
+		backupDir    string
+		restoreID    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "seed-member",
+		Short: "Seed a replacement etcd member's data dir with a snapshot's backend, keeping the old one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := snapshot.ParseCodec(codecName)
+			if err != nil {
+				return err
+			}
+
+			// Only a file matching its recorded checksum is seeded from
+			if err := snapshot.VerifyChecksum(snapshotPath, checksum); err != nil {
+				return err
+			}
+
+			format := snapshot.Format{Codec: codec, Encrypted: keys.encrypted(), Bundle: bundle}
+			dataKey, err := keys.dataKey(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			// Encoded snapshots are decoded into the scratch dir before seeding
+			if !format.IsRaw() {
+				rawPath := filepath.Join(scratchDir, "snapshot.db")
+				if err := snapshot.ReadArtifact(snapshotPath, rawPath, format, dataKey); err != nil {
+					return err
+				}
+				defer os.Remove(rawPath)
+				snapshotPath = rawPath
+			}
+
+			result, err := etcd.SeedMember(logger, snapshotPath, dataDir, backupDir, restoreID)
+			if err != nil {
+				return err
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&snapshotPath, "snapshot", "", "Path to the snapshot file to seed from")
+	flags.StringVar(&codecName, "codec", "none", "Compression of the snapshot file (none, gzip, zstd)")
+	keys.addFlags(cmd)
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the snapshot file must match (empty skips the check)")
+	flags.BoolVar(&bundle, "bundle", false, "The snapshot file is a bundle; its database is unpacked and every entry checked")
+	flags.StringVar(&scratchDir, "scratch-dir", os.TempDir(), "Directory for the decoded snapshot")
+	flags.StringVar(&dataDir, "data-dir", "", "The member's data dir")
+	flags.StringVar(&backupDir, "backup-dir", "", "Where to keep the member's previous data dir")
+	flags.StringVar(&restoreID, "restore-id", "", "ID shared by every attempt at the same replacement")
+	for _, name := range []string{"snapshot", "data-dir", "backup-dir", "restore-id"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+
+	return cmd
+}
+
+func newAgentRollbackMemberCommand() *cobra.Command {
+	var dataDir, backupDir, restoreID string
+
+	cmd := &cobra.Command{
+		Use:   "rollback-member",
+		Short: "Put back the data dir an interrupted member restore moved aside",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := etcd.RollbackMember(logger, dataDir, backupDir, restoreID)
+			if err != nil {
+				return err
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&dataDir, "data-dir", "", "The member's data dir")
+	flags.StringVar(&backupDir, "backup-dir", "", "Where the member restore kept the previous data dir")
+	flags.StringVar(&restoreID, "restore-id", "", "ID of the restore to roll back")
+	_ = cmd.MarkFlagRequired("data-dir")
+	_ = cmd.MarkFlagRequired("backup-dir")
+	_ = cmd.MarkFlagRequired("restore-id")
+
+	return cmd
+}
+
+func newAgentUploadCommand() *cobra.Command {
+	var (
+		file      string
+		checksum  string
+		signature string
+		store     objectStoreFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "upload",
+		Short: "Copy a snapshot file to the object store and verify the copy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := store.client()
+			if err != nil {
+				return err
+			}
+
+			result, err := client.Upload(cmd.Context(), store.key, file, checksum)
+			if err != nil {
+				return err
+			}
+			if signature != "" {
+				if err := client.Put(cmd.Context(), store.key+snapshot.SignatureSuffix, []byte(signature)); err != nil {
+					return err
+				}
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&file, "file", "", "Path to the snapshot file to upload")
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the file must match (empty skips the check)")
+	flags.StringVar(&signature, "signature", "", "Signature file of the snapshot, stored next to the object")
+	store.addFlags(cmd)
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func newAgentDownloadCommand() *cobra.Command {
+	var (
+		output   string
+		checksum string
+		store    objectStoreFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "download",
+		Short: "Fetch a snapshot file from the object store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := store.client()
+			if err != nil {
+				return err
+			}
+
+			result, err := client.Download(cmd.Context(), store.key, output, checksum)
+			if err != nil {
+				return err
+			}
+
+			// The result is the final line of output so the driver can read it from the pod logs
+			return json.NewEncoder(os.Stdout).Encode(result)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&output, "output", "", "Path to write the snapshot file to")
+	flags.StringVar(&checksum, "checksum", "", "Recorded SHA-256 checksum the object must match (empty skips the check)")
+	store.addFlags(cmd)
+	_ = cmd.MarkFlagRequired("output")
+
+	return cmd
+}
+
+// objectStoreFlags select an object of the object store; the credentials are read
+// from the environment so they do not show up in the pod spec's command
+type objectStoreFlags struct {
+	cfg objectstore.Config
+	key string
+}
+
+func (f *objectStoreFlags) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&f.cfg.Endpoint, "endpoint", "", "Object store endpoint (host[:port])")
+	flags.StringVar(&f.cfg.Region, "region", "", "Object store region")
+	flags.StringVar(&f.cfg.Bucket, "bucket", "", "Bucket holding the snapshot")
+	flags.StringVar(&f.key, "key", "", "Object key of the snapshot")
+	flags.BoolVar(&f.cfg.Insecure, "insecure", false, "Reach the endpoint over plain HTTP")
+	_ = cmd.MarkFlagRequired("endpoint")
+	_ = cmd.MarkFlagRequired("bucket")
+	_ = cmd.MarkFlagRequired("key")
+}
+
+func (f *objectStoreFlags) client() (*objectstore.Client, error) {
+	f.cfg.AccessKeyID = os.Getenv(objectstore.AccessKeyIDEnv)
+	f.cfg.SecretAccessKey = os.Getenv(objectstore.SecretAccessKeyEnv)
+	return objectstore.New(f.cfg)
+}
+
+// dataKeyFlags carry the wrapped data key of an encrypted snapshot and the key provider
+// to unwrap it with; the plaintext data key only ever exists in the agent's memory
+type dataKeyFlags struct {
+	keyID    string
+	wrapped  []byte
+	provider encryption.ProviderConfig
+}
+
+func (f *dataKeyFlags) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&f.keyID, "key-id", "", "ID of the key encryption key the data key is wrapped with; the snapshot is encrypted when set")
+	flags.BytesBase64Var(&f.wrapped, "wrapped-data-key", nil, "Wrapped data key of the snapshot, base64 encoded")
+	flags.StringVar(&f.provider.Provider, "key-provider", "secret", "Key provider holding the key encryption key (secret, file)")
+	flags.StringVar(&f.provider.SecretNamespace, "key-secret-namespace", "", "Namespace of the key encryption key Secret")
+	flags.StringVar(&f.provider.SecretName, "key-secret-name", "", "Secret holding the key encryption keys")
+	flags.StringVar(&f.provider.Dir, "key-dir", "", "Directory holding the key encryption keys")
+}
+
+func (f *dataKeyFlags) encrypted() bool {
+	return f.keyID != ""
+}
+
+func (f *dataKeyFlags) dataKey(ctx context.Context) ([]byte, error) {
+	if !f.encrypted() {
+		return nil, nil
+	}
+
+	var k8sClient kubernetes.Interface
+	if f.provider.Provider == "secret" {
+		k8sConfig, err := loadKubeConfig("", "")
+		if err != nil {
+			return nil, fmt.Errorf("loading Kubernetes client config: %w", err)
+		}
+		if k8sClient, err = kubernetes.NewForConfig(k8sConfig); err != nil {
+			return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+		}
+	}
+	provider, err := f.provider.New(k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, f.keyID, f.wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/doctor"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func newDoctorCommand() *cobra.Command {
+	var (
+		opts      adminOptions
+		namespace string
+		cfg       doctor.Config
+	)
+
+	cmd := &cobra.Command{
+		Use:   "doctor [PVC_NAME]",
+		Short: "Check whether a namespace or etcd PVC is ready for snapshots",
+		Long: `Check whether a namespace or etcd PVC is ready for snapshots.
+
+Given a PVC, doctor dry-runs the cluster discovery and health validation the
+driver performs for it. Given only a namespace, it does so for one labeled PVC
+of every etcd cluster in the namespace. It also checks the VolumeGroupSnapshot
+CRDs, RBAC, the snapshot job ServiceAccount, the etcd TLS Secret and the
+snapshot PVC's storage class.
+
+RBAC is checked for the caller; use --as to check the driver's ServiceAccount.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if namespace == "" {
+				return errors.New("--namespace is required")
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			cfg.DriverName = opts.driverName
+			cfg.MetadataNamespace = opts.metadataNamespace
+
+			// Discovery logs would interleave with the report
+			d := doctor.NewDoctor(k8sClient, zap.NewNop().Sugar(), cfg)
+
+			var results []doctor.Result
+			if len(args) == 1 {
+				results = d.CheckPVC(cmd.Context(), namespace, args[0])
+			} else {
+				results = d.CheckNamespace(cmd.Context(), namespace)
+			}
+
+			if err := opts.print(cmd.OutOrStdout(), results, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "STATUS\tCHECK\tMESSAGE")
+				for _, r := range results {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", r.Status, r.Check, r.Message)
+					if r.Status != doctor.StatusPass && r.Hint != "" {
+						fmt.Fprintf(w, "\t\thint: %s\n", r.Hint)
+					}
+				}
+			}); err != nil {
+				return err
+			}
+
+			if doctor.Failed(results) {
+				return errors.New("one or more checks failed")
+			}
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+
+	flags := cmd.Flags()
+	flags.StringVarP(&namespace, "namespace", "n", "", "Namespace of the etcd cluster to check")
+	flags.StringVar(&opts.impersonate, "as", "", "Check RBAC as this user, e.g. system:serviceaccount:etcd-snapshot-driver:etcd-snapshot-driver")
+	flags.StringVar(&cfg.ClusterLabelKey, "cluster-label-key", "etcd.io/cluster", "Label key used to identify ETCD cluster membership")
+	flags.StringVar(&cfg.StorageClass, "default-storage-class", "standard", "Storage class the driver creates snapshot PVCs with (empty uses the cluster default)")
+	flags.BoolVar(&cfg.TLSEnabled, "etcd-tls-enabled", true, "Whether the driver uses TLS for ETCD")
+	flags.StringVar(&cfg.TLSSecretName, "etcd-tls-secret-name", "etcd-client-tls", "Kubernetes secret name containing ETCD TLS certificates")
+	flags.BoolVar(&cfg.SkipHealth, "skip-health", false, "Skip the ETCD health check, e.g. when etcd is unreachable from where doctor runs")
+
+	return cmd
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func newGroupCommand() *cobra.Command {
+	var opts adminOptions
+
+	cmd := &cobra.Command{
+		Use:   "group",
+		Short: "Inspect stored group snapshots",
+	}
+	opts.addFlags(cmd)
+
+	cmd.AddCommand(newGroupListCommand(&opts))
+	cmd.AddCommand(newGroupDescribeCommand(&opts))
+
+	return cmd
+}
+
+func newGroupListCommand(opts *adminOptions) *cobra.Command {
+	var filter listFilter
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored group snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			groups, err := manager.ListGroupSnapshotMetadata(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			selected := make([]*snapshot.GroupSnapshotMetadata, 0, len(groups))
+			for _, g := range groups {
+				// Groups spanning several clusters match when any of their clusters does
+				for _, s := range g.ClusterSnapshots() {
+					if filter.matches(s.ClusterName, s.SnapshotPVCNamespace, g.CreationTime, now) {
+						selected = append(selected, g)
+						break
+					}
+				}
+			}
+
+			return opts.print(cmd.OutOrStdout(), selected, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "GROUP SNAPSHOT ID\tSNAPSHOT ID\tCLUSTER\tNAMESPACE\tVOLUMES\tREADY\tAGE")
+				for _, g := range selected {
+					var snapshotIDs, clusters, namespaces []string
+					for _, s := range g.ClusterSnapshots() {
+						snapshotIDs = append(snapshotIDs, s.SnapshotID)
+						clusters = append(clusters, s.ClusterName)
+						if !slices.Contains(namespaces, s.SnapshotPVCNamespace) {
+							namespaces = append(namespaces, s.SnapshotPVCNamespace)
+						}
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\t%s\n",
+						g.GroupSnapshotID,
+						strings.Join(snapshotIDs, ","),
+						strings.Join(clusters, ","),
+						strings.Join(namespaces, ","),
+						len(g.SourceVolumeIDs),
+						g.ReadyToUse,
+						humanAge(g.CreationTime, now),
+					)
+				}
+			})
+		},
+	}
+	filter.addFlags(cmd)
+
+	return cmd
+}
+
+// groupDescription is the structured output of group describe
+type groupDescription struct {
+	*snapshot.GroupSnapshotMetadata `json:",inline"`
+	Snapshots                       []*snapshot.SnapshotMetadata `json:"snapshots,omitempty"`
+}
+
+func newGroupDescribeCommand(opts *adminOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe GROUP_SNAPSHOT_ID",
+		Short: "Show the details of a stored group snapshot and the snapshot of each of its clusters",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			g, err := manager.RetrieveGroupSnapshotMetadata(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			// Missing snapshots are reported rather than treated as an error
+			clusters := g.ClusterSnapshots()
+			snapshots := make([]*snapshot.SnapshotMetadata, len(clusters))
+			description := groupDescription{GroupSnapshotMetadata: g}
+			for i, c := range clusters {
+				if s, err := manager.RetrieveSnapshotMetadata(ctx, c.SnapshotID); err == nil {
+					snapshots[i] = s
+					description.Snapshots = append(description.Snapshots, s)
+				}
+			}
+
+			return opts.print(cmd.OutOrStdout(), description, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Group Snapshot ID:\t%s\n", g.GroupSnapshotID)
+				fmt.Fprintf(w, "Source Volumes:\t%s\n", strings.Join(g.SourceVolumeIDs, ", "))
+				if g.ContentName != "" {
+					fmt.Fprintf(w, "Content:\t%s\n", g.ContentName)
+				}
+				fmt.Fprintf(w, "Created:\t%s (%s ago)\n", g.CreationTime.Format(time.RFC3339), humanAge(g.CreationTime, time.Now()))
+				fmt.Fprintf(w, "Ready To Use:\t%t\n", g.ReadyToUse)
+				for i, c := range clusters {
+					fmt.Fprintln(w)
+					fmt.Fprintf(w, "Cluster:\t%s\n", c.ClusterName)
+					fmt.Fprintf(w, "Source Volumes:\t%s\n", strings.Join(c.SourceVolumeIDs, ", "))
+					// Snapshots kept in VolumeSnapshots are on no snapshot PVC
+					if c.SnapshotPVCName != "" {
+						fmt.Fprintf(w, "Snapshot PVC:\t%s/%s\n", c.SnapshotPVCNamespace, c.SnapshotPVCName)
+					}
+					if snapshots[i] == nil {
+						fmt.Fprintf(w, "Snapshot:\t%s (metadata missing)\n", c.SnapshotID)
+						continue
+					}
+					describeSnapshot(w, snapshots[i])
+				}
+			})
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// runAsLeader runs fn once this instance holds the leader-election Lease of its driver
+// name. Every replica serves CSI requests; only background work such as data key
+// rotation is restricted to the leader. It returns when ctx is cancelled.
+func runAsLeader(ctx context.Context, k8sClient kubernetes.Interface, names config.Names, namespace string, fn func(context.Context)) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to determine leader election identity: %w", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      names.LeaderElectionLock(),
+			Namespace: namespace,
+		},
+		Client: k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            names.DriverName(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Infow("Acquired leadership", "lock", lock.LeaseMeta.Name, "identity", identity)
+				fn(ctx)
+			},
+			OnStoppedLeading: func() {
+				logger.Infow("Lost leadership", "lock", lock.LeaseMeta.Name, "identity", identity)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logger.Infow("Following leader", "lock", lock.LeaseMeta.Name, "leader", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up leader election: %w", err)
+	}
+
+	elector.Run(ctx)
+	return nil
+}
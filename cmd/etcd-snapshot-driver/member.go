@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/restore"
+	"github.com/spf13/cobra"
+)
+
+func newMemberCommand() *cobra.Command {
+	var opts adminOptions
+
+	cmd := &cobra.Command{
+		Use:   "member",
+		Short: "Manage the members of an etcd StatefulSet",
+	}
+	opts.addFlags(cmd)
+
+	cmd.AddCommand(newMemberReplaceCommand(&opts))
+
+	return cmd
+}
+
+func newMemberReplaceCommand(opts *adminOptions) *cobra.Command {
+	var (
+		namespace  string
+		snapshotID string
+		dryRun     bool
+		certFile   string
+		keyFile    string
+		caFile     string
+		cfg        restore.Config
+		signatures signatureOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replace STATEFULSET ORDINAL -n NAMESPACE",
+		Short: "Replace a failed etcd member, seeding it from the latest snapshot",
+		Long: `Replace a failed etcd member, seeding it from the latest snapshot.
+
+The member is removed from the cluster, a Job replaces its data dir with the
+snapshot's backend, and the member is added back as a learner and its pod
+restarted. The learner only receives what changed since the snapshot, and is
+promoted to a voting member once it has caught up. The member's previous data
+dir is kept next to the seeded one.
+
+The replacement is refused when the remaining members could not keep quorum
+without it; restore the whole StatefulSet with "snapshot restore" instead. The
+etcd endpoints must be reachable, so run it from inside the cluster.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if namespace == "" {
+				return errors.New("--namespace is required")
+			}
+			ordinal, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid ordinal %q: %w", args[1], err)
+			}
+
+			if certFile != "" || keyFile != "" || caFile != "" {
+				var tlsConfig *tls.Config
+				if tlsConfig, err = etcd.LoadTLSConfig(certFile, keyFile, caFile); err != nil {
+					return err
+				}
+				cfg.TLSConfig = tlsConfig
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			cfg.DriverName = opts.driverName
+			cfg.Signatures = signatures.policy(k8sClient)
+			orchestrator := restore.NewOrchestrator(k8sClient, logger, manager, cfg)
+
+			plan, err := orchestrator.PlanReplace(cmd.Context(), namespace, args[0], ordinal, snapshotID)
+			if err != nil {
+				return err
+			}
+
+			if err := opts.print(cmd.OutOrStdout(), plan, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Snapshot:\t%s\n", plan.SnapshotID)
+				fmt.Fprintf(w, "StatefulSet:\t%s/%s\n", plan.Namespace, plan.StatefulSet)
+				fmt.Fprintf(w, "Member:\t%s (pod %s, PVC %s)\n", plan.Member.Name, plan.Member.PodName, plan.Member.PVCName)
+				memberID := plan.MemberID
+				if memberID == "" {
+					memberID = "<not a member>"
+				}
+				fmt.Fprintf(w, "Member ID:\t%s\n", memberID)
+				fmt.Fprintf(w, "Peer URLs:\t%s\n", strings.Join(plan.Member.PeerURLs, ","))
+				fmt.Fprintf(w, "Healthy Voting Members:\t%d of %d\n", plan.HealthyVotingMembers, plan.VotingMembers)
+				fmt.Fprintf(w, "Data Dir:\t%s (previous kept in %s)\n", plan.DataDir, plan.BackupDir)
+				for _, warning := range plan.Warnings {
+					fmt.Fprintf(w, "Warning:\t%s\n", warning)
+				}
+			}); err != nil {
+				return err
+			}
+
+			if dryRun {
+				return nil
+			}
+
+			if err := orchestrator.Replace(cmd.Context(), plan); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Replaced member %s from snapshot %s\n", plan.Member.Name, plan.SnapshotID)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&namespace, "namespace", "n", "", "Namespace of the etcd StatefulSet")
+	flags.StringVar(&snapshotID, "snapshot-id", "", "Snapshot to seed the member from (empty picks the newest usable one)")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the replacement plan without changing anything")
+	flags.StringVar(&cfg.DataVolume, "data-volume", "", "volumeClaimTemplate holding etcd data (empty picks the only one)")
+	flags.StringVar(&cfg.DataDir, "data-dir", "data", "etcd data dir relative to the root of the member PVC")
+	flags.StringVar(&cfg.ClusterLabelKey, "cluster-label-key", "etcd.io/cluster", "Label key used to identify ETCD cluster membership")
+	flags.StringVar(&cfg.AgentImage, "agent-image", "etcd-snapshot-driver:latest", "Driver container image for the seed job")
+	flags.DurationVar(&cfg.JobTimeout, "timeout", 10*time.Minute, "Time allowed for the seed job")
+	flags.DurationVar(&cfg.PromoteTimeout, "promote-timeout", 10*time.Minute, "Time allowed for the learner to catch up")
+	flags.StringVar(&certFile, "etcd-cert", "", "Client certificate for etcd")
+	flags.StringVar(&keyFile, "etcd-key", "", "Client key for etcd")
+	flags.StringVar(&caFile, "etcd-ca", "", "CA certificate for etcd")
+	signatures.addFlags(cmd)
+
+	return cmd
+}
@@ -18,6 +18,10 @@ func SetupViper(cmd *cobra.Command) (*viper.Viper, error) {
 	flags.String("csi-endpoint", "unix:///var/lib/kubelet/plugins/etcd-snapshot-driver/csi.sock", "CSI endpoint socket location")
 	flags.String("driver-name", "etcd-snapshot-driver", "CSI driver name")
 
+	// Kubernetes Client Configuration
+	flags.String("kubeconfig", "", "Path to a kubeconfig file (empty uses $KUBECONFIG, ~/.kube/config or the in-cluster config)")
+	flags.String("context", "", "Kubeconfig context to use (empty uses the current context)")
+
 	// ETCD Configuration
 	flags.String("etcd-namespace", "etcd", "Default namespace for ETCD resources")
 	flags.String("cluster-label-key", "etcd.io/cluster", "Label key used to identify ETCD cluster membership")
@@ -28,6 +32,40 @@ func SetupViper(cmd *cobra.Command) (*viper.Viper, error) {
 	flags.Int64("job-active-deadline", 600, "Kubernetes job active deadline in seconds")
 	flags.String("default-storage-class", "standard", "Default storage class for snapshots")
 	flags.String("snapshot-pvc-size", "10Gi", "Size of the dedicated snapshot PVC")
+	flags.String("snapshot-compression", "none", "Compression applied to stored snapshots (none, gzip, zstd)")
+	flags.Bool("snapshot-bundle-enabled", false, "Store new snapshots as versioned bundles of a manifest, the database and the companion Secrets")
+	flags.String("snapshot-bundle-companion-selector", "", "Label selector of the Secrets, in each cluster's namespace, captured in its snapshot bundles; requires snapshot encryption")
+	flags.String("snapshot-member-selection", "prefer-follower", "Member each snapshot is streamed from (prefer-follower, leader, smallest-db, highest-raft-index, member:NAME)")
+	flags.String("snapshot-storage-mode", "shared-pvc", "Where snapshots are kept (shared-pvc, volume-snapshot)")
+	flags.String("volume-snapshot-class", "", "VolumeSnapshotClass of snapshots kept in VolumeSnapshots (empty uses the CSI driver's default)")
+
+	// Snapshot Tiering
+	flags.Duration("snapshot-tiering-cold-after", 0, "Age at which snapshots move from the snapshot PVC to the object store; 0 disables tiering")
+	flags.Duration("snapshot-tiering-interval", 10*time.Minute, "How often the tiering policy is applied")
+	flags.String("object-store-endpoint", "", "Host[:port] of the S3-compatible object store cold snapshots are kept in")
+	flags.String("object-store-region", "", "Region of the object store bucket")
+	flags.String("object-store-bucket", "", "Object store bucket cold snapshots are kept in")
+	flags.String("object-store-prefix", "etcd-snapshots", "Key prefix of snapshots in the object store bucket")
+	flags.Bool("object-store-insecure", false, "Reach the object store over plain HTTP")
+	flags.String("object-store-secret-name", "etcd-snapshot-object-store", "Secret, in each snapshot's namespace, holding the object store credentials")
+
+	// Snapshot Replication
+	flags.StringSlice("replication-targets", nil, "Secondary locations every snapshot is copied to, as NAME=URL with URL http(s)://ENDPOINT/BUCKET[/PREFIX][?region=REGION&secret=SECRET]")
+	flags.String("replication-readiness", "stored", "When snapshots are reported ready to use (stored, any-replica, all-replicas)")
+	flags.Duration("replication-interval", 10*time.Minute, "How often snapshots are checked for missing replicas")
+
+	// Snapshot Encryption
+	flags.String("snapshot-encryption-key-provider", "", "Key provider for snapshot envelope encryption (secret, file); empty disables encryption")
+	flags.String("snapshot-encryption-key-id", "", "ID of the key encryption key used to wrap new data keys")
+	flags.String("snapshot-encryption-key-secret-name", "etcd-snapshot-encryption-keys", "Secret holding key encryption keys for the secret provider")
+	flags.String("snapshot-encryption-key-secret-namespace", "etcd-snapshot-driver", "Namespace of the key encryption key Secret")
+	flags.String("snapshot-encryption-key-dir", "/etc/etcd-snapshot/keys", "Directory holding key encryption keys for the file provider")
+
+	// Snapshot Signing
+	flags.Bool("snapshot-signing-enabled", false, "Sign the manifest of every new snapshot with the Ed25519 key in the signing key Secret")
+	flags.String("snapshot-signing-key-secret-name", "etcd-snapshot-signing-key", "Secret holding the snapshot signing key and any further trusted public keys")
+	flags.String("snapshot-signing-key-secret-namespace", "etcd-snapshot-driver", "Namespace of the signing key Secret")
+	flags.Bool("snapshot-signing-strict", false, "Refuse to drill snapshots that are unsigned or whose signature does not match, rather than only warning")
 
 	// ETCD TLS Configuration
 	flags.Bool("etcd-tls-enabled", true, "Enable TLS authentication for ETCD")
@@ -38,15 +76,31 @@ func SetupViper(cmd *cobra.Command) (*viper.Viper, error) {
 	flags.String("etcd-ca-path", "/etc/etcd/tls/etcd-ca/ca.crt", "Path to ETCD CA certificate in job pods")
 
 	// Container Images
-	flags.String("etcd-image", "quay.io/coreos/etcd:v3.5.0", "ETCD container image for snapshot jobs")
+	flags.String("etcd-image", "quay.io/coreos/etcd:v3.5.0", "ETCD container image for snapshot jobs of servers whose version is not in the image matrix")
+	flags.StringSlice("etcd-image-matrix", []string{
+		"3.4=quay.io/coreos/etcd:v3.4.37",
+		"3.5=quay.io/coreos/etcd:v3.5.21",
+		"3.6=quay.io/coreos/etcd:v3.6.8",
+	}, "ETCD container images for snapshot jobs by server version (MAJOR.MINOR=IMAGE)")
 	flags.String("busybox-image", "busybox:1.35", "Busybox container image for cleanup jobs")
+	flags.String("agent-image", "etcd-snapshot-driver:latest", "Driver container image for jobs that run the snapshot agent")
+
+	// Restore Drills
+	flags.Bool("restore-drill-enabled", false, "Restore each snapshot into a throwaway etcd member to prove it is restorable")
+	flags.Int64("restore-drill-min-keys", 1, "Minimum number of keys a restored snapshot must contain")
+	flags.StringSlice("restore-drill-expected-prefixes", []string{"/registry/"}, "Key prefixes that must be present in a restored snapshot")
+
 	// Observability
 	flags.String("metrics-bind-address", ":8080", "Address for metrics and health endpoints")
 	flags.String("log-level", "info", "Log level (debug, info, warn, error)")
 	flags.String("log-format", "json", "Log format (json, console)")
 
+	// Validation
+	flags.Bool("validate-config", false, "Validate the configuration, report every problem and exit")
+
 	// High Availability
 	flags.Bool("leader-elect", false, "Enable leader election for high availability")
+	flags.String("leader-election-namespace", "etcd-snapshot-driver", "Namespace of the leader election Lease, named after the driver name")
 
 	viper := viper.New()
 
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/driver"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// logLevel is shared by every logger so config reloads can change the level in place
+var logLevel = zap.NewAtomicLevel()
+
+// reloadableOptions reads the controller settings that may change while the driver runs
+func reloadableOptions(viper *viper.Viper) []driver.ControllerOption {
+	return []driver.ControllerOption{
+		driver.WithSnapShotTimeout(viper.GetDuration("snapshot-timeout")),
+		driver.WithJobBackoffLimit(viper.GetInt32("job-backoff-limit")),
+		driver.WithJobActiveDeadlineSeconds(viper.GetInt64("job-active-deadline")),
+		driver.WithETCDImage(viper.GetString("etcd-image")),
+		driver.WithETCDImageMatrix(etcdImageMatrix(viper)),
+		driver.WithBusyboxImage(viper.GetString("busybox-image")),
+		driver.WithAgentImage(viper.GetString("agent-image")),
+		driver.WithETCDClientCertPath(viper.GetString("etcd-client-cert-path")),
+		driver.WithETCDClientKeyPath(viper.GetString("etcd-client-key-path")),
+		driver.WithETCDCAPath(viper.GetString("etcd-ca-path")),
+		driver.WithDefaultStorageClass(viper.GetString("default-storage-class")),
+		driver.WithMemberSelection(viper.GetString("snapshot-member-selection")),
+		driver.WithSnapshotStorageMode(viper.GetString("snapshot-storage-mode")),
+		driver.WithVolumeSnapshotClass(viper.GetString("volume-snapshot-class")),
+		driver.WithTieringColdAfter(viper.GetDuration("snapshot-tiering-cold-after")),
+		driver.WithTieringInterval(viper.GetDuration("snapshot-tiering-interval")),
+		driver.WithObjectStoreEndpoint(viper.GetString("object-store-endpoint")),
+		driver.WithObjectStoreRegion(viper.GetString("object-store-region")),
+		driver.WithObjectStoreBucket(viper.GetString("object-store-bucket")),
+		driver.WithObjectStorePrefix(viper.GetString("object-store-prefix")),
+		driver.WithObjectStoreInsecure(viper.GetBool("object-store-insecure")),
+		driver.WithObjectStoreSecretName(viper.GetString("object-store-secret-name")),
+		driver.WithReplicationTargets(replicationTargets(viper)),
+		driver.WithReplicationReadiness(viper.GetString("replication-readiness")),
+		driver.WithReplicationInterval(viper.GetDuration("replication-interval")),
+		driver.WithRequireSignatures(viper.GetBool("snapshot-signing-strict")),
+	}
+}
+
+// etcdImageMatrix reads the etcd image matrix, which ValidateConfig has already checked
+func etcdImageMatrix(viper *viper.Viper) job.ImageMatrix {
+	matrix, _ := job.ParseImageMatrix(viper.GetStringSlice("etcd-image-matrix"))
+	return matrix
+}
+
+// replicationTargets reads the replication targets, which ValidateConfig has already checked
+func replicationTargets(viper *viper.Viper) []snapshot.ReplicationTarget {
+	targets, _ := snapshot.ParseReplicationTargets(viper.GetStringSlice("replication-targets"))
+	return targets
+}
+
+// watchConfig applies edits to the config file to the running driver
+func watchConfig(viper *viper.Viper, server *driver.GroupControllerServer) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		logger.Infow("Configuration file changed", "file", e.Name, "op", e.Op.String())
+		_ = applyConfig(viper, server)
+	})
+}
+
+// applyConfig validates the current configuration and swaps it into the running
+// driver. Nothing is applied when any setting is rejected.
+func applyConfig(viper *viper.Viper, server *driver.GroupControllerServer) error {
+	if err := ValidateConfig(viper); err != nil {
+		logger.Errorw("Rejected configuration change, keeping the previous configuration", "error", err)
+		return err
+	}
+
+	if err := server.Reload(reloadableOptions(viper)...); err != nil {
+		logger.Errorw("Rejected configuration change, keeping the previous configuration", "error", err)
+		return err
+	}
+
+	logLevel.SetLevel(parseLogLevel(viper.GetString("log-level")))
+	logger.Infow("Applied configuration change", "log_level", logLevel.Level().String())
+
+	return nil
+}
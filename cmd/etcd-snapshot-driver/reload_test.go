@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/driver"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApplyConfig(t *testing.T) {
+	logger = zap.NewNop().Sugar()
+
+	cmd := &cobra.Command{}
+	v, err := SetupViper(cmd)
+	require.NoError(t, err)
+
+	server := driver.NewGroupControllerServer(fake.NewSimpleClientset(),
+		append([]driver.ControllerOption{driver.WithSnapshotPVCSize("10Gi")}, reloadableOptions(v)...)...,
+	)
+	logLevel.SetLevel(zapcore.InfoLevel)
+
+	v.Set("log-level", "debug")
+	v.Set("etcd-image", "quay.io/coreos/etcd:v3.6.0")
+	require.NoError(t, applyConfig(v, server))
+	assert.Equal(t, zapcore.DebugLevel, logLevel.Level())
+
+	// A rejected change leaves both the driver config and the log level alone
+	v.Set("log-level", "warn")
+	v.Set("snapshot-timeout", "0s")
+	assert.Error(t, applyConfig(v, server))
+	assert.Equal(t, zapcore.DebugLevel, logLevel.Level())
+
+	v.Set("snapshot-timeout", "1m")
+	v.Set("log-level", "verbose")
+	assert.Error(t, applyConfig(v, server))
+	assert.Equal(t, zapcore.DebugLevel, logLevel.Level())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -8,15 +9,23 @@ import (
 
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/driver"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/health"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/metrics"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -33,6 +42,12 @@ func newRootCommand() *cobra.Command {
 	for HyperShift managed ETCD deployments.`,
 	}
 	cmd.AddCommand(newVersionCommand())
+	cmd.AddCommand(newAgentCommand())
+	cmd.AddCommand(newSnapshotCommand())
+	cmd.AddCommand(newGroupCommand())
+	cmd.AddCommand(newDoctorCommand())
+	cmd.AddCommand(newMemberCommand())
+	cmd.AddCommand(newStorageCommand())
 
 	viper, err := SetupViper(cmd)
 	if err != nil {
@@ -59,7 +74,8 @@ func initializeLogging(viper *viper.Viper) func(*cobra.Command, []string) error
 			config = zap.NewProductionConfig()
 		}
 
-		config.Level = zap.NewAtomicLevelAt(parseLogLevel(level))
+		logLevel.SetLevel(parseLogLevel(level))
+		config.Level = logLevel
 		config.OutputPaths = []string{"stdout"}
 		config.ErrorOutputPaths = []string{"stderr"}
 
@@ -73,6 +89,53 @@ func initializeLogging(viper *viper.Viper) func(*cobra.Command, []string) error
 	}
 }
 
+// loadKubeConfig resolves the Kubernetes client config with the standard client-go
+// loading rules (--kubeconfig, $KUBECONFIG, ~/.kube/config) and falls back to the
+// in-cluster config when none of them yield a config
+func loadKubeConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+// newSigningKeys builds the keys new snapshots are signed with; nil disables signing
+func newSigningKeys(viper *viper.Viper, k8sClient kubernetes.Interface) signing.Keys {
+	if !viper.GetBool("snapshot-signing-enabled") {
+		return nil
+	}
+	return signing.NewSecretKeys(k8sClient,
+		viper.GetString("snapshot-signing-key-secret-namespace"),
+		viper.GetString("snapshot-signing-key-secret-name"),
+	)
+}
+
+// keyProviderConfig selects the key provider for snapshot envelope encryption; an empty
+// provider disables encryption
+func keyProviderConfig(viper *viper.Viper) encryption.ProviderConfig {
+	return encryption.ProviderConfig{
+		Provider:        viper.GetString("snapshot-encryption-key-provider"),
+		SecretNamespace: viper.GetString("snapshot-encryption-key-secret-namespace"),
+		SecretName:      viper.GetString("snapshot-encryption-key-secret-name"),
+		Dir:             viper.GetString("snapshot-encryption-key-dir"),
+	}
+}
+
+// newKeyProvider builds the key provider for snapshot envelope encryption; nil disables encryption
+func newKeyProvider(viper *viper.Viper, k8sClient kubernetes.Interface) (encryption.KeyProvider, error) {
+	providerConfig := keyProviderConfig(viper)
+	if providerConfig.Provider == "" {
+		return nil, nil
+	}
+	if viper.GetString("snapshot-encryption-key-id") == "" {
+		return nil, fmt.Errorf("snapshot-encryption-key-id is required when snapshot encryption is enabled")
+	}
+
+	return providerConfig.New(k8sClient)
+}
+
 func parseLogLevel(level string) zapcore.Level {
 	switch level {
 	case "debug":
@@ -92,15 +155,24 @@ func run(viper *viper.Viper) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		LoadOptions(viper)
 
+		// Reject bad settings up front instead of on the first snapshot request
+		if err := ValidateConfig(viper); err != nil {
+			return fmt.Errorf("invalid configuration:\n%w", err)
+		}
+		if viper.GetBool("validate-config") {
+			fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+			return nil
+		}
+
 		logger.Infow("Starting etcd-snapshot-driver",
 			"version", config.Version,
 			"endpoint", viper.GetString("csi-endpoint"),
 		)
 
 		// Create Kubernetes client
-		k8sConfig, err := rest.InClusterConfig()
+		k8sConfig, err := loadKubeConfig(viper.GetString("kubeconfig"), viper.GetString("context"))
 		if err != nil {
-			logger.Errorw("Failed to load in-cluster config", "error", err)
+			logger.Errorw("Failed to load Kubernetes client config", "error", err)
 			return err
 		}
 
@@ -110,8 +182,21 @@ func run(viper *viper.Viper) func(*cobra.Command, []string) error {
 			return err
 		}
 
+		// VolumeSnapshots are managed without the external-snapshotter client
+		dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+		if err != nil {
+			logger.Errorw("Failed to create Kubernetes dynamic client", "error", err)
+			return err
+		}
+
 		logger.Infow("Kubernetes client initialized")
 
+		// Post snapshot lifecycle events to the API server
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+		defer eventBroadcaster.Shutdown()
+		eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: viper.GetString("driver-name")})
+
 		// Initialize metrics and health checker
 		m := metrics.NewMetrics()
 		hc := health.NewHealthChecker(k8sClient, logger)
@@ -132,30 +217,74 @@ func run(viper *viper.Viper) func(*cobra.Command, []string) error {
 		// Mark as ready after initialization
 		hc.SetReady(true)
 
+		// Object names are derived from the driver name so instances stay isolated
+		names := config.NewNames(viper.GetString("driver-name"))
+
+		keyProvider, err := newKeyProvider(viper, k8sClient)
+		if err != nil {
+			logger.Errorw("Failed to set up snapshot encryption", "error", err)
+			return err
+		}
+
 		// Create and run driver
-	groupControllerServer := driver.NewGroupControllerServer(k8sClient,
-		driver.WithLogger{Logger: logger},
-		driver.WithClusterLabelKey(viper.GetString("cluster-label-key")),
-		driver.WithSnapShotTimeout(viper.GetDuration("snapshot-timeout")),
-		driver.WithJobBackoffLimit(viper.GetInt32("job-backoff-limit")),
-		driver.WithJobActiveDeadlineSeconds(viper.GetInt64("job-active-deadline")),
-		driver.WithETCDImage(viper.GetString("etcd-image")),
-		driver.WithBusyboxImage(viper.GetString("busybox-image")),
-		driver.WithETCDTLSEnabled(viper.GetBool("etcd-tls-enabled")),
-		driver.WithETCDTLSSecretName(viper.GetString("etcd-tls-secret-name")),
-		driver.WithETCDTLSSecretNamespace(viper.GetString("etcd-tls-secret-namespace")),
-		driver.WithETCDClientCertPath(viper.GetString("etcd-client-cert-path")),
-		driver.WithETCDClientKeyPath(viper.GetString("etcd-client-key-path")),
-		driver.WithETCDCAPath(viper.GetString("etcd-ca-path")),
-		driver.WithDefaultStorageClass(viper.GetString("default-storage-class")),
-		driver.WithSnapshotPVCSize(viper.GetString("snapshot-pvc-size")),
-	)
+		opts := []driver.ControllerOption{
+			driver.WithLogger{Logger: logger},
+			driver.WithDriverName(names.DriverName()),
+			driver.WithClusterLabelKey(viper.GetString("cluster-label-key")),
+			driver.WithETCDTLSEnabled(viper.GetBool("etcd-tls-enabled")),
+			driver.WithETCDTLSSecretName(viper.GetString("etcd-tls-secret-name")),
+			driver.WithETCDTLSSecretNamespace(viper.GetString("etcd-tls-secret-namespace")),
+			driver.WithSnapshotPVCSize(viper.GetString("snapshot-pvc-size")),
+			driver.WithSnapshotCompression(viper.GetString("snapshot-compression")),
+			driver.WithSnapshotBundle(viper.GetBool("snapshot-bundle-enabled")),
+			driver.WithBundleCompanionSelector(viper.GetString("snapshot-bundle-companion-selector")),
+			driver.WithRestoreDrillEnabled(viper.GetBool("restore-drill-enabled")),
+			driver.WithRestoreDrillMinKeys(viper.GetInt64("restore-drill-min-keys")),
+			driver.WithRestoreDrillPrefixes(viper.GetStringSlice("restore-drill-expected-prefixes")),
+			driver.WithEncryptionKeyProvider{Provider: keyProvider, Config: keyProviderConfig(viper)},
+			driver.WithEncryptionKeyID(viper.GetString("snapshot-encryption-key-id")),
+			driver.WithSigningKeys{Keys: newSigningKeys(viper, k8sClient)},
+			driver.WithEventRecorder{Recorder: eventRecorder},
+			driver.WithMetrics{Metrics: m},
+			driver.WithDynamicClient{Client: dynamicClient},
+		}
+		groupControllerServer := driver.NewGroupControllerServer(k8sClient,
+			append(opts, reloadableOptions(viper)...)...,
+		)
+
+		// Apply later edits to the config file to the running driver
+		watchConfig(viper, groupControllerServer)
+
+		// Background work runs on a single replica when leader election is enabled
+		leaderTasks := func(ctx context.Context) {
+			// Re-wrap data keys still wrapped with a retired key encryption key
+			if err := groupControllerServer.RewrapDataKeys(ctx); err != nil {
+				logger.Warnw("Failed to rewrap snapshot data keys", "error", err)
+			}
+			// Move snapshots past the tiering cold-after age to the object store
+			go groupControllerServer.RunTiering(ctx)
+			// Copy snapshots to the replication targets
+			go groupControllerServer.RunReplication(ctx)
+		}
+		if viper.GetBool("leader-elect") {
+			go func() {
+				if err := runAsLeader(cmd.Context(), k8sClient, names, viper.GetString("leader-election-namespace"), leaderTasks); err != nil {
+					logger.Errorw("Leader election failed", "error", err)
+				}
+			}()
+		} else {
+			leaderTasks(cmd.Context())
+		}
 
-			identityServer := driver.NewIdentityServer(driver.WithLogger{Logger: logger})
+		identityServer := driver.NewIdentityServer(
+			driver.WithLogger{Logger: logger},
+			driver.WithDriverName(names.DriverName()),
+		)
 
 		driverInstance := driver.NewDriver(k8sClient, groupControllerServer, identityServer,
 			driver.WithLogger{Logger: logger},
 			driver.WithEndPoint(viper.GetString("csi-endpoint")),
+			driver.WithDriverName(names.DriverName()),
 		)
 
 		_ = m // Keep metrics reference to avoid GC
@@ -37,6 +37,12 @@ func TestFlagDefaults(t *testing.T) {
 			want:    "etcd-snapshot-driver",
 			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetString(k) },
 		},
+		{
+			name:    "kubeconfig default",
+			flag:    "kubeconfig",
+			want:    "",
+			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetString(k) },
+		},
 		{
 			name:    "etcd-namespace default",
 			flag:    "etcd-namespace",
@@ -79,6 +85,24 @@ func TestFlagDefaults(t *testing.T) {
 			want:    false,
 			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetBool(k) },
 		},
+		{
+			name:    "restore-drill-enabled default",
+			flag:    "restore-drill-enabled",
+			want:    false,
+			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetBool(k) },
+		},
+		{
+			name:    "restore-drill-expected-prefixes default",
+			flag:    "restore-drill-expected-prefixes",
+			want:    []string{"/registry/"},
+			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetStringSlice(k) },
+		},
+		{
+			name:    "snapshot-encryption-key-provider default",
+			flag:    "snapshot-encryption-key-provider",
+			want:    "",
+			getFunc: func(vv *viper.Viper, k string) interface{} { return vv.GetString(k) },
+		},
 		{
 			name:    "log-level default",
 			flag:    "log-level",
@@ -0,0 +1,844 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/driver"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/restore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+func newSnapshotCommand() *cobra.Command {
+	var opts adminOptions
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Inspect and manage stored snapshots",
+	}
+	opts.addFlags(cmd)
+
+	cmd.AddCommand(newSnapshotListCommand(&opts))
+	cmd.AddCommand(newSnapshotDescribeCommand(&opts))
+	cmd.AddCommand(newSnapshotDeleteCommand(&opts))
+	cmd.AddCommand(newSnapshotHoldCommand(&opts))
+	cmd.AddCommand(newSnapshotReleaseCommand(&opts))
+	cmd.AddCommand(newSnapshotLockCommand(&opts))
+	cmd.AddCommand(newSnapshotVerifyCommand(&opts))
+	cmd.AddCommand(newSnapshotRestoreCommand(&opts))
+	cmd.AddCommand(newSnapshotExportCompanionsCommand(&opts))
+
+	return cmd
+}
+
+func newSnapshotListCommand(opts *adminOptions) *cobra.Command {
+	var filter listFilter
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			snapshots, err := manager.ListSnapshotMetadata(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			selected := make([]*snapshot.SnapshotMetadata, 0, len(snapshots))
+			for _, s := range snapshots {
+				if filter.matches(s.ClusterName, s.Namespace, s.CreationTime, now) {
+					selected = append(selected, s)
+				}
+			}
+
+			return opts.print(cmd.OutOrStdout(), selected, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "SNAPSHOT ID\tCLUSTER\tNAMESPACE\tSIZE\tCOMPRESSION\tENCRYPTED\tRESTORE DRILL\tAGE")
+				for _, s := range selected {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n",
+						s.SnapshotID,
+						s.ClusterName,
+						s.Namespace,
+						humanSize(s.Size),
+						compressionName(s),
+						s.Encryption != nil,
+						drillSummary(s.RestoreDrill),
+						humanAge(s.CreationTime, now),
+					)
+				}
+			})
+		},
+	}
+	filter.addFlags(cmd)
+
+	return cmd
+}
+
+func newSnapshotDescribeCommand(opts *adminOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe SNAPSHOT_ID",
+		Short: "Show the details of a stored snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			s, err := manager.RetrieveSnapshotMetadata(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			return opts.print(cmd.OutOrStdout(), s, func(w *tabwriter.Writer) {
+				describeSnapshot(w, s)
+			})
+		},
+	}
+}
+
+func newSnapshotDeleteCommand(opts *adminOptions) *cobra.Command {
+	var (
+		dryRun       bool
+		force        bool
+		busyboxImage string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "delete SNAPSHOT_ID",
+		Short: "Delete a stored snapshot file and its metadata",
+		Long: `Delete a stored snapshot file and its metadata.
+
+Group snapshots that reference the snapshot are removed as well, since they
+cannot be restored without it. Snapshots under legal hold or locked are not
+deleted, nor are snapshots still shared by member snapshots of other group
+snapshots unless --force is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			out := cmd.OutOrStdout()
+
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			s, err := manager.RetrieveSnapshotMetadata(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if err := s.CheckDeletable(time.Now()); err != nil {
+				return err
+			}
+			// Member snapshots of other group snapshots still share the file
+			if len(s.References) > 0 && !force {
+				return fmt.Errorf("snapshot %s is still referenced by member snapshots %s; use --force to delete it anyway",
+					s.SnapshotID, strings.Join(s.References, ", "))
+			}
+
+			groups, err := manager.ListGroupSnapshotMetadata(ctx)
+			if err != nil {
+				return err
+			}
+			var referencing []string
+			for _, g := range groups {
+				if g.HasSnapshot(s.SnapshotID) {
+					referencing = append(referencing, g.GroupSnapshotID)
+				}
+			}
+
+			jobConfig := &job.JobConfig{
+				DriverName:           opts.driverName,
+				SnapshotID:           s.SnapshotID,
+				Namespace:            s.Namespace,
+				SnapshotPVCName:      s.PVCName,
+				SnapshotPVCNamespace: s.Namespace,
+				Operation:            "delete",
+				Format:               s.Format(),
+				BusyboxImage:         busyboxImage,
+			}
+			deleteJob := job.GenerateSnapshotDeleteJob(jobConfig)
+
+			if dryRun {
+				if s.VolumeSnapshot != nil {
+					fmt.Fprintf(out, "Would delete VolumeSnapshot %s/%s\n", s.Namespace, s.VolumeSnapshot.Name)
+				}
+				if s.ObjectStore != nil {
+					fmt.Fprintf(out, "Would delete object %s/%s from %s\n", s.ObjectStore.Bucket, s.ObjectStore.Key, s.ObjectStore.Endpoint)
+				}
+				for _, replica := range s.Replicas {
+					if replica.Location != nil {
+						fmt.Fprintf(out, "Would delete replica %s/%s on %s\n", replica.Location.Bucket, replica.Location.Key, replica.Target)
+					}
+				}
+				if s.VolumeSnapshot == nil && s.PVCName != "" {
+					fmt.Fprintf(out, "Would run job %s/%s to remove %s from PVC %s\n",
+						deleteJob.Namespace, deleteJob.Name, s.Format().FileName(s.SnapshotID), s.PVCName)
+				}
+				fmt.Fprintf(out, "Would delete metadata of snapshot %s\n", s.SnapshotID)
+				for _, id := range referencing {
+					fmt.Fprintf(out, "Would delete metadata of group snapshot %s\n", id)
+				}
+				return nil
+			}
+
+			// The driver's own cleanup removes the file, object and replicas, then the metadata
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			serverOpts := []driver.ControllerOption{
+				driver.WithLogger{Logger: logger},
+				driver.WithDriverName(opts.driverName),
+				driver.WithMetadataNamespace(opts.metadataNamespace),
+				driver.WithBusyboxImage(busyboxImage),
+			}
+			if s.VolumeSnapshot != nil {
+				dynamicClient, err := opts.dynamic()
+				if err != nil {
+					return err
+				}
+				serverOpts = append(serverOpts, driver.WithDynamicClient{Client: dynamicClient})
+			}
+			if err := driver.NewGroupControllerServer(k8sClient, serverOpts...).CleanupSnapshot(ctx, s.SnapshotID); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Deleted snapshot %s\n", s.SnapshotID)
+
+			for _, id := range referencing {
+				if err := manager.DeleteGroupSnapshotMetadata(ctx, id); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Deleted group snapshot %s\n", id)
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&dryRun, "dry-run", false, "Print what would be deleted without deleting anything")
+	flags.BoolVar(&force, "force", false, "Delete the snapshot even while member snapshots still reference it")
+	flags.StringVar(&busyboxImage, "busybox-image", "busybox:1.35", "Busybox container image for the delete job")
+
+	return cmd
+}
+
+func replicaSummary(r snapshot.Replica) string {
+	switch r.State {
+	case snapshot.ReplicaReplicated:
+		return fmt.Sprintf("replicated %s to %s/%s", r.ReplicatedTime.Format(time.RFC3339), r.Location.Bucket, r.Location.Key)
+	case snapshot.ReplicaFailed:
+		return fmt.Sprintf("failed %d time(s), last at %s: %s", r.Attempts, r.LastAttemptTime.Format(time.RFC3339), r.LastError)
+	default:
+		return string(r.State)
+	}
+}
+
+// objectClient creates a client for the bucket of location with the credentials from its
+// Secret in namespace
+func objectClient(ctx context.Context, k8sClient kubernetes.Interface, namespace string, location *snapshot.ObjectLocation) (*objectstore.Client, error) {
+	return objectstore.NewWithSecret(ctx, k8sClient, objectstore.Config{
+		Endpoint: location.Endpoint,
+		Region:   location.Region,
+		Bucket:   location.Bucket,
+		Insecure: location.Insecure,
+	}, namespace, location.SecretName)
+}
+
+func newSnapshotHoldCommand(opts *adminOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "hold SNAPSHOT_ID",
+		Short: "Place a stored snapshot under legal hold",
+		Long: `Place a stored snapshot under legal hold.
+
+A snapshot under legal hold is not deleted, neither through its
+VolumeGroupSnapshot nor with "snapshot delete", until the hold is released.
+Copies of the snapshot in object stores are put under the store's legal hold.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			legalHold := true
+			return setHold(cmd, opts, args[0], snapshot.Hold{LegalHold: &legalHold})
+		},
+	}
+}
+
+func newSnapshotReleaseCommand(opts *adminOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "release SNAPSHOT_ID",
+		Short: "Release the legal hold on a stored snapshot",
+		Long: `Release the legal hold on a stored snapshot.
+
+A lock set with "snapshot lock" stays in force until it expires.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			legalHold := false
+			return setHold(cmd, opts, args[0], snapshot.Hold{LegalHold: &legalHold})
+		},
+	}
+}
+
+func newSnapshotLockCommand(opts *adminOptions) *cobra.Command {
+	var (
+		until   string
+		lockFor time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lock SNAPSHOT_ID",
+		Short: "Keep a stored snapshot from being deleted until a given time",
+		Long: `Keep a stored snapshot from being deleted until a given time.
+
+The lock can be extended but not shortened or removed. Copies of the snapshot
+in object stores get compliance mode retention until the same time.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (until == "") == (lockFor == 0) {
+				return fmt.Errorf("exactly one of --until and --for is required")
+			}
+
+			hold, err := snapshot.ParseHold("", until)
+			if err != nil {
+				return err
+			}
+			if lockFor != 0 {
+				if lockFor < 0 {
+					return fmt.Errorf("--for must be positive, got %s", lockFor)
+				}
+				hold.LockedUntil = time.Now().Add(lockFor).Truncate(time.Second)
+			}
+			return setHold(cmd, opts, args[0], hold)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&until, "until", "", "Time to lock the snapshot until (RFC 3339)")
+	flags.DurationVar(&lockFor, "for", 0, "Time to lock the snapshot for, from now")
+
+	return cmd
+}
+
+// setHold records a hold on a snapshot and maps it onto the object lock of every copy of
+// the snapshot in an object store
+func setHold(cmd *cobra.Command, opts *adminOptions, snapshotID string, hold snapshot.Hold) error {
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+
+	manager, err := opts.manager()
+	if err != nil {
+		return err
+	}
+	s, err := manager.SetSnapshotHold(ctx, snapshotID, hold)
+	if err != nil {
+		return err
+	}
+	if held, reason := s.Held(time.Now()); held {
+		fmt.Fprintf(out, "Snapshot %s is %s\n", s.SnapshotID, reason)
+	} else {
+		fmt.Fprintf(out, "Snapshot %s is not held\n", s.SnapshotID)
+	}
+
+	var locations []*snapshot.ObjectLocation
+	if s.ObjectStore != nil {
+		locations = append(locations, s.ObjectStore)
+	}
+	for _, replica := range s.Replicas {
+		if replica.State == snapshot.ReplicaReplicated && replica.Location != nil {
+			locations = append(locations, replica.Location)
+		}
+	}
+	if len(locations) == 0 {
+		return nil
+	}
+
+	k8sClient, err := opts.client()
+	if err != nil {
+		return err
+	}
+	for _, location := range locations {
+		client, err := objectClient(ctx, k8sClient, s.Namespace, location)
+		if err == nil {
+			err = client.Lock(ctx, location.Key, s.LegalHold, s.LockedUntil)
+		}
+		if err != nil {
+			return fmt.Errorf("hold recorded, but object lock of %s/%s not applied: %w", location.Bucket, location.Key, err)
+		}
+		fmt.Fprintf(out, "Applied object lock to %s/%s\n", location.Bucket, location.Key)
+	}
+	return nil
+}
+
+func newSnapshotVerifyCommand(opts *adminOptions) *cobra.Command {
+	var (
+		agentImage       string
+		minKeys          int64
+		expectedPrefixes []string
+		timeout          time.Duration
+		signatures       signatureOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify SNAPSHOT_ID",
+		Short: "Run a restore drill against a stored snapshot and record the result",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			s, err := manager.RetrieveSnapshotMetadata(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			// The data key of encrypted snapshots is only ever unwrapped by the driver
+			if s.Encryption != nil {
+				return fmt.Errorf("snapshot %s is encrypted with key %q; enable restore drills on the driver to verify it",
+					s.SnapshotID, s.Encryption.KeyID)
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			// Snapshots whose signature is rejected are not drilled
+			if err := signatures.policy(k8sClient).Check(ctx, logger, s); err != nil {
+				return err
+			}
+			executor := job.NewExecutor(k8sClient, logger)
+
+			// Snapshots kept in a VolumeSnapshot are drilled from a PVC restored from it
+			names := config.NewNames(opts.driverName)
+			pvcName, release, err := snapshot.ReadablePVC(ctx, k8sClient, s,
+				names.SnapshotVolume(s.SnapshotID)+"-drill", map[string]string{"app": names.AppLabel()})
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			// The snapshot PVC is ReadWriteOnce; the drill waits for the jobs of the driver
+			// and other commands to let go of it and runs where it is attached. Snapshots in
+			// the object store are fetched by the drill and need no PVC.
+			var nodeName string
+			if pvcName != "" {
+				unlock, err := executor.LockPVC(ctx, s.Namespace, pvcName)
+				if err != nil {
+					return err
+				}
+				defer unlock()
+				if nodeName, err = executor.AttachmentNode(ctx, s.Namespace, pvcName); err != nil {
+					return fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
+				}
+			}
+
+			drillJob := job.GenerateRestoreDrillJob(&job.JobConfig{
+				DriverName:            opts.driverName,
+				SnapshotID:            s.SnapshotID,
+				Namespace:             s.Namespace,
+				SnapshotPVCName:       pvcName,
+				SnapshotPVCNamespace:  s.Namespace,
+				ActiveDeadlineSeconds: int64(timeout.Seconds()),
+				Operation:             "restore-drill",
+				Format:                s.Format(),
+				ObjectStore:           s.ObjectStore,
+				ChecksumSHA256:        s.ChecksumSHA256,
+				AgentImage:            agentImage,
+				DrillMinKeys:          minKeys,
+				DrillExpectedPrefixes: expectedPrefixes,
+				NodeName:              nodeName,
+			})
+
+			var result etcd.DrillResult
+			drillStatus := &snapshot.RestoreDrillStatus{}
+			if _, err := executor.ExecuteSnapshotJob(ctx, drillJob, timeout); err != nil {
+				drillStatus.Message = fmt.Sprintf("restore drill job failed: %v", err)
+			} else if output, err := executor.JobOutput(ctx, drillJob, 20); err != nil {
+				drillStatus.Message = fmt.Sprintf("failed to read restore drill result: %v", err)
+			} else if err := job.DecodeResult(output, &result); err != nil {
+				drillStatus.Message = fmt.Sprintf("failed to read restore drill result: %v", err)
+			} else {
+				drillStatus.Passed = result.Passed
+				drillStatus.TotalKeys = result.TotalKeys
+				drillStatus.Revision = result.Revision
+				drillStatus.Message = result.Message
+			}
+			drillStatus.CheckedAt = time.Now()
+
+			s.RestoreDrill = drillStatus
+			if err := manager.StoreSnapshotMetadata(ctx, s); err != nil {
+				return fmt.Errorf("recording restore drill result: %w", err)
+			}
+
+			if err := opts.print(cmd.OutOrStdout(), drillStatus, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Snapshot:\t%s\n", s.SnapshotID)
+				describeDrill(w, drillStatus)
+			}); err != nil {
+				return err
+			}
+
+			if !drillStatus.Passed {
+				return fmt.Errorf("snapshot %s failed verification", s.SnapshotID)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&agentImage, "agent-image", "etcd-snapshot-driver:latest", "Driver container image for the restore drill job")
+	flags.Int64Var(&minKeys, "min-keys", 1, "Minimum number of keys the restored snapshot must contain")
+	flags.StringSliceVar(&expectedPrefixes, "expect-prefix", []string{"/registry/"}, "Key prefix that must be present (repeatable)")
+	flags.DurationVar(&timeout, "timeout", 10*time.Minute, "Time allowed for the restore drill job")
+	signatures.addFlags(cmd)
+
+	return cmd
+}
+
+func newSnapshotExportCompanionsCommand(opts *adminOptions) *cobra.Command {
+	var (
+		outputDir  string
+		agentImage string
+		timeout    time.Duration
+		signatures signatureOptions
+		keys       encryptionOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export-companions SNAPSHOT_ID --output-dir DIR",
+		Short: "Decrypt the companion Secrets of a snapshot bundle into a local directory",
+		Long: `Decrypt the companion Secrets of a snapshot bundle into a local directory.
+
+A Job checks the bundle against its recorded checksum and prints the companion
+Secret entries, still encrypted, to its logs. The entries are checked against
+the snapshot's metadata and decrypted locally with the snapshot's data key,
+which is unwrapped with the same key encryption keys the driver uses. Each
+Secret is written to a directory of its own holding a file per key, as
+Secrets are mounted into pods; existing files are never overwritten.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			s, err := manager.RetrieveSnapshotMetadata(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if s.Bundle == nil {
+				return fmt.Errorf("snapshot %s is not a bundle and holds no companion Secrets", s.SnapshotID)
+			}
+			if !hasCompanions(s.Bundle) {
+				return fmt.Errorf("bundle of snapshot %s holds no companion Secrets", s.SnapshotID)
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			// Companions of snapshots whose signature is rejected are not exported
+			if err := signatures.policy(k8sClient).Check(ctx, logger, s); err != nil {
+				return err
+			}
+			dataKey, err := keys.dataKey(ctx, k8sClient, s)
+			if err != nil {
+				return err
+			}
+			executor := job.NewExecutor(k8sClient, logger)
+
+			// Snapshots kept in a VolumeSnapshot are read from a PVC restored from it
+			names := config.NewNames(opts.driverName)
+			pvcName, release, err := snapshot.ReadablePVC(ctx, k8sClient, s,
+				names.SnapshotVolume(s.SnapshotID)+"-companions", map[string]string{"app": names.AppLabel()})
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			// The snapshot PVC is ReadWriteOnce; the job waits for the jobs of the driver
+			// and other commands to let go of it and runs where it is attached. Snapshots in
+			// the object store are fetched by the job and need no PVC.
+			var nodeName string
+			if pvcName != "" {
+				unlock, err := executor.LockPVC(ctx, s.Namespace, pvcName)
+				if err != nil {
+					return err
+				}
+				defer unlock()
+				if nodeName, err = executor.AttachmentNode(ctx, s.Namespace, pvcName); err != nil {
+					return fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
+				}
+			}
+
+			companionsJob := job.GenerateBundleCompanionsJob(&job.JobConfig{
+				DriverName:            opts.driverName,
+				SnapshotID:            s.SnapshotID,
+				Namespace:             s.Namespace,
+				SnapshotPVCName:       pvcName,
+				SnapshotPVCNamespace:  s.Namespace,
+				ActiveDeadlineSeconds: int64(timeout.Seconds()),
+				Operation:             "bundle-companions",
+				Format:                s.Format(),
+				ObjectStore:           s.ObjectStore,
+				ChecksumSHA256:        s.ChecksumSHA256,
+				AgentImage:            agentImage,
+				NodeName:              nodeName,
+			})
+			// The job's logs hold the encrypted entries; they are not kept around
+			defer func() {
+				if err := executor.DeleteJob(context.WithoutCancel(ctx), companionsJob); err != nil {
+					logger.Warnw("Failed to delete companions job", "job", companionsJob.Name, "error", err)
+				}
+			}()
+
+			if _, err := executor.ExecuteSnapshotJob(ctx, companionsJob, timeout); err != nil {
+				return fmt.Errorf("companions job failed: %w", err)
+			}
+			output, err := executor.JobOutput(ctx, companionsJob, 20)
+			if err != nil {
+				return fmt.Errorf("failed to read companions: %w", err)
+			}
+			var companions snapshot.BundleCompanions
+			if err := job.DecodeResult(output, &companions); err != nil {
+				return fmt.Errorf("failed to read companions: %w", err)
+			}
+
+			// The entries are only trusted as far as they match the snapshot's metadata,
+			// which its signature covers
+			if companions.Manifest.SnapshotID != s.SnapshotID || !slices.Equal(companions.Manifest.Files, s.Bundle.Files) {
+				return fmt.Errorf("bundle manifest of snapshot %s does not match its metadata", s.SnapshotID)
+			}
+
+			paths, err := companions.Extract(outputDir, dataKey)
+			if err != nil {
+				return err
+			}
+
+			return opts.print(cmd.OutOrStdout(), paths, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "FILE")
+				for _, path := range paths {
+					fmt.Fprintln(w, path)
+				}
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&outputDir, "output-dir", "", "Directory to write the companion Secrets to, one directory each")
+	flags.StringVar(&agentImage, "agent-image", "etcd-snapshot-driver:latest", "Driver container image for the companions job")
+	flags.DurationVar(&timeout, "timeout", 5*time.Minute, "Time allowed for the companions job")
+	signatures.addFlags(cmd)
+	keys.addFlags(cmd)
+	_ = cmd.MarkFlagRequired("output-dir")
+
+	return cmd
+}
+
+// hasCompanions reports whether a bundle holds any companion Secrets
+func hasCompanions(bundle *snapshot.BundleInfo) bool {
+	for _, file := range bundle.Files {
+		if file.Kind == snapshot.BundleFileSecret {
+			return true
+		}
+	}
+	return false
+}
+
+func newSnapshotRestoreCommand(opts *adminOptions) *cobra.Command {
+	var (
+		statefulSet string
+		dryRun      bool
+		cfg         restore.Config
+		signatures  signatureOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore SNAPSHOT_ID --statefulset NAME",
+		Short: "Restore a snapshot into every member of an etcd StatefulSet",
+		Long: `Restore a snapshot into every member of an etcd StatefulSet.
+
+The StatefulSet is scaled to 0, the snapshot is restored into each member's
+PVC in ordinal order by a Job, and the StatefulSet is scaled back up. Each
+member's previous data dir is kept next to the restored one. If a member fails,
+the members restored so far are rolled back; the StatefulSet is only scaled
+back up when a quorum of members holds consistent data.
+
+The etcd pods must be running when the restore starts so their member names
+and peer URLs can be discovered.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if statefulSet == "" {
+				return fmt.Errorf("--statefulset is required")
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			cfg.DriverName = opts.driverName
+			cfg.Signatures = signatures.policy(k8sClient)
+			orchestrator := restore.NewOrchestrator(k8sClient, logger, manager, cfg)
+
+			plan, err := orchestrator.Plan(cmd.Context(), statefulSet, args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := opts.print(cmd.OutOrStdout(), plan, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Snapshot:\t%s\n", plan.SnapshotID)
+				fmt.Fprintf(w, "StatefulSet:\t%s/%s (%d replicas)\n", plan.Namespace, plan.StatefulSet, plan.Replicas)
+				fmt.Fprintf(w, "Initial Cluster Token:\t%s\n", plan.InitialClusterToken)
+				fmt.Fprintf(w, "Data Dir:\t%s (previous kept in %s)\n", plan.DataDir, plan.BackupDir)
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "ORDINAL\tMEMBER\tPVC\tPEER URLS")
+				for _, m := range plan.Members {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.Ordinal, m.Name, m.PVCName, strings.Join(m.PeerURLs, ","))
+				}
+			}); err != nil {
+				return err
+			}
+
+			if dryRun {
+				return nil
+			}
+
+			if err := orchestrator.Run(cmd.Context(), plan); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Restored snapshot %s into StatefulSet %s/%s\n", plan.SnapshotID, plan.Namespace, plan.StatefulSet)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&statefulSet, "statefulset", "", "etcd StatefulSet to restore, in the snapshot's namespace")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the restore plan without changing anything")
+	flags.StringVar(&cfg.DataVolume, "data-volume", "", "volumeClaimTemplate holding etcd data (empty picks the only one)")
+	flags.StringVar(&cfg.DataDir, "data-dir", "data", "etcd data dir relative to the root of the member PVC")
+	flags.StringVar(&cfg.InitialClusterToken, "initial-cluster-token", "", "Token of the restored cluster (empty derives one from the StatefulSet and snapshot)")
+	flags.StringVar(&cfg.ClusterLabelKey, "cluster-label-key", "etcd.io/cluster", "Label key used to identify ETCD cluster membership")
+	flags.StringVar(&cfg.AgentImage, "agent-image", "etcd-snapshot-driver:latest", "Driver container image for the restore jobs")
+	flags.DurationVar(&cfg.JobTimeout, "timeout", 10*time.Minute, "Time allowed for each member's restore job")
+	signatures.addFlags(cmd)
+
+	return cmd
+}
+
+func describeSnapshot(w *tabwriter.Writer, s *snapshot.SnapshotMetadata) {
+	fmt.Fprintf(w, "Snapshot ID:\t%s\n", s.SnapshotID)
+	fmt.Fprintf(w, "Source Volume:\t%s\n", s.SourceVolumeID)
+	fmt.Fprintf(w, "Cluster:\t%s\n", s.ClusterName)
+	fmt.Fprintf(w, "Namespace:\t%s\n", s.Namespace)
+	fmt.Fprintf(w, "Tier:\t%s\n", s.Tier())
+	if s.VolumeSnapshot != nil {
+		fmt.Fprintf(w, "VolumeSnapshot:\t%s (class %s, restore size %s)\n", s.VolumeSnapshot.Name, s.VolumeSnapshot.ClassName, s.VolumeSnapshot.RestoreSize)
+	}
+	if s.ObjectStore != nil {
+		fmt.Fprintf(w, "Object:\t%s/%s at %s\n", s.ObjectStore.Bucket, s.ObjectStore.Key, s.ObjectStore.Endpoint)
+	}
+	if s.VolumeSnapshot == nil && (s.ObjectStore == nil || s.PVCName != "") {
+		fmt.Fprintf(w, "Snapshot PVC:\t%s\n", s.PVCName)
+	}
+	for _, replica := range s.Replicas {
+		fmt.Fprintf(w, "Replica %s:\t%s\n", replica.Target, replicaSummary(replica))
+	}
+	if s.MemberName != "" {
+		fmt.Fprintf(w, "Member:\t%s (%s) at revision %d\n", s.MemberName, s.MemberID, s.Revision)
+	}
+	if s.ETCDVersion != "" {
+		fmt.Fprintf(w, "ETCD Version:\t%s\n", s.ETCDVersion)
+	}
+	fmt.Fprintf(w, "File:\t%s\n", s.Format().FileName(s.SnapshotID))
+	if s.Bundle != nil {
+		fmt.Fprintf(w, "Bundle:\tversion %d\n", s.Bundle.Version)
+		for _, file := range s.Bundle.Files {
+			fmt.Fprintf(w, "  %s:\t%s (%s)\n", file.Name, file.Kind, humanSize(file.Size))
+		}
+	}
+	fmt.Fprintf(w, "Created:\t%s (%s ago)\n", s.CreationTime.Format(time.RFC3339), humanAge(s.CreationTime, time.Now()))
+	fmt.Fprintf(w, "Ready To Use:\t%t\n", s.ReadyToUse)
+	fmt.Fprintf(w, "Size:\t%s\n", humanSize(s.Size))
+	if s.UncompressedSize > 0 {
+		fmt.Fprintf(w, "Uncompressed Size:\t%s\n", humanSize(s.UncompressedSize))
+	}
+	fmt.Fprintf(w, "Compression:\t%s\n", compressionName(s))
+	if s.ChecksumSHA256 != "" {
+		fmt.Fprintf(w, "Checksum (SHA-256):\t%s\n", s.ChecksumSHA256)
+	}
+	if s.Encryption != nil {
+		fmt.Fprintf(w, "Encryption:\t%s (key %s)\n", s.Encryption.Algorithm, s.Encryption.KeyID)
+	} else {
+		fmt.Fprintf(w, "Encryption:\tnone\n")
+	}
+	if s.Signature != nil {
+		fmt.Fprintf(w, "Signature:\t%s (key %s)\n", s.Signature.Algorithm, s.Signature.KeyID)
+	} else {
+		fmt.Fprintf(w, "Signature:\tnone\n")
+	}
+	if s.LegalHold {
+		fmt.Fprintf(w, "Legal Hold:\t%t\n", s.LegalHold)
+	}
+	if s.LockedUntil != nil {
+		fmt.Fprintf(w, "Locked Until:\t%s\n", s.LockedUntil.Format(time.RFC3339))
+	}
+	if len(s.References) > 0 {
+		fmt.Fprintf(w, "Member Snapshots:\t%s\n", strings.Join(s.References, ", "))
+	}
+	describeDrill(w, s.RestoreDrill)
+}
+
+func describeDrill(w *tabwriter.Writer, d *snapshot.RestoreDrillStatus) {
+	fmt.Fprintf(w, "Restore Drill:\t%s\n", drillSummary(d))
+	if d == nil {
+		return
+	}
+	fmt.Fprintf(w, "  Checked:\t%s\n", d.CheckedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "  Keys:\t%d\n", d.TotalKeys)
+	fmt.Fprintf(w, "  Revision:\t%d\n", d.Revision)
+	if d.Message != "" {
+		fmt.Fprintf(w, "  Message:\t%s\n", d.Message)
+	}
+}
+
+func drillSummary(d *snapshot.RestoreDrillStatus) string {
+	switch {
+	case d == nil:
+		return "-"
+	case d.Passed:
+		return "Passed"
+	default:
+		return "Failed"
+	}
+}
+
+// compressionName reports snapshots stored before compression support as uncompressed
+func compressionName(s *snapshot.SnapshotMetadata) snapshot.Codec {
+	if s.Compression == "" {
+		return snapshot.CodecNone
+	}
+	return s.Compression
+}
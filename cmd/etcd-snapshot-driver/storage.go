@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/restore"
+	"github.com/spf13/cobra"
+)
+
+func newStorageCommand() *cobra.Command {
+	var opts adminOptions
+
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Move etcd StatefulSets to other volumes",
+	}
+	opts.addFlags(cmd)
+
+	cmd.AddCommand(newStorageMigrateCommand(&opts))
+	cmd.AddCommand(newStorageRollbackCommand(&opts))
+
+	return cmd
+}
+
+func newStorageMigrateCommand(opts *adminOptions) *cobra.Command {
+	var (
+		snapshotID string
+		dryRun     bool
+		target     restore.MigrationTarget
+		cfg        restore.Config
+		signatures signatureOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "migrate STATEFULSET --snapshot-id ID --storage-class CLASS --size SIZE",
+		Short: "Move an etcd StatefulSet to a new storage class and size through a snapshot",
+		Long: `Move an etcd StatefulSet to a new storage class and size through a snapshot.
+
+The storage class and size of a StatefulSet's volumes cannot be changed in
+place. This command creates a new PVC per member with the target storage class
+and size, scales the StatefulSet to 0, restores the snapshot into the new PVCs
+and recreates the StatefulSet with a new volumeClaimTemplate. The old PVCs are
+kept, and "storage rollback" switches the StatefulSet back to them.
+
+The snapshot must have passed a restore drill ("snapshot verify") and should be
+recent: writes made after it was taken are lost. Stop writers to etcd before
+taking it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if snapshotID == "" {
+				return errors.New("--snapshot-id is required")
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			cfg.DriverName = opts.driverName
+			cfg.Signatures = signatures.policy(k8sClient)
+			orchestrator := restore.NewOrchestrator(k8sClient, logger, manager, cfg)
+
+			plan, err := orchestrator.PlanMigration(cmd.Context(), args[0], snapshotID, target)
+			if err != nil {
+				return err
+			}
+
+			if err := opts.print(cmd.OutOrStdout(), plan, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Snapshot:\t%s\n", plan.SnapshotID)
+				fmt.Fprintf(w, "StatefulSet:\t%s/%s (%d replicas)\n", plan.Namespace, plan.StatefulSet, plan.Replicas)
+				fmt.Fprintf(w, "Volume:\t%s -> %s\n", plan.OldVolume, plan.NewVolume)
+				fmt.Fprintf(w, "Storage Class:\t%s\n", plan.StorageClass)
+				fmt.Fprintf(w, "Size:\t%s\n", plan.Size)
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "ORDINAL\tMEMBER\tOLD PVC\tNEW PVC\tPEER URLS")
+				for i, m := range plan.Members {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.Ordinal, m.Name, m.PVCName, plan.NewPVCs[i], strings.Join(m.PeerURLs, ","))
+				}
+			}); err != nil {
+				return err
+			}
+
+			if dryRun {
+				return nil
+			}
+
+			if err := orchestrator.RunMigration(cmd.Context(), plan); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Migrated StatefulSet %s/%s to %s; the old PVCs are kept for rollback\n", plan.Namespace, plan.StatefulSet, plan.NewVolume)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&snapshotID, "snapshot-id", "", "Verified snapshot to restore into the new volumes")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the migration plan without changing anything")
+	flags.StringVar(&target.StorageClass, "storage-class", "", "Storage class of the new volumes")
+	flags.StringVar(&target.Size, "size", "", "Size of the new volumes")
+	flags.StringVar(&target.VolumeName, "volume-name", "", "Name of the new volumeClaimTemplate (empty appends the storage class to the old name)")
+	flags.DurationVar(&target.MaxSnapshotAge, "max-snapshot-age", time.Hour, "Refuse snapshots older than this (0 allows any age)")
+	flags.StringVar(&cfg.DataVolume, "data-volume", "", "volumeClaimTemplate holding etcd data (empty picks the only one)")
+	flags.StringVar(&cfg.DataDir, "data-dir", "data", "etcd data dir relative to the root of the member PVC")
+	flags.StringVar(&cfg.InitialClusterToken, "initial-cluster-token", "", "Token of the restored cluster (empty derives one from the StatefulSet and snapshot)")
+	flags.StringVar(&cfg.ClusterLabelKey, "cluster-label-key", "etcd.io/cluster", "Label key used to identify ETCD cluster membership")
+	flags.StringVar(&cfg.AgentImage, "agent-image", "etcd-snapshot-driver:latest", "Driver container image for the restore jobs")
+	flags.DurationVar(&cfg.JobTimeout, "timeout", 10*time.Minute, "Time allowed for each member's restore job")
+	signatures.addFlags(cmd)
+
+	return cmd
+}
+
+func newStorageRollbackCommand(opts *adminOptions) *cobra.Command {
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:   "rollback STATEFULSET -n NAMESPACE",
+		Short: "Switch a migrated etcd StatefulSet back to its old PVCs",
+		Long: `Switch a migrated etcd StatefulSet back to its old PVCs.
+
+The StatefulSet is scaled to 0 and recreated as it was before "storage
+migrate". Its members come back with the data the old PVCs held when the
+migration started; writes made since are lost. The new PVCs are left in place.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if namespace == "" {
+				return errors.New("--namespace is required")
+			}
+
+			k8sClient, err := opts.client()
+			if err != nil {
+				return err
+			}
+			manager, err := opts.manager()
+			if err != nil {
+				return err
+			}
+
+			orchestrator := restore.NewOrchestrator(k8sClient, logger, manager, restore.Config{DriverName: opts.driverName})
+			if err := orchestrator.RollbackMigration(cmd.Context(), namespace, args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "StatefulSet %s/%s switched back to its old PVCs\n", namespace, args[0])
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the etcd StatefulSet")
+
+	return cmd
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	// imageReferencePattern follows the distribution reference grammar: an optional
+	// registry host and port, a lowercase repository path, an optional tag and an
+	// optional sha256 digest
+	imageReferencePattern = regexp.MustCompile(`^` +
+		`(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?` +
+		`(?:@sha256:[a-f0-9]{64})?$`)
+
+	// driverNamePattern is the CSI plugin name format: at most 63 characters,
+	// alphanumerics, '-', '_' and '.', beginning and ending with an alphanumeric
+	driverNamePattern = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// ValidateConfig checks every setting the driver reads and reports all problems at once
+func ValidateConfig(viper *viper.Viper) error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	// CSI Configuration
+	check("csi-endpoint", validateEndpoint(viper.GetString("csi-endpoint")))
+	check("driver-name", validateDriverName(viper.GetString("driver-name")))
+	check("kubeconfig", validateOptionalFile(viper.GetString("kubeconfig")))
+
+	// ETCD Configuration
+	check("etcd-namespace", validateDNSLabel(viper.GetString("etcd-namespace")))
+	check("cluster-label-key", validateLabelKey(viper.GetString("cluster-label-key")))
+
+	// Snapshot Configuration
+	check("snapshot-timeout", validatePositiveDuration(viper.GetString("snapshot-timeout")))
+	check("job-backoff-limit", validateInt(viper.GetString("job-backoff-limit"), 0))
+	check("job-active-deadline", validateInt(viper.GetString("job-active-deadline"), 1))
+	check("default-storage-class", validateOptionalDNSSubdomain(viper.GetString("default-storage-class")))
+	check("snapshot-pvc-size", validateQuantity(viper.GetString("snapshot-pvc-size")))
+	_, err := snapshot.ParseCodec(viper.GetString("snapshot-compression"))
+	check("snapshot-compression", err)
+	// Companion Secrets are only ever stored encrypted
+	if selector := viper.GetString("snapshot-bundle-companion-selector"); selector != "" {
+		switch {
+		case !viper.GetBool("snapshot-bundle-enabled"):
+			check("snapshot-bundle-companion-selector", errors.New("requires snapshot bundles to be enabled"))
+		case viper.GetString("snapshot-encryption-key-provider") == "":
+			check("snapshot-bundle-companion-selector", errors.New("requires snapshot encryption to be enabled"))
+		default:
+			check("snapshot-bundle-companion-selector", validateLabelSelector(selector))
+		}
+	}
+	_, err = etcd.ParseMemberSelection(viper.GetString("snapshot-member-selection"))
+	check("snapshot-member-selection", err)
+	_, err = snapshot.ParseStorageMode(viper.GetString("snapshot-storage-mode"))
+	check("snapshot-storage-mode", err)
+	check("volume-snapshot-class", validateOptionalDNSSubdomain(viper.GetString("volume-snapshot-class")))
+
+	// Snapshot Tiering
+	coldAfter, err := time.ParseDuration(viper.GetString("snapshot-tiering-cold-after"))
+	switch {
+	case err != nil:
+		check("snapshot-tiering-cold-after", fmt.Errorf("invalid duration %q, expected a value such as 24h", viper.GetString("snapshot-tiering-cold-after")))
+	case coldAfter < 0:
+		check("snapshot-tiering-cold-after", fmt.Errorf("must not be negative, got %s", coldAfter))
+	case coldAfter > 0:
+		check("snapshot-tiering-interval", validatePositiveDuration(viper.GetString("snapshot-tiering-interval")))
+		check("object-store-endpoint", validateObjectStoreEndpoint(viper.GetString("object-store-endpoint")))
+		if viper.GetString("object-store-bucket") == "" {
+			check("object-store-bucket", errors.New("required when snapshot tiering is enabled"))
+		}
+		check("object-store-secret-name", validateDNSSubdomain(viper.GetString("object-store-secret-name")))
+	}
+
+	// Snapshot Replication
+	check("replication-targets", validateReplicationTargets(viper.GetStringSlice("replication-targets")))
+	_, err = snapshot.ParseReplicationReadiness(viper.GetString("replication-readiness"))
+	check("replication-readiness", err)
+	if len(viper.GetStringSlice("replication-targets")) > 0 {
+		check("replication-interval", validatePositiveDuration(viper.GetString("replication-interval")))
+	}
+
+	// Snapshot Encryption
+	switch provider := viper.GetString("snapshot-encryption-key-provider"); provider {
+	case "":
+	case "secret", "file":
+		if viper.GetString("snapshot-encryption-key-id") == "" {
+			check("snapshot-encryption-key-id", errors.New("required when snapshot encryption is enabled"))
+		}
+		if provider == "secret" {
+			check("snapshot-encryption-key-secret-name", validateDNSSubdomain(viper.GetString("snapshot-encryption-key-secret-name")))
+			check("snapshot-encryption-key-secret-namespace", validateDNSLabel(viper.GetString("snapshot-encryption-key-secret-namespace")))
+		} else {
+			check("snapshot-encryption-key-dir", validateAbsolutePath(viper.GetString("snapshot-encryption-key-dir")))
+		}
+	default:
+		check("snapshot-encryption-key-provider", fmt.Errorf("unsupported provider %q (supported: secret, file)", provider))
+	}
+
+	// Snapshot Signing
+	if viper.GetBool("snapshot-signing-enabled") {
+		check("snapshot-signing-key-secret-name", validateDNSSubdomain(viper.GetString("snapshot-signing-key-secret-name")))
+		check("snapshot-signing-key-secret-namespace", validateDNSLabel(viper.GetString("snapshot-signing-key-secret-namespace")))
+	} else if viper.GetBool("snapshot-signing-strict") {
+		check("snapshot-signing-strict", errors.New("requires snapshot signing to be enabled"))
+	}
+
+	// ETCD TLS Configuration
+	if viper.GetBool("etcd-tls-enabled") {
+		check("etcd-tls-secret-name", validateDNSSubdomain(viper.GetString("etcd-tls-secret-name")))
+		check("etcd-client-cert-path", validateAbsolutePath(viper.GetString("etcd-client-cert-path")))
+		check("etcd-client-key-path", validateAbsolutePath(viper.GetString("etcd-client-key-path")))
+		check("etcd-ca-path", validateAbsolutePath(viper.GetString("etcd-ca-path")))
+	}
+	if namespace := viper.GetString("etcd-tls-secret-namespace"); namespace != "" {
+		check("etcd-tls-secret-namespace", validateDNSLabel(namespace))
+	}
+
+	// Container Images
+	check("etcd-image", validateImage(viper.GetString("etcd-image")))
+	check("etcd-image-matrix", validateImageMatrix(viper.GetStringSlice("etcd-image-matrix")))
+	check("busybox-image", validateImage(viper.GetString("busybox-image")))
+	check("agent-image", validateImage(viper.GetString("agent-image")))
+
+	// Restore Drills
+	check("restore-drill-min-keys", validateInt(viper.GetString("restore-drill-min-keys"), 0))
+
+	// High Availability
+	if viper.GetBool("leader-elect") {
+		check("leader-election-namespace", validateDNSLabel(viper.GetString("leader-election-namespace")))
+	}
+
+	// Observability
+	check("metrics-bind-address", validateListenAddress(viper.GetString("metrics-bind-address")))
+	check("log-level", validateOneOf(viper.GetString("log-level"), "debug", "info", "warn", "error"))
+	check("log-format", validateOneOf(viper.GetString("log-format"), "json", "console"))
+
+	return errors.Join(errs...)
+}
+
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		// unix:///path parses into Path, unix://path into Host and Path
+		path := u.Host + u.Path
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("unix endpoint %q must use an absolute socket path, e.g. unix:///csi/csi.sock", endpoint)
+		}
+	case "tcp":
+		if err := validateListenAddress(u.Host); err != nil {
+			return fmt.Errorf("tcp endpoint %q: %w", endpoint, err)
+		}
+	default:
+		return fmt.Errorf("unsupported endpoint %q, expected unix:///path/to/csi.sock or tcp://host:port", endpoint)
+	}
+	return nil
+}
+
+func validateListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q, expected host:port", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q in address %q", port, address)
+	}
+	return nil
+}
+
+func validateDriverName(name string) error {
+	if !driverNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid driver name %q: must be at most 63 characters of alphanumerics, '-', '_' or '.', beginning and ending with an alphanumeric", name)
+	}
+	return nil
+}
+
+func validateImage(image string) error {
+	if image == "" {
+		return errors.New("must not be empty")
+	}
+	if !imageReferencePattern.MatchString(image) {
+		return fmt.Errorf("invalid image reference %q, expected [registry/]repository[:tag][@sha256:digest]", image)
+	}
+	return nil
+}
+
+func validateImageMatrix(entries []string) error {
+	matrix, err := job.ParseImageMatrix(entries)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, version := range slices.Sorted(maps.Keys(matrix)) {
+		if err := validateImage(matrix[version]); err != nil {
+			errs = append(errs, fmt.Errorf("version %s: %w", version, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateReplicationTargets(specs []string) error {
+	targets, err := snapshot.ParseReplicationTargets(specs)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, t := range targets {
+		if err := validateDNSLabel(t.Name); err != nil {
+			errs = append(errs, fmt.Errorf("target name %q: %w", t.Name, err))
+		}
+		if err := validateDNSSubdomain(t.SecretName); err != nil {
+			errs = append(errs, fmt.Errorf("secret of target %s: %w", t.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateObjectStoreEndpoint accepts a host[:port]; whether to use TLS is set separately
+func validateObjectStoreEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return errors.New("required when snapshot tiering is enabled")
+	}
+	if strings.Contains(endpoint, "://") || strings.Contains(endpoint, "/") {
+		return fmt.Errorf("must be a host[:port] without a scheme or path, got %q", endpoint)
+	}
+	return nil
+}
+
+func validatePositiveDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value such as 30s or 5m", value)
+	}
+	if d <= 0 {
+		return fmt.Errorf("must be positive, got %s", d)
+	}
+	return nil
+}
+
+func validateInt(value string, min int64) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	if n < min {
+		return fmt.Errorf("must be at least %d, got %d", min, n)
+	}
+	return nil
+}
+
+func validateQuantity(value string) error {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("invalid quantity %q, expected a value such as 10Gi", value)
+	}
+	if q.Sign() <= 0 {
+		return fmt.Errorf("must be positive, got %s", value)
+	}
+	return nil
+}
+
+func validateAbsolutePath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("must be an absolute path, got %q", path)
+	}
+	return nil
+}
+
+func validateOptionalFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %q: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%q is a directory", path)
+	}
+	return nil
+}
+
+func validateDNSLabel(value string) error {
+	if problems := validation.IsDNS1123Label(value); len(problems) > 0 {
+		return fmt.Errorf("invalid name %q: %s", value, problems[0])
+	}
+	return nil
+}
+
+func validateDNSSubdomain(value string) error {
+	if problems := validation.IsDNS1123Subdomain(value); len(problems) > 0 {
+		return fmt.Errorf("invalid name %q: %s", value, problems[0])
+	}
+	return nil
+}
+
+// validateOptionalDNSSubdomain allows an empty value, which means the cluster default
+func validateOptionalDNSSubdomain(value string) error {
+	if value == "" {
+		return nil
+	}
+	return validateDNSSubdomain(value)
+}
+
+func validateLabelKey(value string) error {
+	if problems := validation.IsQualifiedName(value); len(problems) > 0 {
+		return fmt.Errorf("invalid label key %q: %s", value, problems[0])
+	}
+	return nil
+}
+
+func validateLabelSelector(value string) error {
+	if _, err := labels.Parse(value); err != nil {
+		return fmt.Errorf("invalid label selector %q: %w", value, err)
+	}
+	return nil
+}
+
+func validateOneOf(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported value %q (supported: %s)", value, strings.Join(allowed, ", "))
+}
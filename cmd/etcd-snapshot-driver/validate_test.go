@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfigDefaults(t *testing.T) {
+	cmd := &cobra.Command{}
+	v, err := SetupViper(cmd)
+	require.NoError(t, err)
+
+	assert.NoError(t, ValidateConfig(v))
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	cmd := &cobra.Command{}
+	v, err := SetupViper(cmd)
+	require.NoError(t, err)
+
+	v.Set("csi-endpoint", "http://localhost:10000")
+	v.Set("driver-name", "-bad-name-")
+	v.Set("snapshot-timeout", "300")
+	v.Set("job-backoff-limit", "-1")
+	v.Set("job-active-deadline", "0")
+	v.Set("snapshot-pvc-size", "ten gigs")
+	v.Set("snapshot-bundle-enabled", true)
+	v.Set("snapshot-bundle-companion-selector", "etcd.io/companion in (")
+	v.Set("snapshot-member-selection", "random")
+	v.Set("snapshot-storage-mode", "per-snapshot")
+	v.Set("snapshot-tiering-cold-after", "24h")
+	v.Set("object-store-endpoint", "https://s3.example.com")
+	v.Set("replication-targets", []string{"dr=s3.example.com/dr"})
+	v.Set("replication-readiness", "eventually")
+	v.Set("snapshot-signing-enabled", true)
+	v.Set("snapshot-signing-key-secret-name", "Signing_Key")
+	v.Set("etcd-image", "Quay.io/CoreOS/ETCD:v3.5.0")
+	v.Set("etcd-image-matrix", []string{"3.5"})
+	v.Set("etcd-ca-path", "ca.crt")
+	v.Set("log-level", "verbose")
+
+	err = ValidateConfig(v)
+	require.Error(t, err)
+
+	for _, key := range []string{
+		"csi-endpoint",
+		"driver-name",
+		"snapshot-timeout",
+		"job-backoff-limit",
+		"job-active-deadline",
+		"snapshot-pvc-size",
+		"snapshot-bundle-companion-selector",
+		"snapshot-member-selection",
+		"snapshot-storage-mode",
+		"object-store-endpoint",
+		"object-store-bucket",
+		"replication-targets",
+		"replication-readiness",
+		"snapshot-signing-key-secret-name",
+		"etcd-image",
+		"etcd-image-matrix",
+		"etcd-ca-path",
+		"log-level",
+	} {
+		assert.Contains(t, err.Error(), key+":")
+	}
+}
+
+func TestValidateBundleCompanionSelector(t *testing.T) {
+	cmd := &cobra.Command{}
+	v, err := SetupViper(cmd)
+	require.NoError(t, err)
+
+	v.Set("snapshot-bundle-companion-selector", "etcd-snapshot.io/companion=true")
+	assert.ErrorContains(t, ValidateConfig(v), "requires snapshot bundles to be enabled")
+
+	// Companion Secrets would otherwise be stored in the clear
+	v.Set("snapshot-bundle-enabled", true)
+	assert.ErrorContains(t, ValidateConfig(v), "requires snapshot encryption to be enabled")
+
+	v.Set("snapshot-encryption-key-provider", "file")
+	v.Set("snapshot-encryption-key-id", "key-1")
+	assert.NoError(t, ValidateConfig(v))
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{endpoint: "unix:///var/lib/kubelet/plugins/etcd-snapshot-driver/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000"},
+		{endpoint: "tcp://:10000"},
+		{endpoint: "unix://relative.sock", wantErr: true},
+		{endpoint: "tcp://127.0.0.1", wantErr: true},
+		{endpoint: "/var/run/csi.sock", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			err := validateEndpoint(tt.endpoint)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	valid := []string{
+		"busybox",
+		"busybox:1.35",
+		"quay.io/coreos/etcd:v3.5.0",
+		"localhost:5000/etcd-snapshot-driver:latest",
+		"registry.k8s.io/etcd@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	}
+	for _, image := range valid {
+		assert.NoError(t, validateImage(image), image)
+	}
+
+	invalid := []string{"", "Busybox", "quay.io/etcd:", "etcd:v1:v2", "etcd@sha256:short"}
+	for _, image := range invalid {
+		assert.Error(t, validateImage(image), image)
+	}
+}
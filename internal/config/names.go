@@ -0,0 +1,95 @@
+package config
+
+import "strings"
+
+// DefaultDriverName is the CSI driver name used when none is configured
+const DefaultDriverName = "etcd-snapshot-driver"
+
+// Names derives the names of the Kubernetes objects owned by a driver instance from
+// its CSI driver name, so several instances can share a cluster without touching each
+// other's Jobs, metadata or snapshot PVCs. The default driver name keeps the object
+// names used before the driver name was configurable.
+type Names struct {
+	driverName string
+}
+
+// NewNames returns the object names for the given driver name; empty means the default
+func NewNames(driverName string) Names {
+	if driverName == "" {
+		driverName = DefaultDriverName
+	}
+	return Names{driverName: driverName}
+}
+
+// DriverName returns the CSI driver name
+func (n Names) DriverName() string {
+	return n.driverName
+}
+
+// AppLabel returns the value of the "app" label on objects created by the driver
+func (n Names) AppLabel() string {
+	return n.driverName
+}
+
+// SnapshotMetadataConfigMap returns the name of the ConfigMap holding snapshot metadata
+func (n Names) SnapshotMetadataConfigMap() string {
+	if n.isDefault() {
+		return "etcd-snapshot-metadata"
+	}
+	return n.prefix() + "-snapshot-metadata"
+}
+
+// GroupSnapshotMetadataConfigMap returns the name of the ConfigMap holding group snapshot metadata
+func (n Names) GroupSnapshotMetadataConfigMap() string {
+	if n.isDefault() {
+		return "etcd-group-snapshot-metadata"
+	}
+	return n.prefix() + "-group-snapshot-metadata"
+}
+
+// SnapshotPVC returns the name of the PVC snapshots are stored on
+func (n Names) SnapshotPVC() string {
+	if n.isDefault() {
+		return "etcd-snapshots"
+	}
+	return n.prefix() + "-snapshots"
+}
+
+// SnapshotVolume returns the name of the PVC a snapshot is written to when every snapshot
+// gets a PVC of its own, and of the VolumeSnapshot it is then kept in
+func (n Names) SnapshotVolume(snapshotID string) string {
+	return n.SnapshotPVC() + "-" + snapshotID
+}
+
+// LeaderElectionLock returns the name of the Lease used for leader election
+func (n Names) LeaderElectionLock() string {
+	return n.prefix() + "-leader"
+}
+
+// PreMigrationAnnotation returns the annotation that keeps a StatefulSet as it was
+// before its volumes were migrated, so the migration can be rolled back
+func (n Names) PreMigrationAnnotation() string {
+	return n.prefix() + "/pre-migration-statefulset"
+}
+
+// LegalHoldAnnotation returns the VolumeGroupSnapshotContent annotation that puts its
+// snapshots under legal hold ("true") or releases them ("false")
+func (n Names) LegalHoldAnnotation() string {
+	return n.prefix() + "/legal-hold"
+}
+
+// LockedUntilAnnotation returns the VolumeGroupSnapshotContent annotation that locks its
+// snapshots until an RFC 3339 time
+func (n Names) LockedUntilAnnotation() string {
+	return n.prefix() + "/locked-until"
+}
+
+func (n Names) isDefault() bool {
+	return n.driverName == DefaultDriverName
+}
+
+// prefix turns the driver name into a valid object name prefix; CSI driver names
+// may contain upper case letters and underscores, object names may not
+func (n Names) prefix() string {
+	return strings.ReplaceAll(strings.ToLower(n.driverName), "_", "-")
+}
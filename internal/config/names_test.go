@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamesDefaultDriverKeepsLegacyNames(t *testing.T) {
+	for _, driverName := range []string{"", DefaultDriverName} {
+		n := NewNames(driverName)
+		assert.Equal(t, DefaultDriverName, n.DriverName())
+		assert.Equal(t, "etcd-snapshot-driver", n.AppLabel())
+		assert.Equal(t, "etcd-snapshot-metadata", n.SnapshotMetadataConfigMap())
+		assert.Equal(t, "etcd-group-snapshot-metadata", n.GroupSnapshotMetadataConfigMap())
+		assert.Equal(t, "etcd-snapshots", n.SnapshotPVC())
+		assert.Equal(t, "etcd-snapshot-driver-leader", n.LeaderElectionLock())
+		assert.Equal(t, "etcd-snapshot-driver/pre-migration-statefulset", n.PreMigrationAnnotation())
+		assert.Equal(t, "etcd-snapshot-driver/legal-hold", n.LegalHoldAnnotation())
+		assert.Equal(t, "etcd-snapshot-driver/locked-until", n.LockedUntilAnnotation())
+	}
+}
+
+func TestNamesCustomDriver(t *testing.T) {
+	n := NewNames("HyperShift_etcd.example.com")
+	assert.Equal(t, "HyperShift_etcd.example.com", n.AppLabel())
+	assert.Equal(t, "hypershift-etcd.example.com-snapshot-metadata", n.SnapshotMetadataConfigMap())
+	assert.Equal(t, "hypershift-etcd.example.com-group-snapshot-metadata", n.GroupSnapshotMetadataConfigMap())
+	assert.Equal(t, "hypershift-etcd.example.com-snapshots", n.SnapshotPVC())
+	assert.Equal(t, "hypershift-etcd.example.com-leader", n.LeaderElectionLock())
+	assert.Equal(t, "hypershift-etcd.example.com/pre-migration-statefulset", n.PreMigrationAnnotation())
+}
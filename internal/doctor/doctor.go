@@ -0,0 +1,443 @@
+// Package doctor checks whether a cluster is ready for etcd group snapshots
+// and explains how to fix what is not.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of a single check with a hint on how to fix it
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Failed reports whether any result failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// groupSnapshotGroupVersion serves the VolumeGroupSnapshot API the driver is used through
+	groupSnapshotGroupVersion = "groupsnapshot.storage.k8s.io/v1beta2"
+
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+)
+
+// Config mirrors the driver settings the checks depend on
+type Config struct {
+	DriverName        string
+	ClusterLabelKey   string
+	MetadataNamespace string
+	StorageClass      string
+	TLSEnabled        bool
+	TLSSecretName     string
+	SkipHealth        bool
+}
+
+// Doctor runs read-only checks against a cluster
+type Doctor struct {
+	k8sClient kubernetes.Interface
+	logger    *zap.SugaredLogger
+	cfg       Config
+	names     config.Names
+	discovery *etcd.Discovery
+
+	// validateHealth is Discovery.ValidateClusterHealth; replaced in tests
+	validateHealth func(ctx context.Context, cluster *etcd.ClusterInfo) error
+}
+
+func NewDoctor(k8sClient kubernetes.Interface, logger *zap.SugaredLogger, cfg Config) *Doctor {
+	discovery := etcd.NewDiscovery(k8sClient, logger, cfg.ClusterLabelKey)
+
+	return &Doctor{
+		k8sClient:      k8sClient,
+		logger:         logger,
+		cfg:            cfg,
+		names:          config.NewNames(cfg.DriverName),
+		discovery:      discovery,
+		validateHealth: discovery.ValidateClusterHealth,
+	}
+}
+
+// CheckPVC checks everything a group snapshot of the given etcd data PVC needs
+func (d *Doctor) CheckPVC(ctx context.Context, namespace, name string) []Result {
+	results := d.checkNamespace(ctx, namespace)
+	return append(results, d.checkCluster(ctx, namespace, name)...)
+}
+
+// CheckNamespace checks every etcd cluster whose data PVCs carry the cluster label in the namespace
+func (d *Doctor) CheckNamespace(ctx context.Context, namespace string) []Result {
+	results := d.checkNamespace(ctx, namespace)
+
+	pvcs, err := d.k8sClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: d.cfg.ClusterLabelKey,
+	})
+	if err != nil {
+		return append(results, Result{
+			Check:   "cluster-label",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("listing PVCs in %s: %v", namespace, err),
+			Hint:    "check that the namespace exists and that you may list PVCs in it",
+		})
+	}
+	if len(pvcs.Items) == 0 {
+		return append(results, Result{
+			Check:   "cluster-label",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("no PVCs in %s carry the %s label", namespace, d.cfg.ClusterLabelKey),
+			Hint:    fmt.Sprintf("label each etcd data PVC: kubectl label pvc -n %s <pvc> %s=<cluster-name>", namespace, d.cfg.ClusterLabelKey),
+		})
+	}
+
+	// One PVC per cluster is enough; discovery resolves the same members for all of them
+	seen := make(map[string]bool)
+	sort.Slice(pvcs.Items, func(i, j int) bool { return pvcs.Items[i].Name < pvcs.Items[j].Name })
+	for _, pvc := range pvcs.Items {
+		cluster := pvc.Labels[d.cfg.ClusterLabelKey]
+		if seen[cluster] {
+			continue
+		}
+		seen[cluster] = true
+		results = append(results, d.checkCluster(ctx, namespace, pvc.Name)...)
+	}
+
+	return results
+}
+
+// checkNamespace runs the checks that do not depend on a particular etcd cluster
+func (d *Doctor) checkNamespace(ctx context.Context, namespace string) []Result {
+	results := []Result{
+		d.checkCRDs(),
+		d.checkRBAC(ctx, namespace),
+		d.checkServiceAccount(ctx, namespace),
+	}
+	if d.cfg.TLSEnabled {
+		results = append(results, d.checkTLSSecret(ctx, namespace))
+	}
+	return append(results, d.checkStorageClass(ctx, namespace))
+}
+
+// checkCluster dry-runs the discovery and health validation of a snapshot request
+func (d *Doctor) checkCluster(ctx context.Context, namespace, pvcName string) []Result {
+	check := fmt.Sprintf("discovery %s/%s", namespace, pvcName)
+
+	info, err := d.discovery.DiscoverCluster(ctx, namespace, pvcName)
+	if err != nil {
+		hint := fmt.Sprintf("label the PVC and the etcd pods with %s=<cluster-name>", d.cfg.ClusterLabelKey)
+		if apierrors.IsNotFound(err) {
+			hint = "check the PVC name and namespace"
+		}
+		return []Result{{Check: check, Status: StatusFail, Message: err.Error(), Hint: hint}}
+	}
+
+	results := []Result{{
+		Check:   check,
+		Status:  StatusPass,
+		Message: fmt.Sprintf("cluster %s with %d member(s): %s", info.Name, len(info.Endpoints), strings.Join(info.Endpoints, ", ")),
+	}}
+
+	check = fmt.Sprintf("health %s", info.Name)
+	switch {
+	case d.cfg.SkipHealth:
+		results = append(results, Result{
+			Check:   check,
+			Status:  StatusWarn,
+			Message: "skipped",
+			Hint:    "run without --skip-health from inside the cluster to check that etcd is reachable",
+		})
+	case !info.HasQuorum:
+		results = append(results, Result{
+			Check:   check,
+			Status:  StatusWarn,
+			Message: fmt.Sprintf("only %d member(s) found; fewer than 3 cannot tolerate a member failure", len(info.Endpoints)),
+			Hint:    "check that every etcd pod carries the cluster label",
+		})
+		fallthrough
+	default:
+		if err := d.validateHealth(ctx, info); err != nil {
+			results = append(results, Result{
+				Check:   check,
+				Status:  StatusFail,
+				Message: err.Error(),
+				Hint:    "check that etcd is running and that its client port 2379 is reachable from the driver",
+			})
+		} else {
+			results = append(results, Result{Check: check, Status: StatusPass, Message: "etcd is healthy"})
+		}
+	}
+
+	return results
+}
+
+func (d *Doctor) checkCRDs() Result {
+	const check = "volume-group-snapshot-crds"
+
+	resources, err := d.k8sClient.Discovery().ServerResourcesForGroupVersion(groupSnapshotGroupVersion)
+	if err != nil {
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%s is not served: %v", groupSnapshotGroupVersion, err),
+			Hint:    "install the VolumeGroupSnapshot CRDs and the snapshot controller from kubernetes-csi/external-snapshotter",
+		}
+	}
+
+	served := make(map[string]bool)
+	for _, r := range resources.APIResources {
+		served[r.Name] = true
+	}
+	var missing []string
+	for _, name := range []string{"volumegroupsnapshots", "volumegroupsnapshotcontents", "volumegroupsnapshotclasses"} {
+		if !served[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%s is missing %s", groupSnapshotGroupVersion, strings.Join(missing, ", ")),
+			Hint:    "install the VolumeGroupSnapshot CRDs and the snapshot controller from kubernetes-csi/external-snapshotter",
+		}
+	}
+
+	return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf("%s is served", groupSnapshotGroupVersion)}
+}
+
+// permission is an access the driver needs to take a snapshot
+type permission struct {
+	namespace   string
+	group       string
+	resource    string
+	subresource string
+	verb        string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	return fmt.Sprintf("%s %s in %s", p.verb, resource, p.namespace)
+}
+
+// checkRBAC asks the API server whether the caller may do what the driver does
+func (d *Doctor) checkRBAC(ctx context.Context, namespace string) Result {
+	const check = "rbac"
+
+	permissions := []permission{
+		{namespace: namespace, resource: "persistentvolumeclaims", verb: "get"},
+		{namespace: namespace, resource: "persistentvolumeclaims", verb: "create"},
+		{namespace: namespace, resource: "pods", verb: "list"},
+		{namespace: namespace, resource: "pods", subresource: "log", verb: "get"},
+		{namespace: namespace, group: "batch", resource: "jobs", verb: "create"},
+		{namespace: namespace, group: "batch", resource: "jobs", verb: "get"},
+		{namespace: namespace, group: "batch", resource: "jobs", verb: "delete"},
+		{namespace: namespace, resource: "secrets", verb: "get"},
+		{namespace: namespace, resource: "events", verb: "create"},
+		{namespace: d.cfg.MetadataNamespace, resource: "configmaps", verb: "get"},
+		{namespace: d.cfg.MetadataNamespace, resource: "configmaps", verb: "create"},
+		{namespace: d.cfg.MetadataNamespace, resource: "configmaps", verb: "update"},
+	}
+
+	var denied []string
+	for _, p := range permissions {
+		review, err := d.k8sClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.namespace,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+					Verb:        p.verb,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return Result{
+				Check:   check,
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("could not review access: %v", err),
+				Hint:    "allow the caller to create selfsubjectaccessreviews.authorization.k8s.io",
+			}
+		}
+		if !review.Status.Allowed {
+			denied = append(denied, p.String())
+		}
+	}
+
+	if len(denied) > 0 {
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: "denied: " + strings.Join(denied, "; "),
+			Hint:    "bind the ClusterRole from deploy/base/rbac.yaml to the driver's ServiceAccount, and run doctor with --as=system:serviceaccount:<namespace>:<driver-service-account>",
+		}
+	}
+
+	return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf("all %d permissions granted", len(permissions))}
+}
+
+func (d *Doctor) checkServiceAccount(ctx context.Context, namespace string) Result {
+	const check = "executor-service-account"
+
+	_, err := d.k8sClient.CoreV1().ServiceAccounts(namespace).Get(ctx, job.ExecutorServiceAccount, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("ServiceAccount %s/%s not found; snapshot job pods cannot be created", namespace, job.ExecutorServiceAccount),
+			Hint:    fmt.Sprintf("kubectl create serviceaccount -n %s %s", namespace, job.ExecutorServiceAccount),
+		}
+	case err != nil:
+		return Result{Check: check, Status: StatusWarn, Message: err.Error()}
+	}
+
+	return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf("ServiceAccount %s/%s exists", namespace, job.ExecutorServiceAccount)}
+}
+
+// checkTLSSecret checks the Secret items the snapshot save job mounts
+func (d *Doctor) checkTLSSecret(ctx context.Context, namespace string) Result {
+	const check = "etcd-tls-secret"
+
+	secret, err := d.k8sClient.CoreV1().Secrets(namespace).Get(ctx, d.cfg.TLSSecretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("Secret %s/%s not found", namespace, d.cfg.TLSSecretName),
+			Hint: fmt.Sprintf("kubectl create secret generic -n %s %s --from-file=%s=<cert> --from-file=%s=<key> --from-file=%s=<ca>",
+				namespace, d.cfg.TLSSecretName, job.TLSClientCertKey, job.TLSClientKeyKey, job.TLSCAKey),
+		}
+	case err != nil:
+		return Result{Check: check, Status: StatusWarn, Message: err.Error()}
+	}
+
+	var missing []string
+	for _, key := range []string{job.TLSClientCertKey, job.TLSClientKeyKey, job.TLSCAKey} {
+		if len(secret.Data[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("Secret %s/%s is missing %s", namespace, d.cfg.TLSSecretName, strings.Join(missing, ", ")),
+			Hint:    "add the missing keys; snapshot jobs mount them by these exact names",
+		}
+	}
+
+	return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf("Secret %s/%s has every key snapshot jobs mount", namespace, d.cfg.TLSSecretName)}
+}
+
+// checkStorageClass checks the snapshot PVC, or the storage class it will be created with
+func (d *Doctor) checkStorageClass(ctx context.Context, namespace string) Result {
+	const check = "snapshot-storage"
+	pvcName := d.names.SnapshotPVC()
+
+	pvc, err := d.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return Result{Check: check, Status: StatusWarn, Message: err.Error()}
+	}
+
+	if err == nil {
+		if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+			if res, ok := d.checkStorageClassExists(ctx, check, *pvc.Spec.StorageClassName); !ok {
+				return res
+			}
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return Result{
+				Check:   check,
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("snapshot PVC %s/%s is %s", namespace, pvcName, pvc.Status.Phase),
+				Hint:    fmt.Sprintf("kubectl describe pvc -n %s %s", namespace, pvcName),
+			}
+		}
+		return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf("snapshot PVC %s/%s is bound", namespace, pvcName)}
+	}
+
+	// The driver creates the PVC on the first snapshot
+	if d.cfg.StorageClass != "" {
+		if res, ok := d.checkStorageClassExists(ctx, check, d.cfg.StorageClass); !ok {
+			return res
+		}
+		return Result{
+			Check:   check,
+			Status:  StatusPass,
+			Message: fmt.Sprintf("snapshot PVC %s/%s will be created with storage class %s", namespace, pvcName, d.cfg.StorageClass),
+		}
+	}
+
+	classes, err := d.k8sClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Result{Check: check, Status: StatusWarn, Message: fmt.Sprintf("listing storage classes: %v", err)}
+	}
+	for _, sc := range classes.Items {
+		if sc.Annotations[defaultStorageClassAnnotation] == "true" {
+			return Result{
+				Check:   check,
+				Status:  StatusPass,
+				Message: fmt.Sprintf("snapshot PVC %s/%s will be created with the default storage class %s", namespace, pvcName, sc.Name),
+			}
+		}
+	}
+	return Result{
+		Check:   check,
+		Status:  StatusFail,
+		Message: "no storage class is configured and the cluster has no default storage class",
+		Hint:    "set --default-storage-class on the driver or mark a storage class as the cluster default",
+	}
+}
+
+func (d *Doctor) checkStorageClassExists(ctx context.Context, check, name string) (Result, bool) {
+	_, err := d.k8sClient.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return Result{
+			Check:   check,
+			Status:  StatusFail,
+			Message: fmt.Sprintf("storage class %s not found", name),
+			Hint:    "set --default-storage-class on the driver to an existing storage class",
+		}, false
+	case err != nil:
+		return Result{Check: check, Status: StatusWarn, Message: err.Error()}, false
+	}
+	return Result{}, true
+}
@@ -0,0 +1,227 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "etcd"
+
+func testConfig() Config {
+	return Config{
+		DriverName:        "etcd-snapshot-driver",
+		ClusterLabelKey:   "etcd.io/cluster",
+		MetadataNamespace: "kube-system",
+		StorageClass:      "standard",
+		TLSEnabled:        true,
+		TLSSecretName:     "etcd-client-tls",
+	}
+}
+
+// healthyCluster returns objects for a cluster that passes every check
+func healthyCluster() []runtime.Object {
+	objects := []runtime.Object{
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "data-etcd-0", Namespace: testNamespace, Labels: map[string]string{"etcd.io/cluster": "main"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: job.ExecutorServiceAccount, Namespace: testNamespace}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-client-tls", Namespace: testNamespace},
+			Data: map[string][]byte{
+				job.TLSClientCertKey: []byte("cert"),
+				job.TLSClientKeyKey:  []byte("key"),
+				job.TLSCAKey:         []byte("ca"),
+			},
+		},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "standard"}},
+	}
+	for _, name := range []string{"etcd-0", "etcd-1", "etcd-2"} {
+		objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: testNamespace, Labels: map[string]string{"etcd.io/cluster": "main"},
+		}})
+	}
+	return objects
+}
+
+func newTestDoctor(t *testing.T, cfg Config, allowed bool, objects ...runtime.Object) (*Doctor, *fake.Clientset) {
+	t.Helper()
+
+	k8sClient := fake.NewSimpleClientset(objects...)
+	k8sClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: groupSnapshotGroupVersion,
+		APIResources: []metav1.APIResource{
+			{Name: "volumegroupsnapshots"},
+			{Name: "volumegroupsnapshotcontents"},
+			{Name: "volumegroupsnapshotclasses"},
+		},
+	}}
+	k8sClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = allowed
+		return true, review, nil
+	})
+
+	d := NewDoctor(k8sClient, zap.NewNop().Sugar(), cfg)
+	d.validateHealth = func(context.Context, *etcd.ClusterInfo) error { return nil }
+	return d, k8sClient
+}
+
+func statuses(results []Result) map[string]Status {
+	out := make(map[string]Status, len(results))
+	for _, r := range results {
+		out[r.Check] = r.Status
+	}
+	return out
+}
+
+func TestCheckPVCHealthy(t *testing.T) {
+	d, _ := newTestDoctor(t, testConfig(), true, healthyCluster()...)
+
+	results := d.CheckPVC(context.Background(), testNamespace, "data-etcd-0")
+	for _, r := range results {
+		assert.Equal(t, StatusPass, r.Status, "%s: %s", r.Check, r.Message)
+	}
+	assert.False(t, Failed(results))
+	assert.Contains(t, statuses(results), "health main")
+}
+
+func TestCheckPVCFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Doctor, *fake.Clientset)
+		allowed bool
+		check   string
+		status  Status
+	}{
+		{
+			name:  "missing executor service account",
+			check: "executor-service-account", status: StatusFail, allowed: true,
+			mutate: func(_ *Doctor, c *fake.Clientset) {
+				require.NoError(t, c.CoreV1().ServiceAccounts(testNamespace).Delete(context.Background(), job.ExecutorServiceAccount, metav1.DeleteOptions{}))
+			},
+		},
+		{
+			name:  "missing TLS key",
+			check: "etcd-tls-secret", status: StatusFail, allowed: true,
+			mutate: func(_ *Doctor, c *fake.Clientset) {
+				_, err := c.CoreV1().Secrets(testNamespace).Update(context.Background(), &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "etcd-client-tls", Namespace: testNamespace},
+					Data:       map[string][]byte{job.TLSClientCertKey: []byte("cert")},
+				}, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:  "missing CRDs",
+			check: "volume-group-snapshot-crds", status: StatusFail, allowed: true,
+			mutate: func(_ *Doctor, c *fake.Clientset) {
+				c.Discovery().(*fakediscovery.FakeDiscovery).Resources = nil
+			},
+		},
+		{
+			name:  "RBAC denied",
+			check: "rbac", status: StatusFail, allowed: false,
+		},
+		{
+			name:  "missing storage class",
+			check: "snapshot-storage", status: StatusFail, allowed: true,
+			mutate: func(_ *Doctor, c *fake.Clientset) {
+				require.NoError(t, c.StorageV1().StorageClasses().Delete(context.Background(), "standard", metav1.DeleteOptions{}))
+			},
+		},
+		{
+			name:  "unhealthy etcd",
+			check: "health main", status: StatusFail, allowed: true,
+			mutate: func(d *Doctor, _ *fake.Clientset) {
+				d.validateHealth = func(context.Context, *etcd.ClusterInfo) error { return errors.New("context deadline exceeded") }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, c := newTestDoctor(t, testConfig(), tt.allowed, healthyCluster()...)
+			if tt.mutate != nil {
+				tt.mutate(d, c)
+			}
+
+			results := d.CheckPVC(context.Background(), testNamespace, "data-etcd-0")
+			assert.True(t, Failed(results))
+			for _, r := range results {
+				if r.Check == tt.check {
+					assert.Equal(t, tt.status, r.Status, r.Message)
+					assert.NotEmpty(t, r.Hint)
+					return
+				}
+			}
+			t.Fatalf("no %s result in %v", tt.check, results)
+		})
+	}
+}
+
+func TestCheckPVCMissingLabel(t *testing.T) {
+	objects := append(healthyCluster(), &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "unlabeled", Namespace: testNamespace,
+	}})
+	d, _ := newTestDoctor(t, testConfig(), true, objects...)
+
+	results := d.CheckPVC(context.Background(), testNamespace, "unlabeled")
+	assert.Equal(t, StatusFail, statuses(results)["discovery etcd/unlabeled"])
+}
+
+func TestCheckNamespace(t *testing.T) {
+	objects := append(healthyCluster(), &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "data-etcd-1", Namespace: testNamespace, Labels: map[string]string{"etcd.io/cluster": "main"},
+	}})
+	d, _ := newTestDoctor(t, testConfig(), true, objects...)
+
+	// Both PVCs belong to one cluster, which is checked once
+	results := d.CheckNamespace(context.Background(), testNamespace)
+	got := statuses(results)
+	assert.Equal(t, StatusPass, got["discovery etcd/data-etcd-0"])
+	assert.NotContains(t, got, "discovery etcd/data-etcd-1")
+	assert.False(t, Failed(results))
+
+	d, _ = newTestDoctor(t, testConfig(), true)
+	results = d.CheckNamespace(context.Background(), testNamespace)
+	assert.Equal(t, StatusFail, statuses(results)["cluster-label"])
+}
+
+func TestCheckStorageClassDefault(t *testing.T) {
+	cfg := testConfig()
+	cfg.StorageClass = ""
+	cfg.TLSEnabled = false
+
+	d, c := newTestDoctor(t, cfg, true, healthyCluster()...)
+	assert.Equal(t, StatusFail, d.checkStorageClass(context.Background(), testNamespace).Status)
+
+	_, err := c.StorageV1().StorageClasses().Create(context.Background(), &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+		Name:        "fast",
+		Annotations: map[string]string{defaultStorageClassAnnotation: "true"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPass, d.checkStorageClass(context.Background(), testNamespace).Status)
+
+	// An existing but unbound snapshot PVC is a warning
+	_, err = c.CoreV1().PersistentVolumeClaims(testNamespace).Create(context.Background(), &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: d.names.SnapshotPVC(), Namespace: testNamespace},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusWarn, d.checkStorageClass(context.Background(), testNamespace).Status)
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+)
+
+// Helper function to prepare a job that mounts a snapshot PVC. The snapshot PVC is
+// ReadWriteOnce, so jobs that share it run one at a time, each pinned to the node the
+// PVC is attached to, if any. Jobs of this driver queue up in process first; the PVC Lease
+// then serializes them with the jobs of admin commands and restores. The returned function
+// releases the PVC once the job is done; on error the PVC is not held. Jobs that fetch their
+// snapshot from the object store mount no snapshot PVC and are not held back.
+func (g *GroupControllerServer) prepareSnapshotPVCJob(ctx context.Context, jobConfig *job.JobConfig) (func(), error) {
+	if jobConfig.SnapshotPVCName == "" {
+		return func() {}, nil
+	}
+
+	key := jobConfig.SnapshotPVCNamespace + "/" + jobConfig.SnapshotPVCName
+	lock, _ := g.snapshotPVCLocks.LoadOrStore(key, make(chan struct{}, 1))
+	sem := lock.(chan struct{})
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	unlock, err := g.jobExecutor.LockPVC(ctx, jobConfig.SnapshotPVCNamespace, jobConfig.SnapshotPVCName)
+	if err != nil {
+		<-sem
+		return nil, err
+	}
+	release := func() {
+		unlock()
+		<-sem
+	}
+
+	nodeName, err := g.jobExecutor.AttachmentNode(ctx, jobConfig.SnapshotPVCNamespace, jobConfig.SnapshotPVCName)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	jobConfig.NodeName = nodeName
+
+	return release, nil
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPrepareSnapshotPVCJobSerializesJobs(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	lingering := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-snapshot-drill-snap-0", Namespace: "hcp"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-a",
+			Volumes: []corev1.Volume{{
+				Name: "snapshot-pvc",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: snapshotPVCName},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	server := NewGroupControllerServer(fake.NewSimpleClientset(node, lingering),
+		WithLogger{Logger: zap.NewNop().Sugar()},
+	)
+	newJobConfig := func() *job.JobConfig {
+		return &job.JobConfig{SnapshotPVCName: snapshotPVCName, SnapshotPVCNamespace: "hcp"}
+	}
+
+	first := newJobConfig()
+	release, err := server.prepareSnapshotPVCJob(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", first.NodeName)
+
+	// A second job on the same PVC waits for the first
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = server.prepareSnapshotPVCJob(waitCtx, newJobConfig())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Jobs on other PVCs do not
+	otherRelease, err := server.prepareSnapshotPVCJob(ctx, &job.JobConfig{SnapshotPVCName: snapshotPVCName, SnapshotPVCNamespace: "other"})
+	require.NoError(t, err)
+	otherRelease()
+
+	release()
+	release, err = server.prepareSnapshotPVCJob(ctx, newJobConfig())
+	require.NoError(t, err)
+	release()
+}
@@ -0,0 +1,26 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Helper function to list the companion Secrets captured in the snapshot bundles of the
+// clusters in a namespace. The save job mounts them, so only Secrets in the cluster's own
+// namespace can be selected.
+func (g *GroupControllerServer) companionSecrets(ctx context.Context, namespace, selector string) ([]string, error) {
+	secrets, err := g.k8sClient.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list companion Secrets: %w", err)
+	}
+
+	names := make([]string, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
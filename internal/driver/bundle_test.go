@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCompanionSecrets(t *testing.T) {
+	secret := func(namespace, name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+	}
+	companion := map[string]string{"etcd-snapshot.io/companion": "true"}
+	k8sClient := fake.NewSimpleClientset(
+		secret("hcp", "etcd-ca", companion),
+		secret("hcp", "encryption-config", companion),
+		secret("hcp", "etcd-client-tls", nil),
+		secret("other", "etcd-ca", companion),
+	)
+	server := NewGroupControllerServer(k8sClient)
+
+	names, err := server.companionSecrets(context.Background(), "hcp", "etcd-snapshot.io/companion=true")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"encryption-config", "etcd-ca"}, names)
+}
+
+func TestBundleCompanionsNeedEncryption(t *testing.T) {
+	cfg := &ControllerConfig{
+		SnapshotTimeout:          time.Minute,
+		JobActiveDeadlineSeconds: 600,
+		ETCDImage:                "etcd:v1",
+		BusyboxImage:             "busybox:1",
+		AgentImage:               "agent:1",
+		SnapshotPVCSize:          "10Gi",
+		SnapshotBundle:           true,
+		BundleCompanionSelector:  "etcd-snapshot.io/companion=true",
+	}
+	assert.ErrorContains(t, cfg.Validate(), "need snapshot encryption")
+
+	cfg.EncryptionKeyProvider = encryption.NewSecretKeyProvider(fake.NewSimpleClientset(), "etcd-snapshot-driver", "etcd-snapshot-kek")
+	assert.NoError(t, cfg.Validate())
+}
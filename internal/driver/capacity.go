@@ -0,0 +1,247 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// snapshotHeadroomPercent is added to the estimated size of each snapshot, since the
+// database may grow between the estimate and the save
+const snapshotHeadroomPercent = 10
+
+// pvcExpansionTimeout bounds how long a snapshot waits for its snapshot PVC to be expanded
+const pvcExpansionTimeout = 2 * time.Minute
+
+// pvcExpansionPollInterval is how often an expanding PVC is checked; shortened in tests
+var pvcExpansionPollInterval = 2 * time.Second
+
+// estimateSnapshotSize estimates the space a snapshot of a database of dbSize bytes takes
+// on the snapshot PVC. Raw snapshots are as large as the database, or as the cluster's last
+// snapshot if that was larger. Encoded snapshots are expected to shrink as much as the last
+// snapshot did with the same codec, and are assumed to be as large as the database otherwise.
+func estimateSnapshotSize(dbSize int64, format snapshot.Format, last *snapshot.SnapshotMetadata) int64 {
+	estimate := dbSize
+	switch {
+	case last == nil:
+	case format.IsRaw():
+		estimate = max(estimate, last.Size)
+	case last.Compression == format.Codec && last.Size > 0 && last.UncompressedSize > 0:
+		estimate = int64(float64(dbSize) * float64(last.Size) / float64(last.UncompressedSize))
+	}
+	return estimate + estimate*snapshotHeadroomPercent/100
+}
+
+// lastClusterSnapshot returns the most recent of snapshots taken of a cluster
+func lastClusterSnapshot(snapshots []*snapshot.SnapshotMetadata, namespace, clusterName string) *snapshot.SnapshotMetadata {
+	var last *snapshot.SnapshotMetadata
+	for _, s := range snapshots {
+		if s.Namespace != namespace || s.ClusterName != clusterName {
+			continue
+		}
+		if last == nil || s.CreationTime.After(last.CreationTime) {
+			last = s
+		}
+	}
+	return last
+}
+
+// snapshotPVCUsage adds up the recorded sizes of the snapshots stored on a snapshot PVC
+func snapshotPVCUsage(snapshots []*snapshot.SnapshotMetadata, namespace, pvcName string) int64 {
+	var used int64
+	for _, s := range snapshots {
+		if s.Namespace == namespace && s.PVCName == pvcName {
+			used += s.Size
+		}
+	}
+	return used
+}
+
+// pvcCapacity returns the provisioned size of a PVC, or its requested size before it is bound
+func pvcCapacity(pvc *corev1.PersistentVolumeClaim) resource.Quantity {
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		return capacity
+	}
+	return pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+}
+
+// Helper function to make sure a snapshot PVC has room for snapshots estimated to take
+// needed bytes. A PVC that is too small is expanded when its storage class allows volume
+// expansion, to fit the snapshots twice over so the next round fits as well, and the
+// snapshot waits for the expansion; otherwise ResourceExhausted is returned. Usage is
+// estimated from the recorded snapshot sizes; snapshots stored before sizes were recorded
+// are measured first, by a job named after snapshotID.
+func (g *GroupControllerServer) ensureSnapshotPVCSpace(ctx context.Context, provisioner *snapshot.SnapshotPVCProvisioner, namespace, pvcName, snapshotID string, needed int64, snapshots []*snapshot.SnapshotMetadata, eventTargets []runtime.Object) error {
+	pvc, err := g.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		// The save job reports a full volume itself; not knowing the size is no reason to refuse
+		g.logger.Warnw("Failed to check snapshot PVC capacity", "namespace", namespace, "pvc_name", pvcName, "error", err)
+		return nil
+	}
+
+	if err := g.measureSnapshotSizes(ctx, namespace, pvcName, snapshotID, snapshots); err != nil {
+		g.logger.Warnw("Failed to measure snapshots of unknown size; they are not counted as used space",
+			"namespace", namespace,
+			"pvc_name", pvcName,
+			"error", err,
+		)
+	}
+
+	capacity := pvcCapacity(pvc)
+	used := snapshotPVCUsage(snapshots, namespace, pvcName)
+	available := max(capacity.Value()-used, 0)
+
+	if m := g.config().Metrics; m != nil {
+		m.SnapshotPVCUsage.WithLabelValues(pvcName, namespace).Set(float64(used))
+		m.SnapshotPVCAvailable.WithLabelValues(pvcName, namespace).Set(float64(available))
+	}
+
+	g.logger.Debugw("Checked snapshot PVC capacity",
+		"namespace", namespace,
+		"pvc_name", pvcName,
+		"capacity", capacity.Value(),
+		"used", used,
+		"needed", needed,
+	)
+	if needed <= available {
+		return nil
+	}
+
+	size := roundUpToGiB(used + 2*needed)
+	expanded, err := provisioner.ExpandPVC(ctx, pvc, size)
+	if err != nil {
+		g.logger.Errorw("Failed to expand snapshot PVC", "namespace", namespace, "pvc_name", pvcName, "size", size.String(), "error", err)
+		return status.Errorf(codes.Internal, "failed to expand snapshot PVC %s/%s: %v", namespace, pvcName, err)
+	}
+	if !expanded {
+		g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotPVCFull,
+			"Snapshot PVC %s/%s has about %s free but the snapshot needs about %s, and its storage class does not allow volume expansion",
+			namespace, pvcName, util.HumanBytes(available), util.HumanBytes(needed))
+		return status.Errorf(codes.ResourceExhausted,
+			"snapshot PVC %s/%s has about %s free of %s but the snapshot needs about %s, and its storage class does not allow volume expansion; delete old snapshots or move to a larger PVC",
+			namespace, pvcName, util.HumanBytes(available), capacity.String(), util.HumanBytes(needed))
+	}
+
+	g.recordEvent(eventTargets, corev1.EventTypeNormal, ReasonSnapshotPVCExpanded,
+		"Expanding snapshot PVC %s/%s from %s to %s to make room for the snapshot", namespace, pvcName, capacity.String(), size.String())
+
+	if err := g.waitForPVCExpansion(ctx, namespace, pvcName, size); err != nil {
+		return status.Errorf(codes.Unavailable, "snapshot PVC %s/%s was not expanded to %s: %v", namespace, pvcName, size.String(), err)
+	}
+	return nil
+}
+
+// Helper function to wait until the storage backend has expanded a PVC to size. A pending
+// file system resize counts as done: the node grows the file system when the snapshot job
+// mounts the volume, before its containers start.
+func (g *GroupControllerServer) waitForPVCExpansion(ctx context.Context, namespace, pvcName string, size resource.Quantity) error {
+	return wait.PollUntilContextTimeout(ctx, pvcExpansionPollInterval, pvcExpansionTimeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := g.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(size) >= 0 {
+			return true, nil
+		}
+		for _, condition := range pvc.Status.Conditions {
+			if condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending && condition.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// Helper function to measure the files of the snapshots on a snapshot PVC that were stored
+// before their sizes were recorded, so they count towards its usage. The sizes are recorded
+// in the snapshots' metadata, so each file is only measured once.
+func (g *GroupControllerServer) measureSnapshotSizes(ctx context.Context, namespace, pvcName, snapshotID string, snapshots []*snapshot.SnapshotMetadata) error {
+	unknown := make(map[string]*snapshot.SnapshotMetadata)
+	var fileNames []string
+	for _, s := range snapshots {
+		if s.Namespace != namespace || s.PVCName != pvcName || s.Size > 0 {
+			continue
+		}
+		fileName := s.Format().FileName(s.SnapshotID)
+		unknown[fileName] = s
+		fileNames = append(fileNames, fileName)
+	}
+	if len(fileNames) == 0 {
+		return nil
+	}
+
+	cfg := g.config()
+	jobConfig := &job.JobConfig{
+		DriverName:            cfg.DriverName,
+		SnapshotID:            snapshotID,
+		Namespace:             namespace,
+		SnapshotPVCName:       pvcName,
+		SnapshotPVCNamespace:  namespace,
+		BackoffLimit:          1,
+		ActiveDeadlineSeconds: 120,
+		BusyboxImage:          cfg.BusyboxImage,
+	}
+	release, err := g.prepareSnapshotPVCJob(ctx, jobConfig)
+	if err != nil {
+		return fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
+	}
+	defer release()
+
+	statJob := job.GenerateSnapshotStatJob(jobConfig, fileNames)
+	if _, err := g.jobExecutor.ExecuteSnapshotJob(ctx, statJob, time.Minute); err != nil {
+		return fmt.Errorf("stat job failed: %w", err)
+	}
+	output, err := g.jobExecutor.JobOutput(ctx, statJob, int64(len(fileNames)))
+	if err != nil {
+		return err
+	}
+
+	for fileName, size := range parseFileSizes(output) {
+		s, ok := unknown[fileName]
+		if !ok {
+			continue
+		}
+		s.Size = size
+		if err := g.snapshotManager.StoreSnapshotMetadata(ctx, s); err != nil {
+			g.logger.Warnw("Failed to record measured snapshot size", "snapshot_id", s.SnapshotID, "error", err)
+		}
+	}
+	return nil
+}
+
+// parseFileSizes reads the size and name lines printed by a stat job. Files that are
+// missing from the output were not found on the snapshot PVC.
+func parseFileSizes(output string) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		size, fileName, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		sizes[fileName] = n
+	}
+	return sizes
+}
+
+// roundUpToGiB returns bytes rounded up to a whole number of GiB
+func roundUpToGiB(bytes int64) resource.Quantity {
+	const gib = 1 << 30
+	return *resource.NewQuantity((bytes+gib-1)/gib*gib, resource.BinarySI)
+}
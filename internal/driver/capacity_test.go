@@ -0,0 +1,159 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// snapshotPVCName is the snapshot PVC of the default driver instance
+var snapshotPVCName = config.NewNames("").SnapshotPVC()
+
+func TestEstimateSnapshotSize(t *testing.T) {
+	zstd := snapshot.Format{Codec: snapshot.CodecZstd}
+	compressed := &snapshot.SnapshotMetadata{Compression: snapshot.CodecZstd, Size: 200, UncompressedSize: 1000}
+
+	assert.Equal(t, int64(1100), estimateSnapshotSize(1000, snapshot.Format{}, nil))
+	assert.Equal(t, int64(2200), estimateSnapshotSize(1000, snapshot.Format{}, &snapshot.SnapshotMetadata{Size: 2000}))
+	assert.Equal(t, int64(440), estimateSnapshotSize(2000, zstd, compressed))
+	// Without a snapshot compressed the same way, encoded snapshots are assumed not to shrink
+	assert.Equal(t, int64(1100), estimateSnapshotSize(1000, snapshot.Format{Codec: snapshot.CodecGzip}, compressed))
+	assert.Equal(t, int64(1100), estimateSnapshotSize(1000, zstd, nil))
+}
+
+func TestLastClusterSnapshotAndUsage(t *testing.T) {
+	now := time.Now()
+	snapshots := []*snapshot.SnapshotMetadata{
+		{SnapshotID: "a", ClusterName: "etcd", Namespace: "hcp", PVCName: "snapshots", Size: 100, CreationTime: now.Add(-time.Hour)},
+		{SnapshotID: "b", ClusterName: "etcd", Namespace: "hcp", PVCName: "snapshots", Size: 300, CreationTime: now},
+		{SnapshotID: "c", ClusterName: "events", Namespace: "hcp", PVCName: "snapshots", Size: 50, CreationTime: now.Add(time.Hour)},
+		{SnapshotID: "d", ClusterName: "etcd", Namespace: "other", PVCName: "snapshots", Size: 1000, CreationTime: now.Add(time.Hour)},
+	}
+
+	assert.Equal(t, "b", lastClusterSnapshot(snapshots, "hcp", "etcd").SnapshotID)
+	assert.Nil(t, lastClusterSnapshot(snapshots, "hcp", "missing"))
+	assert.Equal(t, int64(450), snapshotPVCUsage(snapshots, "hcp", "snapshots"))
+}
+
+func TestEnsureSnapshotPVCSpace(t *testing.T) {
+	const gib = int64(1 << 30)
+
+	newPVC := func(storageClass string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: snapshotPVCName, Namespace: "hcp"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass,
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+				},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		}
+	}
+	newStorageClass := func(name string, allowExpansion bool) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: name},
+			AllowVolumeExpansion: &allowExpansion,
+		}
+	}
+	existing := []*snapshot.SnapshotMetadata{
+		{SnapshotID: "old", ClusterName: "etcd", Namespace: "hcp", PVCName: snapshotPVCName, Size: 8 * gib},
+	}
+
+	tests := []struct {
+		name         string
+		storageClass string
+		needed       int64
+		wantCode     codes.Code
+		wantSize     string
+		wantEvent    string
+	}{
+		{name: "fits", storageClass: "fixed", needed: gib, wantCode: codes.OK, wantSize: "10Gi"},
+		{name: "expands", storageClass: "expandable", needed: 3 * gib, wantCode: codes.OK, wantSize: "14Gi", wantEvent: "Normal SnapshotPVCExpanded"},
+		{name: "exhausted", storageClass: "fixed", needed: 3 * gib, wantCode: codes.ResourceExhausted, wantSize: "10Gi", wantEvent: "Warning SnapshotPVCFull"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeClient := fake.NewSimpleClientset(
+				newPVC(tt.storageClass),
+				newStorageClass("fixed", false),
+				newStorageClass("expandable", true),
+			)
+			recorder := record.NewFakeRecorder(10)
+			server := NewGroupControllerServer(fakeClient,
+				WithLogger{Logger: zap.NewNop().Sugar()},
+				WithEventRecorder{Recorder: recorder},
+			)
+			provisioner := snapshot.NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "", "10Gi", zap.NewNop().Sugar())
+			targets := []runtime.Object{server.pvcEventTarget(ctx, "hcp", snapshotPVCName)}
+
+			// The storage backend expands the volume, and the node grows the file system once it is mounted
+			fakeClient.PrependReactor("update", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				pvc := action.(k8stesting.UpdateAction).GetObject().(*corev1.PersistentVolumeClaim)
+				pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{
+					{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue},
+				}
+				return false, nil, nil
+			})
+			pvcExpansionPollInterval = time.Millisecond
+
+			err := server.ensureSnapshotPVCSpace(ctx, provisioner, "hcp", snapshotPVCName, "snap-new", tt.needed, existing, targets)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			pvc, err := fakeClient.CoreV1().PersistentVolumeClaims("hcp").Get(ctx, snapshotPVCName, metav1.GetOptions{})
+			require.NoError(t, err)
+			size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			assert.Equal(t, tt.wantSize, size.String())
+
+			if tt.wantEvent == "" {
+				assert.Empty(t, recorder.Events)
+			} else {
+				require.Len(t, recorder.Events, 1)
+				assert.Contains(t, <-recorder.Events, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestWaitForPVCExpansionTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pvcExpansionPollInterval = time.Millisecond
+
+	fakeClient := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotPVCName, Namespace: "hcp"},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		},
+	})
+	server := NewGroupControllerServer(fakeClient, WithLogger{Logger: zap.NewNop().Sugar()})
+
+	// Expansions the storage backend has not acted on yet are waited for
+	assert.Error(t, server.waitForPVCExpansion(ctx, "hcp", snapshotPVCName, resource.MustParse("14Gi")))
+	assert.NoError(t, server.waitForPVCExpansion(ctx, "hcp", snapshotPVCName, resource.MustParse("10Gi")))
+}
+
+func TestParseFileSizes(t *testing.T) {
+	output := "8589934592 snap-0.db\nnot a size line\n1024 snap-1.db.gz\n0 snap-2.db\n"
+	assert.Equal(t, map[string]int64{"snap-0.db": 8 << 30, "snap-1.db.gz": 1024}, parseFileSizes(output))
+}
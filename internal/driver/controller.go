@@ -1,10 +1,22 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"time"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/metrics"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 )
 
 // parseVolumeID extracts namespace and PVC name from volume ID
@@ -40,11 +52,14 @@ func parseVolumeID(volumeID string) (namespace, pvcName string, err error) {
 
 type ControllerConfig struct {
 	Logger 				 *zap.SugaredLogger
+	DriverName               string
+	MetadataNamespace        string
 	SnapshotTimeout          time.Duration
 	ClusterLabelKey          string
 	JobBackoffLimit          int32
 	JobActiveDeadlineSeconds int64
 	ETCDImage                string
+	ETCDImageMatrix          job.ImageMatrix
 	BusyboxImage             string
 	ETCDTLSEnabled           bool
 	ETCDTLSSecretName        string
@@ -54,6 +69,35 @@ type ControllerConfig struct {
 	ETCDCAPath               string
 	DefaultStorageClass      string
 	SnapshotPVCSize          string
+	SnapshotCompression      snapshot.Codec
+	SnapshotBundle           bool
+	BundleCompanionSelector  string
+	MemberSelection          etcd.MemberSelection
+	SnapshotStorageMode      snapshot.StorageMode
+	VolumeSnapshotClass      string
+	DynamicClient            dynamic.Interface
+	TieringColdAfter         time.Duration
+	TieringInterval          time.Duration
+	ObjectStoreEndpoint      string
+	ObjectStoreRegion        string
+	ObjectStoreBucket        string
+	ObjectStorePrefix        string
+	ObjectStoreInsecure      bool
+	ObjectStoreSecretName    string
+	ReplicationTargets       []snapshot.ReplicationTarget
+	ReplicationReadiness     snapshot.ReplicationReadiness
+	ReplicationInterval      time.Duration
+	AgentImage               string
+	RestoreDrillEnabled      bool
+	RestoreDrillMinKeys      int64
+	RestoreDrillPrefixes     []string
+	EncryptionKeyProvider    encryption.KeyProvider
+	EncryptionKeyConfig      encryption.ProviderConfig
+	EncryptionKeyID          string
+	SigningKeys              signing.Keys
+	RequireSignatures        bool
+	EventRecorder            record.EventRecorder
+	Metrics                  *metrics.Metrics
 }
 
 func (c *ControllerConfig) Options(opts ...ControllerOption) {
@@ -66,6 +110,115 @@ func (c *ControllerConfig) Default() {
 	if c.Logger == nil {
 		c.Logger = zap.NewNop().Sugar()
 	}
+	if c.DriverName == "" {
+		c.DriverName = config.DefaultDriverName
+	}
+	if c.MetadataNamespace == "" {
+		c.MetadataNamespace = "kube-system"
+	}
+	if c.EventRecorder == nil {
+		c.EventRecorder = noopRecorder{}
+	}
+}
+
+// Validate checks the settings that can change while the driver runs
+func (c *ControllerConfig) Validate() error {
+	var errs []error
+
+	if c.SnapshotTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("snapshot timeout must be positive, got %s", c.SnapshotTimeout))
+	}
+	if c.JobBackoffLimit < 0 {
+		errs = append(errs, fmt.Errorf("job backoff limit must not be negative, got %d", c.JobBackoffLimit))
+	}
+	if c.JobActiveDeadlineSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("job active deadline must be positive, got %d", c.JobActiveDeadlineSeconds))
+	}
+
+	images := []struct{ name, image string }{
+		{"etcd image", c.ETCDImage},
+		{"busybox image", c.BusyboxImage},
+		{"agent image", c.AgentImage},
+	}
+	for _, i := range images {
+		if i.image == "" {
+			errs = append(errs, fmt.Errorf("%s must not be empty", i.name))
+		}
+	}
+	for version, image := range c.ETCDImageMatrix {
+		if image == "" {
+			errs = append(errs, fmt.Errorf("etcd image for version %s must not be empty", version))
+		}
+	}
+
+	if c.ETCDTLSEnabled {
+		paths := []struct{ name, path string }{
+			{"etcd client cert path", c.ETCDClientCertPath},
+			{"etcd client key path", c.ETCDClientKeyPath},
+			{"etcd CA path", c.ETCDCAPath},
+		}
+		for _, p := range paths {
+			if !filepath.IsAbs(p.path) {
+				errs = append(errs, fmt.Errorf("%s must be an absolute path when TLS is enabled, got %q", p.name, p.path))
+			}
+		}
+	}
+
+	if _, err := etcd.ParseMemberSelection(string(c.MemberSelection)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if mode, err := snapshot.ParseStorageMode(string(c.SnapshotStorageMode)); err != nil {
+		errs = append(errs, err)
+	} else if mode == snapshot.StorageVolumeSnapshot && c.DynamicClient == nil {
+		errs = append(errs, fmt.Errorf("snapshot storage mode %s requires a dynamic client", mode))
+	}
+
+	if c.TieringColdAfter < 0 {
+		errs = append(errs, fmt.Errorf("tiering cold-after must not be negative, got %s", c.TieringColdAfter))
+	}
+	if c.TieringColdAfter > 0 {
+		if c.TieringInterval <= 0 {
+			errs = append(errs, fmt.Errorf("tiering interval must be positive, got %s", c.TieringInterval))
+		}
+		settings := []struct{ name, value string }{
+			{"object store endpoint", c.ObjectStoreEndpoint},
+			{"object store bucket", c.ObjectStoreBucket},
+			{"object store secret name", c.ObjectStoreSecretName},
+		}
+		for _, s := range settings {
+			if s.value == "" {
+				errs = append(errs, fmt.Errorf("%s must be set when tiering is enabled", s.name))
+			}
+		}
+	}
+
+	if _, err := snapshot.ParseReplicationReadiness(string(c.ReplicationReadiness)); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.ReplicationTargets) > 0 && c.ReplicationInterval <= 0 {
+		errs = append(errs, fmt.Errorf("replication interval must be positive, got %s", c.ReplicationInterval))
+	}
+	for _, t := range c.ReplicationTargets {
+		if t.Name == "" || t.Endpoint == "" || t.Bucket == "" || t.SecretName == "" {
+			errs = append(errs, fmt.Errorf("replication target %q needs a name, endpoint, bucket and secret name", t.Name))
+		}
+	}
+
+	// Companion Secrets are only ever stored encrypted
+	if c.BundleCompanionSelector != "" && c.EncryptionKeyProvider == nil {
+		errs = append(errs, fmt.Errorf("bundle companion Secrets need snapshot encryption"))
+	}
+
+	if c.RequireSignatures && c.SigningKeys == nil {
+		errs = append(errs, fmt.Errorf("requiring snapshot signatures needs signing keys"))
+	}
+
+	if _, err := resource.ParseQuantity(c.SnapshotPVCSize); err != nil {
+		errs = append(errs, fmt.Errorf("invalid snapshot PVC size %q: %w", c.SnapshotPVCSize, err))
+	}
+
+	return errors.Join(errs...)
 }
 
 type ControllerOption interface {
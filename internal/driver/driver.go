@@ -17,29 +17,34 @@ import (
 )
 
 type Driver struct {
-	version                string
-	k8sClient              kubernetes.Interface
+	version               string
+	k8sClient             kubernetes.Interface
 	identityServer        *IdentityServer
 	groupControllerServer *GroupControllerServer
-	server                *grpc.Server
-	cfg                   *DriverConfig
+	// snapshotControllerServer deletes the member snapshots of group snapshots
+	snapshotControllerServer *SnapshotControllerServer
+	server                   *grpc.Server
+	cfg                      *DriverConfig
 }
 
 func NewDriver(client kubernetes.Interface, groupControllerServer *GroupControllerServer, identityServer *IdentityServer, opts ...DriverOption) *Driver {
 	var cfg DriverConfig
 	cfg.Options(opts...)
+	cfg.Default()
 
 	return &Driver{
-		version:                config.Version,
-		k8sClient:              client,
-		groupControllerServer:  groupControllerServer,
-		identityServer:         identityServer,
-		cfg:                    &cfg,
+		version:                  config.Version,
+		k8sClient:                client,
+		groupControllerServer:    groupControllerServer,
+		snapshotControllerServer: NewSnapshotControllerServer(groupControllerServer),
+		identityServer:           identityServer,
+		cfg:                      &cfg,
 	}
 }
 
 func (d *Driver) Run(ctx context.Context) error {
 	d.cfg.Logger.Infow("Starting etcd-snapshot-driver",
+		"driver_name", d.cfg.DriverName,
 		"version", d.version,
 		"endpoint", d.cfg.EndPoint,
 	)
@@ -53,6 +58,7 @@ func (d *Driver) Run(ctx context.Context) error {
 	// Register services
 	csi.RegisterIdentityServer(d.server, d.identityServer)
 	csi.RegisterGroupControllerServer(d.server, d.groupControllerServer)
+	csi.RegisterControllerServer(d.server, d.snapshotControllerServer)
 
 	// Setup listener
 	listener, err := d.setupListener()
@@ -111,6 +117,16 @@ func (d *Driver) setupListener() (net.Listener, error) {
 		return listener, nil
 	}
 
+	// TCP endpoints let csi-sanity and local tools reach the driver without a kubelet plugin dir
+	if strings.HasPrefix(d.cfg.EndPoint, "tcp://") {
+		address := strings.TrimPrefix(d.cfg.EndPoint, "tcp://")
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on tcp address: %w", err)
+		}
+		return listener, nil
+	}
+
 	return nil, fmt.Errorf("unsupported endpoint format: %s", d.cfg.EndPoint)
 }
 
@@ -130,8 +146,9 @@ func (d *Driver) loggingInterceptor(ctx context.Context, req interface{}, info *
 }
 
 type DriverConfig struct {
-	EndPoint string
-	Logger   *zap.SugaredLogger
+	EndPoint   string
+	DriverName string
+	Logger     *zap.SugaredLogger
 }
 
 func (c *DriverConfig) Options(opts ...DriverOption) {
@@ -144,6 +161,9 @@ func (c *DriverConfig) Default() {
 	if c.Logger == nil {
 		c.Logger = zap.NewNop().Sugar()
 	}
+	if c.DriverName == "" {
+		c.DriverName = DriverName
+	}
 }
 
 type DriverOption interface {
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSetupListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		network  string
+		wantErr  bool
+	}{
+		{
+			name:     "unix socket",
+			endpoint: "unix://" + filepath.Join(t.TempDir(), "plugin", "csi.sock"),
+			network:  "unix",
+		},
+		{
+			name:     "tcp address",
+			endpoint: "tcp://127.0.0.1:0",
+			network:  "tcp",
+		},
+		{
+			name:     "unsupported scheme",
+			endpoint: "http://127.0.0.1:10000",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDriver(nil, nil, nil,
+				WithLogger{Logger: zap.NewNop().Sugar()},
+				WithEndPoint(tt.endpoint),
+			)
+
+			listener, err := d.setupListener()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer listener.Close()
+
+			assert.Equal(t, tt.network, listener.Addr().Network())
+		})
+	}
+}
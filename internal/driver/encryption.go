@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+)
+
+// Helper function to generate a data key for a new snapshot and wrap it with the active
+// key encryption key. Only the wrapped data key is kept; the save job unwraps it itself.
+func (g *GroupControllerServer) newDataKey(ctx context.Context) (*snapshot.EncryptionInfo, error) {
+	cfg := g.config()
+
+	dataKey, err := encryption.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := cfg.EncryptionKeyProvider.WrapKey(ctx, cfg.EncryptionKeyID, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return &snapshot.EncryptionInfo{
+		Algorithm:      encryption.Algorithm,
+		KeyID:          cfg.EncryptionKeyID,
+		WrappedDataKey: wrapped,
+	}, nil
+}
+
+// Helper function to hand a job the wrapped data key of an encrypted snapshot along with
+// the key provider to unwrap it with, so the plaintext data key never leaves the job's pod
+func (g *GroupControllerServer) withDataKey(jobConfig *job.JobConfig, info *snapshot.EncryptionInfo) error {
+	cfg := g.config()
+	if cfg.EncryptionKeyProvider == nil {
+		return fmt.Errorf("snapshot is encrypted with key %q but no key provider is configured", info.KeyID)
+	}
+
+	jobConfig.Encryption = info
+	jobConfig.KeyProvider = cfg.EncryptionKeyConfig
+	return nil
+}
+
+// RewrapDataKeys re-wraps the data key of every encrypted snapshot that is not yet wrapped
+// with the active key encryption key. Snapshot files are not touched, so rotating the key
+// encryption key is cheap; the old key can be retired once this has completed.
+func (g *GroupControllerServer) RewrapDataKeys(ctx context.Context) error {
+	cfg := g.config()
+	if cfg.EncryptionKeyProvider == nil {
+		return nil
+	}
+
+	snapshots, err := g.snapshotManager.ListSnapshotMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshot metadata: %w", err)
+	}
+
+	var rewrapped int
+	for _, metadata := range snapshots {
+		if metadata.Encryption == nil || metadata.Encryption.KeyID == cfg.EncryptionKeyID {
+			continue
+		}
+
+		wrapped, err := encryption.Rewrap(ctx, cfg.EncryptionKeyProvider,
+			metadata.Encryption.KeyID, cfg.EncryptionKeyID, metadata.Encryption.WrappedDataKey)
+		if err != nil {
+			return fmt.Errorf("failed to rewrap data key of snapshot %s: %w", metadata.SnapshotID, err)
+		}
+
+		previousKeyID := metadata.Encryption.KeyID
+		metadata.Encryption.KeyID = cfg.EncryptionKeyID
+		metadata.Encryption.WrappedDataKey = wrapped
+		if err := g.snapshotManager.StoreSnapshotMetadata(ctx, metadata); err != nil {
+			return fmt.Errorf("failed to store rewrapped data key of snapshot %s: %w", metadata.SnapshotID, err)
+		}
+
+		g.logger.Infow("Rewrapped snapshot data key",
+			"snapshot_id", metadata.SnapshotID,
+			"previous_key_id", previousKeyID,
+			"key_id", cfg.EncryptionKeyID,
+		)
+		rewrapped++
+	}
+
+	if rewrapped > 0 {
+		g.logger.Infow("Data key rotation completed", "rewrapped", rewrapped, "key_id", cfg.EncryptionKeyID)
+	}
+
+	return nil
+}
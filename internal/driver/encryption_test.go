@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRewrapDataKeys(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key-1"), bytes.Repeat([]byte{1}, encryption.DataKeySize), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key-2"), bytes.Repeat([]byte{2}, encryption.DataKeySize), 0o600))
+	provider := encryption.NewFileKeyProvider(dir)
+
+	fakeClient := fake.NewSimpleClientset()
+	logger := zap.NewNop().Sugar()
+	ctx := context.Background()
+
+	dataKey, err := encryption.GenerateDataKey()
+	require.NoError(t, err)
+	wrapped, err := provider.WrapKey(ctx, "key-1", dataKey)
+	require.NoError(t, err)
+
+	manager := snapshot.NewManager(fakeClient, logger, "kube-system", config.NewNames(""))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID: "encrypted",
+		Encryption: &snapshot.EncryptionInfo{
+			Algorithm:      encryption.Algorithm,
+			KeyID:          "key-1",
+			WrappedDataKey: wrapped,
+		},
+	}))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID: "plain",
+	}))
+
+	server := NewGroupControllerServer(
+		fakeClient,
+		ControllerOption(WithLogger{Logger: logger}),
+		WithEncryptionKeyProvider{Provider: provider},
+		WithEncryptionKeyID("key-2"),
+	)
+
+	require.NoError(t, server.RewrapDataKeys(ctx))
+
+	metadata, err := manager.RetrieveSnapshotMetadata(ctx, "encrypted")
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", metadata.Encryption.KeyID)
+
+	got, err := provider.UnwrapKey(ctx, "key-2", metadata.Encryption.WrappedDataKey)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+
+	plain, err := manager.RetrieveSnapshotMetadata(ctx, "plain")
+	require.NoError(t, err)
+	assert.Nil(t, plain.Encryption)
+}
+
+func TestRestoreDrillOfEncryptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key-1"), bytes.Repeat([]byte{1}, encryption.DataKeySize), 0o600))
+	provider := encryption.NewFileKeyProvider(dir)
+
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset()
+	server := NewGroupControllerServer(
+		fakeClient,
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+		WithEncryptionKeyProvider{Provider: provider, Config: encryption.ProviderConfig{Provider: "file", Dir: dir}},
+		WithEncryptionKeyID("key-1"),
+		WithSnapShotTimeout(100*time.Millisecond),
+	)
+
+	info, err := server.newDataKey(ctx)
+	require.NoError(t, err)
+	metadata := &snapshot.SnapshotMetadata{
+		SnapshotID:   "snap-1",
+		Namespace:    "hcp",
+		PVCName:      "etcd-snapshots",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		Compression:  snapshot.CodecZstd,
+		Encryption:   info,
+	}
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, metadata))
+
+	server.runRestoreDrill(ctx, metadata, nil)
+
+	stored, err := server.snapshotManager.RetrieveSnapshotMetadata(ctx, "snap-1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.RestoreDrill)
+	assert.NotContains(t, stored.RestoreDrill.Message, "data key")
+	assert.Contains(t, stored.RestoreDrill.Message, "restore drill job failed")
+
+	// The drill gets the wrapped data key and unwraps it itself; no plaintext data key
+	// is published in the snapshot's namespace
+	drillJob, err := fakeClient.BatchV1().Jobs("hcp").Get(ctx, "etcd-snapshot-drill-snap-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	command := drillJob.Spec.Template.Spec.Containers[0].Command
+	assert.Contains(t, command, base64.StdEncoding.EncodeToString(info.WrappedDataKey))
+	assert.Contains(t, command, "key-1")
+	for _, volume := range drillJob.Spec.Template.Spec.Volumes {
+		assert.Nil(t, volume.Secret)
+	}
+	secrets, err := fakeClient.CoreV1().Secrets("hcp").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, secrets.Items)
+}
+
+func TestRestoreDrillOfEncryptedSnapshotWithoutKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	server := NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	)
+
+	metadata := &snapshot.SnapshotMetadata{
+		SnapshotID: "snap-1",
+		Namespace:  "hcp",
+		PVCName:    "etcd-snapshots",
+		ReadyToUse: true,
+		Encryption: &snapshot.EncryptionInfo{KeyID: "key-1"},
+	}
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, metadata))
+
+	server.runRestoreDrill(ctx, metadata, nil)
+
+	stored, err := server.snapshotManager.RetrieveSnapshotMetadata(ctx, "snap-1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.RestoreDrill)
+	assert.Contains(t, stored.RestoreDrill.Message, "no key provider is configured")
+}
@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Event reasons posted for the snapshot lifecycle
+const (
+	ReasonDiscoveryFailed           = "DiscoveryFailed"
+	ReasonHealthCheckFailed         = "HealthCheckFailed"
+	ReasonSnapshotJobStarted        = "SnapshotJobStarted"
+	ReasonSnapshotJobSucceeded      = "SnapshotJobSucceeded"
+	ReasonSnapshotJobFailed         = "SnapshotJobFailed"
+	ReasonSnapshotDeleted           = "SnapshotDeleted"
+	ReasonSnapshotDeleteFailed      = "SnapshotDeleteFailed"
+	ReasonRestoreDrillPassed        = "RestoreDrillPassed"
+	ReasonRestoreDrillFailed        = "RestoreDrillFailed"
+	ReasonSnapshotPVCExpanded       = "SnapshotPVCExpanded"
+	ReasonSnapshotPVCFull           = "SnapshotPVCFull"
+	ReasonVolumeSnapshotReady       = "VolumeSnapshotReady"
+	ReasonVolumeSnapshotFailed      = "VolumeSnapshotFailed"
+	ReasonSnapshotTiered            = "SnapshotTiered"
+	ReasonSnapshotTieringFailed     = "SnapshotTieringFailed"
+	ReasonSnapshotReplicated        = "SnapshotReplicated"
+	ReasonSnapshotReplicationFailed = "SnapshotReplicationFailed"
+	ReasonSnapshotHeld              = "SnapshotHeld"
+	ReasonSnapshotSigningFailed     = "SnapshotSigningFailed"
+)
+
+// noopRecorder drops events; it stands in when no event recorder is configured
+type noopRecorder struct{}
+
+func (noopRecorder) Event(object runtime.Object, eventType, reason, message string) {}
+
+func (noopRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+}
+
+func (noopRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+}
+
+// groupSnapshotContentNameKey is the parameter the external-snapshotter sidecar sets to the
+// name of the VolumeGroupSnapshotContent when started with --extra-create-metadata
+const groupSnapshotContentNameKey = "csi.storage.k8s.io/volumegroupsnapshotcontent/name"
+
+// Helper function to post an event on every object a snapshot relates to
+func (g *GroupControllerServer) recordEvent(targets []runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	for _, target := range targets {
+		if target == nil {
+			continue
+		}
+		g.config().EventRecorder.Eventf(target, eventType, reason, messageFmt, args...)
+	}
+}
+
+// Helper function to resolve a PVC for event recording. Events on an unresolvable
+// PVC are still posted by reference so they are not silently dropped.
+func (g *GroupControllerServer) pvcEventTarget(ctx context.Context, namespace, name string) runtime.Object {
+	pvc, err := g.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return pvc
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// Helper function to collect the objects an existing group snapshot's events are posted on
+func (g *GroupControllerServer) groupSnapshotEventTargets(ctx context.Context, metadata *snapshot.GroupSnapshotMetadata) []runtime.Object {
+	targets := []runtime.Object{groupSnapshotContentEventTarget(metadata.ContentName)}
+	for _, volumeID := range metadata.SourceVolumeIDs {
+		namespace, name, err := parseVolumeID(volumeID)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, g.pvcEventTarget(ctx, namespace, name))
+	}
+	seen := make(map[string]bool)
+	for _, s := range metadata.ClusterSnapshots() {
+		key := s.SnapshotPVCNamespace + "/" + s.SnapshotPVCName
+		if s.SnapshotPVCName == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, g.pvcEventTarget(ctx, s.SnapshotPVCNamespace, s.SnapshotPVCName))
+	}
+	return targets
+}
+
+// Helper function to reference a VolumeGroupSnapshotContent for event recording; nil when the name is unknown
+func groupSnapshotContentEventTarget(name string) runtime.Object {
+	if name == "" {
+		return nil
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: "groupsnapshot.storage.k8s.io/v1beta2",
+		Kind:       "VolumeGroupSnapshotContent",
+		Name:       name,
+	}
+}
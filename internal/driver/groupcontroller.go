@@ -2,30 +2,41 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
 	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 )
 
 type GroupControllerServer struct {
 	csi.UnimplementedGroupControllerServer
-	k8sClient              kubernetes.Interface
-	discovery              *etcd.Discovery
-	jobExecutor            *job.Executor
-	snapshotManager        *snapshot.Manager
-	snapshotPVCProvisioner *SnapshotPVCProvisioner
-	cfg                    *ControllerConfig
-	logger                 *zap.SugaredLogger
+	k8sClient       kubernetes.Interface
+	discovery       *etcd.Discovery
+	jobExecutor     *job.Executor
+	snapshotManager *snapshot.Manager
+	cfg             atomic.Pointer[ControllerConfig]
+	logger          *zap.SugaredLogger
+	// snapshotPVCLocks serializes the jobs that mount the same snapshot PVC, keyed by namespace/name
+	snapshotPVCLocks sync.Map
+	// replicationQueue is the queue of the running replicator, if any
+	replicationQueue atomic.Pointer[replicationQueue]
 }
 
 func NewGroupControllerServer(
@@ -36,15 +47,36 @@ func NewGroupControllerServer(
 	cfg.Options(opts...)
 	cfg.Default()
 
-	return &GroupControllerServer{
-		k8sClient:              k8sClient,
-		discovery:              etcd.NewDiscovery(k8sClient, cfg.Logger, cfg.ClusterLabelKey),
-		jobExecutor:            job.NewExecutor(k8sClient, cfg.Logger),
-		snapshotManager:        snapshot.NewManager(k8sClient, cfg.Logger, "kube-system"),
-		snapshotPVCProvisioner: NewSnapshotPVCProvisioner(k8sClient, cfg.DefaultStorageClass, cfg.SnapshotPVCSize, cfg.Logger),
-		cfg:                    &cfg,
-		logger:                 cfg.Logger,
+	g := &GroupControllerServer{
+		k8sClient:       k8sClient,
+		discovery:       etcd.NewDiscovery(k8sClient, cfg.Logger, cfg.ClusterLabelKey),
+		jobExecutor:     job.NewExecutor(k8sClient, cfg.Logger),
+		snapshotManager: snapshot.NewManager(k8sClient, cfg.Logger, cfg.MetadataNamespace, config.NewNames(cfg.DriverName)),
+		logger:          cfg.Logger,
 	}
+	g.cfg.Store(&cfg)
+
+	return g
+}
+
+// config returns the configuration in effect; RPCs should read it once and use that copy throughout
+func (g *GroupControllerServer) config() *ControllerConfig {
+	return g.cfg.Load()
+}
+
+// Reload applies opts on top of the configuration in effect and, once the result
+// validates, atomically swaps it in for subsequent RPCs. RPCs already running keep
+// the configuration they started with. The configuration is left untouched on error.
+func (g *GroupControllerServer) Reload(opts ...ControllerOption) error {
+	next := *g.config()
+	next.Options(opts...)
+
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	g.cfg.Store(&next)
+	return nil
 }
 
 // GroupControllerGetCapabilities returns the capabilities of the group controller
@@ -71,12 +103,13 @@ func (g *GroupControllerServer) GroupControllerGetCapabilities(ctx context.Conte
 // 3. Validate all PVCs exist and are writable
 // 4. Discover ETCD clusters from each PVC
 // 5. Validate all cluster health
-// 6. Generate snapshot jobs for each PVC
+// 6. Generate a snapshot job for each discovered cluster
 // 7. Execute jobs in parallel using errgroup
 // 8. On error, cleanup successful snapshots and return error (all-or-nothing)
 // 9. Store group snapshot metadata
-// 10. Return response with all snapshot IDs
+// 10. Return response with one snapshot per source volume, mapped to its cluster's snapshot
 func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	cfg := g.config()
 	groupSnapshotID := fmt.Sprintf("%s-%d", req.GetName(), time.Now().Unix())
 	sourceVolumeIDs := req.GetSourceVolumeIds()
 
@@ -115,8 +148,13 @@ func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, r
 	}
 
 	// Phase 3: Validate all PVCs
+	// Lifecycle events are posted on the source PVCs and, when known, the VolumeGroupSnapshotContent
+	contentTarget := groupSnapshotContentEventTarget(req.GetParameters()[groupSnapshotContentNameKey])
+	eventTargets := []runtime.Object{contentTarget}
+	pvcs := make([]runtime.Object, len(volumes))
 	for i, vol := range volumes {
-		if err := g.validatePVCForGroup(ctx, vol.namespace, vol.name); err != nil {
+		pvc, err := g.validatePVCForGroup(ctx, vol.namespace, vol.name)
+		if err != nil {
 			g.logger.Errorw("PVC validation failed",
 				"index", i,
 				"namespace", vol.namespace,
@@ -130,17 +168,14 @@ func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, r
 			"namespace", vol.namespace,
 			"pvc_name", vol.name,
 		)
+		eventTargets = append(eventTargets, pvc)
+		pvcs[i] = pvc
 	}
 
 	// Phase 4 & 5: Discover ETCD clusters and validate health
-	type clusterInfo struct {
-		index      int
-		info       *etcd.ClusterInfo
-		volumeInfo volumeInfo
-	}
-	clusterInfos := make([]clusterInfo, 0, len(volumes))
-	var firstClusterName string
-	var firstClusterEndpoints []string
+	// Volumes are grouped by the cluster they belong to, and each cluster is snapshotted once
+	var clusters []*groupCluster
+	clustersByKey := make(map[string]*groupCluster)
 
 	for i, vol := range volumes {
 		info, err := g.discovery.DiscoverCluster(ctx, vol.namespace, vol.name)
@@ -151,51 +186,36 @@ func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, r
 				"pvc_name", vol.name,
 				"error", err,
 			)
+			g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonDiscoveryFailed,
+				"ETCD cluster discovery failed for %s/%s: %v", vol.namespace, vol.name, err)
 			return nil, status.Errorf(codes.Internal, "ETCD discovery failed for volume %d: %v", i, err)
 		}
 
-		// Validate cluster health
-		if err := g.discovery.ValidateClusterHealth(ctx, info); err != nil {
-			g.logger.Errorw("ETCD cluster health validation failed",
-				"index", i,
-				"cluster_name", info.Name,
-				"error", err,
-			)
-			return nil, status.Errorf(codes.FailedPrecondition, "ETCD cluster health validation failed for volume %d: %v", i, err)
-		}
-
-		// Validate cluster consistency: all PVCs must belong to same cluster
-		if i == 0 {
-			firstClusterName = info.Name
-			firstClusterEndpoints = info.Endpoints
-		} else {
-			if info.Name != firstClusterName {
-				g.logger.Errorw("Cluster mismatch in VolumeGroupSnapshot",
-					"volume_index", i,
-					"expected_cluster", firstClusterName,
-					"got_cluster", info.Name,
+		key := vol.namespace + "/" + info.Name
+		cluster, ok := clustersByKey[key]
+		if !ok {
+			// Validate cluster health
+			if err := g.discovery.ValidateClusterHealth(ctx, info); err != nil {
+				g.logger.Errorw("ETCD cluster health validation failed",
+					"index", i,
+					"cluster_name", info.Name,
+					"error", err,
 				)
-				return nil, status.Errorf(codes.FailedPrecondition,
-					"all PVCs must belong to the same ETCD cluster: expected %s, got %s",
-					firstClusterName, info.Name)
+				g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonHealthCheckFailed,
+					"ETCD cluster %s failed health check: %v", info.Name, err)
+				return nil, status.Errorf(codes.FailedPrecondition, "ETCD cluster health validation failed for volume %d: %v", i, err)
 			}
-			// Compare endpoints to ensure it's the same cluster
-			if len(info.Endpoints) != len(firstClusterEndpoints) {
-				g.logger.Errorw("Cluster endpoints mismatch in VolumeGroupSnapshot",
-					"volume_index", i,
-					"expected_endpoints", firstClusterEndpoints,
-					"got_endpoints", info.Endpoints,
-				)
-				return nil, status.Errorf(codes.FailedPrecondition,
-					"all PVCs must belong to the same ETCD cluster: endpoints differ")
+
+			cluster = &groupCluster{
+				info:         info,
+				namespace:    vol.namespace,
+				eventTargets: []runtime.Object{contentTarget},
 			}
+			clustersByKey[key] = cluster
+			clusters = append(clusters, cluster)
 		}
-
-		clusterInfos = append(clusterInfos, clusterInfo{
-			index:      i,
-			info:       info,
-			volumeInfo: vol,
-		})
+		cluster.volumeIDs = append(cluster.volumeIDs, vol.originalID)
+		cluster.eventTargets = append(cluster.eventTargets, pvcs[i])
 
 		g.logger.Debugw("ETCD cluster discovered and validated",
 			"index", i,
@@ -204,82 +224,155 @@ func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, r
 		)
 	}
 
-	// Phase 6: Ensure dedicated snapshot PVC exists
-	firstCluster := clusterInfos[0]
-	snapshotPVCName, err := g.snapshotPVCProvisioner.EnsureSnapshotPVC(ctx, firstCluster.volumeInfo.namespace)
+	// Phase 6: Pick the member each cluster is snapshotted from, and ensure a dedicated
+	// snapshot PVC with room for the snapshots exists in the namespace of each cluster.
+	// Snapshots kept in VolumeSnapshots get a PVC of their own when they are taken instead.
+	provisioner := snapshot.NewSnapshotPVCProvisioner(g.k8sClient, config.NewNames(cfg.DriverName), cfg.DefaultStorageClass, cfg.SnapshotPVCSize, g.logger)
+	sharedPVC := cfg.SnapshotStorageMode != snapshot.StorageVolumeSnapshot
+	snapshotPVCs := make(map[string]string)
+	var namespaces []string
+	now := time.Now()
+
+	// The space each snapshot needs is estimated from the database size and the cluster's last snapshot
+	existing, err := g.snapshotManager.ListSnapshotMetadata(ctx)
 	if err != nil {
-		g.logger.Errorw("Failed to ensure snapshot PVC", "error", err)
-		return nil, status.Errorf(codes.Internal, "failed to prepare snapshot storage: %v", err)
+		g.logger.Warnw("Failed to list snapshots to estimate snapshot PVC usage", "error", err)
 	}
+	format := snapshot.Format{Codec: cfg.SnapshotCompression, Bundle: cfg.SnapshotBundle}
+	for i, cluster := range clusters {
+		// The snapshot is streamed from a single member, chosen by the member selection policy
+		source, err := g.discovery.SelectSnapshotSource(ctx, cluster.info, cfg.MemberSelection)
+		if err != nil {
+			g.logger.Errorw("Failed to select member to snapshot",
+				"cluster_name", cluster.info.Name,
+				"selection", cfg.MemberSelection,
+				"error", err,
+			)
+			g.recordEvent(cluster.eventTargets, corev1.EventTypeWarning, ReasonHealthCheckFailed,
+				"No member of ETCD cluster %s to snapshot: %v", cluster.info.Name, err)
+			return nil, status.Errorf(codes.FailedPrecondition, "no member of ETCD cluster %s to snapshot: %v", cluster.info.Name, err)
+		}
+		cluster.source = source
+		cluster.estimatedSize = estimateSnapshotSize(source.DBSize, format, lastClusterSnapshot(existing, cluster.namespace, cluster.info.Name))
 
-	snapshotID := fmt.Sprintf("%s-%d", groupSnapshotID, time.Now().Unix())
+		cluster.snapshotID = fmt.Sprintf("%s-%d", groupSnapshotID, now.Unix())
+		if len(clusters) > 1 {
+			cluster.snapshotID = fmt.Sprintf("%s-%d", cluster.snapshotID, i)
+		}
+		if !sharedPVC {
+			continue
+		}
 
-	jobConfig := &job.JobConfig{
-		SnapshotID:            snapshotID,
-		Namespace:             firstCluster.volumeInfo.namespace,
-		ETCDEndpoints:         firstCluster.info.Endpoints,
-		SnapshotPVCName:       snapshotPVCName,
-		SnapshotPVCNamespace:  firstCluster.volumeInfo.namespace,
-		Timeout:               300,
-		BackoffLimit:          g.cfg.JobBackoffLimit,
-		ActiveDeadlineSeconds: g.cfg.JobActiveDeadlineSeconds,
-		Operation:             "save",
-		TLSEnabled:            g.cfg.ETCDTLSEnabled,
-		TLSSecretName:         g.cfg.ETCDTLSSecretName,
-		ClientCertPath:        g.cfg.ETCDClientCertPath,
-		ClientKeyPath:         g.cfg.ETCDClientKeyPath,
-		CAPath:                g.cfg.ETCDCAPath,
-		ETCDImage:             g.cfg.ETCDImage,
-		BusyboxImage:          g.cfg.BusyboxImage,
+		snapshotPVCName, ok := snapshotPVCs[cluster.namespace]
+		if !ok {
+			if snapshotPVCName, err = provisioner.EnsureSnapshotPVC(ctx, cluster.namespace); err != nil {
+				g.logger.Errorw("Failed to ensure snapshot PVC", "namespace", cluster.namespace, "error", err)
+				return nil, status.Errorf(codes.Internal, "failed to prepare snapshot storage: %v", err)
+			}
+			snapshotPVCs[cluster.namespace] = snapshotPVCName
+			namespaces = append(namespaces, cluster.namespace)
+		}
+		cluster.snapshotPVCName = snapshotPVCName
+		cluster.eventTargets = append(cluster.eventTargets, g.pvcEventTarget(ctx, cluster.namespace, snapshotPVCName))
 	}
 
-	snapshotJob := job.GenerateSnapshotSaveJob(jobConfig)
-	g.logger.Debugw("Generated snapshot job",
-		"snapshot_id", snapshotID,
-		"job_name", snapshotJob.Name,
-		"pvc_name", firstCluster.volumeInfo.name,
-	)
+	for _, namespace := range namespaces {
+		var needed int64
+		var targets []runtime.Object
+		var snapshotID string
+		for _, cluster := range clusters {
+			if cluster.namespace != namespace {
+				continue
+			}
+			needed += cluster.estimatedSize
+			targets = append(targets, cluster.eventTargets...)
+			if snapshotID == "" {
+				snapshotID = cluster.snapshotID
+			}
+		}
+		if err := g.ensureSnapshotPVCSpace(ctx, provisioner, namespace, snapshotPVCs[namespace], snapshotID, needed, existing, targets); err != nil {
+			return nil, err
+		}
+	}
 
-	// Phase 7: Execute the single snapshot job
-	jobResult, err := g.jobExecutor.ExecuteSnapshotJob(ctx, snapshotJob, g.cfg.SnapshotTimeout)
-	if err != nil {
-		g.logger.Errorw("Snapshot job failed",
-			"snapshot_id", snapshotID,
-			"error", err,
-		)
-		return nil, status.Errorf(codes.Internal, "snapshot creation failed: %v", err)
+	// Phase 7: Execute one snapshot job per cluster in parallel; the first failure cancels
+	// the rest, since the group is discarded anyway. Clusters in one namespace share the
+	// ReadWriteOnce snapshot PVC, and their jobs take turns on the node it is attached to
+	// (see prepareSnapshotPVCJob) rather than racing to attach it.
+	snapshots := make([]*snapshot.SnapshotMetadata, len(clusters))
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i, cluster := range clusters {
+		eg.Go(func() error {
+			metadata, err := g.snapshotCluster(egCtx, cfg, cluster)
+			snapshots[i] = metadata
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		// All or nothing: the snapshots of clusters that succeeded are removed again
+		for _, metadata := range snapshots {
+			if metadata == nil {
+				continue
+			}
+			if cleanupErr := g.deleteSnapshotFile(ctx, metadata); cleanupErr != nil {
+				g.logger.Warnw("Failed to cleanup snapshot of failed group snapshot",
+					"group_snapshot_id", groupSnapshotID,
+					"snapshot_id", metadata.SnapshotID,
+					"error", cleanupErr,
+				)
+			}
+		}
+		return nil, err
 	}
 
-	g.logger.Infow("Snapshot job completed successfully",
-		"snapshot_id", snapshotID,
-		"duration", jobResult.Duration.String(),
-	)
+	// Phase 8: Store individual snapshot metadata (needed by CleanupSnapshot); the
+	// metadata ConfigMap is updated in place, so one snapshot at a time. Replicas to be
+	// copied are recorded as pending.
+	for _, metadata := range snapshots {
+		for _, target := range cfg.ReplicationTargets {
+			metadata.SetReplica(snapshot.Replica{Target: target.Name, State: snapshot.ReplicaPending})
+		}
+		if err := g.snapshotManager.StoreSnapshotMetadata(ctx, metadata); err != nil {
+			g.logger.Warnw("Failed to store snapshot metadata", "snapshot_id", metadata.SnapshotID, "error", err)
+		}
+	}
 
-	// Phase 8: Store individual snapshot metadata (needed by cleanupSnapshot)
-	snapMetadata := &snapshot.SnapshotMetadata{
-		SnapshotID:     snapshotID,
-		SourceVolumeID: sourceVolumeIDs[0],
-		ClusterName:    firstClusterName,
-		CreationTime:   time.Now(),
-		ReadyToUse:     true,
-		PVCName:        snapshotPVCName,
-		Namespace:      firstCluster.volumeInfo.namespace,
+	// Prove the snapshots are restorable in the background when restore drills are enabled
+	if cfg.RestoreDrillEnabled {
+		for i, metadata := range snapshots {
+			g.startRestoreDrill(ctx, metadata, clusters[i].eventTargets)
+		}
 	}
-	if err := g.snapshotManager.StoreSnapshotMetadata(ctx, snapMetadata); err != nil {
-		g.logger.Warnw("Failed to store snapshot metadata", "error", err)
+
+	// Copy the snapshots to the replication targets in the background
+	if len(cfg.ReplicationTargets) > 0 {
+		for _, metadata := range snapshots {
+			g.enqueueReplication(metadata.SnapshotID)
+		}
 	}
 
 	// Store group snapshot metadata
+	first := clusters[0]
 	groupMetadata := &snapshot.GroupSnapshotMetadata{
 		GroupSnapshotID:      groupSnapshotID,
-		SnapshotID:           snapshotID,
 		SourceVolumeIDs:      sourceVolumeIDs,
-		ClusterName:          firstClusterName,
-		SnapshotPVCName:      snapshotPVCName,
-		SnapshotPVCNamespace: firstCluster.volumeInfo.namespace,
+		SnapshotID:           first.snapshotID,
+		ClusterName:          first.info.Name,
+		SnapshotPVCName:      snapshots[0].PVCName,
+		SnapshotPVCNamespace: first.namespace,
+		ContentName:          req.GetParameters()[groupSnapshotContentNameKey],
 		CreationTime:         time.Now(),
 		ReadyToUse:           true,
 	}
+	for i, cluster := range clusters {
+		groupMetadata.Clusters = append(groupMetadata.Clusters, snapshot.GroupClusterSnapshot{
+			SnapshotID:           cluster.snapshotID,
+			ClusterName:          cluster.info.Name,
+			SourceVolumeIDs:      cluster.volumeIDs,
+			SnapshotPVCName:      snapshots[i].PVCName,
+			SnapshotPVCNamespace: cluster.namespace,
+		})
+	}
 
 	if err := g.snapshotManager.StoreGroupSnapshotMetadata(ctx, groupMetadata); err != nil {
 		g.logger.Warnw("Failed to store group snapshot metadata", "error", err)
@@ -288,38 +381,267 @@ func (g *GroupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, r
 
 	g.logger.Infow("CreateVolumeGroupSnapshot workflow completed successfully",
 		"group_snapshot_id", groupSnapshotID,
-		"snapshot_id", snapshotID,
-		"cluster_name", firstClusterName,
+		"cluster_count", len(clusters),
 		"source_volumes_count", len(sourceVolumeIDs),
 	)
 
-	// Phase 9: Build response with single snapshot representing all volumes
+	// Phase 9: Build response with one snapshot per source volume, each carrying the snapshot
+	// of its cluster; the readiness policy may require replicas before they are ready to use
+	creationTimes := make(map[string]time.Time, len(snapshots))
+	ready := true
+	for _, metadata := range snapshots {
+		creationTimes[metadata.SnapshotID] = metadata.CreationTime
+		ready = ready && metadata.ReplicationReady(cfg.ReplicationTargets, cfg.ReplicationReadiness)
+	}
 	response := &csi.CreateVolumeGroupSnapshotResponse{
 		GroupSnapshot: &csi.VolumeGroupSnapshot{
 			GroupSnapshotId: groupSnapshotID,
-			Snapshots: []*csi.Snapshot{
-				{
-					SnapshotId:     snapshotID,
-					SourceVolumeId: sourceVolumeIDs[0],
-					CreationTime:   timestamppb.Now(),
-					ReadyToUse:     true,
-				},
-			},
-			CreationTime: timestamppb.Now(),
-			ReadyToUse:   true,
+			Snapshots:       groupSnapshotEntries(groupMetadata, creationTimes, ready),
+			CreationTime:    timestamppb.New(groupMetadata.CreationTime),
+			ReadyToUse:      ready,
 		},
 	}
 
 	return response, nil
 }
 
+// groupCluster is one ETCD cluster of a group snapshot and the source volumes that belong to it
+type groupCluster struct {
+	info            *etcd.ClusterInfo
+	namespace       string
+	volumeIDs       []string
+	snapshotID      string
+	snapshotPVCName string
+	eventTargets    []runtime.Object
+	// source is the member the snapshot is streamed from
+	source *etcd.SnapshotSource
+	// estimatedSize is the space the cluster's snapshot is expected to take
+	estimatedSize int64
+}
+
+// Helper function to snapshot one cluster of a group snapshot. The returned metadata is
+// not stored yet, so a group that fails as a whole leaves nothing behind.
+func (g *GroupControllerServer) snapshotCluster(ctx context.Context, cfg *ControllerConfig, cluster *groupCluster) (*snapshot.SnapshotMetadata, error) {
+	source := cluster.source
+	format := snapshot.Format{Codec: cfg.SnapshotCompression, Bundle: cfg.SnapshotBundle}
+
+	// Snapshots kept in VolumeSnapshots are written to a PVC of their own, removed once snapshotted
+	if cfg.SnapshotStorageMode == snapshot.StorageVolumeSnapshot {
+		deleteVolume, err := g.provisionSnapshotVolume(ctx, cfg, cluster)
+		if err != nil {
+			g.logger.Errorw("Failed to provision snapshot volume", "snapshot_id", cluster.snapshotID, "error", err)
+			return nil, status.Errorf(codes.Internal, "failed to prepare snapshot storage: %v", err)
+		}
+		defer deleteVolume()
+	}
+
+	// Encrypted snapshots get a fresh data key; the save job is handed it wrapped
+	var encryptionInfo *snapshot.EncryptionInfo
+	if cfg.EncryptionKeyProvider != nil {
+		info, err := g.newDataKey(ctx)
+		if err != nil {
+			g.logger.Errorw("Failed to prepare snapshot data key", "snapshot_id", cluster.snapshotID, "error", err)
+			return nil, status.Errorf(codes.Internal, "failed to prepare snapshot encryption: %v", err)
+		}
+
+		format.Encrypted = true
+		encryptionInfo = info
+	}
+
+	// Bundles capture the cluster's companion Secrets along with the database
+	var companions []string
+	if format.Bundle && cfg.BundleCompanionSelector != "" {
+		var err error
+		if companions, err = g.companionSecrets(ctx, cluster.namespace, cfg.BundleCompanionSelector); err != nil {
+			g.logger.Errorw("Failed to select companion Secrets", "snapshot_id", cluster.snapshotID, "namespace", cluster.namespace, "error", err)
+			return nil, status.Errorf(codes.Internal, "failed to prepare snapshot bundle: %v", err)
+		}
+	}
+
+	jobConfig := &job.JobConfig{
+		DriverName:            cfg.DriverName,
+		SnapshotID:            cluster.snapshotID,
+		Namespace:             cluster.namespace,
+		ETCDEndpoints:         []string{source.ClientURL},
+		SnapshotPVCName:       cluster.snapshotPVCName,
+		SnapshotPVCNamespace:  cluster.namespace,
+		Timeout:               300,
+		BackoffLimit:          cfg.JobBackoffLimit,
+		ActiveDeadlineSeconds: cfg.JobActiveDeadlineSeconds,
+		Operation:             "save",
+		Format:                format,
+		Encryption:            encryptionInfo,
+		KeyProvider:           cfg.EncryptionKeyConfig,
+		TLSEnabled:            cfg.ETCDTLSEnabled,
+		TLSSecretName:         cfg.ETCDTLSSecretName,
+		ClientCertPath:        cfg.ETCDClientCertPath,
+		ClientKeyPath:         cfg.ETCDClientKeyPath,
+		CAPath:                cfg.ETCDCAPath,
+		ETCDVersion:           source.Version,
+		DBSize:                source.DBSize,
+		ETCDImage:             cfg.ETCDImageMatrix.Image(source.Version, cfg.ETCDImage),
+		BusyboxImage:          cfg.BusyboxImage,
+		AgentImage:            cfg.AgentImage,
+		ReportChecksum:        cfg.SigningKeys != nil,
+		ClusterName:           cluster.info.Name,
+		MemberID:              fmt.Sprintf("%x", source.ID),
+		Revision:              source.Revision,
+		CompanionSecrets:      companions,
+	}
+
+	release, err := g.prepareSnapshotPVCJob(ctx, jobConfig)
+	if err != nil {
+		g.logger.Errorw("Snapshot PVC cannot be mounted",
+			"snapshot_id", cluster.snapshotID,
+			"pvc_name", cluster.snapshotPVCName,
+			"error", err,
+		)
+		g.recordEvent(cluster.eventTargets, corev1.EventTypeWarning, ReasonSnapshotJobFailed,
+			"Snapshot PVC %s/%s cannot be mounted: %v", cluster.namespace, cluster.snapshotPVCName, err)
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshot PVC %s/%s cannot be mounted: %v", cluster.namespace, cluster.snapshotPVCName, err)
+	}
+	defer release()
+
+	snapshotJob := job.GenerateSnapshotSaveJob(jobConfig)
+	g.logger.Debugw("Generated snapshot job",
+		"snapshot_id", cluster.snapshotID,
+		"job_name", snapshotJob.Name,
+		"cluster_name", cluster.info.Name,
+		"member", source.Name,
+	)
+
+	g.recordEvent(cluster.eventTargets, corev1.EventTypeNormal, ReasonSnapshotJobStarted,
+		"Started snapshot job %s for ETCD cluster %s from member %s", snapshotJob.Name, cluster.info.Name, source.Name)
+	jobResult, err := g.jobExecutor.ExecuteSnapshotJob(ctx, snapshotJob, cfg.SnapshotTimeout)
+	if err != nil {
+		g.logger.Errorw("Snapshot job failed",
+			"snapshot_id", cluster.snapshotID,
+			"cluster_name", cluster.info.Name,
+			"error", err,
+		)
+		g.recordEvent(cluster.eventTargets, corev1.EventTypeWarning, ReasonSnapshotJobFailed,
+			"Snapshot job %s failed: %v", snapshotJob.Name, err)
+		return nil, status.Errorf(codes.Internal, "snapshot creation failed for ETCD cluster %s: %v", cluster.info.Name, err)
+	}
+	g.recordEvent(cluster.eventTargets, corev1.EventTypeNormal, ReasonSnapshotJobSucceeded,
+		"Snapshot %s completed in %s", cluster.snapshotID, jobResult.Duration.Round(time.Second))
+
+	g.logger.Infow("Snapshot job completed successfully",
+		"snapshot_id", cluster.snapshotID,
+		"cluster_name", cluster.info.Name,
+		"duration", jobResult.Duration.String(),
+	)
+
+	metadata := &snapshot.SnapshotMetadata{
+		SnapshotID:     cluster.snapshotID,
+		SourceVolumeID: cluster.volumeIDs[0],
+		ClusterName:    cluster.info.Name,
+		CreationTime:   time.Now(),
+		ReadyToUse:     true,
+		PVCName:        cluster.snapshotPVCName,
+		Namespace:      cluster.namespace,
+		Compression:    format.Codec,
+		Encryption:     encryptionInfo,
+		MemberID:       fmt.Sprintf("%x", source.ID),
+		MemberName:     source.Name,
+		Revision:       source.Revision,
+		ETCDVersion:    source.Version,
+	}
+
+	// Every source volume of the cluster gets its own member snapshot of the shared file
+	for _, volumeID := range cluster.volumeIDs {
+		metadata.References = append(metadata.References, snapshot.MemberSnapshotID(cluster.snapshotID, volumeID))
+	}
+
+	// Encoded snapshots, and raw ones to be signed, report their stored and uncompressed
+	// sizes and checksum; other raw ones the status of the file
+	if !format.IsRaw() || cfg.SigningKeys != nil {
+		g.recordArtifactInfo(ctx, snapshotJob, metadata)
+	} else {
+		g.recordSnapshotStatus(ctx, snapshotJob, metadata)
+	}
+
+	// The signature covers the checksum, which ties the snapshot's provenance to its file
+	if cfg.SigningKeys != nil {
+		err := metadata.Sign(ctx, cfg.SigningKeys)
+		if err == nil {
+			err = g.storeSignatureFile(ctx, jobConfig, metadata)
+		}
+		if err != nil {
+			g.logger.Errorw("Failed to sign snapshot", "snapshot_id", cluster.snapshotID, "error", err)
+			g.recordEvent(cluster.eventTargets, corev1.EventTypeWarning, ReasonSnapshotSigningFailed,
+				"Snapshot %s could not be signed: %v", cluster.snapshotID, err)
+			err = status.Errorf(codes.Internal, "failed to sign snapshot of ETCD cluster %s: %v", cluster.info.Name, err)
+			// The group's cleanup removes the unsigned file from the snapshot PVC; a volume
+			// of its own is removed on return
+			if cfg.SnapshotStorageMode == snapshot.StorageVolumeSnapshot {
+				return nil, err
+			}
+			return metadata, err
+		}
+	}
+
+	if cfg.SnapshotStorageMode == snapshot.StorageVolumeSnapshot {
+		if err := g.takeVolumeSnapshot(ctx, cfg, cluster, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	return metadata, nil
+}
+
+// Helper function to write a snapshot's signature file next to its file on the snapshot PVC,
+// so copies of the file can be checked without the metadata. The caller still holds the
+// snapshot PVC for the save job, whose node the sign job runs on.
+func (g *GroupControllerServer) storeSignatureFile(ctx context.Context, saveConfig *job.JobConfig, metadata *snapshot.SnapshotMetadata) error {
+	signature, err := metadata.SignatureFile()
+	if err != nil {
+		return err
+	}
+
+	jobConfig := *saveConfig
+	jobConfig.Operation = "sign"
+	jobConfig.Signature = string(signature)
+	signJob := job.GenerateSnapshotSignatureJob(&jobConfig)
+	if _, err := g.jobExecutor.ExecuteSnapshotJob(ctx, signJob, time.Minute); err != nil {
+		return fmt.Errorf("failed to store signature file: %w", err)
+	}
+	return nil
+}
+
+// Helper function to list one CSI snapshot per source volume of a group, each a member
+// snapshot of the snapshot of the cluster the volume belongs to. Snapshots without a known
+// creation time take the group's.
+func groupSnapshotEntries(metadata *snapshot.GroupSnapshotMetadata, creationTimes map[string]time.Time, ready bool) []*csi.Snapshot {
+	entries := make([]*csi.Snapshot, 0, len(metadata.SourceVolumeIDs))
+	for _, volumeID := range metadata.SourceVolumeIDs {
+		s, ok := metadata.SnapshotForVolume(volumeID)
+		if !ok {
+			continue
+		}
+
+		creationTime, ok := creationTimes[s.SnapshotID]
+		if !ok {
+			creationTime = metadata.CreationTime
+		}
+		entries = append(entries, &csi.Snapshot{
+			SnapshotId:     snapshot.MemberSnapshotID(s.SnapshotID, volumeID),
+			SourceVolumeId: volumeID,
+			CreationTime:   timestamppb.New(creationTime),
+			ReadyToUse:     ready,
+		})
+	}
+	return entries
+}
+
 // DeleteVolumeGroupSnapshot deletes all snapshots in a group
 // Workflow:
 // 1. Validate request
 // 2. Retrieve group metadata (idempotent - if not found, return success)
-// 3. Delete each individual snapshot
-// 4. Delete group metadata
-// 5. Return success (always idempotent)
+// 3. Refuse to delete snapshots under legal hold or locked
+// 4. Delete each individual snapshot
+// 5. Delete group metadata
+// 6. Return success (always idempotent)
 func (g *GroupControllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
 	groupSnapshotID := req.GetGroupSnapshotId()
 
@@ -341,21 +663,60 @@ func (g *GroupControllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, r
 		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
 	}
 
+	clusterSnapshots := metadata.ClusterSnapshots()
 	g.logger.Debugw("Retrieved group snapshot metadata",
 		"group_snapshot_id", groupSnapshotID,
-		"snapshot_id", metadata.SnapshotID,
+		"cluster_count", len(clusterSnapshots),
 	)
 
-	// Phase 3: Delete the single snapshot
-	if err := g.cleanupSnapshot(ctx, metadata.SnapshotID); err != nil {
-		g.logger.Warnw("Failed to cleanup snapshot",
-			"snapshot_id", metadata.SnapshotID,
-			"error", err,
-		)
-		// Continue with metadata cleanup even if snapshot cleanup fails
+	eventTargets := g.groupSnapshotEventTargets(ctx, metadata)
+
+	// Phase 3: Refuse to delete held snapshots; holds set through annotations on the
+	// VolumeGroupSnapshotContent are recorded first, and nothing is deleted unless every
+	// snapshot of the group may be
+	if err := g.applyHoldAnnotations(ctx, metadata); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to apply snapshot holds: %v", err)
+	}
+	for _, s := range clusterSnapshots {
+		snapMetadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, s.SnapshotID)
+		if err != nil {
+			continue
+		}
+		if err := snapMetadata.CheckDeletable(time.Now()); err != nil {
+			g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotHeld,
+				"Group snapshot %s cannot be deleted: %v", groupSnapshotID, err)
+			return nil, status.Errorf(codes.FailedPrecondition, "group snapshot %s cannot be deleted: %v", groupSnapshotID, err)
+		}
+	}
+
+	// Phase 4: Delete the member snapshot of each source volume; the snapshot of a
+	// cluster goes with its last member, unless that was already deleted on its own.
+	// The group metadata is kept while any member is left, so the retry finds them.
+	var errs []error
+	for _, s := range clusterSnapshots {
+		for _, volumeID := range s.SourceVolumeIDs {
+			deleted, err := g.deleteMemberSnapshot(ctx, snapshot.MemberSnapshotID(s.SnapshotID, volumeID))
+			if err != nil {
+				g.logger.Warnw("Failed to cleanup snapshot",
+					"snapshot_id", s.SnapshotID,
+					"error", err,
+				)
+				g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotDeleteFailed,
+					"Failed to delete snapshot %s: %v", s.SnapshotID, err)
+				errs = append(errs, fmt.Errorf("snapshot %s: %w", s.SnapshotID, err))
+				continue
+			}
+			if deleted {
+				g.recordEvent(eventTargets, corev1.EventTypeNormal, ReasonSnapshotDeleted,
+					"Deleted snapshot %s of group snapshot %s", s.SnapshotID, groupSnapshotID)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete group snapshot %s: %v", groupSnapshotID, err)
 	}
 
-	// Phase 4: Delete group metadata
+	// Phase 5: Delete group metadata
 	if err := g.snapshotManager.DeleteGroupSnapshotMetadata(ctx, groupSnapshotID); err != nil {
 		g.logger.Warnw("Failed to delete group snapshot metadata",
 			"group_snapshot_id", groupSnapshotID,
@@ -364,7 +725,7 @@ func (g *GroupControllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, r
 		// Still return success
 	}
 
-	// Phase 5: Return success (always idempotent)
+	// Phase 6: Return success (always idempotent)
 	g.logger.Infow("DeleteVolumeGroupSnapshot workflow completed",
 		"group_snapshot_id", groupSnapshotID,
 	)
@@ -395,33 +756,38 @@ func (g *GroupControllerServer) GetVolumeGroupSnapshot(ctx context.Context, req
 		return nil, status.Errorf(codes.NotFound, "group snapshot not found: %s", groupSnapshotID)
 	}
 
+	clusterSnapshots := metadata.ClusterSnapshots()
 	g.logger.Debugw("Retrieved group snapshot metadata",
 		"group_snapshot_id", groupSnapshotID,
-		"snapshot_id", metadata.SnapshotID,
+		"cluster_count", len(clusterSnapshots),
 		"ready_to_use", metadata.ReadyToUse,
 	)
 
-	// Phase 3: Retrieve snapshot metadata for details
-	snapMetadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, metadata.SnapshotID)
-	creationTime := timestamppb.New(metadata.CreationTime)
-	if err == nil && snapMetadata != nil {
-		creationTime = timestamppb.New(snapMetadata.CreationTime)
+	// Phase 3: Retrieve snapshot metadata for details; the group was created when its
+	// earliest snapshot was taken, and is ready once the readiness policy's replicas exist
+	cfg := g.config()
+	creationTimes := make(map[string]time.Time, len(clusterSnapshots))
+	groupCreationTime := metadata.CreationTime
+	ready := metadata.ReadyToUse
+	for i, s := range clusterSnapshots {
+		snapMetadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, s.SnapshotID)
+		if err != nil || snapMetadata == nil {
+			continue
+		}
+		creationTimes[s.SnapshotID] = snapMetadata.CreationTime
+		ready = ready && snapMetadata.ReplicationReady(cfg.ReplicationTargets, cfg.ReplicationReadiness)
+		if i == 0 || snapMetadata.CreationTime.Before(groupCreationTime) {
+			groupCreationTime = snapMetadata.CreationTime
+		}
 	}
 
-	// Phase 4: Build response with single snapshot
+	// Phase 4: Build response with one snapshot per source volume
 	response := &csi.GetVolumeGroupSnapshotResponse{
 		GroupSnapshot: &csi.VolumeGroupSnapshot{
 			GroupSnapshotId: groupSnapshotID,
-			Snapshots: []*csi.Snapshot{
-				{
-					SnapshotId:     metadata.SnapshotID,
-					SourceVolumeId: metadata.SourceVolumeIDs[0],
-					CreationTime:   creationTime,
-					ReadyToUse:     metadata.ReadyToUse,
-				},
-			},
-			CreationTime: creationTime,
-			ReadyToUse:   metadata.ReadyToUse,
+			Snapshots:       groupSnapshotEntries(metadata, creationTimes, ready),
+			CreationTime:    timestamppb.New(groupCreationTime),
+			ReadyToUse:      ready,
 		},
 	}
 
@@ -429,15 +795,15 @@ func (g *GroupControllerServer) GetVolumeGroupSnapshot(ctx context.Context, req
 }
 
 // Helper function to validate PVC for group snapshots
-func (g *GroupControllerServer) validatePVCForGroup(ctx context.Context, namespace, name string) error {
+func (g *GroupControllerServer) validatePVCForGroup(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
 	pvc, err := g.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("PVC not found: %w", err)
+		return nil, fmt.Errorf("PVC not found: %w", err)
 	}
 
 	// Check PVC is bound
 	if pvc.Status.Phase != "Bound" {
-		return fmt.Errorf("PVC not bound, current phase: %s", pvc.Status.Phase)
+		return nil, fmt.Errorf("PVC not bound, current phase: %s", pvc.Status.Phase)
 	}
 
 	// Check access mode supports writing
@@ -449,14 +815,51 @@ func (g *GroupControllerServer) validatePVCForGroup(ctx context.Context, namespa
 		}
 	}
 	if !hasWriteAccess {
-		return fmt.Errorf("PVC does not have write access mode")
+		return nil, fmt.Errorf("PVC does not have write access mode")
 	}
 
-	return nil
+	return pvc, nil
+}
+
+// Helper function to delete one member snapshot of a group snapshot. The snapshot it
+// shares with the other source volumes of its cluster is cleaned up once no member refers
+// to it anymore; deleted reports whether that happened. Member snapshots of a held snapshot
+// are not deleted.
+func (g *GroupControllerServer) deleteMemberSnapshot(ctx context.Context, memberSnapshotID string) (deleted bool, err error) {
+	snapshotID, volumeID := snapshot.ParseMemberSnapshotID(memberSnapshotID)
+	if metadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, snapshotID); err == nil {
+		if err := metadata.CheckDeletable(time.Now()); err != nil {
+			return false, err
+		}
+	}
+	if volumeID != "" {
+		remaining, err := g.snapshotManager.ReleaseSnapshotReference(ctx, snapshotID, memberSnapshotID)
+		if err != nil {
+			return false, err
+		}
+		if remaining > 0 {
+			g.logger.Infow("Snapshot still referenced by other member snapshots",
+				"snapshot_id", snapshotID,
+				"member_snapshot_id", memberSnapshotID,
+				"remaining", remaining,
+			)
+			return false, nil
+		}
+	}
+
+	if _, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, snapshotID); err != nil {
+		// Already deleted
+		return false, nil
+	}
+	if err := g.CleanupSnapshot(ctx, snapshotID); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// Helper function to cleanup a single snapshot (used for error handling in CreateVolumeGroupSnapshot)
-func (g *GroupControllerServer) cleanupSnapshot(ctx context.Context, snapshotID string) error {
+// CleanupSnapshot removes a single snapshot's file, object and replicas and then its
+// metadata. Member snapshot references are not checked; callers release them first.
+func (g *GroupControllerServer) CleanupSnapshot(ctx context.Context, snapshotID string) error {
 	// Retrieve metadata to find the PVC details
 	metadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, snapshotID)
 	if err != nil {
@@ -465,9 +868,47 @@ func (g *GroupControllerServer) cleanupSnapshot(ctx context.Context, snapshotID
 		)
 		return nil
 	}
+	// Nothing deletes a held snapshot
+	if err := metadata.CheckDeletable(time.Now()); err != nil {
+		return err
+	}
+
+	if err := g.deleteSnapshotFile(ctx, metadata); err != nil {
+		return err
+	}
+	g.deleteReplicas(ctx, metadata)
+
+	// Delete metadata
+	if err := g.snapshotManager.DeleteSnapshotMetadata(ctx, snapshotID); err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+
+	g.logger.Infow("Snapshot cleanup completed",
+		"snapshot_id", snapshotID,
+	)
+	return nil
+}
+
+// Helper function to remove a snapshot's file from its snapshot PVC with a delete job, or
+// to delete the VolumeSnapshot or object it is kept in
+func (g *GroupControllerServer) deleteSnapshotFile(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	snapshotID := metadata.SnapshotID
+	if metadata.VolumeSnapshot != nil {
+		return g.deleteVolumeSnapshot(ctx, metadata)
+	}
+	if metadata.ObjectStore != nil {
+		if err := g.deleteSnapshotObject(ctx, metadata); err != nil {
+			return err
+		}
+		// The copy on the snapshot PVC may not have been removed yet
+		if metadata.PVCName == "" {
+			return nil
+		}
+	}
 
 	// Create and execute cleanup job
 	jobConfig := &job.JobConfig{
+		DriverName:            g.config().DriverName,
 		SnapshotID:            snapshotID,
 		Namespace:             metadata.Namespace,
 		SnapshotPVCName:       metadata.PVCName,
@@ -476,7 +917,15 @@ func (g *GroupControllerServer) cleanupSnapshot(ctx context.Context, snapshotID
 		BackoffLimit:          1,
 		ActiveDeadlineSeconds: 120,
 		Operation:             "delete",
+		Format:                metadata.Format(),
+		BusyboxImage:          g.config().BusyboxImage,
+	}
+
+	release, err := g.prepareSnapshotPVCJob(ctx, jobConfig)
+	if err != nil {
+		return fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
 	}
+	defer release()
 
 	deleteJob := job.GenerateSnapshotDeleteJob(jobConfig)
 	g.logger.Debugw("Generated cleanup job",
@@ -496,14 +945,175 @@ func (g *GroupControllerServer) cleanupSnapshot(ctx context.Context, snapshotID
 		return fmt.Errorf("cleanup job failed: %w", err)
 	}
 
-	// Delete metadata
-	if err := g.snapshotManager.DeleteSnapshotMetadata(ctx, snapshotID); err != nil {
-		return fmt.Errorf("failed to delete metadata: %w", err)
+	return nil
+}
+
+// Helper function to run a restore drill without holding up the CSI call that took the snapshot.
+// The drill outlives the call, so it must not share its deadline; its job has its own timeout.
+func (g *GroupControllerServer) startRestoreDrill(ctx context.Context, metadata *snapshot.SnapshotMetadata, eventTargets []runtime.Object) {
+	go g.runRestoreDrill(context.WithoutCancel(ctx), metadata, eventTargets)
+}
+
+// Helper function to run a restore drill against a snapshot and record the outcome in its metadata.
+// A failed drill does not fail the snapshot; the result is only recorded.
+func (g *GroupControllerServer) runRestoreDrill(ctx context.Context, metadata *snapshot.SnapshotMetadata, eventTargets []runtime.Object) {
+	cfg := g.config()
+	names := config.NewNames(cfg.DriverName)
+
+	// Snapshots whose signature is rejected fail their drill without it being run
+	policy := &snapshot.SignaturePolicy{Verifier: cfg.SigningKeys, Strict: cfg.RequireSignatures}
+	if err := policy.Check(ctx, g.logger, metadata); err != nil {
+		g.recordRestoreDrill(ctx, metadata, eventTargets, &snapshot.RestoreDrillStatus{
+			CheckedAt: time.Now(),
+			Message:   fmt.Sprintf("signature rejected: %v", err),
+		})
+		return
 	}
 
-	g.logger.Infow("Snapshot cleanup completed",
-		"snapshot_id", snapshotID,
+	// Snapshots kept in a VolumeSnapshot are drilled from a PVC restored from it
+	snapshotPVCName, releasePVC, pvcErr := snapshot.ReadablePVC(ctx, g.k8sClient, metadata,
+		names.SnapshotVolume(metadata.SnapshotID)+"-drill", map[string]string{"app": names.AppLabel()})
+	if pvcErr == nil {
+		defer releasePVC()
+	}
+
+	jobConfig := &job.JobConfig{
+		DriverName:            cfg.DriverName,
+		SnapshotID:            metadata.SnapshotID,
+		Namespace:             metadata.Namespace,
+		SnapshotPVCName:       snapshotPVCName,
+		SnapshotPVCNamespace:  metadata.Namespace,
+		BackoffLimit:          0,
+		ActiveDeadlineSeconds: cfg.JobActiveDeadlineSeconds,
+		Operation:             "restore-drill",
+		Format:                metadata.Format(),
+		ObjectStore:           metadata.ObjectStore,
+		ChecksumSHA256:        metadata.ChecksumSHA256,
+		AgentImage:            cfg.AgentImage,
+		DrillMinKeys:          cfg.RestoreDrillMinKeys,
+		DrillExpectedPrefixes: cfg.RestoreDrillPrefixes,
+	}
+
+	// Encrypted snapshots are restored with their data key, which the drill unwraps itself
+	var dataKeyErr error
+	if metadata.Encryption != nil {
+		dataKeyErr = g.withDataKey(jobConfig, metadata.Encryption)
+	}
+
+	// The drill runs once the snapshot PVC is free, on the node it is attached to
+	var mountErr error
+	if pvcErr != nil {
+		mountErr = pvcErr
+	} else if release, err := g.prepareSnapshotPVCJob(ctx, jobConfig); err != nil {
+		mountErr = err
+	} else {
+		defer release()
+	}
+
+	drillJob := job.GenerateRestoreDrillJob(jobConfig)
+	g.logger.Debugw("Generated restore drill job",
+		"snapshot_id", metadata.SnapshotID,
+		"job_name", drillJob.Name,
 	)
 
-	return nil
+	var result etcd.DrillResult
+	drillStatus := &snapshot.RestoreDrillStatus{}
+	if dataKeyErr != nil {
+		drillStatus.Message = fmt.Sprintf("failed to prepare snapshot data key: %v", dataKeyErr)
+	} else if mountErr != nil {
+		drillStatus.Message = fmt.Sprintf("snapshot PVC cannot be mounted: %v", mountErr)
+	} else if _, err := g.jobExecutor.ExecuteSnapshotJob(ctx, drillJob, cfg.SnapshotTimeout); err != nil {
+		drillStatus.Message = fmt.Sprintf("restore drill job failed: %v", err)
+	} else if output, err := g.jobExecutor.JobOutput(ctx, drillJob, 20); err != nil {
+		drillStatus.Message = fmt.Sprintf("failed to read restore drill result: %v", err)
+	} else if err := job.DecodeResult(output, &result); err != nil {
+		drillStatus.Message = fmt.Sprintf("failed to read restore drill result: %v", err)
+	} else {
+		drillStatus.Passed = result.Passed
+		drillStatus.TotalKeys = result.TotalKeys
+		drillStatus.Revision = result.Revision
+		drillStatus.Message = result.Message
+	}
+	drillStatus.CheckedAt = time.Now()
+
+	g.recordRestoreDrill(ctx, metadata, eventTargets, drillStatus)
+}
+
+// Helper function to report the outcome of a restore drill and record it in the snapshot's metadata
+func (g *GroupControllerServer) recordRestoreDrill(ctx context.Context, metadata *snapshot.SnapshotMetadata, eventTargets []runtime.Object, drillStatus *snapshot.RestoreDrillStatus) {
+	if drillStatus.Passed {
+		g.logger.Infow("Restore drill passed",
+			"snapshot_id", metadata.SnapshotID,
+			"total_keys", drillStatus.TotalKeys,
+		)
+		g.recordEvent(eventTargets, corev1.EventTypeNormal, ReasonRestoreDrillPassed,
+			"Snapshot %s restored with %d keys at revision %d", metadata.SnapshotID, drillStatus.TotalKeys, drillStatus.Revision)
+	} else {
+		g.logger.Warnw("Restore drill failed",
+			"snapshot_id", metadata.SnapshotID,
+			"reason", drillStatus.Message,
+		)
+		g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonRestoreDrillFailed,
+			"Snapshot %s failed its restore drill: %s", metadata.SnapshotID, drillStatus.Message)
+	}
+
+	metadata.RestoreDrill = drillStatus
+	if _, err := g.snapshotManager.UpdateSnapshotMetadata(ctx, metadata.SnapshotID, func(m *snapshot.SnapshotMetadata) {
+		m.RestoreDrill = drillStatus
+	}); err != nil {
+		g.logger.Warnw("Failed to record restore drill result", "error", err)
+	}
+}
+
+// snapshotFileStatus is the status of a snapshot file as printed by `etcdutl snapshot status -w json`
+type snapshotFileStatus struct {
+	Hash      uint32 `json:"hash"`
+	Revision  int64  `json:"revision"`
+	TotalKey  int    `json:"totalKey"`
+	TotalSize int64  `json:"totalSize"`
+	Version   string `json:"version,omitempty"`
+}
+
+// Helper function to read the size of a raw snapshot from the status a save job printed
+func (g *GroupControllerServer) recordSnapshotStatus(ctx context.Context, saveJob *batchv1.Job, metadata *snapshot.SnapshotMetadata) {
+	output, err := g.jobExecutor.JobOutput(ctx, saveJob, 20)
+	if err != nil {
+		g.logger.Warnw("Failed to read snapshot status", "snapshot_id", metadata.SnapshotID, "error", err)
+		return
+	}
+
+	var fileStatus snapshotFileStatus
+	if err := job.DecodeResult(output, &fileStatus); err != nil {
+		g.logger.Warnw("Failed to decode snapshot status", "snapshot_id", metadata.SnapshotID, "error", err)
+		return
+	}
+
+	metadata.Size = fileStatus.TotalSize
+}
+
+// Helper function to read the sizes and checksum reported by a save job's compress stage into the snapshot metadata
+func (g *GroupControllerServer) recordArtifactInfo(ctx context.Context, saveJob *batchv1.Job, metadata *snapshot.SnapshotMetadata) {
+	output, err := g.jobExecutor.JobOutput(ctx, saveJob, 20)
+	if err != nil {
+		g.logger.Warnw("Failed to read snapshot artifact info", "snapshot_id", metadata.SnapshotID, "error", err)
+		return
+	}
+
+	var info snapshot.ArtifactInfo
+	if err := job.DecodeResult(output, &info); err != nil {
+		g.logger.Warnw("Failed to decode snapshot artifact info", "snapshot_id", metadata.SnapshotID, "error", err)
+		return
+	}
+
+	metadata.Size = info.Size
+	metadata.UncompressedSize = info.UncompressedSize
+	metadata.ChecksumSHA256 = info.ChecksumSHA256
+	metadata.Bundle = info.Bundle
+
+	g.logger.Infow("Snapshot compressed",
+		"snapshot_id", metadata.SnapshotID,
+		"compression", info.Compression,
+		"size", info.Size,
+		"uncompressed_size", info.UncompressedSize,
+	)
 }
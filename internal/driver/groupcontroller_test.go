@@ -2,12 +2,23 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestGroupControllerGetCapabilities(t *testing.T) {
@@ -130,6 +141,57 @@ func TestDeleteVolumeGroupSnapshotIdempotent(t *testing.T) {
 	assert.NotNil(t, resp)
 }
 
+func TestDeleteVolumeGroupSnapshotKeepsGroupOnFailure(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+	server := NewGroupControllerServer(fakeClient,
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	)
+	// The file of the first cluster's snapshot cannot be deleted: its delete job is refused.
+	// The second cluster's snapshot is shared with another group, so only its member goes.
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "group-1-0",
+		Namespace:    "hcp-a",
+		PVCName:      "etcd-snapshots",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		References:   []string{"group-1-0/hcp-a/data-etcd-0"},
+	}))
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "group-1-1",
+		Namespace:    "hcp-b",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		References:   []string{"group-1-1/hcp-b/data-etcd-0", "group-2-0/hcp-b/data-etcd-0"},
+	}))
+	require.NoError(t, server.snapshotManager.StoreGroupSnapshotMetadata(ctx, &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID: "group-1",
+		SourceVolumeIDs: []string{"hcp-a/data-etcd-0", "hcp-b/data-etcd-0"},
+		SnapshotID:      "group-1-0",
+		Clusters: []snapshot.GroupClusterSnapshot{
+			{SnapshotID: "group-1-0", SourceVolumeIDs: []string{"hcp-a/data-etcd-0"}},
+			{SnapshotID: "group-1-1", SourceVolumeIDs: []string{"hcp-b/data-etcd-0"}},
+		},
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+	}))
+
+	_, err := server.DeleteVolumeGroupSnapshot(ctx, &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// The other cluster's member snapshot is still released, but the group stays for the retry
+	shared, err := server.snapshotManager.RetrieveSnapshotMetadata(ctx, "group-1-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-2-0/hcp-b/data-etcd-0"}, shared.References)
+	_, err = server.snapshotManager.RetrieveSnapshotMetadata(ctx, "group-1-0")
+	assert.NoError(t, err)
+	_, err = server.snapshotManager.RetrieveGroupSnapshotMetadata(ctx, "group-1")
+	assert.NoError(t, err)
+}
+
 func TestGetVolumeGroupSnapshotMissingID(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset()
 	logger := zap.NewNop().Sugar()
@@ -177,7 +239,7 @@ func TestGetVolumeGroupSnapshotNotFound(t *testing.T) {
 }
 
 func TestCreateVolumeGroupSnapshotMixedClusters(t *testing.T) {
-	// This test verifies that CreateVolumeGroupSnapshot fails when PVCs belong to different ETCD clusters
+	// PVCs of different ETCD clusters are snapshotted together, one snapshot per cluster.
 	// Since we don't have full mocking infrastructure for ETCD discovery in tests,
 	// this test validates the expected behavior at the validation layer.
 	// Full integration tests would require mocking the discovery.DiscoverCluster method.
@@ -203,3 +265,135 @@ func TestCreateVolumeGroupSnapshotMixedClusters(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 }
+
+func TestGetVolumeGroupSnapshotAcrossClusters(t *testing.T) {
+	server := NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	)
+
+	ctx := context.Background()
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "group-1-0",
+		ClusterName:  "etcd",
+		Namespace:    "hcp",
+		CreationTime: created,
+		ReadyToUse:   true,
+	}))
+	require.NoError(t, server.snapshotManager.StoreGroupSnapshotMetadata(ctx, &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID: "group-1",
+		SourceVolumeIDs: []string{"hcp/data-etcd-0", "hcp/data-etcd-events-0", "hcp/data-etcd-1"},
+		SnapshotID:      "group-1-0",
+		ClusterName:     "etcd",
+		Clusters: []snapshot.GroupClusterSnapshot{
+			{SnapshotID: "group-1-0", ClusterName: "etcd", SourceVolumeIDs: []string{"hcp/data-etcd-0", "hcp/data-etcd-1"}},
+			{SnapshotID: "group-1-1", ClusterName: "etcd-events", SourceVolumeIDs: []string{"hcp/data-etcd-events-0"}},
+		},
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+	}))
+
+	resp, err := server.GetVolumeGroupSnapshot(ctx, &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+	require.NoError(t, err)
+
+	// One member snapshot per source volume, each of the snapshot of its cluster
+	snapshots := resp.GroupSnapshot.Snapshots
+	require.Len(t, snapshots, 3)
+	assert.Equal(t, "hcp/data-etcd-0", snapshots[0].SourceVolumeId)
+	assert.Equal(t, "group-1-0/hcp/data-etcd-0", snapshots[0].SnapshotId)
+	assert.Equal(t, "hcp/data-etcd-events-0", snapshots[1].SourceVolumeId)
+	assert.Equal(t, "group-1-1/hcp/data-etcd-events-0", snapshots[1].SnapshotId)
+	assert.Equal(t, "hcp/data-etcd-1", snapshots[2].SourceVolumeId)
+	assert.Equal(t, "group-1-0/hcp/data-etcd-1", snapshots[2].SnapshotId)
+
+	assert.Equal(t, created, snapshots[0].CreationTime.AsTime().Local())
+	assert.Equal(t, created, resp.GroupSnapshot.CreationTime.AsTime().Local())
+}
+
+func TestCreateVolumeGroupSnapshotDiscoveryFailureRecordsEvent(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-data-0", Namespace: "default"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	fakeClient := fake.NewSimpleClientset(pvc)
+	recorder := record.NewFakeRecorder(10)
+
+	server := NewGroupControllerServer(
+		fakeClient,
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+		WithEventRecorder{Recorder: recorder},
+	)
+
+	req := &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            "test-snapshot",
+		SourceVolumeIds: []string{"default/etcd-data-0"},
+		Parameters: map[string]string{
+			groupSnapshotContentNameKey: "groupsnapcontent-1",
+		},
+	}
+
+	_, err := server.CreateVolumeGroupSnapshot(context.Background(), req)
+	assert.Error(t, err)
+
+	// One event for the VolumeGroupSnapshotContent and one for the source PVC
+	require.Len(t, recorder.Events, 2)
+	for range 2 {
+		assert.Contains(t, <-recorder.Events, "Warning DiscoveryFailed")
+	}
+}
+
+func TestReloadSwapsValidConfig(t *testing.T) {
+	server := NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+		WithSnapShotTimeout(time.Minute),
+		WithJobActiveDeadlineSeconds(600),
+		WithETCDImage("etcd:v1"),
+		WithBusyboxImage("busybox:1"),
+		WithAgentImage("agent:1"),
+		WithSnapshotPVCSize("10Gi"),
+	)
+	before := server.config()
+
+	require.NoError(t, server.Reload(WithETCDImage("etcd:v2"), WithDefaultStorageClass("fast")))
+
+	after := server.config()
+	assert.Equal(t, "etcd:v2", after.ETCDImage)
+	assert.Equal(t, "fast", after.DefaultStorageClass)
+	assert.Equal(t, time.Minute, after.SnapshotTimeout)
+
+	// RPCs holding the previous configuration are unaffected
+	assert.Equal(t, "etcd:v1", before.ETCDImage)
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	server := NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+		WithSnapShotTimeout(time.Minute),
+		WithJobActiveDeadlineSeconds(600),
+		WithETCDImage("etcd:v1"),
+		WithBusyboxImage("busybox:1"),
+		WithAgentImage("agent:1"),
+		WithSnapshotPVCSize("10Gi"),
+		WithETCDTLSEnabled(true),
+		WithETCDClientCertPath("/tls/client.crt"),
+		WithETCDClientKeyPath("/tls/client.key"),
+		WithETCDCAPath("/tls/ca.crt"),
+	)
+
+	err := server.Reload(WithETCDImage(""), WithETCDCAPath("ca.crt"), WithSnapShotTimeout(0))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "etcd image must not be empty")
+	assert.Contains(t, err.Error(), "etcd CA path must be an absolute path")
+	assert.Contains(t, err.Error(), "snapshot timeout must be positive")
+
+	cfg := server.config()
+	assert.Equal(t, "etcd:v1", cfg.ETCDImage)
+	assert.Equal(t, "/tls/ca.crt", cfg.ETCDCAPath)
+	assert.Equal(t, time.Minute, cfg.SnapshotTimeout)
+}
@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// volumeGroupSnapshotContentResource is the resource of the VolumeGroupSnapshotContents
+// group snapshots are bound to
+var volumeGroupSnapshotContentResource = schema.GroupVersionResource{
+	Group:    "groupsnapshot.storage.k8s.io",
+	Version:  "v1beta2",
+	Resource: "volumegroupsnapshotcontents",
+}
+
+// Helper function to apply the hold annotations of a group snapshot's VolumeGroupSnapshotContent
+// to the snapshots of the group. Locks earlier than a snapshot's current lock are ignored. Without
+// a dynamic client or a known VolumeGroupSnapshotContent there are no annotations to read.
+func (g *GroupControllerServer) applyHoldAnnotations(ctx context.Context, metadata *snapshot.GroupSnapshotMetadata) error {
+	cfg := g.config()
+	if cfg.DynamicClient == nil || metadata.ContentName == "" {
+		return nil
+	}
+
+	content, err := cfg.DynamicClient.Resource(volumeGroupSnapshotContentResource).Get(ctx, metadata.ContentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get VolumeGroupSnapshotContent %s: %w", metadata.ContentName, err)
+	}
+
+	names := config.NewNames(cfg.DriverName)
+	annotations := content.GetAnnotations()
+	hold, err := snapshot.ParseHold(annotations[names.LegalHoldAnnotation()], annotations[names.LockedUntilAnnotation()])
+	if err != nil {
+		return fmt.Errorf("VolumeGroupSnapshotContent %s: %w", metadata.ContentName, err)
+	}
+	if hold.IsZero() {
+		return nil
+	}
+
+	for _, s := range metadata.ClusterSnapshots() {
+		current, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, s.SnapshotID)
+		if errors.Is(err, snapshot.ErrSnapshotNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		change := hold
+		if current.LockedUntil != nil && !change.LockedUntil.After(*current.LockedUntil) {
+			change.LockedUntil = time.Time{}
+		}
+		if change.LegalHold != nil && *change.LegalHold == current.LegalHold {
+			change.LegalHold = nil
+		}
+		if change.IsZero() {
+			continue
+		}
+
+		if _, err := g.setSnapshotHold(ctx, s.SnapshotID, change); err != nil && !errors.Is(err, snapshot.ErrSnapshotNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function to record a hold on a snapshot and map it onto the object lock of every
+// copy of the snapshot in an object store
+func (g *GroupControllerServer) setSnapshotHold(ctx context.Context, snapshotID string, hold snapshot.Hold) (*snapshot.SnapshotMetadata, error) {
+	metadata, err := g.snapshotManager.SetSnapshotHold(ctx, snapshotID, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	held, reason := metadata.Held(time.Now())
+	g.logger.Infow("Snapshot hold changed",
+		"snapshot_id", snapshotID,
+		"held", held,
+		"reason", reason,
+	)
+
+	for _, location := range objectLocations(metadata) {
+		g.lockObject(ctx, metadata, location)
+	}
+	return metadata, nil
+}
+
+// Helper function to map the hold of a snapshot onto the object lock of one of its copies.
+// A store without object lock does not keep the snapshot from being deleted through the
+// driver, so failures are logged rather than returned.
+func (g *GroupControllerServer) lockObject(ctx context.Context, metadata *snapshot.SnapshotMetadata, location *snapshot.ObjectLocation) {
+	client, err := g.objectClient(ctx, metadata.Namespace, location)
+	if err == nil {
+		err = client.Lock(ctx, location.Key, metadata.LegalHold, metadata.LockedUntil)
+	}
+	if err != nil {
+		g.logger.Warnw("Failed to apply object lock to snapshot copy",
+			"snapshot_id", metadata.SnapshotID,
+			"bucket", location.Bucket,
+			"key", location.Key,
+			"error", err,
+		)
+	}
+}
+
+// Helper function to lock a copy of a snapshot just written to an object store, if the
+// snapshot is held
+func (g *GroupControllerServer) lockNewObject(ctx context.Context, metadata *snapshot.SnapshotMetadata, location *snapshot.ObjectLocation) {
+	if metadata.LegalHold || metadata.LockedUntil != nil {
+		g.lockObject(ctx, metadata, location)
+	}
+}
+
+// objectLocations returns where the copies of a snapshot in object stores are kept
+func objectLocations(metadata *snapshot.SnapshotMetadata) []*snapshot.ObjectLocation {
+	var locations []*snapshot.ObjectLocation
+	if metadata.ObjectStore != nil {
+		locations = append(locations, metadata.ObjectStore)
+	}
+	for _, replica := range metadata.Replicas {
+		if replica.State == snapshot.ReplicaReplicated && replica.Location != nil {
+			locations = append(locations, replica.Location)
+		}
+	}
+	return locations
+}
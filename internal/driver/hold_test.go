@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteVolumeGroupSnapshotRefusesHeldSnapshots(t *testing.T) {
+	ctx := context.Background()
+	content := &unstructured.Unstructured{}
+	content.SetGroupVersionKind(volumeGroupSnapshotContentResource.GroupVersion().WithKind("VolumeGroupSnapshotContent"))
+	content.SetName("groupsnapcontent-1")
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), content)
+
+	server := NewGroupControllerServer(fake.NewSimpleClientset(),
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithDynamicClient{Client: dynamicClient},
+	)
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "group-1-0",
+		Namespace:    "hcp",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		// Another group shares the snapshot, so deleting this one leaves the file in place
+		References: []string{"group-1-0/hcp/data-etcd-0", "group-2-0/hcp/data-etcd-0"},
+	}))
+	require.NoError(t, server.snapshotManager.StoreGroupSnapshotMetadata(ctx, &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID: "group-1",
+		SourceVolumeIDs: []string{"hcp/data-etcd-0"},
+		SnapshotID:      "group-1-0",
+		ContentName:     "groupsnapcontent-1",
+		CreationTime:    time.Now(),
+		ReadyToUse:      true,
+	}))
+
+	deleteGroup := func() error {
+		_, err := server.DeleteVolumeGroupSnapshot(ctx, &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+		return err
+	}
+
+	// A legal hold set through the VolumeGroupSnapshotContent is recorded and refuses deletion
+	content.SetAnnotations(map[string]string{"etcd-snapshot-driver/legal-hold": "true"})
+	_, err := dynamicClient.Resource(volumeGroupSnapshotContentResource).Update(ctx, content, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(deleteGroup()))
+	metadata, err := server.snapshotManager.RetrieveSnapshotMetadata(ctx, "group-1-0")
+	require.NoError(t, err)
+	assert.True(t, metadata.LegalHold)
+	assert.Equal(t, []string{"group-1-0/hcp/data-etcd-0", "group-2-0/hcp/data-etcd-0"}, metadata.References)
+
+	// The member snapshot cannot be deleted on its own either
+	_, err = NewSnapshotControllerServer(server).DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "group-1-0/hcp/data-etcd-0"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Releasing the hold allows deletion
+	content.SetAnnotations(map[string]string{"etcd-snapshot-driver/legal-hold": "false"})
+	_, err = dynamicClient.Resource(volumeGroupSnapshotContentResource).Update(ctx, content, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, deleteGroup())
+	metadata, err = server.snapshotManager.RetrieveSnapshotMetadata(ctx, "group-1-0")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-2-0/hcp/data-etcd-0"}, metadata.References)
+	_, err = server.snapshotManager.RetrieveGroupSnapshotMetadata(ctx, "group-1")
+	assert.Error(t, err)
+}
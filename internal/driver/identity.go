@@ -10,7 +10,8 @@ import (
 )
 
 const (
-	DriverName = "etcd-snapshot-driver"
+	// DriverName is the default CSI driver name
+	DriverName = config.DefaultDriverName
 )
 
 type IdentityServer struct {
@@ -33,7 +34,7 @@ func (s *IdentityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginIn
 	s.cfg.Logger.Debugw("GetPluginInfo called")
 
 	return &csi.GetPluginInfoResponse{
-		Name:          DriverName,
+		Name:          s.cfg.DriverName,
 		VendorVersion: config.Version,
 	}, nil
 }
@@ -65,7 +66,8 @@ func (s *IdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi
 }
 
 type IdentityServerConfig struct {
-	Logger *zap.SugaredLogger
+	Logger     *zap.SugaredLogger
+	DriverName string
 }
 
 func (c *IdentityServerConfig) Options(opts ...IdentityServerOption) {
@@ -78,6 +80,9 @@ func (c *IdentityServerConfig) Default() {
 	if c.Logger == nil {
 		c.Logger = zap.NewNop().Sugar()
 	}
+	if c.DriverName == "" {
+		c.DriverName = DriverName
+	}
 }
 
 type IdentityServerOption interface {
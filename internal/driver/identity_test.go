@@ -25,6 +25,19 @@ func TestIdentityGetPluginInfo(t *testing.T) {
 	}
 }
 
+func TestIdentityGetPluginInfoDriverName(t *testing.T) {
+	server := driver.NewIdentityServer(driver.WithDriverName("hypershift-etcd.example.com"))
+
+	resp, err := server.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginInfo failed: %v", err)
+	}
+
+	if resp.Name != "hypershift-etcd.example.com" {
+		t.Errorf("Expected name 'hypershift-etcd.example.com', got '%s'", resp.Name)
+	}
+}
+
 func TestIdentityGetPluginCapabilities(t *testing.T) {
 	server := driver.NewIdentityServer()
 
@@ -3,7 +3,15 @@ package driver
 import (
 	"time"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/metrics"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
 	"go.uber.org/zap"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 )
 
 type WithSnapShotTimeout time.Duration
@@ -36,6 +44,14 @@ func (w WithETCDImage) ConfigureController(c *ControllerConfig) {
 	c.ETCDImage = string(w)
 }
 
+// WithETCDImageMatrix sets the images whose etcd tooling matches each etcd minor version;
+// servers of other versions get the etcd image
+type WithETCDImageMatrix job.ImageMatrix
+
+func (w WithETCDImageMatrix) ConfigureController(c *ControllerConfig) {
+	c.ETCDImageMatrix = job.ImageMatrix(w)
+}
+
 type WithBusyboxImage string
 
 func (w WithBusyboxImage) ConfigureController(c *ControllerConfig) {
@@ -90,6 +106,209 @@ func (w WithSnapshotPVCSize) ConfigureController(c *ControllerConfig) {
 	c.SnapshotPVCSize = string(w)
 }
 
+type WithSnapshotCompression string
+
+func (w WithSnapshotCompression) ConfigureController(c *ControllerConfig) {
+	c.SnapshotCompression = snapshot.Codec(w)
+}
+
+// WithSnapshotBundle stores new snapshots as bundles of the database and its companions
+type WithSnapshotBundle bool
+
+func (w WithSnapshotBundle) ConfigureController(c *ControllerConfig) {
+	c.SnapshotBundle = bool(w)
+}
+
+// WithBundleCompanionSelector selects the Secrets, in the namespace of each cluster,
+// captured in its snapshot bundles
+type WithBundleCompanionSelector string
+
+func (w WithBundleCompanionSelector) ConfigureController(c *ControllerConfig) {
+	c.BundleCompanionSelector = string(w)
+}
+
+// WithSnapshotStorageMode sets where snapshot files are kept
+type WithSnapshotStorageMode string
+
+func (w WithSnapshotStorageMode) ConfigureController(c *ControllerConfig) {
+	c.SnapshotStorageMode = snapshot.StorageMode(w)
+}
+
+// WithVolumeSnapshotClass sets the VolumeSnapshotClass of snapshots kept in VolumeSnapshots
+type WithVolumeSnapshotClass string
+
+func (w WithVolumeSnapshotClass) ConfigureController(c *ControllerConfig) {
+	c.VolumeSnapshotClass = string(w)
+}
+
+// WithDynamicClient sets the client used for VolumeSnapshots
+type WithDynamicClient struct {
+	Client dynamic.Interface
+}
+
+func (w WithDynamicClient) ConfigureController(c *ControllerConfig) {
+	c.DynamicClient = w.Client
+}
+
+// WithTieringColdAfter sets the age at which snapshots move from the snapshot PVC to the
+// object store; zero disables tiering
+type WithTieringColdAfter time.Duration
+
+func (w WithTieringColdAfter) ConfigureController(c *ControllerConfig) {
+	c.TieringColdAfter = time.Duration(w)
+}
+
+// WithTieringInterval sets how often the tiering policy is applied
+type WithTieringInterval time.Duration
+
+func (w WithTieringInterval) ConfigureController(c *ControllerConfig) {
+	c.TieringInterval = time.Duration(w)
+}
+
+type WithObjectStoreEndpoint string
+
+func (w WithObjectStoreEndpoint) ConfigureController(c *ControllerConfig) {
+	c.ObjectStoreEndpoint = string(w)
+}
+
+type WithObjectStoreRegion string
+
+func (w WithObjectStoreRegion) ConfigureController(c *ControllerConfig) {
+	c.ObjectStoreRegion = string(w)
+}
+
+type WithObjectStoreBucket string
+
+func (w WithObjectStoreBucket) ConfigureController(c *ControllerConfig) {
+	c.ObjectStoreBucket = string(w)
+}
+
+// WithObjectStorePrefix sets the key prefix snapshots are stored under
+type WithObjectStorePrefix string
+
+func (w WithObjectStorePrefix) ConfigureController(c *ControllerConfig) {
+	c.ObjectStorePrefix = string(w)
+}
+
+type WithObjectStoreInsecure bool
+
+func (w WithObjectStoreInsecure) ConfigureController(c *ControllerConfig) {
+	c.ObjectStoreInsecure = bool(w)
+}
+
+// WithObjectStoreSecretName sets the Secret holding the object store credentials, which
+// has to exist in every namespace snapshots are taken in
+type WithObjectStoreSecretName string
+
+func (w WithObjectStoreSecretName) ConfigureController(c *ControllerConfig) {
+	c.ObjectStoreSecretName = string(w)
+}
+
+// WithReplicationTargets sets the secondary locations every snapshot is copied to
+type WithReplicationTargets []snapshot.ReplicationTarget
+
+func (w WithReplicationTargets) ConfigureController(c *ControllerConfig) {
+	c.ReplicationTargets = []snapshot.ReplicationTarget(w)
+}
+
+// WithReplicationReadiness sets how far a snapshot has to be replicated to be ready to use
+type WithReplicationReadiness string
+
+func (w WithReplicationReadiness) ConfigureController(c *ControllerConfig) {
+	c.ReplicationReadiness = snapshot.ReplicationReadiness(w)
+}
+
+// WithReplicationInterval sets how often snapshots are checked for missing replicas
+type WithReplicationInterval time.Duration
+
+func (w WithReplicationInterval) ConfigureController(c *ControllerConfig) {
+	c.ReplicationInterval = time.Duration(w)
+}
+
+// WithMemberSelection sets the policy that picks the member each snapshot is streamed from
+type WithMemberSelection string
+
+func (w WithMemberSelection) ConfigureController(c *ControllerConfig) {
+	c.MemberSelection = etcd.MemberSelection(w)
+}
+
+type WithAgentImage string
+
+func (w WithAgentImage) ConfigureController(c *ControllerConfig) {
+	c.AgentImage = string(w)
+}
+
+type WithRestoreDrillEnabled bool
+
+func (w WithRestoreDrillEnabled) ConfigureController(c *ControllerConfig) {
+	c.RestoreDrillEnabled = bool(w)
+}
+
+type WithRestoreDrillMinKeys int64
+
+func (w WithRestoreDrillMinKeys) ConfigureController(c *ControllerConfig) {
+	c.RestoreDrillMinKeys = int64(w)
+}
+
+type WithRestoreDrillPrefixes []string
+
+func (w WithRestoreDrillPrefixes) ConfigureController(c *ControllerConfig) {
+	c.RestoreDrillPrefixes = []string(w)
+}
+
+// WithEncryptionKeyProvider enables envelope encryption of stored snapshots. Config
+// selects the same keys for the jobs, which unwrap the data keys they need themselves.
+type WithEncryptionKeyProvider struct {
+	Provider encryption.KeyProvider
+	Config   encryption.ProviderConfig
+}
+
+func (w WithEncryptionKeyProvider) ConfigureController(c *ControllerConfig) {
+	c.EncryptionKeyProvider = w.Provider
+	c.EncryptionKeyConfig = w.Config
+}
+
+type WithEncryptionKeyID string
+
+func (w WithEncryptionKeyID) ConfigureController(c *ControllerConfig) {
+	c.EncryptionKeyID = string(w)
+}
+
+// WithSigningKeys enables signing of new snapshots and verifying the signatures of
+// snapshots before they are drilled
+type WithSigningKeys struct {
+	Keys signing.Keys
+}
+
+func (w WithSigningKeys) ConfigureController(c *ControllerConfig) {
+	c.SigningKeys = w.Keys
+}
+
+// WithRequireSignatures rejects snapshots that are unsigned or whose signature does not match
+type WithRequireSignatures bool
+
+func (w WithRequireSignatures) ConfigureController(c *ControllerConfig) {
+	c.RequireSignatures = bool(w)
+}
+
+// WithEventRecorder sets the recorder used to post snapshot lifecycle events
+type WithEventRecorder struct {
+	Recorder record.EventRecorder
+}
+
+func (w WithEventRecorder) ConfigureController(c *ControllerConfig) {
+	c.EventRecorder = w.Recorder
+}
+
+// WithMetrics sets the metrics the controller reports to
+type WithMetrics struct {
+	Metrics *metrics.Metrics
+}
+
+func (w WithMetrics) ConfigureController(c *ControllerConfig) {
+	c.Metrics = w.Metrics
+}
+
 type WithLogger struct {
 	Logger *zap.SugaredLogger
 }
@@ -104,6 +323,26 @@ func (w WithLogger) ConfigureController(c *ControllerConfig) {
 	c.Logger = w.Logger
 }
 
+// WithDriverName sets the CSI driver name, which also isolates the objects each driver instance owns
+type WithDriverName string
+
+func (w WithDriverName) ConfigureDriver(c *DriverConfig) {
+	c.DriverName = string(w)
+}
+func (w WithDriverName) ConfigureIdentityServer(c *IdentityServerConfig) {
+	c.DriverName = string(w)
+}
+func (w WithDriverName) ConfigureController(c *ControllerConfig) {
+	c.DriverName = string(w)
+}
+
+// WithMetadataNamespace sets the namespace holding the snapshot metadata ConfigMaps
+type WithMetadataNamespace string
+
+func (w WithMetadataNamespace) ConfigureController(c *ControllerConfig) {
+	c.MetadataNamespace = string(w)
+}
+
 type WithEndPoint string
 
 func (w WithEndPoint) ConfigureDriver(c *DriverConfig) {
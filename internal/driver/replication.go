@@ -0,0 +1,314 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Failed replications are retried with exponential backoff between these delays
+const (
+	replicationRetryBaseDelay = 10 * time.Second
+	replicationRetryMaxDelay  = 15 * time.Minute
+)
+
+// replicationQueue holds the IDs of snapshots with replicas still to be copied
+type replicationQueue struct {
+	workqueue.TypedRateLimitingInterface[string]
+}
+
+// RunReplication copies every snapshot to the configured replication targets until ctx is
+// done. Snapshots are queued as they are taken and by a periodic resync, which also catches
+// snapshots taken while no replicator ran and targets added later; failed copies are
+// requeued with backoff.
+func (g *GroupControllerServer) RunReplication(ctx context.Context) {
+	queue := &replicationQueue{workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](replicationRetryBaseDelay, replicationRetryMaxDelay),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "snapshot-replication"},
+	)}
+	g.replicationQueue.Store(queue)
+	defer g.replicationQueue.CompareAndSwap(queue, nil)
+
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+	go g.resyncReplication(ctx, queue)
+
+	for g.processReplication(ctx, queue) {
+	}
+}
+
+// Helper function to queue a new snapshot for replication; without a running replicator
+// the next resync picks it up
+func (g *GroupControllerServer) enqueueReplication(snapshotID string) {
+	if queue := g.replicationQueue.Load(); queue != nil {
+		queue.Add(snapshotID)
+	}
+}
+
+// Helper function to queue every snapshot with missing replicas and report replication lag,
+// once per replication interval
+func (g *GroupControllerServer) resyncReplication(ctx context.Context, queue *replicationQueue) {
+	for {
+		if len(g.config().ReplicationTargets) > 0 {
+			snapshots, err := g.snapshotManager.ListSnapshotMetadata(ctx)
+			if err != nil {
+				g.logger.Warnw("Failed to list snapshots for replication", "error", err)
+			}
+			for _, metadata := range snapshots {
+				// Snapshots waiting out a retry backoff are already queued
+				if needsReplication(metadata, g.config().ReplicationTargets) && queue.NumRequeues(metadata.SnapshotID) == 0 {
+					queue.Add(metadata.SnapshotID)
+				}
+			}
+			g.reportReplicationLag(snapshots, time.Now())
+		}
+
+		interval := g.config().ReplicationInterval
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// needsReplication reports whether a ready snapshot has yet to be copied to any of targets
+func needsReplication(metadata *snapshot.SnapshotMetadata, targets []snapshot.ReplicationTarget) bool {
+	if !metadata.ReadyToUse {
+		return false
+	}
+	for _, target := range targets {
+		if !metadata.Replicated(target.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to set the replication lag of each target: the age of its oldest ready
+// snapshot not yet replicated there, or 0 once it has caught up
+func (g *GroupControllerServer) reportReplicationLag(snapshots []*snapshot.SnapshotMetadata, now time.Time) {
+	cfg := g.config()
+	if cfg.Metrics == nil {
+		return
+	}
+
+	for _, target := range cfg.ReplicationTargets {
+		var lag time.Duration
+		for _, metadata := range snapshots {
+			if metadata.ReadyToUse && !metadata.Replicated(target.Name) {
+				lag = max(lag, now.Sub(metadata.CreationTime))
+			}
+		}
+		cfg.Metrics.ReplicationLag.WithLabelValues(target.Name).Set(lag.Seconds())
+	}
+}
+
+// Helper function to replicate the next queued snapshot; false once the queue shuts down
+func (g *GroupControllerServer) processReplication(ctx context.Context, queue *replicationQueue) bool {
+	snapshotID, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(snapshotID)
+
+	if err := g.replicateSnapshot(ctx, snapshotID); err != nil {
+		g.logger.Warnw("Snapshot replication failed, will retry",
+			"snapshot_id", snapshotID,
+			"attempt", queue.NumRequeues(snapshotID)+1,
+			"error", err,
+		)
+		queue.AddRateLimited(snapshotID)
+	} else {
+		queue.Forget(snapshotID)
+	}
+
+	if snapshots, err := g.snapshotManager.ListSnapshotMetadata(ctx); err == nil {
+		g.reportReplicationLag(snapshots, time.Now())
+	}
+	return true
+}
+
+// Helper function to copy a snapshot to every replication target it is missing from and
+// record the outcome per replica. A snapshot deleted in the meantime is not an error.
+func (g *GroupControllerServer) replicateSnapshot(ctx context.Context, snapshotID string) error {
+	cfg := g.config()
+
+	metadata, err := g.snapshotManager.RetrieveSnapshotMetadata(ctx, snapshotID)
+	if errors.Is(err, snapshot.ErrSnapshotNotFound) || apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metadata.ReadyToUse {
+		return nil
+	}
+	// Events are posted on the snapshot PVC while the snapshot is kept on it
+	var eventTargets []runtime.Object
+	if metadata.PVCName != "" {
+		eventTargets = append(eventTargets, g.pvcEventTarget(ctx, metadata.Namespace, metadata.PVCName))
+	}
+
+	var errs []error
+	for _, target := range cfg.ReplicationTargets {
+		if metadata.Replicated(target.Name) {
+			continue
+		}
+
+		replica := snapshot.Replica{Target: target.Name}
+		if existing, ok := metadata.Replica(target.Name); ok {
+			replica = *existing
+		}
+		replica.Location = target.Location(metadata)
+		replica.LastAttemptTime = time.Now()
+
+		result, err := g.copyToReplica(ctx, cfg, metadata, target.Name, replica.Location)
+		if err != nil {
+			replica.State = snapshot.ReplicaFailed
+			replica.Attempts++
+			replica.LastError = err.Error()
+			errs = append(errs, fmt.Errorf("replicating to %s: %w", target.Name, err))
+			g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotReplicationFailed,
+				"Snapshot %s could not be replicated to %s: %v", snapshotID, target.Name, err)
+		} else {
+			replica.State = snapshot.ReplicaReplicated
+			replica.ChecksumSHA256 = result.ChecksumSHA256
+			replica.Attempts = 0
+			replica.LastError = ""
+			replica.ReplicatedTime = time.Now()
+			g.logger.Infow("Snapshot replicated",
+				"snapshot_id", snapshotID,
+				"target", target.Name,
+				"bucket", replica.Location.Bucket,
+				"key", replica.Location.Key,
+			)
+			g.recordEvent(eventTargets, corev1.EventTypeNormal, ReasonSnapshotReplicated,
+				"Snapshot %s replicated to %s", snapshotID, target.Name)
+		}
+		if cfg.Metrics != nil {
+			cfg.Metrics.ReplicationsTotal.WithLabelValues(target.Name, string(replica.State)).Inc()
+		}
+
+		updated, err := g.snapshotManager.UpdateSnapshotMetadata(ctx, snapshotID, func(m *snapshot.SnapshotMetadata) {
+			m.SetReplica(replica)
+		})
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if updated == nil {
+			// Deleted while it was copied; the copy is not referenced by anything
+			if replica.State == snapshot.ReplicaReplicated {
+				g.deleteReplica(ctx, metadata, replica)
+			}
+			return nil
+		}
+		metadata = updated
+		if replica.State == snapshot.ReplicaReplicated {
+			g.lockNewObject(ctx, metadata, replica.Location)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Helper function to copy a snapshot's file to one replication target with a replicate job
+func (g *GroupControllerServer) copyToReplica(ctx context.Context, cfg *ControllerConfig, metadata *snapshot.SnapshotMetadata, target string, location *snapshot.ObjectLocation) (*objectstore.Result, error) {
+	names := config.NewNames(cfg.DriverName)
+
+	// Snapshots kept in a VolumeSnapshot are read from a PVC restored from it
+	snapshotPVCName, releasePVC, err := snapshot.ReadablePVC(ctx, g.k8sClient, metadata,
+		names.SnapshotVolume(metadata.SnapshotID)+"-replicate", map[string]string{"app": names.AppLabel()})
+	if err != nil {
+		return nil, err
+	}
+	defer releasePVC()
+
+	signature, err := metadata.SignatureFile()
+	if err != nil {
+		return nil, err
+	}
+
+	jobConfig := &job.JobConfig{
+		DriverName:            cfg.DriverName,
+		SnapshotID:            metadata.SnapshotID,
+		Namespace:             metadata.Namespace,
+		SnapshotPVCName:       snapshotPVCName,
+		SnapshotPVCNamespace:  metadata.Namespace,
+		BackoffLimit:          cfg.JobBackoffLimit,
+		ActiveDeadlineSeconds: cfg.JobActiveDeadlineSeconds,
+		Operation:             "snapshot-replicate",
+		Format:                metadata.Format(),
+		ObjectStore:           metadata.ObjectStore,
+		ChecksumSHA256:        metadata.ChecksumSHA256,
+		ReplicaTarget:         target,
+		ReplicaLocation:       location,
+		Signature:             string(signature),
+		AgentImage:            cfg.AgentImage,
+	}
+
+	release, err := g.prepareSnapshotPVCJob(ctx, jobConfig)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
+	}
+	defer release()
+
+	replicateJob := job.GenerateSnapshotReplicateJob(jobConfig)
+	g.logger.Debugw("Generated snapshot replicate job",
+		"snapshot_id", metadata.SnapshotID,
+		"job_name", replicateJob.Name,
+	)
+
+	if _, err := g.jobExecutor.ExecuteSnapshotJob(ctx, replicateJob, cfg.SnapshotTimeout); err != nil {
+		// The retry runs a job of the same name
+		if deleteErr := g.jobExecutor.DeleteJob(ctx, replicateJob); deleteErr != nil {
+			g.logger.Warnw("Failed to delete replicate job", "job_name", replicateJob.Name, "error", deleteErr)
+		}
+		return nil, fmt.Errorf("replicate job failed: %w", err)
+	}
+
+	output, err := g.jobExecutor.JobOutput(ctx, replicateJob, 20)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replicate job result: %w", err)
+	}
+	var result objectstore.Result
+	if err := job.DecodeResult(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to read replicate job result: %w", err)
+	}
+	return &result, nil
+}
+
+// Helper function to delete the replicas of a snapshot. Replicas that cannot be deleted are
+// logged and left behind rather than keeping the snapshot around.
+func (g *GroupControllerServer) deleteReplicas(ctx context.Context, metadata *snapshot.SnapshotMetadata) {
+	for _, replica := range metadata.Replicas {
+		if replica.Location != nil {
+			g.deleteReplica(ctx, metadata, replica)
+		}
+	}
+}
+
+func (g *GroupControllerServer) deleteReplica(ctx context.Context, metadata *snapshot.SnapshotMetadata, replica snapshot.Replica) {
+	if err := g.deleteObject(ctx, metadata.Namespace, replica.Location); err != nil {
+		g.logger.Warnw("Failed to delete snapshot replica",
+			"snapshot_id", metadata.SnapshotID,
+			"target", replica.Target,
+			"error", err,
+		)
+	}
+}
@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNeedsReplication(t *testing.T) {
+	targets := []snapshot.ReplicationTarget{{Name: "dr"}, {Name: "lab"}}
+
+	assert.False(t, needsReplication(&snapshot.SnapshotMetadata{}, targets))
+	assert.True(t, needsReplication(&snapshot.SnapshotMetadata{ReadyToUse: true}, targets))
+	assert.True(t, needsReplication(&snapshot.SnapshotMetadata{
+		ReadyToUse: true,
+		Replicas: []snapshot.Replica{
+			{Target: "dr", State: snapshot.ReplicaReplicated},
+			{Target: "lab", State: snapshot.ReplicaFailed},
+		},
+	}, targets))
+	assert.False(t, needsReplication(&snapshot.SnapshotMetadata{
+		ReadyToUse: true,
+		Replicas: []snapshot.Replica{
+			{Target: "dr", State: snapshot.ReplicaReplicated},
+			{Target: "lab", State: snapshot.ReplicaReplicated},
+		},
+	}, targets))
+	assert.False(t, needsReplication(&snapshot.SnapshotMetadata{ReadyToUse: true}, nil))
+}
+
+func TestGetVolumeGroupSnapshotWaitsForReplicas(t *testing.T) {
+	server := NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+		WithReplicationTargets([]snapshot.ReplicationTarget{
+			{Name: "dr", Endpoint: "s3.example.com", Bucket: "dr", SecretName: "dr-credentials"},
+		}),
+		WithReplicationReadiness("all-replicas"),
+	)
+
+	ctx := context.Background()
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "group-1-0",
+		ClusterName:  "etcd",
+		Namespace:    "hcp",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		Replicas:     []snapshot.Replica{{Target: "dr", State: snapshot.ReplicaPending}},
+	}))
+	require.NoError(t, server.snapshotManager.StoreGroupSnapshotMetadata(ctx, &snapshot.GroupSnapshotMetadata{
+		GroupSnapshotID: "group-1",
+		SourceVolumeIDs: []string{"hcp/data-etcd-0"},
+		SnapshotID:      "group-1-0",
+		ClusterName:     "etcd",
+		CreationTime:    time.Now(),
+		ReadyToUse:      true,
+	}))
+
+	// Stored but not yet replicated
+	resp, err := server.GetVolumeGroupSnapshot(ctx, &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+	require.NoError(t, err)
+	assert.False(t, resp.GroupSnapshot.ReadyToUse)
+	for _, s := range resp.GroupSnapshot.Snapshots {
+		assert.False(t, s.ReadyToUse)
+	}
+
+	_, err = server.snapshotManager.UpdateSnapshotMetadata(ctx, "group-1-0", func(m *snapshot.SnapshotMetadata) {
+		m.SetReplica(snapshot.Replica{Target: "dr", State: snapshot.ReplicaReplicated})
+	})
+	require.NoError(t, err)
+
+	resp, err = server.GetVolumeGroupSnapshot(ctx, &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+	require.NoError(t, err)
+	assert.True(t, resp.GroupSnapshot.ReadyToUse)
+	for _, s := range resp.GroupSnapshot.Snapshots {
+		assert.True(t, s.ReadyToUse)
+	}
+}
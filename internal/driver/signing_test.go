@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRestoreDrillRejectsUnsignedSnapshots(t *testing.T) {
+	ctx := context.Background()
+	k8sClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-snapshot-signing-key", Namespace: "etcd-snapshot-driver"},
+		Data:       map[string][]byte{signing.PrivateKeyKey: []byte(strings.Repeat("01", 32))},
+	})
+	recorder := record.NewFakeRecorder(10)
+	server := NewGroupControllerServer(k8sClient,
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithEventRecorder{Recorder: recorder},
+		WithSigningKeys{Keys: signing.NewSecretKeys(k8sClient, "etcd-snapshot-driver", "etcd-snapshot-signing-key")},
+		WithRequireSignatures(true),
+	)
+
+	metadata := &snapshot.SnapshotMetadata{
+		SnapshotID:     "snap-1",
+		Namespace:      "hcp",
+		ClusterName:    "hcp-etcd",
+		ChecksumSHA256: "abc123",
+		CreationTime:   time.Now(),
+		ReadyToUse:     true,
+	}
+	require.NoError(t, server.snapshotManager.StoreSnapshotMetadata(ctx, metadata))
+
+	// The drill fails without a job being run
+	server.runRestoreDrill(ctx, metadata, []runtime.Object{&corev1.Pod{}})
+
+	stored, err := server.snapshotManager.RetrieveSnapshotMetadata(ctx, "snap-1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.RestoreDrill)
+	assert.False(t, stored.RestoreDrill.Passed)
+	assert.Contains(t, stored.RestoreDrill.Message, "not signed")
+	assert.Contains(t, <-recorder.Events, "Warning RestoreDrillFailed")
+
+	jobs, err := k8sClient.BatchV1().Jobs("hcp").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items)
+}
+
+func TestRequireSignaturesNeedsSigningKeys(t *testing.T) {
+	cfg := &ControllerConfig{
+		SnapshotTimeout:          time.Minute,
+		JobActiveDeadlineSeconds: 600,
+		ETCDImage:                "etcd:v1",
+		BusyboxImage:             "busybox:1",
+		AgentImage:               "agent:1",
+		SnapshotPVCSize:          "10Gi",
+		RequireSignatures:        true,
+	}
+	assert.ErrorContains(t, cfg.Validate(), "signing keys")
+
+	cfg.SigningKeys = signing.NewSecretKeys(fake.NewSimpleClientset(), "etcd-snapshot-driver", "etcd-snapshot-signing-key")
+	assert.NoError(t, cfg.Validate())
+}
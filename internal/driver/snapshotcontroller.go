@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// SnapshotControllerServer serves the snapshot RPCs of the CSI controller service. Snapshots
+// are only taken through group snapshots, but the snapshot-controller deletes the member
+// snapshot of each source volume on its own.
+type SnapshotControllerServer struct {
+	csi.UnimplementedControllerServer
+	group *GroupControllerServer
+}
+
+func NewSnapshotControllerServer(group *GroupControllerServer) *SnapshotControllerServer {
+	return &SnapshotControllerServer{
+		group: group,
+	}
+}
+
+// ControllerGetCapabilities returns the capabilities of the controller
+func (s *SnapshotControllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	s.group.logger.Debugw("ControllerGetCapabilities called")
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.ControllerServiceCapability{
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateSnapshot is not supported; snapshots are taken through VolumeGroupSnapshots
+func (s *SnapshotControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "snapshots are only taken through VolumeGroupSnapshots")
+}
+
+// DeleteSnapshot deletes a member snapshot of a group snapshot. The snapshot of the
+// cluster is shared by all of its source volumes and only removed with the last of them.
+// Deleting a snapshot that no longer exists succeeds; deleting one of a snapshot under legal
+// hold or locked fails with FailedPrecondition.
+func (s *SnapshotControllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	memberSnapshotID := req.GetSnapshotId()
+
+	s.group.logger.Infow("DeleteSnapshot called",
+		"snapshot_id", memberSnapshotID,
+	)
+
+	if memberSnapshotID == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot_id required")
+	}
+
+	deleted, err := s.group.deleteMemberSnapshot(ctx, memberSnapshotID)
+	if errors.Is(err, snapshot.ErrSnapshotHeld) {
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshot %s cannot be deleted: %v", memberSnapshotID, err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete snapshot %s: %v", memberSnapshotID, err)
+	}
+
+	// The deletion is posted on the source PVC of the member snapshot
+	snapshotID, volumeID := snapshot.ParseMemberSnapshotID(memberSnapshotID)
+	if deleted && volumeID != "" {
+		if namespace, name, err := parseVolumeID(volumeID); err == nil {
+			s.group.recordEvent([]runtime.Object{s.group.pvcEventTarget(ctx, namespace, name)}, corev1.EventTypeNormal, ReasonSnapshotDeleted,
+				"Deleted snapshot %s with its last member snapshot %s", snapshotID, memberSnapshotID)
+		}
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSnapshotControllerGetCapabilities(t *testing.T) {
+	server := NewSnapshotControllerServer(NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	))
+
+	resp, err := server.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Capabilities, 1)
+	assert.Equal(t, csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT, resp.Capabilities[0].GetRpc().Type)
+}
+
+func TestDeleteSnapshotKeepsSharedSnapshot(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	group := NewGroupControllerServer(
+		fakeClient,
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	)
+	server := NewSnapshotControllerServer(group)
+
+	ctx := context.Background()
+	members := []string{
+		snapshot.MemberSnapshotID("group-1-100", "hcp/data-etcd-0"),
+		snapshot.MemberSnapshotID("group-1-100", "hcp/data-etcd-1"),
+		snapshot.MemberSnapshotID("group-1-100", "hcp/data-etcd-2"),
+	}
+	require.NoError(t, group.snapshotManager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID: "group-1-100",
+		Namespace:  "hcp",
+		PVCName:    "etcd-snapshots",
+		References: members,
+	}))
+
+	// Deleting one member, twice, only drops its reference
+	for range 2 {
+		_, err := server.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: members[1]})
+		require.NoError(t, err)
+	}
+
+	metadata, err := group.snapshotManager.RetrieveSnapshotMetadata(ctx, "group-1-100")
+	require.NoError(t, err)
+	assert.Equal(t, []string{members[0], members[2]}, metadata.References)
+
+	// The snapshot file stays in place until the last member is deleted
+	jobs, err := fakeClient.BatchV1().Jobs("hcp").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items)
+}
+
+func TestDeleteSnapshotValidation(t *testing.T) {
+	server := NewSnapshotControllerServer(NewGroupControllerServer(
+		fake.NewSimpleClientset(),
+		ControllerOption(WithLogger{Logger: zap.NewNop().Sugar()}),
+	))
+
+	_, err := server.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Snapshots that no longer exist are deleted already
+	_, err = server.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "group-1-100/hcp/data-etcd-0"})
+	assert.NoError(t, err)
+}
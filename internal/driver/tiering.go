@@ -0,0 +1,253 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RunTiering applies the tiering policy every tiering interval until ctx is done. The
+// policy is read afresh each round, so enabling or disabling tiering takes effect on reload.
+func (g *GroupControllerServer) RunTiering(ctx context.Context) {
+	for {
+		interval := g.config().TieringInterval
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if err := g.TierSnapshots(ctx); err != nil {
+			g.logger.Warnw("Failed to apply snapshot tiering policy", "error", err)
+		}
+	}
+}
+
+// TierSnapshots moves every snapshot older than the tiering cold-after age from the
+// snapshot PVC to the object store. Each file is copied and verified against its checksum
+// before its location in the metadata is updated, and only then removed from the PVC.
+func (g *GroupControllerServer) TierSnapshots(ctx context.Context) error {
+	cfg := g.config()
+	if cfg.TieringColdAfter <= 0 {
+		return nil
+	}
+
+	snapshots, err := g.snapshotManager.ListSnapshotMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshot metadata: %w", err)
+	}
+
+	var tiered int
+	for _, metadata := range tieringCandidates(snapshots, cfg.TieringColdAfter, time.Now()) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A snapshot failing to move is retried next round; it does not hold up the others
+		if err := g.tierSnapshot(ctx, metadata); err != nil {
+			g.logger.Warnw("Failed to move snapshot to the object store",
+				"snapshot_id", metadata.SnapshotID,
+				"error", err,
+			)
+			continue
+		}
+		tiered++
+	}
+
+	if tiered > 0 {
+		g.logger.Infow("Snapshot tiering completed", "tiered", tiered)
+	}
+
+	return nil
+}
+
+// tieringCandidates returns the snapshots the tiering policy has yet to finish with: ready
+// snapshots on a snapshot PVC older than coldAfter, and snapshots already copied to the
+// object store whose PVC copy is still to be removed
+func tieringCandidates(snapshots []*snapshot.SnapshotMetadata, coldAfter time.Duration, now time.Time) []*snapshot.SnapshotMetadata {
+	var candidates []*snapshot.SnapshotMetadata
+	for _, metadata := range snapshots {
+		switch metadata.Tier() {
+		case snapshot.TierObjectStore:
+			if metadata.PVCName != "" {
+				candidates = append(candidates, metadata)
+			}
+		case snapshot.TierPVC:
+			if metadata.ReadyToUse && metadata.PVCName != "" && now.Sub(metadata.CreationTime) >= coldAfter {
+				candidates = append(candidates, metadata)
+			}
+		}
+	}
+	return candidates
+}
+
+// Helper function to move one snapshot to the object store
+func (g *GroupControllerServer) tierSnapshot(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	eventTargets := []runtime.Object{g.pvcEventTarget(ctx, metadata.Namespace, metadata.PVCName)}
+
+	if metadata.ObjectStore == nil {
+		if err := g.uploadSnapshot(ctx, metadata); err != nil {
+			g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotTieringFailed,
+				"Snapshot %s could not be moved to the object store: %v", metadata.SnapshotID, err)
+			return err
+		}
+	}
+
+	// The object holds a verified copy and the metadata points at it; the PVC copy can go
+	pvcName := metadata.PVCName
+	if err := g.deleteSnapshotPVCFile(ctx, metadata); err != nil {
+		g.recordEvent(eventTargets, corev1.EventTypeWarning, ReasonSnapshotTieringFailed,
+			"Snapshot %s was copied to the object store but could not be removed from PVC %s: %v",
+			metadata.SnapshotID, pvcName, err)
+		return err
+	}
+
+	if _, err := g.snapshotManager.UpdateSnapshotMetadata(ctx, metadata.SnapshotID, func(m *snapshot.SnapshotMetadata) {
+		m.PVCName = ""
+	}); err != nil {
+		return err
+	}
+
+	g.logger.Infow("Snapshot moved to the object store",
+		"snapshot_id", metadata.SnapshotID,
+		"bucket", metadata.ObjectStore.Bucket,
+		"key", metadata.ObjectStore.Key,
+	)
+	g.recordEvent(eventTargets, corev1.EventTypeNormal, ReasonSnapshotTiered,
+		"Snapshot %s moved from PVC %s to %s/%s", metadata.SnapshotID, pvcName, metadata.ObjectStore.Bucket, metadata.ObjectStore.Key)
+	return nil
+}
+
+// Helper function to copy a snapshot's file to the object store with a tier job and record
+// its new location. The PVC copy is left in place.
+func (g *GroupControllerServer) uploadSnapshot(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	cfg := g.config()
+	location := &snapshot.ObjectLocation{
+		Endpoint:   cfg.ObjectStoreEndpoint,
+		Region:     cfg.ObjectStoreRegion,
+		Bucket:     cfg.ObjectStoreBucket,
+		Key:        path.Join(cfg.ObjectStorePrefix, metadata.Namespace, metadata.Format().FileName(metadata.SnapshotID)),
+		Insecure:   cfg.ObjectStoreInsecure,
+		SecretName: cfg.ObjectStoreSecretName,
+	}
+	signature, err := metadata.SignatureFile()
+	if err != nil {
+		return err
+	}
+
+	jobConfig := &job.JobConfig{
+		DriverName:            cfg.DriverName,
+		SnapshotID:            metadata.SnapshotID,
+		Namespace:             metadata.Namespace,
+		SnapshotPVCName:       metadata.PVCName,
+		SnapshotPVCNamespace:  metadata.Namespace,
+		BackoffLimit:          cfg.JobBackoffLimit,
+		ActiveDeadlineSeconds: cfg.JobActiveDeadlineSeconds,
+		Operation:             "snapshot-tier",
+		Format:                metadata.Format(),
+		ObjectStore:           location,
+		ChecksumSHA256:        metadata.ChecksumSHA256,
+		Signature:             string(signature),
+		AgentImage:            cfg.AgentImage,
+	}
+
+	release, err := g.prepareSnapshotPVCJob(ctx, jobConfig)
+	if err != nil {
+		return fmt.Errorf("snapshot PVC cannot be mounted: %w", err)
+	}
+	defer release()
+
+	tierJob := job.GenerateSnapshotTierJob(jobConfig)
+	g.logger.Debugw("Generated snapshot tier job",
+		"snapshot_id", metadata.SnapshotID,
+		"job_name", tierJob.Name,
+	)
+
+	if _, err := g.jobExecutor.ExecuteSnapshotJob(ctx, tierJob, cfg.SnapshotTimeout); err != nil {
+		// The next round runs a job of the same name
+		if deleteErr := g.jobExecutor.DeleteJob(ctx, tierJob); deleteErr != nil {
+			g.logger.Warnw("Failed to delete tier job", "job_name", tierJob.Name, "error", deleteErr)
+		}
+		return fmt.Errorf("tier job failed: %w", err)
+	}
+
+	output, err := g.jobExecutor.JobOutput(ctx, tierJob, 20)
+	if err != nil {
+		return fmt.Errorf("failed to read tier job result: %w", err)
+	}
+	var result objectstore.Result
+	if err := job.DecodeResult(output, &result); err != nil {
+		return fmt.Errorf("failed to read tier job result: %w", err)
+	}
+
+	updated, err := g.snapshotManager.UpdateSnapshotMetadata(ctx, metadata.SnapshotID, func(m *snapshot.SnapshotMetadata) {
+		// Snapshots taken before checksums were recorded get the one verified on upload
+		if m.ChecksumSHA256 == "" {
+			m.ChecksumSHA256 = result.ChecksumSHA256
+		}
+		m.ObjectStore = location
+	})
+	if err == nil && updated == nil {
+		err = fmt.Errorf("snapshot was deleted while it was copied")
+	}
+	if err != nil {
+		// The object is unreferenced; remove it so the next round starts over
+		unrecorded := *metadata
+		unrecorded.ObjectStore = location
+		if deleteErr := g.deleteSnapshotObject(ctx, &unrecorded); deleteErr != nil {
+			g.logger.Warnw("Failed to remove unrecorded snapshot object", "snapshot_id", metadata.SnapshotID, "error", deleteErr)
+		}
+		return err
+	}
+
+	*metadata = *updated
+	g.lockNewObject(ctx, metadata, location)
+	return nil
+}
+
+// Helper function to remove the copy of a snapshot in the object store tier from its snapshot PVC
+func (g *GroupControllerServer) deleteSnapshotPVCFile(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	onPVC := *metadata
+	onPVC.ObjectStore = nil
+	return g.deleteSnapshotFile(ctx, &onPVC)
+}
+
+// Helper function to delete the object a snapshot in the object store tier is kept in
+func (g *GroupControllerServer) deleteSnapshotObject(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	return g.deleteObject(ctx, metadata.Namespace, metadata.ObjectStore)
+}
+
+// Helper function to delete an object, and the signature stored next to it, with the
+// credentials from its Secret in namespace
+func (g *GroupControllerServer) deleteObject(ctx context.Context, namespace string, location *snapshot.ObjectLocation) error {
+	client, err := g.objectClient(ctx, namespace, location)
+	if err != nil {
+		return err
+	}
+	if err := client.Delete(ctx, location.Key); err != nil {
+		return err
+	}
+	return client.Delete(ctx, location.Key+snapshot.SignatureSuffix)
+}
+
+// Helper function to create a client for the bucket of location with the credentials from
+// its Secret in namespace
+func (g *GroupControllerServer) objectClient(ctx context.Context, namespace string, location *snapshot.ObjectLocation) (*objectstore.Client, error) {
+	return objectstore.NewWithSecret(ctx, g.k8sClient, objectstore.Config{
+		Endpoint: location.Endpoint,
+		Region:   location.Region,
+		Bucket:   location.Bucket,
+		Insecure: location.Insecure,
+	}, namespace, location.SecretName)
+}
@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTieringCandidates(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	location := &snapshot.ObjectLocation{Bucket: "snapshots", Key: "hcp/old.db"}
+
+	snapshots := []*snapshot.SnapshotMetadata{
+		{SnapshotID: "old", PVCName: "snapshots", ReadyToUse: true, CreationTime: now.Add(-25 * time.Hour)},
+		{SnapshotID: "recent", PVCName: "snapshots", ReadyToUse: true, CreationTime: now.Add(-time.Hour)},
+		{SnapshotID: "not-ready", PVCName: "snapshots", CreationTime: now.Add(-25 * time.Hour)},
+		{SnapshotID: "in-volume-snapshot", ReadyToUse: true, CreationTime: now.Add(-25 * time.Hour),
+			VolumeSnapshot: &snapshot.VolumeSnapshotInfo{Name: "snapshots-in-volume-snapshot"}},
+		{SnapshotID: "pending-removal", PVCName: "snapshots", ReadyToUse: true, CreationTime: now.Add(-25 * time.Hour), ObjectStore: location},
+		{SnapshotID: "cold", ReadyToUse: true, CreationTime: now.Add(-25 * time.Hour), ObjectStore: location},
+	}
+
+	var ids []string
+	for _, metadata := range tieringCandidates(snapshots, 24*time.Hour, now) {
+		ids = append(ids, metadata.SnapshotID)
+	}
+	assert.Equal(t, []string{"old", "pending-removal"}, ids)
+}
+
+func TestReloadTiering(t *testing.T) {
+	server := NewGroupControllerServer(fake.NewSimpleClientset(),
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithSnapShotTimeout(time.Minute),
+		WithJobActiveDeadlineSeconds(600),
+		WithETCDImage("etcd:v1"),
+		WithBusyboxImage("busybox:1"),
+		WithAgentImage("agent:1"),
+		WithSnapshotPVCSize("10Gi"),
+	)
+
+	err := server.Reload(WithTieringColdAfter(24 * time.Hour))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "object store endpoint must be set")
+	assert.Contains(t, err.Error(), "tiering interval must be positive")
+
+	require.NoError(t, server.Reload(
+		WithTieringColdAfter(24*time.Hour),
+		WithTieringInterval(10*time.Minute),
+		WithObjectStoreEndpoint("s3.example.com"),
+		WithObjectStoreBucket("snapshots"),
+		WithObjectStoreSecretName("object-store"),
+	))
+	assert.Equal(t, 24*time.Hour, server.config().TieringColdAfter)
+}
@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Helper function to provision the PVC a snapshot is written to when every snapshot is kept in
+// a VolumeSnapshot of a PVC of its own. The PVC is sized for the estimated snapshot, at least
+// 1Gi; the returned function deletes it again once the VolumeSnapshot has been taken.
+func (g *GroupControllerServer) provisionSnapshotVolume(ctx context.Context, cfg *ControllerConfig, cluster *groupCluster) (func(), error) {
+	names := config.NewNames(cfg.DriverName)
+	size := roundUpToGiB(max(cluster.estimatedSize, 1))
+	provisioner := snapshot.NewSnapshotPVCProvisioner(g.k8sClient, names, cfg.DefaultStorageClass, size.String(), g.logger)
+
+	pvc, err := provisioner.EnsurePVC(ctx, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.SnapshotVolume(cluster.snapshotID),
+			Namespace: cluster.namespace,
+			Labels: map[string]string{
+				"app": names.AppLabel(),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cluster.snapshotPVCName = pvc.Name
+
+	release := func() {
+		// The PVC is removed even when the request's context is done
+		err := g.k8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(context.Background(), pvc.Name, metav1.DeleteOptions{})
+		if err != nil {
+			g.logger.Warnw("Failed to delete snapshot volume PVC", "namespace", pvc.Namespace, "pvc_name", pvc.Name, "error", err)
+		}
+	}
+	return release, nil
+}
+
+// Helper function to keep a snapshot written to a PVC of its own in a native VolumeSnapshot of
+// that PVC, taken through the CSI driver of the PVC's storage class
+func (g *GroupControllerServer) takeVolumeSnapshot(ctx context.Context, cfg *ControllerConfig, cluster *groupCluster, metadata *snapshot.SnapshotMetadata) error {
+	names := config.NewNames(cfg.DriverName)
+	snapshotter := snapshot.NewVolumeSnapshotter(cfg.DynamicClient, g.logger)
+
+	info, err := snapshotter.Snapshot(ctx, cluster.namespace, names.SnapshotVolume(cluster.snapshotID), cluster.snapshotPVCName,
+		cfg.VolumeSnapshotClass, map[string]string{"app": names.AppLabel()}, cfg.SnapshotTimeout)
+	if err != nil {
+		g.logger.Errorw("VolumeSnapshot failed",
+			"snapshot_id", cluster.snapshotID,
+			"pvc_name", cluster.snapshotPVCName,
+			"error", err,
+		)
+		g.recordEvent(cluster.eventTargets, corev1.EventTypeWarning, ReasonVolumeSnapshotFailed,
+			"VolumeSnapshot of snapshot %s failed: %v", cluster.snapshotID, err)
+		if cleanupErr := snapshotter.Delete(ctx, cluster.namespace, names.SnapshotVolume(cluster.snapshotID)); cleanupErr != nil {
+			g.logger.Warnw("Failed to delete failed VolumeSnapshot", "snapshot_id", cluster.snapshotID, "error", cleanupErr)
+		}
+		return status.Errorf(codes.Internal, "VolumeSnapshot of ETCD cluster %s failed: %v", cluster.info.Name, err)
+	}
+	info.StorageClass = cfg.DefaultStorageClass
+
+	g.recordEvent(cluster.eventTargets, corev1.EventTypeNormal, ReasonVolumeSnapshotReady,
+		"Snapshot %s is kept in VolumeSnapshot %s/%s", cluster.snapshotID, cluster.namespace, info.Name)
+
+	// The PVC the file was written to is deleted; the file is read back through the VolumeSnapshot
+	metadata.VolumeSnapshot = info
+	metadata.PVCName = ""
+	return nil
+}
+
+// Helper function to delete the VolumeSnapshot a snapshot is kept in
+func (g *GroupControllerServer) deleteVolumeSnapshot(ctx context.Context, metadata *snapshot.SnapshotMetadata) error {
+	client := g.config().DynamicClient
+	if client == nil {
+		return fmt.Errorf("snapshot %s is kept in VolumeSnapshot %s but no dynamic client is configured", metadata.SnapshotID, metadata.VolumeSnapshot.Name)
+	}
+	return snapshot.NewVolumeSnapshotter(client, g.logger).Delete(ctx, metadata.Namespace, metadata.VolumeSnapshot.Name)
+}
@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProvisionSnapshotVolume(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset()
+	server := NewGroupControllerServer(fakeClient,
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithDefaultStorageClass("fast"),
+	)
+	cluster := &groupCluster{namespace: "hcp", snapshotID: "snap-1", estimatedSize: 3<<30 + 1}
+
+	release, err := server.provisionSnapshotVolume(ctx, server.config(), cluster)
+	require.NoError(t, err)
+
+	pvcName := config.NewNames("").SnapshotVolume("snap-1")
+	assert.Equal(t, pvcName, cluster.snapshotPVCName)
+	pvc, err := fakeClient.CoreV1().PersistentVolumeClaims("hcp").Get(ctx, pvcName, metav1.GetOptions{})
+	require.NoError(t, err)
+	size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.Equal(t, "4Gi", size.String())
+	assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+
+	release()
+	_, err = fakeClient.CoreV1().PersistentVolumeClaims("hcp").Get(ctx, pvcName, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestDeleteSnapshotFileDeletesVolumeSnapshot(t *testing.T) {
+	ctx := context.Background()
+	volumeSnapshot := &unstructured.Unstructured{}
+	volumeSnapshot.SetGroupVersionKind(snapshot.VolumeSnapshotResource.GroupVersion().WithKind("VolumeSnapshot"))
+	volumeSnapshot.SetNamespace("hcp")
+	volumeSnapshot.SetName("snapshots-snap-1")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{snapshot.VolumeSnapshotResource: "VolumeSnapshotList"}, volumeSnapshot)
+
+	server := NewGroupControllerServer(fake.NewSimpleClientset(),
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithDynamicClient{Client: dynamicClient},
+	)
+	metadata := &snapshot.SnapshotMetadata{
+		SnapshotID:     "snap-1",
+		Namespace:      "hcp",
+		VolumeSnapshot: &snapshot.VolumeSnapshotInfo{Name: "snapshots-snap-1", RestoreSize: "1Gi"},
+	}
+
+	// No delete job is run; the VolumeSnapshot is deleted directly
+	require.NoError(t, server.deleteSnapshotFile(ctx, metadata))
+	_, err := dynamicClient.Resource(snapshot.VolumeSnapshotResource).Namespace("hcp").Get(ctx, "snapshots-snap-1", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestReloadVolumeSnapshotMode(t *testing.T) {
+	server := NewGroupControllerServer(fake.NewSimpleClientset(),
+		WithLogger{Logger: zap.NewNop().Sugar()},
+		WithSnapShotTimeout(time.Minute),
+		WithJobActiveDeadlineSeconds(600),
+		WithETCDImage("etcd:v1"),
+		WithBusyboxImage("busybox:1"),
+		WithAgentImage("agent:1"),
+		WithSnapshotPVCSize("10Gi"),
+	)
+
+	assert.ErrorContains(t, server.Reload(WithSnapshotStorageMode("volume-snapshot")), "requires a dynamic client")
+	assert.ErrorContains(t, server.Reload(WithSnapshotStorageMode("per-snapshot")), "unsupported snapshot storage mode")
+
+	require.NoError(t, server.Reload(
+		WithSnapshotStorageMode("volume-snapshot"),
+		WithDynamicClient{Client: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())},
+	))
+	assert.Equal(t, snapshot.StorageVolumeSnapshot, server.config().SnapshotStorageMode)
+}
@@ -0,0 +1,152 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+
+	var sealed bytes.Buffer
+	w, err := NewEncryptWriter(&sealed, key)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return sealed.Bytes()
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key, err := GenerateDataKey()
+	require.NoError(t, err)
+
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17}
+	for _, size := range sizes {
+		plain := bytes.Repeat([]byte{0xab}, size)
+		sealed := encrypt(t, key, plain)
+
+		r, err := NewDecryptReader(bytes.NewReader(sealed), key)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+}
+
+func TestDecryptRejectsTamperingAndTruncation(t *testing.T) {
+	key, err := GenerateDataKey()
+	require.NoError(t, err)
+	sealed := encrypt(t, key, bytes.Repeat([]byte("etcd"), chunkSize))
+
+	otherKey, err := GenerateDataKey()
+	require.NoError(t, err)
+	r, err := NewDecryptReader(bytes.NewReader(sealed), otherKey)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.Error(t, err, "wrong key")
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	r, err = NewDecryptReader(bytes.NewReader(tampered), key)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.Error(t, err, "tampered")
+
+	// Drop the final chunk entirely
+	truncated := sealed[:len(magic)+12+4+chunkSize+16]
+	r, err = NewDecryptReader(bytes.NewReader(truncated), key)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "truncated")
+
+	// Data appended after the final chunk
+	appended := append(append([]byte(nil), sealed...), 0)
+	r, err = NewDecryptReader(bytes.NewReader(appended), key)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "data after its final chunk")
+}
+
+func TestSecretKeyProvider(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x42}, DataKeySize)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapshot-keys", Namespace: "etcd-snapshot-driver"},
+		Data: map[string][]byte{
+			"key-1": []byte(hex.EncodeToString(kek)),
+			"bad":   []byte("short"),
+		},
+	}
+	provider := NewSecretKeyProvider(fake.NewSimpleClientset(secret), "etcd-snapshot-driver", "snapshot-keys")
+
+	ctx := context.Background()
+	dataKey, err := GenerateDataKey()
+	require.NoError(t, err)
+
+	wrapped, err := provider.WrapKey(ctx, "key-1", dataKey)
+	require.NoError(t, err)
+
+	got, err := provider.UnwrapKey(ctx, "key-1", wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+
+	_, err = provider.WrapKey(ctx, "missing", dataKey)
+	assert.ErrorContains(t, err, "not found")
+
+	_, err = provider.WrapKey(ctx, "bad", dataKey)
+	assert.Error(t, err)
+}
+
+func TestFileKeyProviderRotation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old"), bytes.Repeat([]byte{1}, DataKeySize), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new"), bytes.Repeat([]byte{2}, DataKeySize), 0o600))
+	provider := NewFileKeyProvider(dir)
+
+	ctx := context.Background()
+	dataKey, err := GenerateDataKey()
+	require.NoError(t, err)
+
+	wrapped, err := provider.WrapKey(ctx, "old", dataKey)
+	require.NoError(t, err)
+
+	// A data key wrapped with one key cannot be unwrapped as another
+	_, err = provider.UnwrapKey(ctx, "new", wrapped)
+	assert.Error(t, err)
+
+	rewrapped, err := Rewrap(ctx, provider, "old", "new", wrapped)
+	require.NoError(t, err)
+
+	got, err := provider.UnwrapKey(ctx, "new", rewrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+
+	_, err = provider.WrapKey(ctx, "../escape", dataKey)
+	assert.Error(t, err)
+}
+
+func TestProviderConfig(t *testing.T) {
+	provider, err := ProviderConfig{Provider: "secret", SecretNamespace: "ns", SecretName: "keys"}.New(fake.NewSimpleClientset())
+	require.NoError(t, err)
+	assert.Equal(t, "ns", provider.(*SecretKeyProvider).namespace)
+	assert.Equal(t, "keys", provider.(*SecretKeyProvider).name)
+
+	provider, err = ProviderConfig{Provider: "file", Dir: "/keys"}.New(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/keys", provider.(*FileKeyProvider).dir)
+
+	_, err = ProviderConfig{Provider: "vault"}.New(nil)
+	assert.ErrorContains(t, err, `unsupported encryption key provider "vault"`)
+}
@@ -0,0 +1,239 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Algorithm identifies the data encryption scheme recorded in snapshot metadata
+	Algorithm = "AES-256-GCM"
+
+	// DataKeySize is the size of a data encryption key in bytes
+	DataKeySize = 32
+
+	chunkSize = 64 * 1024
+)
+
+var magic = []byte("ESDENC01")
+
+// GenerateDataKey returns a fresh random data encryption key
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it with the data key.
+// Data is sealed in fixed-size AES-GCM chunks so snapshots never have to fit in memory;
+// the final chunk is marked so truncated ciphertext is detected on decryption.
+// Close must be called to flush the final chunk.
+func NewEncryptWriter(w io.Writer, dataKey []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	baseNonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(baseNonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	if _, err := w.Write(magic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(baseNonce); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:         w,
+		aead:      aead,
+		baseNonce: baseNonce,
+		buf:       make([]byte, 0, chunkSize),
+	}, nil
+}
+
+type encryptWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	baseNonce []byte
+	counter   uint64
+	buf       []byte
+	closed    bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		// A full buffer is only flushed once more data arrives, so the
+		// final chunk is always the one sealed by Close
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.baseNonce, e.counter), e.buf, chunkAAD(final))
+	e.counter++
+	e.buf = e.buf[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := e.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// NewDecryptReader returns a reader that decrypts data written by NewEncryptWriter
+func NewDecryptReader(r io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(magic)+aead.NonceSize())
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, errors.New("snapshot is not encrypted with a supported format")
+	}
+
+	return &decryptReader{
+		r:         r,
+		aead:      aead,
+		baseNonce: header[len(magic):],
+	}, nil
+}
+
+type decryptReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	baseNonce []byte
+	counter   uint64
+	plain     []byte
+	done      bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("encrypted snapshot is truncated")
+		}
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("encrypted snapshot chunk exceeds maximum size")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return errors.New("encrypted snapshot is truncated")
+	}
+
+	nonce := chunkNonce(d.baseNonce, d.counter)
+	d.counter++
+
+	// The final flag is authenticated, so try the common case first
+	plain, err := d.aead.Open(nil, nonce, sealed, chunkAAD(false))
+	if err != nil {
+		plain, err = d.aead.Open(nil, nonce, sealed, chunkAAD(true))
+		if err != nil {
+			return errors.New("failed to decrypt snapshot: wrong data key or corrupted data")
+		}
+		d.done = true
+
+		// Nothing may follow the final chunk, or appended data would go unnoticed
+		var trailing [1]byte
+		if n, err := io.ReadFull(d.r, trailing[:]); n > 0 {
+			return errors.New("encrypted snapshot has data after its final chunk")
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+
+	d.plain = plain
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", DataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives a unique nonce per chunk by mixing the chunk counter into the base nonce
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	offset := len(nonce) - len(c)
+	for i := range c {
+		nonce[offset+i] ^= c[i]
+	}
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,197 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KeyProvider wraps and unwraps data encryption keys with a key encryption key.
+// Implementations may keep key encryption keys locally or delegate to an external KMS.
+type KeyProvider interface {
+	// WrapKey encrypts a data key with the key encryption key identified by keyID
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key previously wrapped with the key identified by keyID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Rewrap re-wraps a data key from one key encryption key to another without touching the data it protects
+func Rewrap(ctx context.Context, provider KeyProvider, fromKeyID, toKeyID string, wrapped []byte) ([]byte, error) {
+	dataKey, err := provider.UnwrapKey(ctx, fromKeyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return provider.WrapKey(ctx, toKeyID, dataKey)
+}
+
+// ProviderConfig selects a key provider and where it keeps its key encryption keys. The
+// driver hands it to its jobs along with a snapshot's wrapped data key, so the plaintext
+// data key is only ever unwrapped inside the pod that needs it.
+type ProviderConfig struct {
+	// Provider is the kind of provider: secret or file
+	Provider string
+	// SecretNamespace and SecretName locate the Secret of the secret provider
+	SecretNamespace string
+	SecretName      string
+	// Dir is the key directory of the file provider
+	Dir string
+}
+
+// New builds the key provider the config selects
+func (c ProviderConfig) New(k8sClient kubernetes.Interface) (KeyProvider, error) {
+	switch c.Provider {
+	case "secret":
+		return NewSecretKeyProvider(k8sClient, c.SecretNamespace, c.SecretName), nil
+	case "file":
+		return NewFileKeyProvider(c.Dir), nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption key provider %q (supported: secret, file)", c.Provider)
+	}
+}
+
+// SecretKeyProvider keeps key encryption keys in a Kubernetes Secret.
+// Each Secret data entry is a key ID mapped to a 32-byte key, either raw,
+// hex or base64 encoded. Old keys stay in the Secret so existing snapshots
+// can still be unwrapped after a rotation.
+type SecretKeyProvider struct {
+	k8sClient kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretKeyProvider creates a key provider backed by the given Secret
+func NewSecretKeyProvider(k8sClient kubernetes.Interface, namespace, name string) *SecretKeyProvider {
+	return &SecretKeyProvider{
+		k8sClient: k8sClient,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (p *SecretKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	kek, err := p.key(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(kek, keyID, dataKey)
+}
+
+func (p *SecretKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := p.key(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(kek, keyID, wrapped)
+}
+
+func (p *SecretKeyProvider) key(ctx context.Context, keyID string) ([]byte, error) {
+	secret, err := p.k8sClient.CoreV1().Secrets(p.namespace).Get(ctx, p.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key secret %s/%s: %w", p.namespace, p.name, err)
+	}
+
+	raw, ok := secret.Data[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q not found in secret %s/%s", keyID, p.namespace, p.name)
+	}
+
+	return decodeKey(raw)
+}
+
+// FileKeyProvider is a local stand-in for a KMS. Key encryption keys are
+// files in a directory, named by key ID, each holding a 32-byte key.
+type FileKeyProvider struct {
+	dir string
+}
+
+// NewFileKeyProvider creates a key provider backed by key files in dir
+func NewFileKeyProvider(dir string) *FileKeyProvider {
+	return &FileKeyProvider{dir: dir}
+}
+
+func (p *FileKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	kek, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(kek, keyID, dataKey)
+}
+
+func (p *FileKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(kek, keyID, wrapped)
+}
+
+func (p *FileKeyProvider) key(keyID string) ([]byte, error) {
+	if keyID == "" || strings.ContainsAny(keyID, `/\`) || keyID == "." || keyID == ".." {
+		return nil, fmt.Errorf("invalid encryption key ID %q", keyID)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(p.dir, keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key %q: %w", keyID, err)
+	}
+
+	return decodeKey(raw)
+}
+
+// decodeKey accepts a key as raw bytes or as hex or base64 text
+func decodeKey(raw []byte) ([]byte, error) {
+	if len(raw) == DataKeySize {
+		return raw, nil
+	}
+
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == DataKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == DataKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("encryption key must be %d bytes (raw, hex or base64)", DataKeySize)
+}
+
+// wrap seals a data key with a key encryption key; the key ID is bound as additional data
+func wrap(kek []byte, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func unwrap(kek []byte, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped data key is too short")
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q", keyID)
+	}
+	return dataKey, nil
+}
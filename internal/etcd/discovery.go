@@ -3,8 +3,10 @@ package etcd
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
@@ -73,10 +75,12 @@ func (d *Discovery) discoverByLabel(ctx context.Context, namespace, clusterName
 	}
 
 	endpoints := make([]string, 0)
+	members := make([]MemberInfo, 0, len(pods.Items))
 	for _, pod := range pods.Items {
 		// Assume ETCD API on port 2379
 		endpoint := fmt.Sprintf("https://%s.%s.svc.cluster.local:2379", pod.Name, namespace)
 		endpoints = append(endpoints, endpoint)
+		members = append(members, memberFromPod(&pod, endpoint))
 	}
 
 	d.logger.Infow("Discovered ETCD cluster via labels",
@@ -89,6 +93,7 @@ func (d *Discovery) discoverByLabel(ctx context.Context, namespace, clusterName
 		Name:      clusterName,
 		Namespace: namespace,
 		Endpoints: endpoints,
+		Members:   members,
 		HasQuorum: len(pods.Items) >= 3,
 	}, nil
 }
@@ -106,3 +111,100 @@ func (d *Discovery) ValidateClusterHealth(ctx context.Context, cluster *ClusterI
 	// Use ETCD client to validate health
 	return d.healthValidator.ValidateHealth(ctx, cluster.Endpoints)
 }
+
+// SelectSnapshotSource picks the member of the cluster a snapshot is streamed from
+func (d *Discovery) SelectSnapshotSource(ctx context.Context, cluster *ClusterInfo, selection MemberSelection) (*SnapshotSource, error) {
+	if d.healthValidator == nil {
+		return nil, fmt.Errorf("cannot read the status of the members of cluster %s", cluster.Name)
+	}
+
+	sources, err := d.healthValidator.SnapshotSources(ctx, cluster.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := selection.Pick(sources)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+	}
+
+	d.logger.Infow("Selected member to snapshot",
+		"cluster_name", cluster.Name,
+		"selection", selection,
+		"member", source.Name,
+		"member_id", fmt.Sprintf("%x", source.ID),
+		"leader", source.IsLeader,
+		"revision", source.Revision,
+		"member_version", source.Version,
+	)
+	return source, nil
+}
+
+// memberFromPod reads the member name and peer URLs from the etcd container's flags or
+// ETCD_* environment, falling back to the pod name and the same naming as the client endpoint
+func memberFromPod(pod *corev1.Pod, clientURL string) MemberInfo {
+	member := MemberInfo{
+		PodName:             pod.Name,
+		Name:                podSetting(pod, "name"),
+		ClientURLs:          []string{clientURL},
+		InitialClusterState: podSetting(pod, "initial-cluster-state"),
+	}
+	if member.Name == "" {
+		member.Name = pod.Name
+	}
+
+	if peerURLs := podSetting(pod, "initial-advertise-peer-urls"); peerURLs != "" {
+		member.PeerURLs = strings.Split(peerURLs, ",")
+	} else {
+		member.PeerURLs = []string{fmt.Sprintf("https://%s.%s.svc.cluster.local:2380", pod.Name, pod.Namespace)}
+	}
+
+	return member
+}
+
+// podSetting returns an etcd setting given as --flag=value or --flag value on any
+// container, or as the equivalent ETCD_* variable, with $(VAR) references expanded
+func podSetting(pod *corev1.Pod, flag string) string {
+	envName := "ETCD_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+
+	for _, c := range pod.Spec.Containers {
+		args := append(append([]string{}, c.Command...), c.Args...)
+		for i, arg := range args {
+			// Flags may also be embedded in a shell command line
+			for _, field := range strings.Fields(arg) {
+				if value, ok := strings.CutPrefix(field, "--"+flag+"="); ok {
+					return expandPodEnv(pod, &c, strings.Trim(value, `"'`))
+				}
+			}
+			if arg == "--"+flag && i+1 < len(args) {
+				return expandPodEnv(pod, &c, args[i+1])
+			}
+		}
+		for _, env := range c.Env {
+			if env.Name == envName && env.Value != "" {
+				return expandPodEnv(pod, &c, env.Value)
+			}
+		}
+	}
+	return ""
+}
+
+// expandPodEnv expands $(VAR) references the way the kubelet does, for variables
+// with literal values or taken from the pod's name and namespace
+func expandPodEnv(pod *corev1.Pod, c *corev1.Container, value string) string {
+	for _, env := range c.Env {
+		resolved := env.Value
+		if env.ValueFrom != nil && env.ValueFrom.FieldRef != nil {
+			switch env.ValueFrom.FieldRef.FieldPath {
+			case "metadata.name":
+				resolved = pod.Name
+			case "metadata.namespace":
+				resolved = pod.Namespace
+			}
+		}
+		if resolved != "" {
+			value = strings.ReplaceAll(value, "$("+env.Name+")", resolved)
+		}
+	}
+	return value
+}
@@ -70,3 +70,59 @@ func TestDiscoverClusterByLabel(t *testing.T) {
 		t.Error("Expected cluster to have quorum with 3 members")
 	}
 }
+
+func TestDiscoverClusterMembers(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "data-etcd-0", Namespace: "default", Labels: map[string]string{"etcd.io/cluster": "main"},
+		}},
+		// Settings from flags with $(VAR) references
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: "default", Labels: map[string]string{"etcd.io/cluster": "main"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:    "etcd",
+				Command: []string{"etcd", "--name=$(POD_NAME)", "--initial-advertise-peer-urls", "http://$(POD_NAME).etcd-headless:2380"},
+				Env: []corev1.EnvVar{{
+					Name:      "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+				}},
+			}}},
+		},
+		// Settings from ETCD_* variables
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-1", Namespace: "default", Labels: map[string]string{"etcd.io/cluster": "main"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "etcd",
+				Env: []corev1.EnvVar{
+					{Name: "ETCD_NAME", Value: "member-1"},
+					{Name: "ETCD_INITIAL_ADVERTISE_PEER_URLS", Value: "http://member-1.etcd-headless:2380"},
+				},
+			}}},
+		},
+		// No settings
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-2", Namespace: "default", Labels: map[string]string{"etcd.io/cluster": "main"}},
+		},
+	)
+
+	discovery := etcd.NewDiscovery(k8sClient, zap.NewNop().Sugar(), "etcd.io/cluster")
+	info, err := discovery.DiscoverCluster(context.Background(), "default", "data-etcd-0")
+	if err != nil {
+		t.Fatalf("DiscoverCluster failed: %v", err)
+	}
+
+	expected := map[string]etcd.MemberInfo{
+		"etcd-0": {PodName: "etcd-0", Name: "etcd-0", PeerURLs: []string{"http://etcd-0.etcd-headless:2380"}},
+		"etcd-1": {PodName: "etcd-1", Name: "member-1", PeerURLs: []string{"http://member-1.etcd-headless:2380"}},
+		"etcd-2": {PodName: "etcd-2", Name: "etcd-2", PeerURLs: []string{"https://etcd-2.default.svc.cluster.local:2380"}},
+	}
+	if len(info.Members) != len(expected) {
+		t.Fatalf("Expected %d members, got %d", len(expected), len(info.Members))
+	}
+	for _, m := range info.Members {
+		want := expected[m.PodName]
+		if m.Name != want.Name || len(m.PeerURLs) != 1 || m.PeerURLs[0] != want.PeerURLs[0] {
+			t.Errorf("Member %s: expected name %s and peer URLs %v, got %s and %v", m.PodName, want.Name, want.PeerURLs, m.Name, m.PeerURLs)
+		}
+	}
+}
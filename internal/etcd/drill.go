@@ -0,0 +1,189 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+const drillMemberName = "restore-drill"
+
+// DrillSpec describes the checks a restore drill runs against the restored keyspace
+type DrillSpec struct {
+	// MinKeys is the minimum number of keys the restored keyspace must hold
+	MinKeys int64
+	// ExpectedPrefixes lists key prefixes that must each match at least one key
+	ExpectedPrefixes []string
+	// Timeout bounds how long the throwaway member may take to become ready
+	Timeout time.Duration
+}
+
+// DrillResult is the outcome of a restore drill
+type DrillResult struct {
+	Passed     bool             `json:"passed"`
+	TotalKeys  int64            `json:"total_keys"`
+	PrefixKeys map[string]int64 `json:"prefix_keys,omitempty"`
+	Revision   int64            `json:"revision"`
+	Message    string           `json:"message,omitempty"`
+}
+
+// RestoreDrill proves a snapshot is restorable by restoring it into a scratch
+// data dir and booting a throwaway single-member etcd on loopback
+type RestoreDrill struct {
+	logger     *zap.SugaredLogger
+	scratchDir string
+}
+
+// NewRestoreDrill creates a new restore drill
+// scratchDir is the parent directory for the temporary data dir; empty uses os.TempDir
+func NewRestoreDrill(logger *zap.SugaredLogger, scratchDir string) *RestoreDrill {
+	return &RestoreDrill{
+		logger:     logger,
+		scratchDir: scratchDir,
+	}
+}
+
+// Run restores the snapshot at snapshotPath and checks the restored keyspace against spec.
+// A snapshot that fails to restore or boot yields a failed result rather than an error;
+// an error is only returned when the drill itself cannot be set up.
+func (d *RestoreDrill) Run(ctx context.Context, snapshotPath string, spec DrillSpec) (*DrillResult, error) {
+	if spec.Timeout <= 0 {
+		spec.Timeout = time.Minute
+	}
+
+	workDir, err := os.MkdirTemp(d.scratchDir, "etcd-restore-drill-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	peerURL, err := loopbackURL()
+	if err != nil {
+		return nil, err
+	}
+	clientURL, err := loopbackURL()
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir := filepath.Join(workDir, "data")
+	initialCluster := fmt.Sprintf("%s=%s", drillMemberName, peerURL.String())
+
+	d.logger.Infow("Restoring snapshot into scratch data dir",
+		"snapshot_path", snapshotPath,
+		"data_dir", dataDir,
+	)
+
+	err = snapshot.NewV3(zap.NewNop()).Restore(snapshot.RestoreConfig{
+		SnapshotPath:        snapshotPath,
+		Name:                drillMemberName,
+		OutputDataDir:       dataDir,
+		PeerURLs:            []string{peerURL.String()},
+		InitialCluster:      initialCluster,
+		InitialClusterToken: drillMemberName,
+	})
+	if err != nil {
+		return &DrillResult{Message: fmt.Sprintf("snapshot restore failed: %v", err)}, nil
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Name = drillMemberName
+	cfg.Dir = dataDir
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.InitialCluster = initialCluster
+	cfg.InitialClusterToken = drillMemberName
+	cfg.LogLevel = "error"
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return &DrillResult{Message: fmt.Sprintf("restored member failed to start: %v", err)}, nil
+	}
+	defer server.Close()
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case err := <-server.Err():
+		return &DrillResult{Message: fmt.Sprintf("restored member failed: %v", err)}, nil
+	case <-time.After(spec.Timeout):
+		return &DrillResult{Message: "restored member did not become ready before timeout"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 5 * time.Second,
+		Logger:      zap.NewNop(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ETCD client: %w", err)
+	}
+	defer client.Close()
+
+	return d.check(ctx, client, spec)
+}
+
+// check counts the restored keys and evaluates them against spec
+func (d *RestoreDrill) check(ctx context.Context, client *clientv3.Client, spec DrillSpec) (*DrillResult, error) {
+	total, err := client.Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	if err != nil {
+		return &DrillResult{Message: fmt.Sprintf("failed to count restored keys: %v", err)}, nil
+	}
+
+	result := &DrillResult{
+		TotalKeys:  total.Count,
+		PrefixKeys: make(map[string]int64, len(spec.ExpectedPrefixes)),
+		Revision:   total.Header.GetRevision(),
+	}
+
+	var problems []string
+	if result.TotalKeys < spec.MinKeys {
+		problems = append(problems, fmt.Sprintf("restored %d keys, expected at least %d", result.TotalKeys, spec.MinKeys))
+	}
+
+	for _, prefix := range spec.ExpectedPrefixes {
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return &DrillResult{Message: fmt.Sprintf("failed to count keys under %q: %v", prefix, err)}, nil
+		}
+		result.PrefixKeys[prefix] = resp.Count
+		if resp.Count == 0 {
+			problems = append(problems, fmt.Sprintf("no keys under expected prefix %q", prefix))
+		}
+	}
+
+	result.Passed = len(problems) == 0
+	result.Message = strings.Join(problems, "; ")
+
+	d.logger.Infow("Restore drill finished",
+		"passed", result.Passed,
+		"total_keys", result.TotalKeys,
+		"revision", result.Revision,
+	)
+
+	return result, nil
+}
+
+// loopbackURL reserves a free loopback port for the throwaway member
+func loopbackURL() (*url.URL, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve loopback port: %w", err)
+	}
+	defer listener.Close()
+
+	return &url.URL{Scheme: "http", Host: listener.Addr().String()}, nil
+}
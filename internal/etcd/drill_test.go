@@ -0,0 +1,128 @@
+package etcd_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+// saveEmbeddedSnapshot boots an embedded etcd, writes keys and saves a snapshot of it
+func saveEmbeddedSnapshot(t *testing.T, keys map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := embed.NewConfig()
+	cfg.Dir = filepath.Join(dir, "source")
+	cfg.LogLevel = "error"
+	cfg.ListenPeerUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:0"}}
+	cfg.ListenClientUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:0"}}
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	defer server.Close()
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("embedded etcd did not become ready")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{server.Clients[0].Addr().String()},
+		DialTimeout: 5 * time.Second,
+		Logger:      zap.NewNop(),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx := context.Background()
+	for k, v := range keys {
+		_, err := client.Put(ctx, k, v)
+		require.NoError(t, err)
+	}
+
+	return saveClientSnapshot(t, client)
+}
+
+// saveClientSnapshot streams a snapshot from client into a temporary file
+func saveClientSnapshot(t *testing.T, client *clientv3.Client) string {
+	t.Helper()
+
+	reader, err := client.Snapshot(context.Background())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	f, err := os.Create(snapshotPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = io.Copy(f, reader)
+	require.NoError(t, err)
+
+	return snapshotPath
+}
+
+func TestRestoreDrill(t *testing.T) {
+	snapshotPath := saveEmbeddedSnapshot(t, map[string]string{
+		"/registry/pods/default/a":    "a",
+		"/registry/pods/default/b":    "b",
+		"/registry/secrets/default/c": "c",
+	})
+
+	drill := etcd.NewRestoreDrill(zap.NewNop().Sugar(), t.TempDir())
+
+	tests := []struct {
+		name       string
+		spec       etcd.DrillSpec
+		wantPassed bool
+	}{
+		{
+			name:       "expected prefix present",
+			spec:       etcd.DrillSpec{MinKeys: 3, ExpectedPrefixes: []string{"/registry/"}},
+			wantPassed: true,
+		},
+		{
+			name:       "too few keys",
+			spec:       etcd.DrillSpec{MinKeys: 10},
+			wantPassed: false,
+		},
+		{
+			name:       "expected prefix missing",
+			spec:       etcd.DrillSpec{ExpectedPrefixes: []string{"/registry/", "/missing/"}},
+			wantPassed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := drill.Run(context.Background(), snapshotPath, tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPassed, result.Passed, result.Message)
+			assert.Equal(t, int64(3), result.TotalKeys)
+		})
+	}
+}
+
+func TestRestoreDrillCorruptSnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "corrupt.db")
+	require.NoError(t, os.WriteFile(snapshotPath, []byte("not a snapshot"), 0o600))
+
+	drill := etcd.NewRestoreDrill(zap.NewNop().Sugar(), t.TempDir())
+
+	result, err := drill.Run(context.Background(), snapshotPath, etcd.DrillSpec{})
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Message, "snapshot restore failed")
+}
@@ -30,110 +30,71 @@ func NewHealthValidator(logger *zap.SugaredLogger, tlsConfig *tls.Config) *Healt
 	}
 }
 
-// ValidateHealth checks if an ETCD cluster is healthy
-// Returns nil if healthy, error if unhealthy
-func (hv *HealthValidator) ValidateHealth(ctx context.Context, endpoints []string) error {
-	if len(endpoints) == 0 {
-		return fmt.Errorf("no endpoints provided")
-	}
-
-	// Create ETCD client
-	cfg := clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: hv.healthTimeout,
-		TLS:         hv.tlsConfig,
-		DialOptions: []grpc.DialOption{
-			grpc.WithBlock(),
-		},
-	}
+// MemberStatus is a cluster member as reported by MemberList, along with
+// the outcome of probing its client URL
+type MemberStatus struct {
+	ID         uint64
+	Name       string
+	PeerURLs   []string
+	ClientURLs []string
+	IsLearner  bool
+	// Healthy is only probed for voting members; learners do not serve
+	// linearizable reads
+	Healthy bool
+}
 
-	client, err := clientv3.New(cfg)
+// ListMembers enumerates the members of an ETCD cluster and probes the
+// health of every voting member
+func (hv *HealthValidator) ListMembers(ctx context.Context, endpoints []string) ([]MemberStatus, error) {
+	client, err := hv.newClient(endpoints)
 	if err != nil {
-		return fmt.Errorf("failed to create ETCD client: %w", err)
+		return nil, err
 	}
 	defer client.Close()
 
-	// Get member list to validate cluster state
-	memberCtx, cancel := context.WithTimeout(ctx, hv.healthTimeout)
-	defer cancel()
+	members, _, err := hv.listMembers(ctx, client)
+	return members, err
+}
 
-	memberList, err := client.MemberList(memberCtx)
+// ValidateHealth checks if an ETCD cluster is healthy
+// Returns nil if healthy, error if unhealthy
+func (hv *HealthValidator) ValidateHealth(ctx context.Context, endpoints []string) error {
+	client, err := hv.newClient(endpoints)
 	if err != nil {
-		return fmt.Errorf("failed to get member list: %w", err)
+		return err
 	}
+	defer client.Close()
 
-	if len(memberList.Members) == 0 {
-		return fmt.Errorf("cluster has no members")
+	members, respondingID, err := hv.listMembers(ctx, client)
+	if err != nil {
+		return err
 	}
 
 	// Calculate quorum requirement (majority of voting members)
 	votingMembers := 0
-	for _, member := range memberList.Members {
-		if !member.IsLearner {
-			votingMembers++
-		}
-	}
-
-	if votingMembers == 0 {
-		return fmt.Errorf("cluster has no voting members")
-	}
-
-	quorumRequired := (votingMembers / 2) + 1
-
-	// Check each member's health
 	healthyMembers := 0
 	hasLeader := false
-
-	for _, member := range memberList.Members {
+	for _, member := range members {
 		if member.IsLearner {
 			continue // Skip learner members in quorum calculation
 		}
+		votingMembers++
 
 		// Check if member is the leader
-		if memberList.Header.GetMemberId() == member.ID {
+		if respondingID == member.ID {
 			hasLeader = true
 		}
-
-		// Try to ping the member
-		memberEndpoint := member.ClientURLs[0] // Use first client URL
-		memberCtx, cancel := context.WithTimeout(ctx, hv.healthTimeout)
-
-		memberCfg := clientv3.Config{
-			Endpoints:   []string{memberEndpoint},
-			DialTimeout: hv.healthTimeout,
-			TLS:         hv.tlsConfig,
-			DialOptions: []grpc.DialOption{
-				grpc.WithBlock(),
-			},
-		}
-
-		memberClient, err := clientv3.New(memberCfg)
-		cancel()
-
-		if err != nil {
-			hv.logger.Debugw("Member unhealthy",
-				"member_id", fmt.Sprintf("%x", member.ID),
-				"endpoint", memberEndpoint,
-				"error", err,
-			)
-			memberClient.Close()
-			continue
-		}
-
-		memberCtx, cancel = context.WithTimeout(ctx, hv.healthTimeout)
-		_, err = memberClient.Get(memberCtx, "health")
-		cancel()
-		memberClient.Close()
-
-		if err == nil {
+		if member.Healthy {
 			healthyMembers++
-			hv.logger.Debugw("Member healthy",
-				"member_id", fmt.Sprintf("%x", member.ID),
-				"endpoint", memberEndpoint,
-			)
 		}
 	}
 
+	if votingMembers == 0 {
+		return fmt.Errorf("cluster has no voting members")
+	}
+
+	quorumRequired := (votingMembers / 2) + 1
+
 	// Validate quorum
 	if healthyMembers < quorumRequired {
 		return fmt.Errorf("insufficient healthy members: %d/%d required %d",
@@ -155,6 +116,98 @@ func (hv *HealthValidator) ValidateHealth(ctx context.Context, endpoints []strin
 	return nil
 }
 
+// newClient creates an ETCD client for the given endpoints
+func (hv *HealthValidator) newClient(endpoints []string) (*clientv3.Client, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints provided")
+	}
+
+	cfg := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: hv.healthTimeout,
+		TLS:         hv.tlsConfig,
+		DialOptions: []grpc.DialOption{
+			grpc.WithBlock(),
+		},
+	}
+
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ETCD client: %w", err)
+	}
+	return client, nil
+}
+
+// listMembers returns the cluster members and the ID of the member that
+// answered the MemberList request
+func (hv *HealthValidator) listMembers(ctx context.Context, client *clientv3.Client) ([]MemberStatus, uint64, error) {
+	memberCtx, cancel := context.WithTimeout(ctx, hv.healthTimeout)
+	defer cancel()
+
+	memberList, err := client.MemberList(memberCtx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get member list: %w", err)
+	}
+
+	if len(memberList.Members) == 0 {
+		return nil, 0, fmt.Errorf("cluster has no members")
+	}
+
+	members := make([]MemberStatus, 0, len(memberList.Members))
+	for _, member := range memberList.Members {
+		status := MemberStatus{
+			ID:         member.ID,
+			Name:       member.Name,
+			PeerURLs:   member.PeerURLs,
+			ClientURLs: member.ClientURLs,
+			IsLearner:  member.IsLearner,
+		}
+		if !member.IsLearner {
+			status.Healthy = hv.probeMember(ctx, member.ID, member.ClientURLs)
+		}
+		members = append(members, status)
+	}
+
+	return members, memberList.Header.GetMemberId(), nil
+}
+
+// probeMember reads from a single member to check that it serves requests
+func (hv *HealthValidator) probeMember(ctx context.Context, id uint64, clientURLs []string) bool {
+	if len(clientURLs) == 0 {
+		// Members that have been added but never started have no client URLs
+		return false
+	}
+	memberEndpoint := clientURLs[0] // Use first client URL
+
+	memberClient, err := hv.newClient([]string{memberEndpoint})
+	if err != nil {
+		hv.logger.Debugw("Member unhealthy",
+			"member_id", fmt.Sprintf("%x", id),
+			"endpoint", memberEndpoint,
+			"error", err,
+		)
+		return false
+	}
+	defer memberClient.Close()
+
+	memberCtx, cancel := context.WithTimeout(ctx, hv.healthTimeout)
+	defer cancel()
+	if _, err := memberClient.Get(memberCtx, "health"); err != nil {
+		hv.logger.Debugw("Member unhealthy",
+			"member_id", fmt.Sprintf("%x", id),
+			"endpoint", memberEndpoint,
+			"error", err,
+		)
+		return false
+	}
+
+	hv.logger.Debugw("Member healthy",
+		"member_id", fmt.Sprintf("%x", id),
+		"endpoint", memberEndpoint,
+	)
+	return true
+}
+
 // LoadTLSConfig loads TLS certificates from files
 func LoadTLSConfig(certPath, keyPath, caPath string) (*tls.Config, error) {
 	// Load client certificate and key
@@ -0,0 +1,70 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Membership changes the membership of an ETCD cluster
+type Membership struct {
+	hv     *HealthValidator
+	client *clientv3.Client
+}
+
+// Membership connects to the cluster at endpoints to enumerate and change its members.
+// The caller must Close it.
+func (hv *HealthValidator) Membership(endpoints []string) (*Membership, error) {
+	client, err := hv.newClient(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &Membership{hv: hv, client: client}, nil
+}
+
+// ListMembers enumerates the cluster members and probes the health of every voting member
+func (m *Membership) ListMembers(ctx context.Context) ([]MemberStatus, error) {
+	members, _, err := m.hv.listMembers(ctx, m.client)
+	return members, err
+}
+
+// RemoveMember removes the member with the given ID from the cluster
+func (m *Membership) RemoveMember(ctx context.Context, id uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.hv.healthTimeout)
+	defer cancel()
+
+	if _, err := m.client.MemberRemove(ctx, id); err != nil {
+		return fmt.Errorf("failed to remove member %x: %w", id, err)
+	}
+	return nil
+}
+
+// AddLearner adds a non-voting member with the given peer URLs and returns its ID
+func (m *Membership) AddLearner(ctx context.Context, peerURLs []string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.hv.healthTimeout)
+	defer cancel()
+
+	resp, err := m.client.MemberAddAsLearner(ctx, peerURLs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add learner %v: %w", peerURLs, err)
+	}
+	return resp.Member.ID, nil
+}
+
+// PromoteMember promotes a learner to a voting member. ETCD refuses until the
+// learner has caught up with the leader, so callers are expected to retry.
+func (m *Membership) PromoteMember(ctx context.Context, id uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.hv.healthTimeout)
+	defer cancel()
+
+	if _, err := m.client.MemberPromote(ctx, id); err != nil {
+		return fmt.Errorf("failed to promote member %x: %w", id, err)
+	}
+	return nil
+}
+
+// Close closes the underlying client
+func (m *Membership) Close() error {
+	return m.client.Close()
+}
@@ -0,0 +1,139 @@
+package etcd_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func startMember(t *testing.T, name, dir, initialCluster, state string, peer, client url.URL) *embed.Etcd {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Name = name
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.ListenPeerUrls = []url.URL{peer}
+	cfg.AdvertisePeerUrls = []url.URL{peer}
+	cfg.ListenClientUrls = []url.URL{client}
+	cfg.AdvertiseClientUrls = []url.URL{client}
+	cfg.InitialCluster = initialCluster
+	cfg.ClusterState = state
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatalf("embedded etcd %s did not become ready", name)
+	}
+	return server
+}
+
+func TestSeedMemberJoinsAsLearner(t *testing.T) {
+	ctx := context.Background()
+	logger := zap.NewNop().Sugar()
+	dir := t.TempDir()
+
+	peer0, client0 := freeURL(t), freeURL(t)
+	startMember(t, "etcd-0", filepath.Join(dir, "etcd-0"),
+		fmt.Sprintf("etcd-0=%s", peer0.String()), embed.ClusterStateFlagNew, peer0, client0)
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{client0.String()},
+		DialTimeout: 5 * time.Second,
+		Logger:      zap.NewNop(),
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Put(ctx, "/registry/pods/default/a", "a")
+	require.NoError(t, err)
+	snapshotPath := saveClientSnapshot(t, c)
+	// Written after the snapshot, so it must reach the new member through raft
+	_, err = c.Put(ctx, "/registry/pods/default/b", "b")
+	require.NoError(t, err)
+
+	membership, err := etcd.NewHealthValidator(logger, nil).Membership([]string{client0.String()})
+	require.NoError(t, err)
+	defer membership.Close()
+
+	// A learner that never started can be removed again
+	spare := freeURL(t)
+	spareID, err := membership.AddLearner(ctx, []string{spare.String()})
+	require.NoError(t, err)
+	require.NoError(t, membership.RemoveMember(ctx, spareID))
+
+	peer1, client1 := freeURL(t), freeURL(t)
+	id, err := membership.AddLearner(ctx, []string{peer1.String()})
+	require.NoError(t, err)
+
+	members, err := membership.ListMembers(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	for _, m := range members {
+		if m.ID == id {
+			assert.True(t, m.IsLearner)
+		} else {
+			assert.Equal(t, "etcd-0", m.Name)
+			assert.True(t, m.Healthy)
+		}
+	}
+
+	dataDir := filepath.Join(dir, "etcd-1")
+	_, err = etcd.SeedMember(logger, snapshotPath, dataDir, dataDir+".pre-restore", "replace-1")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dataDir, "member", "snap", "db"))
+
+	startMember(t, "etcd-1", dataDir,
+		fmt.Sprintf("etcd-0=%s,etcd-1=%s", peer0.String(), peer1.String()), embed.ClusterStateFlagExisting, peer1, client1)
+
+	require.Eventually(t, func() bool {
+		return membership.PromoteMember(ctx, id) == nil
+	}, 30*time.Second, 100*time.Millisecond)
+
+	seeded, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{client1.String()},
+		DialTimeout: 5 * time.Second,
+		Logger:      zap.NewNop(),
+	})
+	require.NoError(t, err)
+	defer seeded.Close()
+
+	resp, err := seeded.Get(ctx, "/registry/pods/", clientv3.WithPrefix(), clientv3.WithSerializable())
+	require.NoError(t, err)
+	assert.Len(t, resp.Kvs, 2)
+}
+
+func TestSeedMemberRejectsCorruptSnapshot(t *testing.T) {
+	pvc := t.TempDir()
+	dataDir := filepath.Join(pvc, "data")
+	snapshotPath := filepath.Join(pvc, "corrupt.db")
+	require.NoError(t, os.WriteFile(snapshotPath, []byte("not a snapshot"), 0o600))
+
+	_, err := etcd.SeedMember(zap.NewNop().Sugar(), snapshotPath, dataDir, dataDir+".pre-restore", "replace-1")
+	assert.Error(t, err)
+	assert.NoDirExists(t, dataDir)
+}
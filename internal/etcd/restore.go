@@ -0,0 +1,292 @@
+package etcd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.uber.org/zap"
+)
+
+// MemberRestoreSpec describes how to restore a snapshot into one member's data dir
+type MemberRestoreSpec struct {
+	// Name is the etcd member name (--name)
+	Name string
+	// InitialCluster lists every member of the restored cluster as name=peerURL
+	InitialCluster string
+	// PeerURLs are the member's advertised peer URLs
+	PeerURLs []string
+	// InitialClusterToken must be the same for every member of the restored cluster
+	InitialClusterToken string
+	// DataDir is the member's live data dir
+	DataDir string
+	// BackupDir receives the member's previous data dir so a restore can be rolled back
+	BackupDir string
+	// RestoreID identifies the restore the member is part of; every attempt at the same
+	// restore passes the same ID
+	RestoreID string
+}
+
+// MemberRestoreResult reports what a member restore or rollback did
+type MemberRestoreResult struct {
+	Member    string `json:"member"`
+	DataDir   string `json:"data_dir"`
+	BackupDir string `json:"backup_dir,omitempty"`
+	Message   string `json:"message"`
+}
+
+// RestoreMember restores the snapshot at snapshotPath into a staging dir next to the
+// member's data dir and then swaps it in, keeping the previous data dir as the backup.
+// It is safe to repeat: a leftover staging dir is discarded and, once this restore has
+// made the backup, the data dir is known to hold an earlier attempt and is replaced.
+func RestoreMember(logger *zap.SugaredLogger, snapshotPath string, spec MemberRestoreSpec) (*MemberRestoreResult, error) {
+	staging := spec.DataDir + ".restore"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("failed to clear staging dir: %w", err)
+	}
+
+	logger.Infow("Restoring snapshot for member",
+		"member", spec.Name,
+		"snapshot_path", snapshotPath,
+		"staging_dir", staging,
+	)
+
+	err := snapshot.NewV3(zap.NewNop()).Restore(snapshot.RestoreConfig{
+		SnapshotPath:        snapshotPath,
+		Name:                spec.Name,
+		OutputDataDir:       staging,
+		PeerURLs:            spec.PeerURLs,
+		InitialCluster:      spec.InitialCluster,
+		InitialClusterToken: spec.InitialClusterToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snapshot restore failed: %w", err)
+	}
+
+	if err := swapIn(staging, spec.DataDir, spec.BackupDir, spec.RestoreID); err != nil {
+		return nil, err
+	}
+
+	return &MemberRestoreResult{
+		Member:    spec.Name,
+		DataDir:   spec.DataDir,
+		BackupDir: spec.BackupDir,
+		Message:   "restored",
+	}, nil
+}
+
+// RollbackMember puts back the data dir the restore restoreID moved aside.
+// A member the restore never swapped is left alone, as is a backup kept by another restore.
+func RollbackMember(logger *zap.SugaredLogger, dataDir, backupDir, restoreID string) (*MemberRestoreResult, error) {
+	// Whatever a failed restore left behind is discarded
+	if err := os.RemoveAll(dataDir + ".restore"); err != nil {
+		return nil, fmt.Errorf("failed to clear staging dir: %w", err)
+	}
+
+	ours, err := markedBy(dataDir, restoreID)
+	if err != nil {
+		return nil, err
+	}
+	backupExists, err := exists(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if !ours || !backupExists {
+		return &MemberRestoreResult{DataDir: dataDir, Message: "nothing to roll back"}, nil
+	}
+
+	logger.Infow("Rolling back member data dir",
+		"data_dir", dataDir,
+		"backup_dir", backupDir,
+	)
+
+	if err := os.RemoveAll(dataDir); err != nil {
+		return nil, fmt.Errorf("failed to remove restored data dir: %w", err)
+	}
+	if err := os.Rename(backupDir, dataDir); err != nil {
+		return nil, fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	if err := os.Remove(restoreMarker(dataDir)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove restore marker: %w", err)
+	}
+
+	return &MemberRestoreResult{DataDir: dataDir, BackupDir: backupDir, Message: "rolled back"}, nil
+}
+
+// InitialCluster formats members as the --initial-cluster value for a restored cluster
+func InitialCluster(members []MemberInfo) string {
+	parts := make([]string, 0, len(members))
+	for _, m := range members {
+		for _, u := range m.PeerURLs {
+			parts = append(parts, fmt.Sprintf("%s=%s", m.Name, u))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// restoreMarker names the file recording which restore moved a member's data dir aside,
+// so a repeated attempt can tell its own backup from one a finished restore left behind
+func restoreMarker(dataDir string) string {
+	return dataDir + ".restoring"
+}
+
+// markedBy reports whether the restore restoreID moved dataDir aside
+func markedBy(dataDir, restoreID string) (bool, error) {
+	data, err := os.ReadFile(restoreMarker(dataDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read restore marker: %w", err)
+	}
+	return string(data) == restoreID, nil
+}
+
+// swapIn replaces dataDir with the staging dir for the restore restoreID, moving the
+// previous data dir to backupDir. Restores and member seeds both swap through here.
+func swapIn(staging, dataDir, backupDir, restoreID string) error {
+	if err := moveAside(dataDir, backupDir, restoreID); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dataDir); err != nil {
+		return fmt.Errorf("failed to swap in new data dir: %w", err)
+	}
+	return nil
+}
+
+// moveAside moves dataDir to backupDir for the restore restoreID. A backup left by any
+// other restore is stale, since dataDir has been in use since, and is replaced. Once
+// this restore has made the backup, dataDir holds an earlier attempt and is discarded.
+func moveAside(dataDir, backupDir, restoreID string) error {
+	ours, err := markedBy(dataDir, restoreID)
+	if err != nil {
+		return err
+	}
+	// The marker is written before anything moves so an interrupted attempt is recognised
+	if !ours {
+		if err := os.RemoveAll(backupDir); err != nil {
+			return fmt.Errorf("failed to remove backup of an earlier restore: %w", err)
+		}
+		if err := os.WriteFile(restoreMarker(dataDir), []byte(restoreID), 0o600); err != nil {
+			return fmt.Errorf("failed to write restore marker: %w", err)
+		}
+	}
+
+	backupExists, err := exists(backupDir)
+	if err != nil {
+		return err
+	}
+	if backupExists {
+		if err := os.RemoveAll(dataDir); err != nil {
+			return fmt.Errorf("failed to remove data dir of an earlier attempt: %w", err)
+		}
+	} else if err := os.Rename(dataDir, backupDir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move data dir aside: %w", err)
+	}
+	return nil
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// SeedMember replaces a member's data dir with a data dir holding only the
+// snapshot's backend. Without a WAL etcd joins the running cluster as a new
+// member and takes its ID and membership from its peers; entries already
+// covered by the snapshot's consistent index are skipped rather than
+// re-applied, and the leader replaces the backend outright when it ships a
+// raft snapshot. The previous data dir is kept as the backup, as with
+// RestoreMember, and restoreID tells it from a backup an earlier seed left behind.
+func SeedMember(logger *zap.SugaredLogger, snapshotPath, dataDir, backupDir, restoreID string) (*MemberRestoreResult, error) {
+	staging := dataDir + ".restore"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("failed to clear staging dir: %w", err)
+	}
+
+	logger.Infow("Seeding member data dir from snapshot",
+		"snapshot_path", snapshotPath,
+		"staging_dir", staging,
+	)
+
+	// Check the snapshot can be opened before anything is touched
+	if _, err := snapshot.NewV3(zap.NewNop()).Status(snapshotPath); err != nil {
+		return nil, fmt.Errorf("snapshot status failed: %w", err)
+	}
+
+	snapDir := filepath.Join(staging, "member", "snap")
+	if err := os.MkdirAll(snapDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	if err := copyBackend(snapshotPath, filepath.Join(snapDir, "db")); err != nil {
+		return nil, err
+	}
+
+	if err := swapIn(staging, dataDir, backupDir, restoreID); err != nil {
+		return nil, err
+	}
+
+	return &MemberRestoreResult{
+		DataDir:   dataDir,
+		BackupDir: backupDir,
+		Message:   "seeded",
+	}, nil
+}
+
+// copyBackend copies the bbolt file of a snapshot to dst. Snapshots streamed
+// from etcd end with a SHA-256 of the database, which is verified and dropped
+// the same way etcdutl snapshot restore does.
+func copyBackend(snapshotPath, dst string) error {
+	src, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+	size := info.Size()
+	hasHash := size%512 == sha256.Size
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create backend: %w", err)
+	}
+	defer out.Close()
+
+	if !hasHash {
+		if _, err := io.Copy(out, src); err != nil {
+			return fmt.Errorf("failed to copy backend: %w", err)
+		}
+		return out.Sync()
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), io.LimitReader(src, size-sha256.Size)); err != nil {
+		return fmt.Errorf("failed to copy backend: %w", err)
+	}
+	expected := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(src, expected); err != nil {
+		return fmt.Errorf("failed to read snapshot hash: %w", err)
+	}
+	if !bytes.Equal(expected, h.Sum(nil)) {
+		return fmt.Errorf("snapshot hash mismatch")
+	}
+	return out.Sync()
+}
@@ -0,0 +1,132 @@
+package etcd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRestoreAndRollbackMember(t *testing.T) {
+	snapshotPath := saveEmbeddedSnapshot(t, map[string]string{"/registry/pods/default/a": "a"})
+	logger := zap.NewNop().Sugar()
+
+	pvc := t.TempDir()
+	dataDir := filepath.Join(pvc, "data")
+	backupDir := filepath.Join(pvc, "data.pre-restore")
+
+	// The member's existing data dir
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "member"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "original"), []byte("old"), 0o600))
+
+	members := []etcd.MemberInfo{
+		{Name: "etcd-0", PeerURLs: []string{"https://etcd-0.etcd:2380"}},
+		{Name: "etcd-1", PeerURLs: []string{"https://etcd-1.etcd:2380"}},
+		{Name: "etcd-2", PeerURLs: []string{"https://etcd-2.etcd:2380"}},
+	}
+	initialCluster := etcd.InitialCluster(members)
+	assert.Equal(t, "etcd-0=https://etcd-0.etcd:2380,etcd-1=https://etcd-1.etcd:2380,etcd-2=https://etcd-2.etcd:2380", initialCluster)
+
+	spec := etcd.MemberRestoreSpec{
+		Name:                "etcd-1",
+		InitialCluster:      initialCluster,
+		PeerURLs:            members[1].PeerURLs,
+		InitialClusterToken: "restored",
+		DataDir:             dataDir,
+		BackupDir:           backupDir,
+		RestoreID:           "restore-1",
+	}
+
+	_, err := etcd.RestoreMember(logger, snapshotPath, spec)
+	require.NoError(t, err)
+	assert.DirExists(t, filepath.Join(dataDir, "member", "snap"))
+	assert.FileExists(t, filepath.Join(backupDir, "original"))
+	assert.NoDirExists(t, dataDir+".restore")
+
+	// Repeating the restore keeps the original backup
+	_, err = etcd.RestoreMember(logger, snapshotPath, spec)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(backupDir, "original"))
+
+	// Another restore's rollback leaves the backup alone
+	result, err := etcd.RollbackMember(logger, dataDir, backupDir, "restore-0")
+	require.NoError(t, err)
+	assert.Equal(t, "nothing to roll back", result.Message)
+	assert.FileExists(t, filepath.Join(backupDir, "original"))
+
+	result, err = etcd.RollbackMember(logger, dataDir, backupDir, "restore-1")
+	require.NoError(t, err)
+	assert.Equal(t, "rolled back", result.Message)
+	assert.FileExists(t, filepath.Join(dataDir, "original"))
+	assert.NoDirExists(t, backupDir)
+
+	// Nothing left to roll back
+	result, err = etcd.RollbackMember(logger, dataDir, backupDir, "restore-1")
+	require.NoError(t, err)
+	assert.Equal(t, "nothing to roll back", result.Message)
+	assert.FileExists(t, filepath.Join(dataDir, "original"))
+}
+
+func TestRestoreMemberReplacesStaleBackup(t *testing.T) {
+	snapshotPath := saveEmbeddedSnapshot(t, map[string]string{"/registry/pods/default/a": "a"})
+	logger := zap.NewNop().Sugar()
+
+	pvc := t.TempDir()
+	dataDir := filepath.Join(pvc, "data")
+	backupDir := filepath.Join(pvc, "data.pre-restore")
+	require.NoError(t, os.MkdirAll(dataDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "original"), []byte("old"), 0o600))
+
+	spec := etcd.MemberRestoreSpec{
+		Name:                "etcd-0",
+		InitialCluster:      "etcd-0=https://etcd-0.etcd:2380",
+		PeerURLs:            []string{"https://etcd-0.etcd:2380"},
+		InitialClusterToken: "restored",
+		DataDir:             dataDir,
+		BackupDir:           backupDir,
+		RestoreID:           "restore-1",
+	}
+	_, err := etcd.RestoreMember(logger, snapshotPath, spec)
+	require.NoError(t, err)
+
+	// The cluster ran on the restored data dir before it was restored again
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "since"), []byte("new"), 0o600))
+
+	// The finished restore's backup is replaced by the data dir in use
+	spec.RestoreID = "restore-2"
+	_, err = etcd.RestoreMember(logger, snapshotPath, spec)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(backupDir, "since"))
+	assert.NoFileExists(t, filepath.Join(backupDir, "original"))
+
+	result, err := etcd.RollbackMember(logger, dataDir, backupDir, "restore-2")
+	require.NoError(t, err)
+	assert.Equal(t, "rolled back", result.Message)
+	assert.FileExists(t, filepath.Join(dataDir, "since"))
+}
+
+func TestRestoreMemberFailureLeavesDataDir(t *testing.T) {
+	pvc := t.TempDir()
+	dataDir := filepath.Join(pvc, "data")
+	require.NoError(t, os.MkdirAll(dataDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "original"), []byte("old"), 0o600))
+
+	garbage := filepath.Join(pvc, "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a snapshot"), 0o600))
+
+	_, err := etcd.RestoreMember(zap.NewNop().Sugar(), garbage, etcd.MemberRestoreSpec{
+		Name:                "etcd-0",
+		InitialCluster:      "etcd-0=https://etcd-0.etcd:2380",
+		PeerURLs:            []string{"https://etcd-0.etcd:2380"},
+		InitialClusterToken: "restored",
+		DataDir:             dataDir,
+		BackupDir:           filepath.Join(pvc, "data.pre-restore"),
+	})
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(dataDir, "original"))
+	assert.NoDirExists(t, filepath.Join(pvc, "data.pre-restore"))
+}
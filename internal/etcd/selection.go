@@ -0,0 +1,177 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MemberSelection is the policy that picks the member a snapshot is streamed from
+type MemberSelection string
+
+const (
+	// SelectPreferFollower picks the most caught-up healthy follower, and the leader
+	// only when no follower is healthy
+	SelectPreferFollower MemberSelection = "prefer-follower"
+	// SelectLeader picks the leader
+	SelectLeader MemberSelection = "leader"
+	// SelectSmallestDB picks the member with the smallest backend, which is the least to stream
+	SelectSmallestDB MemberSelection = "smallest-db"
+	// SelectHighestRaftIndex picks the member with the highest raft index, the least behind
+	SelectHighestRaftIndex MemberSelection = "highest-raft-index"
+
+	// selectMemberPrefix precedes the name or hex ID of a specific member to pick
+	selectMemberPrefix = "member:"
+)
+
+// SelectMember returns the policy that picks the member with the given name or hex ID
+func SelectMember(nameOrID string) MemberSelection {
+	return MemberSelection(selectMemberPrefix + nameOrID)
+}
+
+// ParseMemberSelection parses a member selection policy; empty selects prefer-follower
+func ParseMemberSelection(value string) (MemberSelection, error) {
+	switch s := MemberSelection(value); s {
+	case "":
+		return SelectPreferFollower, nil
+	case SelectPreferFollower, SelectLeader, SelectSmallestDB, SelectHighestRaftIndex:
+		return s, nil
+	default:
+		if member, ok := strings.CutPrefix(value, selectMemberPrefix); ok && member != "" {
+			return s, nil
+		}
+		return "", fmt.Errorf("unsupported member selection %q (supported: prefer-follower, leader, smallest-db, highest-raft-index, member:NAME)", value)
+	}
+}
+
+// SnapshotSource is a healthy voting member a snapshot can be streamed from, along
+// with the status it reported
+type SnapshotSource struct {
+	ID        uint64
+	Name      string
+	ClientURL string
+	IsLeader  bool
+	// Revision is the member's revision when its status was read; a snapshot
+	// streamed from it afterwards holds at least this revision
+	Revision  int64
+	DBSize    int64
+	RaftIndex uint64
+	// Version is the member's server version, such as 3.5.21
+	Version string
+}
+
+// Pick chooses the member to snapshot among sources
+func (s MemberSelection) Pick(sources []SnapshotSource) (*SnapshotSource, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no healthy voting member to snapshot")
+	}
+
+	// better reports whether a should be picked over b; ties go to followers so the
+	// leader is spared the load, then to the lowest ID so the choice is stable
+	var better func(a, b *SnapshotSource) bool
+	tieBreak := func(a, b *SnapshotSource) bool {
+		if a.IsLeader != b.IsLeader {
+			return !a.IsLeader
+		}
+		return a.ID < b.ID
+	}
+
+	policy, _ := ParseMemberSelection(string(s))
+	switch policy {
+	case SelectPreferFollower:
+		better = func(a, b *SnapshotSource) bool {
+			if a.IsLeader != b.IsLeader || a.RaftIndex == b.RaftIndex {
+				return tieBreak(a, b)
+			}
+			return a.RaftIndex > b.RaftIndex
+		}
+	case SelectLeader:
+		for i := range sources {
+			if sources[i].IsLeader {
+				return &sources[i], nil
+			}
+		}
+		return nil, fmt.Errorf("the leader is not among the healthy members")
+	case SelectSmallestDB:
+		better = func(a, b *SnapshotSource) bool {
+			if a.DBSize == b.DBSize {
+				return tieBreak(a, b)
+			}
+			return a.DBSize < b.DBSize
+		}
+	case SelectHighestRaftIndex:
+		better = func(a, b *SnapshotSource) bool {
+			if a.RaftIndex == b.RaftIndex {
+				return tieBreak(a, b)
+			}
+			return a.RaftIndex > b.RaftIndex
+		}
+	default:
+		member, ok := strings.CutPrefix(string(s), selectMemberPrefix)
+		if !ok || member == "" {
+			return nil, fmt.Errorf("unsupported member selection %q", s)
+		}
+		for i := range sources {
+			if sources[i].Name == member || strconv.FormatUint(sources[i].ID, 16) == member {
+				return &sources[i], nil
+			}
+		}
+		return nil, fmt.Errorf("member %s is not among the healthy voting members", member)
+	}
+
+	picked := &sources[0]
+	for i := range sources[1:] {
+		if candidate := &sources[i+1]; better(candidate, picked) {
+			picked = candidate
+		}
+	}
+	return picked, nil
+}
+
+// SnapshotSources reads the status of every healthy voting member of an ETCD cluster.
+// Members whose status cannot be read are left out.
+func (hv *HealthValidator) SnapshotSources(ctx context.Context, endpoints []string) ([]SnapshotSource, error) {
+	client, err := hv.newClient(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	members, _, err := hv.listMembers(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []SnapshotSource
+	for _, member := range members {
+		if member.IsLearner || !member.Healthy || len(member.ClientURLs) == 0 {
+			continue
+		}
+
+		statusCtx, cancel := context.WithTimeout(ctx, hv.healthTimeout)
+		status, err := client.Status(statusCtx, member.ClientURLs[0])
+		cancel()
+		if err != nil {
+			hv.logger.Debugw("Failed to read member status",
+				"member_id", fmt.Sprintf("%x", member.ID),
+				"endpoint", member.ClientURLs[0],
+				"error", err,
+			)
+			continue
+		}
+
+		sources = append(sources, SnapshotSource{
+			ID:        member.ID,
+			Name:      member.Name,
+			ClientURL: member.ClientURLs[0],
+			IsLeader:  status.Leader == member.ID,
+			Revision:  status.Header.GetRevision(),
+			DBSize:    status.DbSize,
+			RaftIndex: status.RaftIndex,
+			Version:   status.Version,
+		})
+	}
+
+	return sources, nil
+}
@@ -0,0 +1,105 @@
+package etcd_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+func TestParseMemberSelection(t *testing.T) {
+	for _, value := range []string{"prefer-follower", "leader", "smallest-db", "highest-raft-index", "member:etcd-1"} {
+		selection, err := etcd.ParseMemberSelection(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, etcd.MemberSelection(value), selection)
+	}
+
+	selection, err := etcd.ParseMemberSelection("")
+	require.NoError(t, err)
+	assert.Equal(t, etcd.SelectPreferFollower, selection)
+
+	for _, value := range []string{"random", "member:", "Leader"} {
+		_, err := etcd.ParseMemberSelection(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMemberSelectionPick(t *testing.T) {
+	sources := []etcd.SnapshotSource{
+		{ID: 0x1, Name: "etcd-0", IsLeader: true, DBSize: 100, RaftIndex: 50},
+		{ID: 0x2, Name: "etcd-1", DBSize: 300, RaftIndex: 48},
+		{ID: 0xa, Name: "etcd-2", DBSize: 200, RaftIndex: 49},
+	}
+
+	tests := []struct {
+		selection etcd.MemberSelection
+		want      string
+	}{
+		{selection: etcd.SelectPreferFollower, want: "etcd-2"},
+		{selection: "", want: "etcd-2"},
+		{selection: etcd.SelectLeader, want: "etcd-0"},
+		{selection: etcd.SelectSmallestDB, want: "etcd-0"},
+		{selection: etcd.SelectHighestRaftIndex, want: "etcd-0"},
+		{selection: etcd.SelectMember("etcd-1"), want: "etcd-1"},
+		{selection: etcd.SelectMember("a"), want: "etcd-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.selection), func(t *testing.T) {
+			picked, err := tt.selection.Pick(sources)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, picked.Name)
+		})
+	}
+
+	// Ties go to a follower, then to the lowest ID
+	tied := []etcd.SnapshotSource{
+		{ID: 0x1, Name: "etcd-0", IsLeader: true, RaftIndex: 50},
+		{ID: 0x3, Name: "etcd-2", RaftIndex: 50},
+		{ID: 0x2, Name: "etcd-1", RaftIndex: 50},
+	}
+	picked, err := etcd.SelectHighestRaftIndex.Pick(tied)
+	require.NoError(t, err)
+	assert.Equal(t, "etcd-1", picked.Name)
+
+	// Only the leader is healthy
+	picked, err = etcd.SelectPreferFollower.Pick(sources[:1])
+	require.NoError(t, err)
+	assert.Equal(t, "etcd-0", picked.Name)
+
+	_, err = etcd.SelectLeader.Pick(sources[1:])
+	assert.Error(t, err)
+	_, err = etcd.SelectMember("etcd-9").Pick(sources)
+	assert.Error(t, err)
+	_, err = etcd.SelectPreferFollower.Pick(nil)
+	assert.Error(t, err)
+}
+
+func TestSnapshotSources(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	peer, client := freeURL(t), freeURL(t)
+	server := startMember(t, "etcd-0", filepath.Join(dir, "etcd-0"),
+		fmt.Sprintf("etcd-0=%s", peer.String()), embed.ClusterStateFlagNew, peer, client)
+
+	sources, err := etcd.NewHealthValidator(zap.NewNop().Sugar(), nil).SnapshotSources(ctx, []string{client.String()})
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+
+	source := sources[0]
+	assert.Equal(t, uint64(server.Server.MemberID()), source.ID)
+	assert.Equal(t, "etcd-0", source.Name)
+	assert.Equal(t, client.String(), source.ClientURL)
+	assert.True(t, source.IsLeader)
+	assert.Positive(t, source.Revision)
+	assert.Positive(t, source.DBSize)
+	assert.Positive(t, source.RaftIndex)
+	assert.NotEmpty(t, source.Version)
+}
@@ -12,10 +12,14 @@ type ClusterInfo struct {
 }
 
 type MemberInfo struct {
+	// PodName is the pod running the member
+	PodName    string
 	Name       string
 	ID         string
 	ClientURLs []string
 	PeerURLs   []string
+	// InitialClusterState is the pod's --initial-cluster-state, if set
+	InitialClusterState string
 }
 
 type DiscoveryResult struct {
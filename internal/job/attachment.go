@@ -0,0 +1,155 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// AttachmentNode returns the node a ReadWriteOnce PVC is attached to, found from the
+// running pods that mount it or, for volumes still attached after their pods are gone,
+// from the VolumeAttachments of its volume. Jobs that mount the PVC have to run on that
+// node. No node is returned for a PVC that is not attached. An error is returned when
+// the PVC is attached to more than one node, or to a node that cannot run the job.
+func (e *Executor) AttachmentNode(ctx context.Context, namespace, pvcName string) (string, error) {
+	var nodes []string
+
+	pods, err := e.k8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods using PVC %s/%s: %w", namespace, pvcName, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if podMountsPVC(&pod, pvcName) && !slices.Contains(nodes, pod.Spec.NodeName) {
+			nodes = append(nodes, pod.Spec.NodeName)
+		}
+	}
+
+	if len(nodes) == 0 {
+		if nodes, err = e.volumeAttachmentNodes(ctx, namespace, pvcName); err != nil {
+			return "", err
+		}
+	}
+
+	switch len(nodes) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", fmt.Errorf("PVC %s/%s is attached to more than one node (%s)", namespace, pvcName, strings.Join(nodes, ", "))
+	}
+
+	nodeName := nodes[0]
+	node, err := e.k8sClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", fmt.Errorf("PVC %s/%s is attached to node %s, which no longer exists", namespace, pvcName, nodeName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	if node.Spec.Unschedulable {
+		return "", fmt.Errorf("PVC %s/%s is attached to node %s, which is cordoned", namespace, pvcName, nodeName)
+	}
+	if !nodeReady(node) {
+		return "", fmt.Errorf("PVC %s/%s is attached to node %s, which is not ready", namespace, pvcName, nodeName)
+	}
+
+	e.logger.Debugw("Found PVC attachment node",
+		"pvc_name", pvcName,
+		"namespace", namespace,
+		"node", nodeName,
+	)
+	return nodeName, nil
+}
+
+// volumeAttachmentNodes returns the nodes the volume bound to a PVC is attached to.
+// Attachments being detached are left out.
+func (e *Executor) volumeAttachmentNodes(ctx context.Context, namespace, pvcName string) ([]string, error) {
+	pvc, err := e.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PVC %s/%s: %w", namespace, pvcName, err)
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, nil
+	}
+
+	attachments, err := e.k8sClient.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volume attachments: %w", err)
+	}
+
+	var nodes []string
+	for _, attachment := range attachments.Items {
+		source := attachment.Spec.Source.PersistentVolumeName
+		if source == nil || *source != pvc.Spec.VolumeName || attachment.DeletionTimestamp != nil {
+			continue
+		}
+		if !slices.Contains(nodes, attachment.Spec.NodeName) {
+			nodes = append(nodes, attachment.Spec.NodeName)
+		}
+	}
+	return nodes, nil
+}
+
+// attachConflict reports the reason a job's pod cannot attach its volumes because they are
+// in use on another node. Such pods never start, so there is no point waiting for them.
+func (e *Executor) attachConflict(ctx context.Context, job *batchv1.Job) (string, bool) {
+	pods, err := e.k8sClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
+	})
+	if err != nil {
+		return "", false
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		events, err := e.k8sClient.CoreV1().Events(job.Namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.Set{
+				"involvedObject.kind": "Pod",
+				"involvedObject.name": pod.Name,
+				"reason":              "FailedAttachVolume",
+			}.String(),
+		})
+		if err != nil {
+			continue
+		}
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == pod.Name && strings.Contains(event.Message, "Multi-Attach error") {
+				return event.Message, true
+			}
+		}
+	}
+	return "", false
+}
+
+func podMountsPVC(pod *corev1.Pod, pvcName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvcName {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
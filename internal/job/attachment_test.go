@@ -0,0 +1,145 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAttachmentNode(t *testing.T) {
+	node := func(name string, ready, unschedulable bool) *corev1.Node {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+			},
+		}
+	}
+	pod := func(name, nodeName string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "hcp"},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+				Volumes: []corev1.Volume{{
+					Name: "snapshot-pvc",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "etcd-snapshots"},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-snapshots", Namespace: "hcp"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+	}
+	attachment := func(name, nodeName string) *storagev1.VolumeAttachment {
+		pv := "pv-1"
+		return &storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: storagev1.VolumeAttachmentSpec{
+				NodeName: nodeName,
+				Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pv},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "not attached",
+			objects: []runtime.Object{pvc, node("node-a", true, false)},
+		},
+		{
+			name:    "running pod",
+			objects: []runtime.Object{pvc, pod("job-1", "node-a", corev1.PodRunning), pod("job-0", "node-b", corev1.PodSucceeded), node("node-a", true, false)},
+			want:    "node-a",
+		},
+		{
+			name:    "lingering attachment",
+			objects: []runtime.Object{pvc, pod("job-0", "node-b", corev1.PodSucceeded), attachment("va-1", "node-b"), node("node-b", true, false)},
+			want:    "node-b",
+		},
+		{
+			name:    "attached to two nodes",
+			objects: []runtime.Object{pvc, pod("job-1", "node-a", corev1.PodRunning), pod("job-2", "node-b", corev1.PodPending), node("node-a", true, false), node("node-b", true, false)},
+			wantErr: "more than one node",
+		},
+		{
+			name:    "cordoned node",
+			objects: []runtime.Object{pvc, attachment("va-1", "node-a"), node("node-a", true, true)},
+			wantErr: "cordoned",
+		},
+		{
+			name:    "node not ready",
+			objects: []runtime.Object{pvc, attachment("va-1", "node-a"), node("node-a", false, false)},
+			wantErr: "not ready",
+		},
+		{
+			name:    "node gone",
+			objects: []runtime.Object{pvc, attachment("va-1", "node-a")},
+			wantErr: "no longer exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewExecutor(fake.NewSimpleClientset(tt.objects...), zap.NewNop().Sugar())
+
+			got, err := executor.AttachmentNode(context.Background(), "hcp", "etcd-snapshots")
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAttachConflict(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "etcd-snapshot-save-snap-1", Namespace: "hcp"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-snapshot-save-snap-1-abcde",
+			Namespace: "hcp",
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "attach", Namespace: "hcp"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod.Name, Namespace: "hcp"},
+		Reason:         "FailedAttachVolume",
+		Message:        `Multi-Attach error for volume "pv-1" Volume is already used by pod(s) etcd-snapshot-drill-snap-0-fghij`,
+	}
+
+	executor := NewExecutor(fake.NewSimpleClientset(pod), zap.NewNop().Sugar())
+	_, ok := executor.attachConflict(context.Background(), job)
+	assert.False(t, ok)
+
+	executor = NewExecutor(fake.NewSimpleClientset(pod, event), zap.NewNop().Sugar())
+	reason, ok := executor.attachConflict(context.Background(), job)
+	assert.True(t, ok)
+	assert.Contains(t, reason, "Multi-Attach error")
+}
@@ -2,7 +2,10 @@ package job
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -139,6 +142,25 @@ func (e *Executor) waitForJobCompletion(ctx context.Context, job *batchv1.Job) (
 				}, fmt.Errorf("%s", msg)
 			}
 
+			// A pod whose volumes are attached elsewhere never starts; fail fast instead of
+			// waiting for the deadline, and remove the job so it cannot run later
+			if reason, ok := e.attachConflict(ctx, updatedJob); ok {
+				e.logger.Errorw("Job cannot attach its volumes",
+					"job_name", job.Name,
+					"snapshot_id", snapshotID,
+					"reason", reason,
+				)
+				if err := e.DeleteJob(ctx, job); err != nil {
+					e.logger.Warnw("Failed to delete job", "job_name", job.Name, "error", err)
+				}
+				msg := fmt.Sprintf("job pod cannot attach its volumes: %s", reason)
+				return &JobResult{
+					Success:      false,
+					SnapshotID:   snapshotID,
+					ErrorMessage: msg,
+				}, fmt.Errorf("%s", msg)
+			}
+
 			// Still running
 			e.logger.Debugw("Job still running",
 				"job_name", job.Name,
@@ -148,6 +170,17 @@ func (e *Executor) waitForJobCompletion(ctx context.Context, job *batchv1.Job) (
 	}
 }
 
+// DeleteJob removes a job and its pods, so a job of the same name can be created again.
+// A job that is already gone is not an error.
+func (e *Executor) DeleteJob(ctx context.Context, job *batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+	err := e.k8sClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
 // getJobLogs retrieves logs from the job's pod for debugging
 func (e *Executor) getJobLogs(ctx context.Context, job *batchv1.Job) (string, error) {
 	pods, err := e.k8sClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
@@ -173,3 +206,48 @@ func (e *Executor) getJobLogs(ctx context.Context, job *batchv1.Job) (string, er
 	n, _ := logs.Read(buf)
 	return string(buf[:n]), nil
 }
+
+// JobOutput returns the last lines written by the job's pod.
+// Jobs that report a result print it as their final line of output.
+func (e *Executor) JobOutput(ctx context.Context, job *batchv1.Job, tailLines int64) (string, error) {
+	pods, err := e.k8sClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list job pods: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pods found for job %s", job.Name)
+	}
+
+	logReq := e.k8sClient.CoreV1().Pods(job.Namespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{
+		TailLines: &tailLines,
+	})
+	logs, err := logReq.Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to stream job logs: %w", err)
+	}
+	defer logs.Close()
+
+	output, err := io.ReadAll(logs)
+	if err != nil {
+		return "", fmt.Errorf("failed to read job logs: %w", err)
+	}
+	return string(output), nil
+}
+
+// DecodeResult finds the JSON result a job printed as part of its output and decodes it into v.
+// Log lines interleaved with the result are skipped; the last line matching v's fields wins.
+func DecodeResult(output string, v interface{}) error {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		decoder := json.NewDecoder(strings.NewReader(lines[i]))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(v); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no job result found in job output")
+}
@@ -0,0 +1,26 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeResult(t *testing.T) {
+	type result struct {
+		Passed    bool  `json:"passed"`
+		TotalKeys int64 `json:"total_keys"`
+	}
+
+	output := `{"level":"info","ts":1700000000,"msg":"Restore drill finished"}
+{"passed":true,"total_keys":42}
+`
+	var got result
+	require.NoError(t, DecodeResult(output, &got))
+	assert.True(t, got.Passed)
+	assert.Equal(t, int64(42), got.TotalKeys)
+
+	assert.Error(t, DecodeResult(`{"level":"info","msg":"no result"}`, &got))
+	assert.Error(t, DecodeResult("", &got))
+}
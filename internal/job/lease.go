@@ -0,0 +1,172 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// pvcLeaseDuration is how long a PVC Lease stays current without being renewed, so a
+// holder that dies blocks other jobs for no longer than this
+const pvcLeaseDuration = 30 * time.Second
+
+// pvcLeaseRetryInterval is how often a PVC Lease held by someone else is retried;
+// shortened in tests
+var pvcLeaseRetryInterval = 2 * time.Second
+
+// LockPVC takes a Lease on a ReadWriteOnce PVC so that jobs mounting it run one at a
+// time, whether they are started by the driver, by an admin command or by a restore. It
+// waits while another holder's Lease is current and takes over Leases that have lapsed.
+// The Lease is renewed until the returned function releases it.
+func (e *Executor) LockPVC(ctx context.Context, namespace, pvcName string) (func(), error) {
+	name := pvcLeaseName(pvcName)
+	identity := leaseIdentity()
+
+	err := wait.PollUntilContextCancel(ctx, pvcLeaseRetryInterval, true, func(ctx context.Context) (bool, error) {
+		return e.acquireLease(ctx, namespace, name, identity)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock PVC %s/%s: %w", namespace, pvcName, err)
+	}
+	e.logger.Debugw("Locked PVC",
+		"pvc_name", pvcName,
+		"namespace", namespace,
+		"holder", identity,
+	)
+
+	renewCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.renewLease(renewCtx, namespace, name, identity)
+	}()
+
+	return func() {
+		stop()
+		<-done
+		e.releaseLease(namespace, name, identity)
+	}, nil
+}
+
+// pvcLeaseName returns the name of the Lease guarding a PVC
+func pvcLeaseName(pvcName string) string {
+	return "pvc-" + pvcName
+}
+
+// leaseIdentity returns a holder identity unique to one LockPVC call
+func leaseIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
+
+// acquireLease creates the Lease, or takes it over when its holder let it lapse.
+// Losing a race to another caller is not an error; the caller retries.
+func (e *Executor) acquireLease(ctx context.Context, namespace, name, identity string) (bool, error) {
+	leases := e.k8sClient.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(pvcLeaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if leaseCurrent(lease, now.Time) {
+		return false, nil
+	}
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renewLease keeps the Lease current until ctx is cancelled
+func (e *Executor) renewLease(ctx context.Context, namespace, name, identity string) {
+	leases := e.k8sClient.CoordinationV1().Leases(namespace)
+
+	ticker := time.NewTicker(pvcLeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err == nil && !heldBy(lease, identity) {
+			e.logger.Warnw("Lost PVC lease", "lease", name, "namespace", namespace)
+			return
+		}
+		if err == nil {
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		}
+		if err != nil && ctx.Err() == nil {
+			e.logger.Warnw("Failed to renew PVC lease", "lease", name, "namespace", namespace, "error", err)
+		}
+	}
+}
+
+// releaseLease deletes the Lease if it is still held by identity
+func (e *Executor) releaseLease(namespace, name, identity string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	leases := e.k8sClient.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil || !heldBy(lease, identity) {
+		return
+	}
+	err = leases.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		// The Lease lapses on its own once it is no longer renewed
+		e.logger.Warnw("Failed to release PVC lease", "lease", name, "namespace", namespace, "error", err)
+	}
+}
+
+// leaseCurrent reports whether a Lease has a holder that renewed it recently enough
+func leaseCurrent(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return now.Before(spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+// heldBy reports whether identity holds a Lease
+func heldBy(lease *coordinationv1.Lease, identity string) bool {
+	return lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == identity
+}
@@ -0,0 +1,65 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLockPVC(t *testing.T) {
+	pvcLeaseRetryInterval = 10 * time.Millisecond
+	client := fake.NewSimpleClientset()
+	executor := NewExecutor(client, zap.NewNop().Sugar())
+	ctx := context.Background()
+
+	release, err := executor.LockPVC(ctx, "hcp", "snapshots")
+	require.NoError(t, err)
+
+	// Another holder, in this process or any other, waits for the Lease
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = executor.LockPVC(waitCtx, "hcp", "snapshots")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Other PVCs are not held back
+	other, err := executor.LockPVC(ctx, "hcp", "other")
+	require.NoError(t, err)
+	other()
+
+	release()
+	_, err = client.CoordinationV1().Leases("hcp").Get(ctx, pvcLeaseName("snapshots"), metav1.GetOptions{})
+	assert.Error(t, err, "released Lease should be deleted")
+
+	release, err = executor.LockPVC(ctx, "hcp", "snapshots")
+	require.NoError(t, err)
+	release()
+}
+
+func TestLockPVCTakesOverLapsedLease(t *testing.T) {
+	pvcLeaseRetryInterval = 10 * time.Millisecond
+	holder := "crashed-cli"
+	duration := int32(30)
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: pvcLeaseName("snapshots"), Namespace: "hcp"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renewed,
+		},
+	}
+	executor := NewExecutor(fake.NewSimpleClientset(lease), zap.NewNop().Sugar())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, err := executor.LockPVC(ctx, "hcp", "snapshots")
+	require.NoError(t, err)
+	release()
+}
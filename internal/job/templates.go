@@ -1,15 +1,33 @@
 package job
 
 import (
+	"encoding/base64"
 	"fmt"
+	"path"
+	"strings"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// ExecutorServiceAccount is the ServiceAccount every snapshot job runs as
+	ExecutorServiceAccount = "etcd-snapshot-executor"
+
+	// Keys of the etcd TLS Secret mounted into snapshot save jobs
+	TLSClientCertKey = "etcd-client.crt"
+	TLSClientKeyKey  = "etcd-client.key"
+	TLSCAKey         = "etcd-client-ca.crt"
+)
+
 type JobConfig struct {
+	// DriverName of the instance running the job; used for the app label
+	DriverName            string
 	SnapshotID            string
 	Namespace             string
 	ETCDEndpoints         []string
@@ -27,39 +45,83 @@ type JobConfig struct {
 	ClientKeyPath  string
 	CAPath         string
 
+	// Encoding of the stored snapshot file
+	Format snapshot.Format
+	// Encryption is the wrapped data key of an encrypted snapshot, and KeyProvider selects
+	// the key encryption keys the agent unwraps it with, so the plaintext data key never
+	// leaves the job's pod
+	Encryption  *snapshot.EncryptionInfo
+	KeyProvider encryption.ProviderConfig
+	// ObjectStore is where the snapshot file is uploaded to by tier jobs, and read from by
+	// other jobs instead of the snapshot PVC. ChecksumSHA256 is the file's recorded
+	// checksum, which jobs reading the file check it against.
+	ObjectStore    *snapshot.ObjectLocation
+	ChecksumSHA256 string
+	// Signature is the snapshot's signature file, written next to the snapshot file by
+	// sign jobs and next to the copies made by tier and replicate jobs
+	Signature string
+	// ReportChecksum passes raw snapshots through the encode stage of save jobs as well,
+	// so the checksum of every snapshot is reported for its signature
+	ReportChecksum bool
+	// ClusterName, MemberID and Revision describe the snapshot in the manifest of a
+	// bundle. CompanionSecrets name the Secrets, in the job's namespace, packed into the
+	// bundle next to the database.
+	ClusterName      string
+	MemberID         string
+	Revision         int64
+	CompanionSecrets []string
+	// ReplicaTarget and ReplicaLocation name the replication target a replicate job
+	// copies the snapshot file to and where on it the copy is kept
+	ReplicaTarget   string
+	ReplicaLocation *snapshot.ObjectLocation
+
+	// ETCDVersion is the server version of the member the snapshot is saved from; it
+	// picks the etcdctl or etcdutl invocation matching the tooling in ETCDImage
+	ETCDVersion string
+	// DBSize is the size of that member's database, which save jobs of encrypted
+	// snapshots make room for in memory
+	DBSize int64
+
 	// Container Images
 	ETCDImage    string
 	BusyboxImage string
+	AgentImage   string
+
+	// Restore drill checks
+	DrillMinKeys          int64
+	DrillExpectedPrefixes []string
+
+	// Member restore
+	MemberOrdinal       int
+	MemberName          string
+	MemberPVCName       string
+	MemberPeerURLs      []string
+	InitialCluster      string
+	InitialClusterToken string
+	// DataDir is the etcd data dir relative to the root of the member PVC
+	DataDir string
+	// RestoreID identifies the restore a member job is part of, so a repeated job can
+	// tell the backup it made from one an earlier restore left behind
+	RestoreID string
+	// PodSecurityContext lets member restore jobs run as the etcd pods do, so the
+	// restored data dir has the owner and permissions etcd expects
+	PodSecurityContext *corev1.PodSecurityContext
+	// NodeName pins jobs to the node that has their ReadWriteOnce volume attached:
+	// the member's volume while its pod is still running, or the snapshot PVC
+	NodeName string
 }
 
 // GenerateSnapshotSaveJob creates a Kubernetes Job for snapshot save operation
 // GenerateSnapshotSaveJob creates a Kubernetes Job for snapshot save operation
-// buildSnapshotCommand creates a shell command for snapshot save with TLS and metadata output
-func buildSnapshotCommand(cfg *JobConfig) string {
-	// Build the etcdutl command with TLS flags if needed
-	cmdParts := []string{
-		"etcdutl",
-		"--endpoints", fmt.Sprintf("%v", cfg.ETCDEndpoints),
-	}
-
-	if cfg.TLSEnabled {
-		cmdParts = append(cmdParts,
-			"--cacert", cfg.CAPath,
-			"--cert", cfg.ClientCertPath,
-			"--key", cfg.ClientKeyPath,
-		)
-	}
-
-	cmdParts = append(cmdParts,
-		"snapshot",
-		"save",
-		fmt.Sprintf("/snapshots/%s.db", cfg.SnapshotID),
-	)
-
-	return fmt.Sprintf("set -e\n%s\netcdutl snapshot status /snapshots/%s.db -w json\n",
-		fmt.Sprintf("etcdutl --endpoints '%v'", cfg.ETCDEndpoints) + conditionalTLSFlags(cfg) +
-			fmt.Sprintf(" snapshot save /snapshots/%s.db", cfg.SnapshotID),
-		cfg.SnapshotID,
+// buildSnapshotCommand creates a shell command for snapshot save with TLS and metadata output.
+// Every supported version saves with etcdctl, which streams the snapshot from a member;
+// the status of the saved file is read with the tool of the member's version.
+func buildSnapshotCommand(cfg *JobConfig, savePath string) string {
+	return fmt.Sprintf("set -e\n%s\n%s snapshot status %s -w json\n",
+		fmt.Sprintf("etcdctl --endpoints '%s'", strings.Join(cfg.ETCDEndpoints, ","))+conditionalTLSFlags(cfg)+
+			fmt.Sprintf(" snapshot save %s", savePath),
+		snapshotStatusTool(cfg.ETCDVersion),
+		savePath,
 	)
 }
 
@@ -79,11 +141,19 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 	jobName := fmt.Sprintf("etcd-snapshot-save-%s", cfg.SnapshotID)
 	ttlSecondsAfterFinished := int32(3600) // 1 hour
 
+	// Compressed or encrypted snapshots, and raw ones whose checksum is reported, are saved
+	// to a scratch volume first and encoded onto the snapshot PVC by the agent
+	rawFileName := snapshot.Format{}.FileName(cfg.SnapshotID)
+	savePath := fmt.Sprintf("/snapshots/%s", rawFileName)
+	if !cfg.Format.IsRaw() || cfg.ReportChecksum {
+		savePath = fmt.Sprintf("/work/%s", rawFileName)
+	}
+
 	// Build command with TLS flags and metadata output
 	command := []string{
 		"sh",
 		"-c",
-		buildSnapshotCommand(cfg),
+		buildSnapshotCommand(cfg, savePath),
 	}
 
 	// Build volume mounts
@@ -140,11 +210,11 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 					SecretName: cfg.TLSSecretName,
 					Items: []corev1.KeyToPath{
 						{
-							Key:  "etcd-client.crt",
+							Key:  TLSClientCertKey,
 							Path: "etcd-client.crt",
 						},
 						{
-							Key:  "etcd-client.key",
+							Key:  TLSClientKeyKey,
 							Path: "etcd-client.key",
 						},
 					},
@@ -158,7 +228,7 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 					SecretName: cfg.TLSSecretName,
 					Items: []corev1.KeyToPath{
 						{
-							Key:  "etcd-client-ca.crt",
+							Key:  TLSCAKey,
 							Path: "ca.crt",
 						},
 					},
@@ -178,7 +248,7 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 			Name:      jobName,
 			Namespace: cfg.Namespace,
 			Labels: map[string]string{
-				"app":         "etcd-snapshot-driver",
+				"app":         config.NewNames(cfg.DriverName).AppLabel(),
 				"operation":   "snapshot-save",
 				"snapshot-id": cfg.SnapshotID,
 			},
@@ -190,13 +260,14 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
-						"app":         "etcd-snapshot-driver",
+						"app":         config.NewNames(cfg.DriverName).AppLabel(),
 						"snapshot-id": cfg.SnapshotID,
 					},
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "etcd-snapshot-executor",
+					ServiceAccountName: ExecutorServiceAccount,
 					RestartPolicy:      corev1.RestartPolicyNever,
+					Affinity:           nodeAffinity(cfg.NodeName),
 					SecurityContext: &corev1.PodSecurityContext{
 						RunAsNonRoot: boolPtr(true),
 						RunAsUser:    int64Ptr(65534),
@@ -236,9 +307,162 @@ func GenerateSnapshotSaveJob(cfg *JobConfig) *batchv1.Job {
 		},
 	}
 
+	if !cfg.Format.IsRaw() || cfg.ReportChecksum {
+		addEncodeStage(job, cfg)
+	}
+
 	return job
 }
 
+// addEncodeStage turns the etcd container of a save job into an init container that
+// writes the raw snapshot to a scratch volume, and adds an agent container that
+// compresses and encrypts it, or packs it into a bundle, onto the snapshot PVC and
+// reports the resulting sizes
+func addEncodeStage(job *batchv1.Job, cfg *JobConfig) {
+	podSpec := &job.Spec.Template.Spec
+
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	workMount := corev1.VolumeMount{
+		Name:      "work",
+		MountPath: "/work",
+	}
+
+	saveContainer := podSpec.Containers[0]
+	saveContainer.VolumeMounts = append(saveContainer.VolumeMounts, workMount)
+
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"encode",
+		"--input", fmt.Sprintf("/work/%s", snapshot.Format{}.FileName(cfg.SnapshotID)),
+		"--output", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+		"--codec", string(cfg.Format.Codec),
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "snapshot-pvc",
+			MountPath: "/snapshots",
+		},
+		workMount,
+	}
+	if cfg.Encryption != nil {
+		command, volumeMounts, podSpec.Volumes = withDataKey(cfg, command, volumeMounts, podSpec.Volumes)
+	}
+	// Bundles carry a manifest describing the snapshot, and the companion Secrets
+	// mounted one directory each
+	if cfg.Format.Bundle {
+		command = append(command,
+			"--bundle",
+			"--snapshot-id", cfg.SnapshotID,
+			"--cluster-name", cfg.ClusterName,
+			"--member-id", cfg.MemberID,
+			"--revision", fmt.Sprintf("%d", cfg.Revision),
+		)
+		if len(cfg.CompanionSecrets) > 0 {
+			command = append(command, "--companions-dir", companionsPath)
+		}
+		for i, secretName := range cfg.CompanionSecrets {
+			volumeName := fmt.Sprintf("companion-%d", i)
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      volumeName,
+				MountPath: path.Join(companionsPath, secretName),
+				ReadOnly:  true,
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: volumeName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: secretName,
+					},
+				},
+			})
+		}
+	}
+
+	encodeContainer := corev1.Container{
+		Name:            "encode",
+		Image:           image,
+		Command:         command,
+		SecurityContext: saveContainer.SecurityContext,
+		Resources:       saveContainer.Resources,
+		VolumeMounts:    volumeMounts,
+	}
+
+	// The raw database of an encrypted snapshot is staged in memory rather than on the
+	// node's disk. It counts against the save container's memory, which grows by the size
+	// of the database, with room for it to grow while it is saved.
+	work := &corev1.EmptyDirVolumeSource{}
+	if cfg.Format.Encrypted {
+		work.Medium = corev1.StorageMediumMemory
+		if cfg.DBSize > 0 {
+			stage := resource.NewQuantity(cfg.DBSize+cfg.DBSize/4, resource.BinarySI)
+			work.SizeLimit = stage
+
+			limits := corev1.ResourceList{}
+			for name, quantity := range saveContainer.Resources.Limits {
+				limits[name] = quantity.DeepCopy()
+			}
+			memory := limits[corev1.ResourceMemory]
+			memory.Add(*stage)
+			limits[corev1.ResourceMemory] = memory
+			saveContainer.Resources.Limits = limits
+		}
+	}
+
+	podSpec.InitContainers = []corev1.Container{saveContainer}
+	podSpec.Containers = []corev1.Container{encodeContainer}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "work",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: work,
+		},
+	})
+}
+
+// companionsPath is where the companion Secrets of a bundle are mounted
+const companionsPath = "/etc/etcd-snapshot/companions"
+
+// withDataKey hands the agent the wrapped data key of an encrypted snapshot and the key
+// provider to unwrap it with. The file provider stands in for a KMS on the node, so its
+// key dir is mounted from the node at the path the driver reads it from.
+func withDataKey(cfg *JobConfig, command []string, volumeMounts []corev1.VolumeMount, volumes []corev1.Volume) ([]string, []corev1.VolumeMount, []corev1.Volume) {
+	command = append(command,
+		"--key-id", cfg.Encryption.KeyID,
+		"--wrapped-data-key", base64.StdEncoding.EncodeToString(cfg.Encryption.WrappedDataKey),
+		"--key-provider", cfg.KeyProvider.Provider,
+	)
+
+	switch cfg.KeyProvider.Provider {
+	case "secret":
+		command = append(command,
+			"--key-secret-namespace", cfg.KeyProvider.SecretNamespace,
+			"--key-secret-name", cfg.KeyProvider.SecretName,
+		)
+	case "file":
+		command = append(command, "--key-dir", cfg.KeyProvider.Dir)
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "encryption-keys",
+			MountPath: cfg.KeyProvider.Dir,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "encryption-keys",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: cfg.KeyProvider.Dir,
+					Type: hostPathType(corev1.HostPathDirectory),
+				},
+			},
+		})
+	}
+
+	return command, volumeMounts, volumes
+}
+
 // GenerateSnapshotDeleteJob creates a Kubernetes Job for snapshot deletion
 // GenerateSnapshotDeleteJob creates a Kubernetes Job for snapshot deletion
 func GenerateSnapshotDeleteJob(cfg *JobConfig) *batchv1.Job {
@@ -256,7 +480,7 @@ func GenerateSnapshotDeleteJob(cfg *JobConfig) *batchv1.Job {
 			Name:      jobName,
 			Namespace: cfg.Namespace,
 			Labels: map[string]string{
-				"app":         "etcd-snapshot-driver",
+				"app":         config.NewNames(cfg.DriverName).AppLabel(),
 				"operation":   "snapshot-delete",
 				"snapshot-id": cfg.SnapshotID,
 			},
@@ -268,13 +492,14 @@ func GenerateSnapshotDeleteJob(cfg *JobConfig) *batchv1.Job {
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
-						"app":         "etcd-snapshot-driver",
+						"app":         config.NewNames(cfg.DriverName).AppLabel(),
 						"snapshot-id": cfg.SnapshotID,
 					},
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "etcd-snapshot-executor",
+					ServiceAccountName: ExecutorServiceAccount,
 					RestartPolicy:      corev1.RestartPolicyNever,
+					Affinity:           nodeAffinity(cfg.NodeName),
 					SecurityContext: &corev1.PodSecurityContext{
 						RunAsNonRoot: boolPtr(true),
 						RunAsUser:    int64Ptr(65534),
@@ -285,9 +510,12 @@ func GenerateSnapshotDeleteJob(cfg *JobConfig) *batchv1.Job {
 					},
 					Containers: []corev1.Container{
 						{
-							Name:    "rm",
-							Image:   image,
-							Command: []string{"rm", "-f", fmt.Sprintf("/snapshots/%s.db", cfg.SnapshotID)},
+							Name:  "rm",
+							Image: image,
+							Command: []string{"rm", "-f",
+								fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+								fmt.Sprintf("/snapshots/%s", cfg.Format.SignatureFileName(cfg.SnapshotID)),
+							},
 							SecurityContext: &corev1.SecurityContext{
 								AllowPrivilegeEscalation: boolPtr(false),
 								Capabilities: &corev1.Capabilities{
@@ -342,11 +570,217 @@ func GenerateSnapshotDeleteJob(cfg *JobConfig) *batchv1.Job {
 	return job
 }
 
+// statScript prints the size and name of each file argument that exists on the snapshot PVC
+const statScript = `cd /snapshots && for f in "$@"; do if [ -f "$f" ]; then stat -c '%s %n' "$f"; fi; done`
+
+// GenerateSnapshotStatJob creates a Kubernetes Job that measures files on the snapshot PVC,
+// printing the size and name of each one that exists on a line of its own
+func GenerateSnapshotStatJob(cfg *JobConfig, fileNames []string) *batchv1.Job {
+	job := GenerateSnapshotDeleteJob(cfg)
+	job.Name = fmt.Sprintf("etcd-snapshot-stat-%s", cfg.SnapshotID)
+	job.Labels["operation"] = "snapshot-stat"
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].Name = "stat"
+	podSpec.Containers[0].Command = append([]string{"sh", "-c", statScript, "stat"}, fileNames...)
+	podSpec.Containers[0].VolumeMounts[0].ReadOnly = true
+	podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly = true
+	return job
+}
+
+// signatureScript writes the signature in its first argument to the file named by its
+// second, replacing the file only once it is written in full
+const signatureScript = `printf '%s' "$1" > "/snapshots/$2.part" && mv "/snapshots/$2.part" "/snapshots/$2"`
+
+// GenerateSnapshotSignatureJob creates a Kubernetes Job that writes a snapshot's signature
+// file next to the snapshot file on the snapshot PVC
+func GenerateSnapshotSignatureJob(cfg *JobConfig) *batchv1.Job {
+	job := GenerateSnapshotDeleteJob(cfg)
+	job.Name = fmt.Sprintf("etcd-snapshot-sign-%s", cfg.SnapshotID)
+	job.Labels["operation"] = "snapshot-sign"
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].Name = "sign"
+	podSpec.Containers[0].Command = []string{"sh", "-c", signatureScript, "sign", cfg.Signature, cfg.Format.SignatureFileName(cfg.SnapshotID)}
+	return job
+}
+
+// GenerateBundleCompanionsJob creates a Kubernetes Job that checks a snapshot bundle and
+// prints its companion Secrets, still encrypted, for the admin CLI to decrypt
+func GenerateBundleCompanionsJob(cfg *JobConfig) *batchv1.Job {
+	job := GenerateSnapshotDeleteJob(cfg)
+	job.Name = fmt.Sprintf("etcd-snapshot-companions-%s", cfg.SnapshotID)
+	job.Labels["operation"] = "bundle-companions"
+
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"bundle-companions",
+		"--bundle", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+	}
+	if cfg.ChecksumSHA256 != "" {
+		command = append(command, "--checksum", cfg.ChecksumSHA256)
+	}
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].Name = "companions"
+	podSpec.Containers[0].Image = image
+	podSpec.Containers[0].Command = command
+	podSpec.Containers[0].VolumeMounts[0].ReadOnly = true
+	podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly = true
+
+	addFetchStage(job, cfg)
+	return job
+}
+
+// GenerateRestoreDrillJob creates a Kubernetes Job that restores a snapshot into a
+// scratch data dir, boots a throwaway single-member etcd and checks the restored keyspace
+func GenerateRestoreDrillJob(cfg *JobConfig) *batchv1.Job {
+	jobName := fmt.Sprintf("etcd-snapshot-drill-%s", cfg.SnapshotID)
+	ttlSecondsAfterFinished := int32(3600)
+
+	// Determine image to use
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"drill",
+		"--snapshot", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+		"--codec", string(cfg.Format.Codec),
+		"--scratch-dir", "/tmp",
+		"--min-keys", fmt.Sprintf("%d", cfg.DrillMinKeys),
+	}
+	for _, prefix := range cfg.DrillExpectedPrefixes {
+		command = append(command, "--expect-prefix", prefix)
+	}
+	if cfg.ChecksumSHA256 != "" {
+		command = append(command, "--checksum", cfg.ChecksumSHA256)
+	}
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "snapshot-pvc",
+			MountPath: "/snapshots",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "tmp",
+			MountPath: "/tmp",
+		},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "snapshot-pvc",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: cfg.SnapshotPVCName,
+					ReadOnly:  true,
+				},
+			},
+		},
+		{
+			Name: "tmp",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+
+	// Encrypted snapshots are decrypted with their data key, which the agent unwraps
+	if cfg.Encryption != nil {
+		command, volumeMounts, volumes = withDataKey(cfg, command, volumeMounts, volumes)
+	}
+	// Bundles are unpacked, checking every entry against the bundle's manifest
+	if cfg.Format.Bundle {
+		command = append(command, "--bundle")
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: cfg.Namespace,
+			Labels: map[string]string{
+				"app":         config.NewNames(cfg.DriverName).AppLabel(),
+				"operation":   "restore-drill",
+				"snapshot-id": cfg.SnapshotID,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
+			BackoffLimit:            &cfg.BackoffLimit,
+			ActiveDeadlineSeconds:   &cfg.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":         config.NewNames(cfg.DriverName).AppLabel(),
+						"snapshot-id": cfg.SnapshotID,
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: ExecutorServiceAccount,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					Affinity:           nodeAffinity(cfg.NodeName),
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot: boolPtr(true),
+						RunAsUser:    int64Ptr(65534),
+						FSGroup:      int64Ptr(65534),
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    "restore-drill",
+							Image:   image,
+							Command: command,
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: boolPtr(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+								ReadOnlyRootFilesystem: boolPtr(true),
+							},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceMemory: mustParseQuantity("256Mi"),
+									corev1.ResourceCPU:    mustParseQuantity("100m"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceMemory: mustParseQuantity("1Gi"),
+									corev1.ResourceCPU:    mustParseQuantity("1"),
+								},
+							},
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+
+	addFetchStage(job, cfg)
+	return job
+}
+
 // Helper functions
 func boolPtr(b bool) *bool {
 	return &b
 }
 
+func hostPathType(t corev1.HostPathType) *corev1.HostPathType {
+	return &t
+}
+
 func int64Ptr(i int64) *int64 {
 	return &i
 }
@@ -358,3 +792,249 @@ func mustParseQuantity(s string) resource.Quantity {
 	}
 	return quantity
 }
+
+const memberPVCMountPath = "/var/lib/etcd-member"
+
+// MemberBackupDir is where a member restore keeps the previous data dir, relative to the member PVC
+func MemberBackupDir(dataDir, snapshotID string) string {
+	return fmt.Sprintf("%s.pre-restore-%s", dataDir, snapshotID)
+}
+
+// GenerateMemberRestoreJob creates a Kubernetes Job that restores a snapshot into one
+// member's PVC, keeping the member's previous data dir for rollback
+func GenerateMemberRestoreJob(cfg *JobConfig) *batchv1.Job {
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"restore-member",
+		"--snapshot", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+		"--codec", string(cfg.Format.Codec),
+		"--scratch-dir", "/tmp",
+		"--name", cfg.MemberName,
+		"--initial-cluster", cfg.InitialCluster,
+		"--initial-advertise-peer-urls", strings.Join(cfg.MemberPeerURLs, ","),
+		"--initial-cluster-token", cfg.InitialClusterToken,
+		"--data-dir", path.Join(memberPVCMountPath, cfg.DataDir),
+		"--backup-dir", path.Join(memberPVCMountPath, MemberBackupDir(cfg.DataDir, cfg.SnapshotID)),
+		"--restore-id", cfg.RestoreID,
+	}
+
+	command, volumeMounts, volumes := withSnapshotSource(cfg, command)
+
+	job := memberJob(cfg, fmt.Sprintf("etcd-snapshot-restore-%d-%s", cfg.MemberOrdinal, cfg.SnapshotID), "restore", command, volumeMounts, volumes)
+	addFetchStage(job, cfg)
+	return job
+}
+
+// GenerateMemberSeedJob creates a Kubernetes Job that seeds a replacement member's
+// data dir with the snapshot's backend, keeping the old data dir for inspection
+func GenerateMemberSeedJob(cfg *JobConfig) *batchv1.Job {
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"seed-member",
+		"--snapshot", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+		"--codec", string(cfg.Format.Codec),
+		"--scratch-dir", "/tmp",
+		"--data-dir", path.Join(memberPVCMountPath, cfg.DataDir),
+		"--backup-dir", path.Join(memberPVCMountPath, MemberBackupDir(cfg.DataDir, cfg.SnapshotID)),
+		"--restore-id", cfg.RestoreID,
+	}
+	command, volumeMounts, volumes := withSnapshotSource(cfg, command)
+
+	job := memberJob(cfg, fmt.Sprintf("etcd-snapshot-seed-%d-%s", cfg.MemberOrdinal, cfg.SnapshotID), "seed", command, volumeMounts, volumes)
+	addFetchStage(job, cfg)
+	return job
+}
+
+// withSnapshotSource mounts the snapshot PVC read-only next to the member PVC and
+// a scratch dir, plus the data key of an encrypted snapshot. Snapshots in the object
+// store tier are fetched into the snapshot volume by addFetchStage instead.
+func withSnapshotSource(cfg *JobConfig, command []string) ([]string, []corev1.VolumeMount, []corev1.Volume) {
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "snapshot-pvc",
+			MountPath: "/snapshots",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "member-pvc",
+			MountPath: memberPVCMountPath,
+		},
+		{
+			Name:      "tmp",
+			MountPath: "/tmp",
+		},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "snapshot-pvc",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: cfg.SnapshotPVCName,
+					ReadOnly:  true,
+				},
+			},
+		},
+		memberPVCVolume(cfg),
+		{
+			Name: "tmp",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+
+	// Encrypted snapshots are decrypted with their data key, which the agent unwraps
+	if cfg.Encryption != nil {
+		command, volumeMounts, volumes = withDataKey(cfg, command, volumeMounts, volumes)
+	}
+	// Bundles are unpacked, checking every entry against the bundle's manifest
+	if cfg.Format.Bundle {
+		command = append(command, "--bundle")
+	}
+	// The file is checked against its recorded checksum before it is used
+	if cfg.ChecksumSHA256 != "" {
+		command = append(command, "--checksum", cfg.ChecksumSHA256)
+	}
+
+	return command, volumeMounts, volumes
+}
+
+// GenerateMemberRollbackJob creates a Kubernetes Job that puts back the data dir a
+// member restore moved aside
+func GenerateMemberRollbackJob(cfg *JobConfig) *batchv1.Job {
+	command := []string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"rollback-member",
+		"--data-dir", path.Join(memberPVCMountPath, cfg.DataDir),
+		"--backup-dir", path.Join(memberPVCMountPath, MemberBackupDir(cfg.DataDir, cfg.SnapshotID)),
+		"--restore-id", cfg.RestoreID,
+	}
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "member-pvc",
+			MountPath: memberPVCMountPath,
+		},
+	}
+	volumes := []corev1.Volume{memberPVCVolume(cfg)}
+
+	return memberJob(cfg, fmt.Sprintf("etcd-snapshot-rollback-%d-%s", cfg.MemberOrdinal, cfg.SnapshotID), "rollback", command, volumeMounts, volumes)
+}
+
+// nodeAffinity requires pods to be scheduled onto the named node
+func nodeAffinity(nodeName string) *corev1.Affinity {
+	if nodeName == "" {
+		return nil
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      corev1.LabelHostname,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{nodeName},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func memberPVCVolume(cfg *JobConfig) corev1.Volume {
+	return corev1.Volume{
+		Name: "member-pvc",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: cfg.MemberPVCName,
+			},
+		},
+	}
+}
+
+// memberJob wraps an agent command that works on a member PVC in a Job
+func memberJob(cfg *JobConfig, jobName, operation string, command []string, volumeMounts []corev1.VolumeMount, volumes []corev1.Volume) *batchv1.Job {
+	ttlSecondsAfterFinished := int32(3600)
+
+	// Determine image to use
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	securityContext := cfg.PodSecurityContext
+	if securityContext == nil {
+		securityContext = &corev1.PodSecurityContext{
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+	}
+
+	labels := map[string]string{
+		"app":         config.NewNames(cfg.DriverName).AppLabel(),
+		"snapshot-id": cfg.SnapshotID,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: cfg.Namespace,
+			Labels: map[string]string{
+				"app":         labels["app"],
+				"operation":   operation,
+				"snapshot-id": cfg.SnapshotID,
+				"member":      cfg.MemberName,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
+			BackoffLimit:            &cfg.BackoffLimit,
+			ActiveDeadlineSeconds:   &cfg.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: ExecutorServiceAccount,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					SecurityContext:    securityContext,
+					Affinity:           nodeAffinity(cfg.NodeName),
+					Containers: []corev1.Container{
+						{
+							Name:    operation,
+							Image:   image,
+							Command: command,
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: boolPtr(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+								ReadOnlyRootFilesystem: boolPtr(true),
+							},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceMemory: mustParseQuantity("256Mi"),
+									corev1.ResourceCPU:    mustParseQuantity("100m"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceMemory: mustParseQuantity("1Gi"),
+									corev1.ResourceCPU:    mustParseQuantity("1"),
+								},
+							},
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
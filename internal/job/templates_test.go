@@ -0,0 +1,393 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGenerateSnapshotSaveJobUncompressed(t *testing.T) {
+	job := GenerateSnapshotSaveJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		ETCDEndpoints:   []string{"https://etcd-0:2379"},
+		SnapshotPVCName: "etcd-snapshots",
+	})
+
+	podSpec := job.Spec.Template.Spec
+	assert.Empty(t, podSpec.InitContainers)
+	require.Len(t, podSpec.Containers, 1)
+	assert.Contains(t, podSpec.Containers[0].Command[2], "snapshot save /snapshots/snap-1.db")
+}
+
+func TestGenerateSnapshotSaveJobCompressed(t *testing.T) {
+	job := GenerateSnapshotSaveJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		ETCDEndpoints:   []string{"https://etcd-0:2379"},
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecZstd, Encrypted: true},
+		Encryption:      &snapshot.EncryptionInfo{KeyID: "key-1", WrappedDataKey: []byte("wrapped")},
+		KeyProvider:     encryption.ProviderConfig{Provider: "secret", SecretNamespace: "etcd-snapshot-driver", SecretName: "keys"},
+		DBSize:          1 << 30,
+		AgentImage:      "driver:test",
+	})
+
+	podSpec := job.Spec.Template.Spec
+	require.Len(t, podSpec.InitContainers, 1)
+	assert.Contains(t, podSpec.InitContainers[0].Command[2], "snapshot save /work/snap-1.db")
+
+	require.Len(t, podSpec.Containers, 1)
+	encode := podSpec.Containers[0]
+	assert.Equal(t, "driver:test", encode.Image)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "encode",
+		"--input", "/work/snap-1.db",
+		"--output", "/snapshots/snap-1.db.zst.enc",
+		"--codec", "zstd",
+		"--key-id", "key-1",
+		"--wrapped-data-key", "d3JhcHBlZA==",
+		"--key-provider", "secret",
+		"--key-secret-namespace", "etcd-snapshot-driver",
+		"--key-secret-name", "keys",
+	}, encode.Command)
+
+	// The raw database is staged in memory, never on the node's disk, and the save
+	// container has room for it
+	var work *corev1.EmptyDirVolumeSource
+	for _, v := range podSpec.Volumes {
+		assert.Nil(t, v.Secret)
+		if v.Name == "work" {
+			work = v.EmptyDir
+		}
+	}
+	require.NotNil(t, work)
+	assert.Equal(t, corev1.StorageMediumMemory, work.Medium)
+	assert.Equal(t, "1280Mi", work.SizeLimit.String())
+	assert.Equal(t, "1792Mi", podSpec.InitContainers[0].Resources.Limits.Memory().String())
+}
+
+func TestGenerateSnapshotSaveJobFileKeyProvider(t *testing.T) {
+	job := GenerateSnapshotSaveJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		ETCDEndpoints:   []string{"https://etcd-0:2379"},
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Encrypted: true},
+		Encryption:      &snapshot.EncryptionInfo{KeyID: "key-1", WrappedDataKey: []byte("wrapped")},
+		KeyProvider:     encryption.ProviderConfig{Provider: "file", Dir: "/etc/etcd-snapshot/keys"},
+	})
+
+	podSpec := job.Spec.Template.Spec
+	encode := podSpec.Containers[0]
+	assert.Equal(t, []string{"--key-provider", "file", "--key-dir", "/etc/etcd-snapshot/keys"}, encode.Command[len(encode.Command)-4:])
+
+	// The key dir is read from the node, as the driver reads it
+	var keys *corev1.HostPathVolumeSource
+	for _, v := range podSpec.Volumes {
+		if v.Name == "encryption-keys" {
+			keys = v.HostPath
+		}
+	}
+	require.NotNil(t, keys)
+	assert.Equal(t, "/etc/etcd-snapshot/keys", keys.Path)
+
+	// Without a known database size the stage is still kept off the node's disk
+	for _, v := range podSpec.Volumes {
+		if v.Name == "work" {
+			assert.Equal(t, corev1.StorageMediumMemory, v.EmptyDir.Medium)
+		}
+	}
+}
+
+func TestGenerateSnapshotSaveJobReportsChecksum(t *testing.T) {
+	job := GenerateSnapshotSaveJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		ETCDEndpoints:   []string{"https://etcd-0:2379"},
+		SnapshotPVCName: "etcd-snapshots",
+		ReportChecksum:  true,
+	})
+
+	// Raw snapshots to be signed pass through the encode stage unchanged, which reports their checksum
+	podSpec := job.Spec.Template.Spec
+	require.Len(t, podSpec.InitContainers, 1)
+	assert.Contains(t, podSpec.InitContainers[0].Command[2], "snapshot save /work/snap-1.db")
+	require.Len(t, podSpec.Containers, 1)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "encode",
+		"--input", "/work/snap-1.db",
+		"--output", "/snapshots/snap-1.db",
+		"--codec", "",
+	}, podSpec.Containers[0].Command)
+}
+
+func TestGenerateSnapshotSaveJobBundle(t *testing.T) {
+	job := GenerateSnapshotSaveJob(&JobConfig{
+		SnapshotID:       "snap-1",
+		Namespace:        "etcd",
+		ETCDEndpoints:    []string{"https://etcd-0:2379"},
+		SnapshotPVCName:  "etcd-snapshots",
+		Format:           snapshot.Format{Codec: snapshot.CodecZstd, Bundle: true},
+		ClusterName:      "hcp-etcd",
+		MemberID:         "8e9e05c52164694d",
+		Revision:         42,
+		CompanionSecrets: []string{"encryption-config", "etcd-ca"},
+	})
+
+	podSpec := job.Spec.Template.Spec
+	require.Len(t, podSpec.Containers, 1)
+	encode := podSpec.Containers[0]
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "encode",
+		"--input", "/work/snap-1.db",
+		"--output", "/snapshots/snap-1.bundle.tar",
+		"--codec", "zstd",
+		"--bundle",
+		"--snapshot-id", "snap-1",
+		"--cluster-name", "hcp-etcd",
+		"--member-id", "8e9e05c52164694d",
+		"--revision", "42",
+		"--companions-dir", "/etc/etcd-snapshot/companions",
+	}, encode.Command)
+
+	// Each companion Secret is mounted into a directory of its own
+	mounts := make(map[string]string)
+	for _, mount := range encode.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	secrets := make(map[string]string)
+	for _, volume := range podSpec.Volumes {
+		if volume.Secret != nil {
+			secrets[volume.Name] = volume.Secret.SecretName
+		}
+	}
+	assert.Equal(t, "/etc/etcd-snapshot/companions/encryption-config", mounts["companion-0"])
+	assert.Equal(t, "encryption-config", secrets["companion-0"])
+	assert.Equal(t, "/etc/etcd-snapshot/companions/etcd-ca", mounts["companion-1"])
+	assert.Equal(t, "etcd-ca", secrets["companion-1"])
+
+	// Jobs reading the bundle unpack it
+	drill := GenerateRestoreDrillJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecZstd, Bundle: true},
+	})
+	command := drill.Spec.Template.Spec.Containers[0].Command
+	assert.Contains(t, command, "/snapshots/snap-1.bundle.tar")
+	assert.Contains(t, command, "--bundle")
+}
+
+func TestGenerateSnapshotDeleteJobCompressed(t *testing.T) {
+	job := GenerateSnapshotDeleteJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecGzip},
+	})
+
+	assert.Equal(t, []string{"rm", "-f", "/snapshots/snap-1.db.gz", "/snapshots/snap-1.db.gz.sig"}, job.Spec.Template.Spec.Containers[0].Command)
+}
+
+func TestGenerateSnapshotSignatureJob(t *testing.T) {
+	job := GenerateSnapshotSignatureJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Bundle: true},
+		Signature:       `{"algorithm":"ed25519"}`,
+		NodeName:        "node-a",
+	})
+
+	assert.Equal(t, "etcd-snapshot-sign-snap-1", job.Name)
+	assert.Equal(t, "snapshot-sign", job.Labels["operation"])
+	podSpec := job.Spec.Template.Spec
+	assert.Equal(t, []string{`{"algorithm":"ed25519"}`, "snap-1.bundle.tar.sig"}, podSpec.Containers[0].Command[4:])
+	assert.False(t, podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+	assert.NotNil(t, podSpec.Affinity)
+}
+
+func TestGenerateBundleCompanionsJob(t *testing.T) {
+	job := GenerateBundleCompanionsJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecZstd, Encrypted: true, Bundle: true},
+		ChecksumSHA256:  "abc123",
+		NodeName:        "node-a",
+	})
+
+	assert.Equal(t, "etcd-snapshot-companions-snap-1", job.Name)
+	assert.Equal(t, "bundle-companions", job.Labels["operation"])
+	podSpec := job.Spec.Template.Spec
+	assert.Equal(t, "etcd-snapshot-driver:latest", podSpec.Containers[0].Image)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "bundle-companions",
+		"--bundle", "/snapshots/snap-1.bundle.tar",
+		"--checksum", "abc123",
+	}, podSpec.Containers[0].Command)
+	assert.True(t, podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+	assert.NotNil(t, podSpec.Affinity)
+
+	// The data key never reaches the job; the CLI decrypts the entries itself
+	for _, volume := range podSpec.Volumes {
+		assert.NotEqual(t, "data-key", volume.Name)
+	}
+}
+
+func TestGenerateSnapshotStatJob(t *testing.T) {
+	job := GenerateSnapshotStatJob(&JobConfig{
+		SnapshotID:      "snap-2",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		NodeName:        "node-a",
+	}, []string{"snap-0.db", "snap-1.db.gz"})
+
+	assert.Equal(t, "etcd-snapshot-stat-snap-2", job.Name)
+	assert.Equal(t, "snapshot-stat", job.Labels["operation"])
+	podSpec := job.Spec.Template.Spec
+	assert.Equal(t, []string{"snap-0.db", "snap-1.db.gz"}, podSpec.Containers[0].Command[4:])
+	assert.True(t, podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+	assert.NotNil(t, podSpec.Affinity)
+}
+
+func TestGenerateMemberRestoreJob(t *testing.T) {
+	runAsUser := int64(1001)
+	cfg := &JobConfig{
+		SnapshotID:          "snap-1",
+		Namespace:           "etcd",
+		SnapshotPVCName:     "etcd-snapshots",
+		Format:              snapshot.Format{Codec: snapshot.CodecGzip},
+		MemberOrdinal:       1,
+		MemberName:          "etcd-1",
+		MemberPVCName:       "data-etcd-1",
+		MemberPeerURLs:      []string{"https://etcd-1.etcd:2380"},
+		InitialCluster:      "etcd-0=https://etcd-0.etcd:2380,etcd-1=https://etcd-1.etcd:2380",
+		InitialClusterToken: "etcd-snap-1",
+		DataDir:             "data",
+		RestoreID:           "etcd-1700000000",
+		PodSecurityContext:  &corev1.PodSecurityContext{RunAsUser: &runAsUser},
+	}
+
+	restore := GenerateMemberRestoreJob(cfg)
+	assert.Equal(t, "etcd-snapshot-restore-1-snap-1", restore.Name)
+	assert.Equal(t, &runAsUser, restore.Spec.Template.Spec.SecurityContext.RunAsUser)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "restore-member",
+		"--snapshot", "/snapshots/snap-1.db.gz",
+		"--codec", "gzip",
+		"--scratch-dir", "/tmp",
+		"--name", "etcd-1",
+		"--initial-cluster", "etcd-0=https://etcd-0.etcd:2380,etcd-1=https://etcd-1.etcd:2380",
+		"--initial-advertise-peer-urls", "https://etcd-1.etcd:2380",
+		"--initial-cluster-token", "etcd-snap-1",
+		"--data-dir", "/var/lib/etcd-member/data",
+		"--backup-dir", "/var/lib/etcd-member/data.pre-restore-snap-1",
+		"--restore-id", "etcd-1700000000",
+	}, restore.Spec.Template.Spec.Containers[0].Command)
+
+	rollback := GenerateMemberRollbackJob(cfg)
+	assert.Equal(t, "etcd-snapshot-rollback-1-snap-1", rollback.Name)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "rollback-member",
+		"--data-dir", "/var/lib/etcd-member/data",
+		"--backup-dir", "/var/lib/etcd-member/data.pre-restore-snap-1",
+		"--restore-id", "etcd-1700000000",
+	}, rollback.Spec.Template.Spec.Containers[0].Command)
+	require.Len(t, rollback.Spec.Template.Spec.Volumes, 1)
+	assert.Equal(t, "data-etcd-1", rollback.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+}
+
+func TestGenerateMemberSeedJob(t *testing.T) {
+	job := GenerateMemberSeedJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecZstd},
+		ChecksumSHA256:  "abc123",
+		MemberOrdinal:   2,
+		MemberName:      "etcd-2",
+		MemberPVCName:   "data-etcd-2",
+		DataDir:         "data",
+		RestoreID:       "etcd-2-1700000000",
+		NodeName:        "node-a",
+	})
+
+	assert.Equal(t, "etcd-snapshot-seed-2-snap-1", job.Name)
+	assert.Equal(t, "seed", job.Labels["operation"])
+	podSpec := job.Spec.Template.Spec
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "seed-member",
+		"--snapshot", "/snapshots/snap-1.db.zst",
+		"--codec", "zstd",
+		"--scratch-dir", "/tmp",
+		"--data-dir", "/var/lib/etcd-member/data",
+		"--backup-dir", "/var/lib/etcd-member/data.pre-restore-snap-1",
+		"--restore-id", "etcd-2-1700000000",
+		"--checksum", "abc123",
+	}, podSpec.Containers[0].Command)
+	assert.Equal(t, "data-etcd-2", podSpec.Volumes[1].PersistentVolumeClaim.ClaimName)
+
+	require.NotNil(t, podSpec.Affinity)
+	terms := podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	require.Len(t, terms, 1)
+	assert.Equal(t, []string{"node-a"}, terms[0].MatchExpressions[0].Values)
+}
+
+func TestGenerateSnapshotSaveJobTooling(t *testing.T) {
+	tests := []struct {
+		version    string
+		wantStatus string
+	}{
+		{version: "3.4.35", wantStatus: "etcdctl snapshot status /snapshots/snap-1.db -w json"},
+		{version: "3.5.21", wantStatus: "etcdutl snapshot status /snapshots/snap-1.db -w json"},
+		{version: "3.6.8", wantStatus: "etcdutl snapshot status /snapshots/snap-1.db -w json"},
+		{version: "", wantStatus: "etcdutl snapshot status /snapshots/snap-1.db -w json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			job := GenerateSnapshotSaveJob(&JobConfig{
+				SnapshotID:      "snap-1",
+				Namespace:       "etcd",
+				ETCDEndpoints:   []string{"https://etcd-0:2379", "https://etcd-1:2379"},
+				SnapshotPVCName: "etcd-snapshots",
+				ETCDVersion:     tt.version,
+			})
+
+			command := job.Spec.Template.Spec.Containers[0].Command[2]
+			assert.Contains(t, command, "etcdctl --endpoints 'https://etcd-0:2379,https://etcd-1:2379' snapshot save /snapshots/snap-1.db")
+			assert.Contains(t, command, tt.wantStatus)
+		})
+	}
+}
+
+func TestSnapshotPVCJobsPinnedToNode(t *testing.T) {
+	cfg := &JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		ETCDEndpoints:   []string{"https://etcd-0:2379"},
+		SnapshotPVCName: "etcd-snapshots",
+		NodeName:        "node-a",
+	}
+
+	for _, job := range []*batchv1.Job{
+		GenerateSnapshotSaveJob(cfg),
+		GenerateSnapshotDeleteJob(cfg),
+		GenerateRestoreDrillJob(cfg),
+	} {
+		affinity := job.Spec.Template.Spec.Affinity
+		require.NotNil(t, affinity, job.Name)
+		terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		assert.Equal(t, []string{"node-a"}, terms[0].MatchExpressions[0].Values, job.Name)
+	}
+
+	cfg.NodeName = ""
+	assert.Nil(t, GenerateSnapshotSaveJob(cfg).Spec.Template.Spec.Affinity)
+}
@@ -0,0 +1,219 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GenerateSnapshotTierJob creates a Kubernetes Job that copies a snapshot file from the
+// snapshot PVC to the object store, verifies the copy and reports its checksum. The
+// signature of a signed snapshot is stored next to the copy.
+func GenerateSnapshotTierJob(cfg *JobConfig) *batchv1.Job {
+	return uploadJob(cfg, fmt.Sprintf("etcd-snapshot-tier-%s", cfg.SnapshotID), "snapshot-tier", cfg.ObjectStore)
+}
+
+// GenerateSnapshotReplicateJob creates a Kubernetes Job that copies a snapshot file to a
+// replication target, verifies the copy and reports its checksum. Snapshots in the object
+// store tier are fetched from there first.
+func GenerateSnapshotReplicateJob(cfg *JobConfig) *batchv1.Job {
+	job := uploadJob(cfg, fmt.Sprintf("etcd-snapshot-replicate-%s-%s", cfg.ReplicaTarget, cfg.SnapshotID), "snapshot-replicate", cfg.ReplicaLocation)
+	job.Labels["replica-target"] = cfg.ReplicaTarget
+	addFetchStage(job, cfg)
+	return job
+}
+
+// uploadJob creates a Job that uploads the snapshot file on the snapshot PVC to destination
+func uploadJob(cfg *JobConfig, jobName, operation string, destination *snapshot.ObjectLocation) *batchv1.Job {
+	ttlSecondsAfterFinished := int32(3600)
+
+	// Determine image to use
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	command := append([]string{
+		"/bin/etcd-snapshot-driver",
+		"agent",
+		"upload",
+		"--file", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+		"--checksum", cfg.ChecksumSHA256,
+	}, objectStoreArgs(destination)...)
+	// The signature goes next to the copy, so it can be checked without the metadata
+	if cfg.Signature != "" {
+		command = append(command, "--signature", cfg.Signature)
+	}
+
+	labels := map[string]string{
+		"app":         config.NewNames(cfg.DriverName).AppLabel(),
+		"snapshot-id": cfg.SnapshotID,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: cfg.Namespace,
+			Labels: map[string]string{
+				"app":         labels["app"],
+				"operation":   operation,
+				"snapshot-id": cfg.SnapshotID,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
+			BackoffLimit:            &cfg.BackoffLimit,
+			ActiveDeadlineSeconds:   &cfg.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: ExecutorServiceAccount,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					Affinity:           nodeAffinity(cfg.NodeName),
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot: boolPtr(true),
+						RunAsUser:    int64Ptr(65534),
+						FSGroup:      int64Ptr(65534),
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "upload",
+							Image:           image,
+							Command:         command,
+							Env:             objectStoreEnv(destination),
+							SecurityContext: agentSecurityContext(),
+							Resources:       transferResources(),
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "snapshot-pvc",
+									MountPath: "/snapshots",
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "snapshot-pvc",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: cfg.SnapshotPVCName,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// addFetchStage lets a job that reads a snapshot in the object store tier run unchanged:
+// its snapshot volume becomes a scratch volume that an init container downloads the
+// snapshot file into, verified against the recorded checksum. Jobs reading a snapshot on
+// a PVC are left alone.
+func addFetchStage(job *batchv1.Job, cfg *JobConfig) {
+	if cfg.ObjectStore == nil {
+		return
+	}
+	podSpec := &job.Spec.Template.Spec
+
+	for i := range podSpec.Volumes {
+		if podSpec.Volumes[i].Name == "snapshot-pvc" {
+			podSpec.Volumes[i].VolumeSource = corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			}
+		}
+	}
+
+	image := cfg.AgentImage
+	if image == "" {
+		image = "etcd-snapshot-driver:latest"
+	}
+
+	fetchContainer := corev1.Container{
+		Name:  "fetch",
+		Image: image,
+		Command: append([]string{
+			"/bin/etcd-snapshot-driver",
+			"agent",
+			"download",
+			"--output", fmt.Sprintf("/snapshots/%s", cfg.Format.FileName(cfg.SnapshotID)),
+			"--checksum", cfg.ChecksumSHA256,
+		}, objectStoreArgs(cfg.ObjectStore)...),
+		Env:             objectStoreEnv(cfg.ObjectStore),
+		SecurityContext: agentSecurityContext(),
+		Resources:       transferResources(),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "snapshot-pvc",
+				MountPath: "/snapshots",
+			},
+		},
+	}
+	podSpec.InitContainers = append([]corev1.Container{fetchContainer}, podSpec.InitContainers...)
+}
+
+// objectStoreArgs are the agent flags selecting an object in the object store
+func objectStoreArgs(location *snapshot.ObjectLocation) []string {
+	args := []string{
+		"--endpoint", location.Endpoint,
+		"--region", location.Region,
+		"--bucket", location.Bucket,
+		"--key", location.Key,
+	}
+	if location.Insecure {
+		args = append(args, "--insecure")
+	}
+	return args
+}
+
+// objectStoreEnv hands the object store credentials from their Secret to the agent
+func objectStoreEnv(location *snapshot.ObjectLocation) []corev1.EnvVar {
+	secretKey := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: location.SecretName},
+				Key:                  key,
+			},
+		}
+	}
+	return []corev1.EnvVar{
+		{Name: objectstore.AccessKeyIDEnv, ValueFrom: secretKey(objectstore.AccessKeyIDKey)},
+		{Name: objectstore.SecretAccessKeyEnv, ValueFrom: secretKey(objectstore.SecretAccessKeyKey)},
+	}
+}
+
+func agentSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: boolPtr(false),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		ReadOnlyRootFilesystem: boolPtr(true),
+	}
+}
+
+func transferResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceMemory: mustParseQuantity("64Mi"),
+			corev1.ResourceCPU:    mustParseQuantity("100m"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: mustParseQuantity("256Mi"),
+			corev1.ResourceCPU:    mustParseQuantity("500m"),
+		},
+	}
+}
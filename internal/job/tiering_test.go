@@ -0,0 +1,146 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/objectstore"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+func testObjectLocation() *snapshot.ObjectLocation {
+	return &snapshot.ObjectLocation{
+		Endpoint:   "minio.storage:9000",
+		Bucket:     "snapshots",
+		Key:        "etcd-snapshots/etcd/snap-1.db.gz",
+		Insecure:   true,
+		SecretName: "object-store",
+	}
+}
+
+func TestGenerateSnapshotTierJob(t *testing.T) {
+	job := GenerateSnapshotTierJob(&JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecGzip},
+		ObjectStore:     testObjectLocation(),
+		ChecksumSHA256:  "abc123",
+		NodeName:        "node-a",
+	})
+
+	assert.Equal(t, "etcd-snapshot-tier-snap-1", job.Name)
+	assert.Equal(t, "snapshot-tier", job.Labels["operation"])
+	podSpec := job.Spec.Template.Spec
+	require.Len(t, podSpec.Containers, 1)
+	assert.Equal(t, []string{
+		"/bin/etcd-snapshot-driver", "agent", "upload",
+		"--file", "/snapshots/snap-1.db.gz",
+		"--checksum", "abc123",
+		"--endpoint", "minio.storage:9000",
+		"--region", "",
+		"--bucket", "snapshots",
+		"--key", "etcd-snapshots/etcd/snap-1.db.gz",
+		"--insecure",
+	}, podSpec.Containers[0].Command)
+
+	env := podSpec.Containers[0].Env
+	require.Len(t, env, 2)
+	assert.Equal(t, objectstore.AccessKeyIDEnv, env[0].Name)
+	assert.Equal(t, "object-store", env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, objectstore.SecretAccessKeyKey, env[1].ValueFrom.SecretKeyRef.Key)
+
+	require.Len(t, podSpec.Volumes, 1)
+	assert.Equal(t, "etcd-snapshots", podSpec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	require.NotNil(t, podSpec.Affinity)
+
+	// Signed snapshots take their signature along
+	signed := GenerateSnapshotTierJob(&JobConfig{
+		SnapshotID:  "snap-1",
+		ObjectStore: testObjectLocation(),
+		Signature:   `{"algorithm":"ed25519"}`,
+	})
+	command := signed.Spec.Template.Spec.Containers[0].Command
+	assert.Equal(t, []string{"--signature", `{"algorithm":"ed25519"}`}, command[len(command)-2:])
+}
+
+func TestJobsFetchSnapshotsFromObjectStore(t *testing.T) {
+	cfg := &JobConfig{
+		SnapshotID:     "snap-1",
+		Namespace:      "etcd",
+		Format:         snapshot.Format{Codec: snapshot.CodecGzip},
+		ObjectStore:    testObjectLocation(),
+		ChecksumSHA256: "abc123",
+		MemberName:     "etcd-2",
+		MemberPVCName:  "data-etcd-2",
+		DataDir:        "data",
+	}
+
+	readers := func(cfg *JobConfig) []*batchv1.Job {
+		return []*batchv1.Job{GenerateRestoreDrillJob(cfg), GenerateMemberRestoreJob(cfg), GenerateMemberSeedJob(cfg)}
+	}
+
+	for _, job := range readers(cfg) {
+		podSpec := job.Spec.Template.Spec
+		require.NotEmpty(t, podSpec.InitContainers, job.Name)
+		fetch := podSpec.InitContainers[0]
+		assert.Equal(t, "fetch", fetch.Name, job.Name)
+		assert.Equal(t, []string{"agent", "download", "--output", "/snapshots/snap-1.db.gz", "--checksum", "abc123"},
+			fetch.Command[1:7], job.Name)
+
+		for _, volume := range podSpec.Volumes {
+			if volume.Name == "snapshot-pvc" {
+				assert.NotNil(t, volume.EmptyDir, job.Name)
+				assert.Nil(t, volume.PersistentVolumeClaim, job.Name)
+			}
+		}
+	}
+
+	// Jobs reading from a snapshot PVC are unchanged
+	cfg.ObjectStore = nil
+	cfg.SnapshotPVCName = "etcd-snapshots"
+	for _, job := range readers(cfg) {
+		for _, c := range job.Spec.Template.Spec.InitContainers {
+			assert.NotEqual(t, "fetch", c.Name, job.Name)
+		}
+	}
+}
+
+func TestGenerateSnapshotReplicateJob(t *testing.T) {
+	replica := &snapshot.ObjectLocation{
+		Endpoint:   "s3.us-west-2.amazonaws.com",
+		Region:     "us-west-2",
+		Bucket:     "dr-snapshots",
+		Key:        "etcd/snap-1.db.gz",
+		SecretName: "dr-credentials",
+	}
+	cfg := &JobConfig{
+		SnapshotID:      "snap-1",
+		Namespace:       "etcd",
+		SnapshotPVCName: "etcd-snapshots",
+		Format:          snapshot.Format{Codec: snapshot.CodecGzip},
+		ChecksumSHA256:  "abc123",
+		ReplicaTarget:   "dr",
+		ReplicaLocation: replica,
+	}
+
+	job := GenerateSnapshotReplicateJob(cfg)
+	assert.Equal(t, "etcd-snapshot-replicate-dr-snap-1", job.Name)
+	assert.Equal(t, "snapshot-replicate", job.Labels["operation"])
+	assert.Equal(t, "dr", job.Labels["replica-target"])
+	podSpec := job.Spec.Template.Spec
+	assert.Empty(t, podSpec.InitContainers)
+	require.Len(t, podSpec.Containers, 1)
+	assert.Contains(t, podSpec.Containers[0].Command, "dr-snapshots")
+	assert.Equal(t, "dr-credentials", podSpec.Containers[0].Env[0].ValueFrom.SecretKeyRef.Name)
+
+	// Snapshots in the object store tier are fetched with the tier's credentials first
+	cfg.ObjectStore = testObjectLocation()
+	podSpec = GenerateSnapshotReplicateJob(cfg).Spec.Template.Spec
+	require.Len(t, podSpec.InitContainers, 1)
+	assert.Equal(t, "object-store", podSpec.InitContainers[0].Env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "dr-credentials", podSpec.Containers[0].Env[0].ValueFrom.SecretKeyRef.Name)
+	assert.NotNil(t, podSpec.Volumes[0].EmptyDir)
+}
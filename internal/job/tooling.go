@@ -0,0 +1,78 @@
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ImageMatrix maps etcd minor versions such as "3.5" to the image whose etcdctl and
+// etcdutl match servers of that version
+type ImageMatrix map[string]string
+
+// ParseImageMatrix parses VERSION=IMAGE entries, such as 3.5=quay.io/coreos/etcd:v3.5.21
+func ParseImageMatrix(entries []string) (ImageMatrix, error) {
+	matrix := make(ImageMatrix, len(entries))
+	for _, entry := range entries {
+		version, image, ok := strings.Cut(entry, "=")
+		if !ok || image == "" {
+			return nil, fmt.Errorf("invalid image matrix entry %q (expected VERSION=IMAGE)", entry)
+		}
+		minor, ok := minorVersion(version)
+		if !ok {
+			return nil, fmt.Errorf("invalid etcd version %q in image matrix entry %q (expected MAJOR.MINOR)", version, entry)
+		}
+		if _, ok := matrix[minor]; ok {
+			return nil, fmt.Errorf("etcd version %s appears more than once in the image matrix", minor)
+		}
+		matrix[minor] = image
+	}
+	return matrix, nil
+}
+
+// Image returns the image for servers of the given version, or fallback when the
+// version is unknown or not in the matrix
+func (m ImageMatrix) Image(version, fallback string) string {
+	if minor, ok := minorVersion(version); ok {
+		if image, ok := m[minor]; ok {
+			return image
+		}
+	}
+	return fallback
+}
+
+// minorVersion reduces a version such as 3.5.21 or v3.5 to its major and minor parts
+func minorVersion(version string) (string, bool) {
+	major, minor, ok := parseMinorVersion(version)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d.%d", major, minor), true
+}
+
+func parseMinorVersion(version string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// snapshotStatusTool returns the tool that reads the status of a snapshot file taken
+// from a server of the given version. etcdutl took over offline snapshot commands in
+// 3.5, which deprecated `etcdctl snapshot status`, and 3.6 removed it. Unknown versions
+// get etcdutl, which the default image has.
+func snapshotStatusTool(version string) string {
+	if major, minor, ok := parseMinorVersion(version); ok && (major < 3 || major == 3 && minor < 5) {
+		return "etcdctl"
+	}
+	return "etcdutl"
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageMatrix(t *testing.T) {
+	matrix, err := ParseImageMatrix([]string{
+		"3.4=quay.io/coreos/etcd:v3.4.37",
+		"v3.5=quay.io/coreos/etcd:v3.5.21",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ImageMatrix{
+		"3.4": "quay.io/coreos/etcd:v3.4.37",
+		"3.5": "quay.io/coreos/etcd:v3.5.21",
+	}, matrix)
+
+	assert.Equal(t, "quay.io/coreos/etcd:v3.4.37", matrix.Image("3.4.35", "fallback"))
+	assert.Equal(t, "quay.io/coreos/etcd:v3.5.21", matrix.Image("3.5.0", "fallback"))
+	assert.Equal(t, "fallback", matrix.Image("3.6.8", "fallback"))
+	assert.Equal(t, "fallback", matrix.Image("", "fallback"))
+	assert.Equal(t, "fallback", ImageMatrix(nil).Image("3.5.0", "fallback"))
+
+	for _, entries := range [][]string{
+		{"3.5"},
+		{"3.5="},
+		{"three=quay.io/coreos/etcd:v3.5.21"},
+		{"3.5=quay.io/coreos/etcd:v3.5.21", "3.5.1=quay.io/coreos/etcd:v3.5.1"},
+	} {
+		_, err := ParseImageMatrix(entries)
+		assert.Error(t, err, entries)
+	}
+}
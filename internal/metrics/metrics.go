@@ -22,6 +22,10 @@ type Metrics struct {
 	SnapshotPVCAvailable  *prometheus.GaugeVec
 	StorageErrors         *prometheus.CounterVec
 
+	// Replication
+	ReplicationLag    *prometheus.GaugeVec
+	ReplicationsTotal *prometheus.CounterVec
+
 	// ETCD health
 	ETCDMembers   *prometheus.GaugeVec
 	ETCDHasQuorum *prometheus.GaugeVec
@@ -107,6 +111,22 @@ func NewMetrics() *Metrics {
 			[]string{"reason"},
 		),
 
+		// Replication
+		ReplicationLag: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "etcd_snapshot_replication_lag_seconds",
+				Help: "Age of the oldest snapshot not yet replicated to a target, 0 when caught up",
+			},
+			[]string{"target"},
+		),
+		ReplicationsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "etcd_snapshot_replications_total",
+				Help: "Total number of snapshot replication attempts",
+			},
+			[]string{"target", "status"},
+		),
+
 		// ETCD health
 		ETCDMembers: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -0,0 +1,230 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Keys of the Secret holding object store credentials
+	AccessKeyIDKey     = "access-key-id"
+	SecretAccessKeyKey = "secret-access-key"
+
+	// Environment variables the credentials are handed to job pods in
+	AccessKeyIDEnv     = "OBJECT_STORE_ACCESS_KEY_ID"
+	SecretAccessKeyEnv = "OBJECT_STORE_SECRET_ACCESS_KEY"
+)
+
+// Config selects an S3-compatible bucket and the credentials to reach it with
+type Config struct {
+	// Endpoint is the host[:port] of the object store, without a scheme
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Insecure reaches the endpoint over plain HTTP
+	Insecure        bool
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Result describes an object copied to or from the object store
+type Result struct {
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	Size           int64  `json:"size"`
+}
+
+// Client copies snapshot files to and from a bucket of an S3-compatible object store
+type Client struct {
+	client *minio.Client
+	bucket string
+}
+
+func New(cfg Config) (*Client, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating object store client for %s: %w", cfg.Endpoint, err)
+	}
+
+	return &Client{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Upload copies the file at path to key and reads the object back to verify it arrived
+// intact. A non-empty expected checksum must match the file as well. An object that does
+// not verify is removed again.
+func (c *Client) Upload(ctx context.Context, key, path, expected string) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	_, err = c.client.PutObject(ctx, c.bucket, key, io.TeeReader(f, hash), stat.Size(), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("uploading %s to %s/%s: %w", path, c.bucket, key, err)
+	}
+	local := &Result{ChecksumSHA256: hex.EncodeToString(hash.Sum(nil)), Size: stat.Size()}
+
+	remote, err := c.checksum(ctx, key)
+	if err == nil && (remote.ChecksumSHA256 != local.ChecksumSHA256 || remote.Size != local.Size) {
+		err = fmt.Errorf("object has checksum %s (%d bytes) but the file has %s (%d bytes)",
+			remote.ChecksumSHA256, remote.Size, local.ChecksumSHA256, local.Size)
+	}
+	if err == nil {
+		err = verify(local, expected)
+	}
+	if err != nil {
+		_ = c.Delete(context.Background(), key)
+		return nil, fmt.Errorf("verifying %s/%s: %w", c.bucket, key, err)
+	}
+
+	return local, nil
+}
+
+// Put stores a small object, such as the signature kept next to a snapshot file
+func (c *Client) Put(ctx context.Context, key string, data []byte) error {
+	_, err := c.client.PutObject(ctx, c.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s/%s: %w", c.bucket, key, err)
+	}
+	return nil
+}
+
+// Download copies key to a file at path. A non-empty expected checksum must match the
+// object; the file is only put in place once it does.
+func (c *Client) Download(ctx context.Context, key, path, expected string) (*Result, error) {
+	object, err := c.client.GetObject(ctx, c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s/%s: %w", c.bucket, key, err)
+	}
+	defer object.Close()
+
+	partial := path + ".part"
+	f, err := os.Create(partial)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(partial)
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), object)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s/%s: %w", c.bucket, key, err)
+	}
+
+	result := &Result{ChecksumSHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	if err := verify(result, expected); err != nil {
+		return nil, fmt.Errorf("verifying %s/%s: %w", c.bucket, key, err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Delete removes key; an object that no longer exists is not an error
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if err := c.client.RemoveObject(ctx, c.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("deleting %s/%s: %w", c.bucket, key, err)
+	}
+	return nil
+}
+
+// Lock maps a snapshot's hold onto the object lock of key: a legal hold while legalHold is
+// set, and compliance mode retention until lockedUntil unless that is nil. The bucket must
+// have object lock enabled.
+func (c *Client) Lock(ctx context.Context, key string, legalHold bool, lockedUntil *time.Time) error {
+	status := minio.LegalHoldDisabled
+	if legalHold {
+		status = minio.LegalHoldEnabled
+	}
+	if err := c.client.PutObjectLegalHold(ctx, c.bucket, key, minio.PutObjectLegalHoldOptions{Status: &status}); err != nil {
+		return fmt.Errorf("setting legal hold on %s/%s: %w", c.bucket, key, err)
+	}
+
+	if lockedUntil != nil {
+		mode := minio.Compliance
+		if err := c.client.PutObjectRetention(ctx, c.bucket, key, minio.PutObjectRetentionOptions{
+			Mode:            &mode,
+			RetainUntilDate: lockedUntil,
+		}); err != nil {
+			return fmt.Errorf("setting retention on %s/%s: %w", c.bucket, key, err)
+		}
+	}
+	return nil
+}
+
+// checksum reads an object back and hashes it
+func (c *Client) checksum(ctx context.Context, key string) (*Result, error) {
+	object, err := c.client.GetObject(ctx, c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, object)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{ChecksumSHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
+
+func verify(result *Result, expected string) error {
+	if expected != "" && result.ChecksumSHA256 != expected {
+		return fmt.Errorf("checksum %s does not match the recorded checksum %s", result.ChecksumSHA256, expected)
+	}
+	return nil
+}
+
+// NewWithSecret creates a client for cfg with the credentials held in a Secret
+func NewWithSecret(ctx context.Context, k8sClient kubernetes.Interface, cfg Config, namespace, secretName string) (*Client, error) {
+	var err error
+	cfg.AccessKeyID, cfg.SecretAccessKey, err = CredentialsFromSecret(ctx, k8sClient, namespace, secretName)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// CredentialsFromSecret reads object store credentials from a Secret
+func CredentialsFromSecret(ctx context.Context, k8sClient kubernetes.Interface, namespace, name string) (accessKeyID, secretAccessKey string, err error) {
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get object store credentials secret %s/%s: %w", namespace, name, err)
+	}
+
+	accessKeyID, secretAccessKey = string(secret.Data[AccessKeyIDKey]), string(secret.Data[SecretAccessKeyKey])
+	if accessKeyID == "" || secretAccessKey == "" {
+		return "", "", fmt.Errorf("object store credentials secret %s/%s must hold %s and %s",
+			namespace, name, AccessKeyIDKey, SecretAccessKeyKey)
+	}
+	return accessKeyID, secretAccessKey, nil
+}
@@ -0,0 +1,228 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeS3 keeps objects in memory and answers the requests the client makes.
+// corrupt, when set, changes every object as it is stored. Objects under legal hold
+// or retention cannot be deleted.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	corrupt  bool
+	held     map[string]bool
+	retained map[string]bool
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Has("legal-hold"):
+		body, _ := io.ReadAll(r.Body)
+		s.held[key] = strings.Contains(string(body), "<Status>ON</Status>")
+		return
+	case r.Method == http.MethodPut && r.URL.Query().Has("retention"):
+		body, _ := io.ReadAll(r.Body)
+		s.retained[key] = strings.Contains(string(body), "<Mode>COMPLIANCE</Mode>")
+		return
+	case r.Method == http.MethodDelete && (s.held[key] || s.retained[key]):
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeChunked(data)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.corrupt && len(data) > 0 {
+			data[0] ^= 0xff
+		}
+		s.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeChunked strips the chunk framing of a streaming (aws-chunked) upload
+func decodeChunked(body []byte) ([]byte, error) {
+	var data []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		sizeHex, _, _ := strings.Cut(string(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		if int64(len(rest)) < size+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = append(data, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+func newTestClient(t *testing.T, store *fakeS3) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+
+	client, err := New(Config{
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		Region:          "us-east-1",
+		Bucket:          "snapshots",
+		Insecure:        true,
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeS3{objects: map[string][]byte{}}
+	client := newTestClient(t, store)
+
+	dir := t.TempDir()
+	data := []byte(strings.Repeat("etcd", 1024))
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "snap.db"), data, 0o600))
+
+	uploaded, err := client.Upload(ctx, "hcp/snap.db", filepath.Join(dir, "snap.db"), checksum)
+	require.NoError(t, err)
+	assert.Equal(t, &Result{ChecksumSHA256: checksum, Size: int64(len(data))}, uploaded)
+	assert.Equal(t, data, store.objects["snapshots/hcp/snap.db"])
+
+	downloaded, err := client.Download(ctx, "hcp/snap.db", filepath.Join(dir, "copy.db"), checksum)
+	require.NoError(t, err)
+	assert.Equal(t, uploaded, downloaded)
+	copied, err := os.ReadFile(filepath.Join(dir, "copy.db"))
+	require.NoError(t, err)
+	assert.Equal(t, data, copied)
+
+	// A download that does not match the recorded checksum leaves no file behind
+	_, err = client.Download(ctx, "hcp/snap.db", filepath.Join(dir, "bad.db"), strings.Repeat("0", 64))
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "bad.db"))
+
+	require.NoError(t, client.Put(ctx, "hcp/snap.db.sig", []byte(`{"algorithm":"ed25519"}`)))
+	assert.Equal(t, []byte(`{"algorithm":"ed25519"}`), store.objects["snapshots/hcp/snap.db.sig"])
+
+	require.NoError(t, client.Delete(ctx, "hcp/snap.db"))
+	require.NoError(t, client.Delete(ctx, "hcp/snap.db.sig"))
+	assert.Empty(t, store.objects)
+}
+
+func TestUploadRemovesObjectThatDoesNotVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "snap.db"), []byte("snapshot"), 0o600))
+
+	// The object read back differs from the file
+	corrupting := &fakeS3{objects: map[string][]byte{}, corrupt: true}
+	_, err := newTestClient(t, corrupting).Upload(ctx, "snap.db", filepath.Join(dir, "snap.db"), "")
+	assert.ErrorContains(t, err, "verifying")
+	assert.Empty(t, corrupting.objects)
+
+	// The file differs from the checksum recorded when the snapshot was taken
+	store := &fakeS3{objects: map[string][]byte{}}
+	_, err = newTestClient(t, store).Upload(ctx, "snap.db", filepath.Join(dir, "snap.db"), strings.Repeat("0", 64))
+	assert.ErrorContains(t, err, "recorded checksum")
+	assert.Empty(t, store.objects)
+}
+
+func TestCredentialsFromSecret(t *testing.T) {
+	ctx := context.Background()
+	k8sClient := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "object-store", Namespace: "hcp"},
+			Data: map[string][]byte{
+				AccessKeyIDKey:     []byte("access"),
+				SecretAccessKeyKey: []byte("secret"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "incomplete", Namespace: "hcp"},
+			Data:       map[string][]byte{AccessKeyIDKey: []byte("access")},
+		},
+	)
+
+	accessKeyID, secretAccessKey, err := CredentialsFromSecret(ctx, k8sClient, "hcp", "object-store")
+	require.NoError(t, err)
+	assert.Equal(t, "access", accessKeyID)
+	assert.Equal(t, "secret", secretAccessKey)
+
+	_, _, err = CredentialsFromSecret(ctx, k8sClient, "hcp", "incomplete")
+	assert.Error(t, err)
+	_, _, err = CredentialsFromSecret(ctx, k8sClient, "hcp", "missing")
+	assert.Error(t, err)
+}
+
+func TestLockBlocksDeletion(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeS3{objects: map[string][]byte{}, held: map[string]bool{}, retained: map[string]bool{}}
+	client := newTestClient(t, store)
+	store.objects["snapshots/hcp/snap.db"] = []byte("snapshot")
+
+	require.NoError(t, client.Lock(ctx, "hcp/snap.db", true, nil))
+	assert.True(t, store.held["snapshots/hcp/snap.db"])
+	assert.Error(t, client.Delete(ctx, "hcp/snap.db"))
+
+	// Releasing the legal hold leaves the retention period in force
+	lockedUntil := time.Now().Add(24 * time.Hour)
+	require.NoError(t, client.Lock(ctx, "hcp/snap.db", false, &lockedUntil))
+	assert.False(t, store.held["snapshots/hcp/snap.db"])
+	assert.True(t, store.retained["snapshots/hcp/snap.db"])
+	assert.Error(t, client.Delete(ctx, "hcp/snap.db"))
+	assert.Contains(t, store.objects, "snapshots/hcp/snap.db")
+}
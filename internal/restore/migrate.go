@@ -0,0 +1,347 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// MigrationTarget describes the volumes a StatefulSet is migrated to
+type MigrationTarget struct {
+	StorageClass string
+	Size         string
+	// VolumeName is the new volumeClaimTemplate; empty derives one from the old name and storage class
+	VolumeName string
+	// MaxSnapshotAge refuses snapshots older than this, since later writes are lost; zero allows any age
+	MaxSnapshotAge time.Duration
+}
+
+// MigrationPlan is a restore into new PVCs followed by a switch of the StatefulSet's volumes
+type MigrationPlan struct {
+	Plan
+	OldVolume    string   `json:"old_volume"`
+	NewVolume    string   `json:"new_volume"`
+	StorageClass string   `json:"storage_class"`
+	Size         string   `json:"size"`
+	NewPVCs      []string `json:"new_pvcs"`
+}
+
+// PlanMigration resolves a restore of a verified snapshot into new PVCs of the target
+// storage class and size. The snapshot must have passed a restore drill.
+func (o *Orchestrator) PlanMigration(ctx context.Context, statefulSet, snapshotID string, target MigrationTarget) (*MigrationPlan, error) {
+	if target.StorageClass == "" {
+		return nil, errors.New("a target storage class is required")
+	}
+	if _, err := resource.ParseQuantity(target.Size); err != nil {
+		return nil, fmt.Errorf("invalid size %q: %w", target.Size, err)
+	}
+
+	plan, err := o.Plan(ctx, statefulSet, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	s := plan.snapshot
+	if s.RestoreDrill == nil || !s.RestoreDrill.Passed {
+		return nil, fmt.Errorf("snapshot %s has not passed a restore drill; run snapshot verify first", s.SnapshotID)
+	}
+	if age := time.Since(s.CreationTime); target.MaxSnapshotAge > 0 && age > target.MaxSnapshotAge {
+		return nil, fmt.Errorf("snapshot %s is %s old, more than the allowed %s; writes since then would be lost",
+			s.SnapshotID, age.Round(time.Second), target.MaxSnapshotAge)
+	}
+
+	if _, err := o.k8sClient.StorageV1().StorageClasses().Get(ctx, target.StorageClass, metav1.GetOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to get storage class %s: %w", target.StorageClass, err)
+	}
+
+	sts, err := o.k8sClient.AppsV1().StatefulSets(plan.Namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get StatefulSet %s/%s: %w", plan.Namespace, statefulSet, err)
+	}
+	oldVolume, err := o.dataVolume(sts)
+	if err != nil {
+		return nil, err
+	}
+
+	newVolume := target.VolumeName
+	if newVolume == "" {
+		newVolume = fmt.Sprintf("%s-%s", oldVolume, target.StorageClass)
+	}
+	for _, t := range sts.Spec.VolumeClaimTemplates {
+		if t.Name == newVolume {
+			return nil, fmt.Errorf("StatefulSet %s already has a volumeClaimTemplate %s; choose another volume name", statefulSet, newVolume)
+		}
+	}
+
+	mp := &MigrationPlan{
+		Plan:         *plan,
+		OldVolume:    oldVolume,
+		NewVolume:    newVolume,
+		StorageClass: target.StorageClass,
+		Size:         target.Size,
+	}
+	for _, m := range plan.Members {
+		mp.NewPVCs = append(mp.NewPVCs, memberPVCName(newVolume, statefulSet, m.Ordinal))
+	}
+
+	return mp, nil
+}
+
+// RunMigration creates the new PVCs, restores the snapshot into them with the
+// StatefulSet scaled down and then recreates the StatefulSet on the new volumes. The
+// old PVCs are left in place, and the StatefulSet as it was is kept in an annotation
+// so RollbackMigration can switch back to them.
+func (o *Orchestrator) RunMigration(ctx context.Context, mp *MigrationPlan) error {
+	o.logger.Infow("Migrating StatefulSet volumes",
+		"statefulset", mp.StatefulSet,
+		"namespace", mp.Namespace,
+		"snapshot_id", mp.SnapshotID,
+		"storage_class", mp.StorageClass,
+		"size", mp.Size,
+	)
+
+	sts, err := o.k8sClient.AppsV1().StatefulSets(mp.Namespace).Get(ctx, mp.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StatefulSet %s/%s: %w", mp.Namespace, mp.StatefulSet, err)
+	}
+	release, err := o.readSnapshot(ctx, &mp.Plan)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := o.createMigrationPVCs(ctx, mp, sts); err != nil {
+		return err
+	}
+
+	if err := o.scale(ctx, &mp.Plan, 0); err != nil {
+		return fmt.Errorf("failed to scale down StatefulSet: %w", err)
+	}
+	if err := o.waitForPodsGone(ctx, &mp.Plan); err != nil {
+		return o.abortMigration(mp, fmt.Errorf("StatefulSet did not scale down: %w", err))
+	}
+
+	for i, m := range mp.Members {
+		m.PVCName = mp.NewPVCs[i]
+		o.logger.Infow("Restoring member into new PVC", "member", m.Name, "pvc", m.PVCName)
+		if err := o.runMemberJob(ctx, job.GenerateMemberRestoreJob, o.jobConfig(&mp.Plan, m)); err != nil {
+			return o.abortMigration(mp, fmt.Errorf("restore of member %s failed: %w", m.Name, err))
+		}
+	}
+
+	if err := o.switchVolumes(ctx, mp, sts); err != nil {
+		return err
+	}
+
+	o.logger.Infow("StatefulSet volumes migrated",
+		"statefulset", mp.StatefulSet,
+		"volume", mp.NewVolume,
+	)
+	return nil
+}
+
+// RollbackMigration recreates the StatefulSet as it was before its volumes were
+// migrated, so its pods use the old PVCs again
+func (o *Orchestrator) RollbackMigration(ctx context.Context, namespace, statefulSet string) error {
+	annotation := config.NewNames(o.cfg.DriverName).PreMigrationAnnotation()
+
+	sts, err := o.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StatefulSet %s/%s: %w", namespace, statefulSet, err)
+	}
+	saved, ok := sts.Annotations[annotation]
+	if !ok {
+		return fmt.Errorf("StatefulSet %s/%s has no %s annotation; it was not migrated", namespace, statefulSet, annotation)
+	}
+
+	var original appsv1.StatefulSet
+	if err := json.Unmarshal([]byte(saved), &original); err != nil {
+		return fmt.Errorf("failed to decode %s annotation: %w", annotation, err)
+	}
+
+	o.logger.Infow("Rolling back StatefulSet volume migration",
+		"statefulset", statefulSet,
+		"namespace", namespace,
+	)
+
+	// The migrated pods must be gone before the old PVCs are mounted again
+	plan := &Plan{Namespace: namespace, StatefulSet: statefulSet}
+	for i := 0; i < int(statefulSetReplicas(sts)); i++ {
+		plan.Members = append(plan.Members, MemberPlan{PodName: fmt.Sprintf("%s-%d", statefulSet, i)})
+	}
+	if err := o.scale(ctx, plan, 0); err != nil {
+		return fmt.Errorf("failed to scale down StatefulSet: %w", err)
+	}
+	if err := o.waitForPodsGone(ctx, plan); err != nil {
+		return fmt.Errorf("StatefulSet did not scale down: %w", err)
+	}
+
+	return o.recreateStatefulSet(ctx, &original)
+}
+
+// createMigrationPVCs creates a PVC per member with the target storage class and size,
+// carrying over the labels and access modes of the old volumeClaimTemplate
+func (o *Orchestrator) createMigrationPVCs(ctx context.Context, mp *MigrationPlan, sts *appsv1.StatefulSet) error {
+	var template corev1.PersistentVolumeClaim
+	for _, t := range sts.Spec.VolumeClaimTemplates {
+		if t.Name == mp.OldVolume {
+			template = t
+		}
+	}
+
+	provisioner := snapshot.NewSnapshotPVCProvisioner(o.k8sClient, config.NewNames(o.cfg.DriverName), mp.StorageClass, mp.Size, o.logger)
+	for i, m := range mp.Members {
+		old, err := o.k8sClient.CoreV1().PersistentVolumeClaims(mp.Namespace).Get(ctx, m.PVCName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get PVC %s: %w", m.PVCName, err)
+		}
+
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      mp.NewPVCs[i],
+				Namespace: mp.Namespace,
+				Labels:    old.Labels,
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: template.Spec.AccessModes,
+				VolumeMode:  template.Spec.VolumeMode,
+			},
+		}
+		if len(pvc.Spec.AccessModes) == 0 {
+			pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+		}
+		if _, err := provisioner.EnsurePVC(ctx, pvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// switchVolumes recreates the StatefulSet with the new volumeClaimTemplate in place of
+// the old one. StatefulSet volumeClaimTemplates are immutable, so the StatefulSet is
+// deleted without its pods or PVCs and created again.
+func (o *Orchestrator) switchVolumes(ctx context.Context, mp *MigrationPlan, sts *appsv1.StatefulSet) error {
+	original := sts.DeepCopy()
+	original.Spec.Replicas = &mp.Replicas
+	delete(original.Annotations, config.NewNames(o.cfg.DriverName).PreMigrationAnnotation())
+	saved, err := json.Marshal(cleanStatefulSet(original))
+	if err != nil {
+		return fmt.Errorf("failed to encode StatefulSet: %w", err)
+	}
+
+	migrated := cleanStatefulSet(sts.DeepCopy())
+	migrated.Spec.Replicas = &mp.Replicas
+	if migrated.Annotations == nil {
+		migrated.Annotations = map[string]string{}
+	}
+	migrated.Annotations[config.NewNames(o.cfg.DriverName).PreMigrationAnnotation()] = string(saved)
+
+	size := resource.MustParse(mp.Size)
+	for i, t := range migrated.Spec.VolumeClaimTemplates {
+		if t.Name != mp.OldVolume {
+			continue
+		}
+		t.Name = mp.NewVolume
+		t.Spec.StorageClassName = &mp.StorageClass
+		t.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: size}
+		migrated.Spec.VolumeClaimTemplates[i] = t
+	}
+	renameVolumeMounts(migrated.Spec.Template.Spec.InitContainers, mp.OldVolume, mp.NewVolume)
+	renameVolumeMounts(migrated.Spec.Template.Spec.Containers, mp.OldVolume, mp.NewVolume)
+
+	if err := o.recreateStatefulSet(ctx, migrated); err != nil {
+		return o.abortMigration(mp, fmt.Errorf("switching StatefulSet volumes failed: %w", err))
+	}
+	return nil
+}
+
+// recreateStatefulSet replaces the StatefulSet of the same name with sts, keeping its
+// pods and PVCs. If sts cannot be created the previous StatefulSet is put back.
+func (o *Orchestrator) recreateStatefulSet(ctx context.Context, sts *appsv1.StatefulSet) error {
+	statefulSets := o.k8sClient.AppsV1().StatefulSets(sts.Namespace)
+
+	previous, err := statefulSets.Get(ctx, sts.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StatefulSet %s/%s: %w", sts.Namespace, sts.Name, err)
+	}
+
+	orphan := metav1.DeletePropagationOrphan
+	if err := statefulSets.Delete(ctx, sts.Name, metav1.DeleteOptions{PropagationPolicy: &orphan}); err != nil {
+		return fmt.Errorf("failed to delete StatefulSet %s/%s: %w", sts.Namespace, sts.Name, err)
+	}
+	if err := o.waitForStatefulSetGone(ctx, sts.Namespace, sts.Name); err != nil {
+		return err
+	}
+
+	if _, err := statefulSets.Create(ctx, cleanStatefulSet(sts), metav1.CreateOptions{}); err != nil {
+		// Never leave the etcd cluster without a StatefulSet
+		if _, restoreErr := statefulSets.Create(context.Background(), cleanStatefulSet(previous), metav1.CreateOptions{}); restoreErr != nil {
+			return errors.Join(
+				fmt.Errorf("failed to create StatefulSet %s/%s: %w", sts.Namespace, sts.Name, err),
+				fmt.Errorf("failed to put back the previous StatefulSet: %w", restoreErr),
+			)
+		}
+		return fmt.Errorf("failed to create StatefulSet %s/%s, previous StatefulSet put back: %w", sts.Namespace, sts.Name, err)
+	}
+	return nil
+}
+
+// abortMigration scales the untouched StatefulSet back up after a failed restore
+func (o *Orchestrator) abortMigration(mp *MigrationPlan, cause error) error {
+	if err := o.scale(context.Background(), &mp.Plan, mp.Replicas); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to scale StatefulSet back to %d replicas: %w", mp.Replicas, err))
+	}
+	return fmt.Errorf("%w; StatefulSet scaled back up on its old PVCs, the new PVCs %v can be deleted", cause, mp.NewPVCs)
+}
+
+func (o *Orchestrator) waitForStatefulSetGone(ctx context.Context, namespace, name string) error {
+	return wait.PollUntilContextTimeout(ctx, o.pollInterval, o.cfg.ScaleTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := o.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// cleanStatefulSet drops the server-populated fields so sts can be created again. Owner
+// references, finalizers and the rest of the metadata are kept, so an operator managing
+// the StatefulSet still owns it.
+func cleanStatefulSet(sts *appsv1.StatefulSet) *appsv1.StatefulSet {
+	sts = sts.DeepCopy()
+	sts.ResourceVersion = ""
+	sts.UID = ""
+	sts.CreationTimestamp = metav1.Time{}
+	sts.ManagedFields = nil
+	sts.Status = appsv1.StatefulSetStatus{}
+	return sts
+}
+
+func renameVolumeMounts(containers []corev1.Container, oldName, newName string) {
+	for i := range containers {
+		for j := range containers[i].VolumeMounts {
+			if containers[i].VolumeMounts[j].Name == oldName {
+				containers[i].VolumeMounts[j].Name = newName
+			}
+		}
+	}
+}
+
+func statefulSetReplicas(sts *appsv1.StatefulSet) int32 {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+	return *sts.Spec.Replicas
+}
@@ -0,0 +1,159 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestMigration(t *testing.T) (*Orchestrator, *fake.Clientset) {
+	t.Helper()
+
+	o, c := newTestOrchestrator(t, 3)
+	ctx := context.Background()
+
+	_, err := c.StorageV1().StorageClasses().Create(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	sts, err := c.AppsV1().StatefulSets(testNamespace).Get(ctx, "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	sts.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:         "etcd",
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/lib/etcd"}},
+	}}
+	sts.OwnerReferences = []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "EtcdCluster", Name: "main", UID: "owner-uid"}}
+	_, err = c.AppsV1().StatefulSets(testNamespace).Update(ctx, sts, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, o.manager.StoreSnapshotMetadata(ctx, &snapshot.SnapshotMetadata{
+		SnapshotID:   "snap-verified",
+		ClusterName:  "main",
+		Namespace:    testNamespace,
+		PVCName:      "etcd-snapshots",
+		CreationTime: time.Now(),
+		ReadyToUse:   true,
+		RestoreDrill: &snapshot.RestoreDrillStatus{Passed: true},
+	}))
+	return o, c
+}
+
+func statefulSetOf(t *testing.T, c *fake.Clientset) *appsv1.StatefulSet {
+	t.Helper()
+	sts, err := c.AppsV1().StatefulSets(testNamespace).Get(context.Background(), "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	return sts
+}
+
+func TestPlanMigration(t *testing.T) {
+	o, _ := newTestMigration(t)
+	ctx := context.Background()
+	target := MigrationTarget{StorageClass: "fast", Size: "20Gi", MaxSnapshotAge: time.Hour}
+
+	plan, err := o.PlanMigration(ctx, "etcd", "snap-verified", target)
+	require.NoError(t, err)
+	assert.Equal(t, "data", plan.OldVolume)
+	assert.Equal(t, "data-fast", plan.NewVolume)
+	assert.Equal(t, []string{"data-fast-etcd-0", "data-fast-etcd-1", "data-fast-etcd-2"}, plan.NewPVCs)
+
+	_, err = o.PlanMigration(ctx, "etcd", "snap-1", target)
+	assert.ErrorContains(t, err, "has not passed a restore drill")
+
+	_, err = o.PlanMigration(ctx, "etcd", "snap-verified", MigrationTarget{StorageClass: "missing", Size: "20Gi"})
+	assert.ErrorContains(t, err, "failed to get storage class missing")
+
+	_, err = o.PlanMigration(ctx, "etcd", "snap-verified", MigrationTarget{StorageClass: "fast", Size: "20Gi", VolumeName: "data"})
+	assert.ErrorContains(t, err, "already has a volumeClaimTemplate data")
+}
+
+func TestPlanMigrationRefusesStaleSnapshot(t *testing.T) {
+	o, _ := newTestMigration(t)
+	ctx := context.Background()
+
+	s, err := o.manager.RetrieveSnapshotMetadata(ctx, "snap-verified")
+	require.NoError(t, err)
+	s.CreationTime = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, o.manager.StoreSnapshotMetadata(ctx, s))
+
+	_, err = o.PlanMigration(ctx, "etcd", "snap-verified", MigrationTarget{StorageClass: "fast", Size: "20Gi", MaxSnapshotAge: time.Hour})
+	assert.ErrorContains(t, err, "writes since then would be lost")
+}
+
+func TestRunAndRollbackMigration(t *testing.T) {
+	o, c := newTestMigration(t)
+	ctx := context.Background()
+
+	plan, err := o.PlanMigration(ctx, "etcd", "snap-verified", MigrationTarget{StorageClass: "fast", Size: "20Gi"})
+	require.NoError(t, err)
+	scaledDown(t, c, &plan.Plan)
+
+	var claims []string
+	o.runJob = func(_ context.Context, j *batchv1.Job) error {
+		claims = append(claims, j.Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName)
+		return nil
+	}
+	require.NoError(t, o.RunMigration(ctx, plan))
+	assert.Equal(t, plan.NewPVCs, claims)
+
+	for i := 0; i < 3; i++ {
+		pvc, err := c.CoreV1().PersistentVolumeClaims(testNamespace).Get(ctx, fmt.Sprintf("data-fast-etcd-%d", i), metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+		assert.Equal(t, "main", pvc.Labels["etcd.io/cluster"])
+
+		// The old PVCs are kept for rollback
+		_, err = c.CoreV1().PersistentVolumeClaims(testNamespace).Get(ctx, fmt.Sprintf("data-etcd-%d", i), metav1.GetOptions{})
+		require.NoError(t, err)
+	}
+
+	sts := statefulSetOf(t, c)
+	assert.Equal(t, int32(3), *sts.Spec.Replicas)
+	require.Len(t, sts.Spec.VolumeClaimTemplates, 1)
+	assert.Equal(t, "data-fast", sts.Spec.VolumeClaimTemplates[0].Name)
+	assert.Equal(t, "fast", *sts.Spec.VolumeClaimTemplates[0].Spec.StorageClassName)
+	assert.Equal(t, "data-fast", sts.Spec.Template.Spec.Containers[0].VolumeMounts[0].Name)
+	assert.Contains(t, sts.Annotations, config.NewNames("").PreMigrationAnnotation())
+	// The recreated StatefulSet keeps its owner
+	require.Len(t, sts.OwnerReferences, 1)
+	assert.Equal(t, "main", sts.OwnerReferences[0].Name)
+
+	require.NoError(t, o.RollbackMigration(ctx, testNamespace, "etcd"))
+	sts = statefulSetOf(t, c)
+	assert.Len(t, sts.OwnerReferences, 1)
+	assert.Equal(t, int32(3), *sts.Spec.Replicas)
+	assert.Equal(t, "data", sts.Spec.VolumeClaimTemplates[0].Name)
+	assert.Equal(t, "data", sts.Spec.Template.Spec.Containers[0].VolumeMounts[0].Name)
+	assert.NotContains(t, sts.Annotations, config.NewNames("").PreMigrationAnnotation())
+
+	err = o.RollbackMigration(ctx, testNamespace, "etcd")
+	assert.ErrorContains(t, err, "it was not migrated")
+}
+
+func TestRunMigrationRestoreFailure(t *testing.T) {
+	o, c := newTestMigration(t)
+	ctx := context.Background()
+
+	plan, err := o.PlanMigration(ctx, "etcd", "snap-verified", MigrationTarget{StorageClass: "fast", Size: "20Gi"})
+	require.NoError(t, err)
+	scaledDown(t, c, &plan.Plan)
+
+	o.runJob = func(context.Context, *batchv1.Job) error { return errors.New("job failed after 1 attempts") }
+	err = o.RunMigration(ctx, plan)
+	assert.ErrorContains(t, err, "scaled back up on its old PVCs")
+
+	sts := statefulSetOf(t, c)
+	assert.Equal(t, int32(3), *sts.Spec.Replicas)
+	assert.Equal(t, "data", sts.Spec.VolumeClaimTemplates[0].Name)
+}
@@ -0,0 +1,316 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// membership is the part of etcd.Membership a member replacement needs
+type membership interface {
+	ListMembers(ctx context.Context) ([]etcd.MemberStatus, error)
+	RemoveMember(ctx context.Context, id uint64) error
+	AddLearner(ctx context.Context, peerURLs []string) (uint64, error)
+	PromoteMember(ctx context.Context, id uint64) error
+	Close() error
+}
+
+// ReplacePlan is everything Replace does to one member, resolved up front so it can
+// be reviewed with a dry run
+type ReplacePlan struct {
+	SnapshotID  string     `json:"snapshot_id"`
+	ClusterName string     `json:"cluster_name"`
+	Namespace   string     `json:"namespace"`
+	StatefulSet string     `json:"statefulset"`
+	Member      MemberPlan `json:"member"`
+	// MemberID is the etcd member ID to remove; empty when it is no longer a member
+	MemberID string `json:"member_id,omitempty"`
+	// NodeName is where the member pod runs, so the seed job can mount its volume
+	NodeName  string `json:"node,omitempty"`
+	DataDir   string `json:"data_dir"`
+	BackupDir string `json:"backup_dir"`
+	// RestoreID tells the backup this replacement makes from those of earlier ones
+	RestoreID string `json:"restore_id"`
+	// Endpoints of the remaining members, which every membership change goes through
+	Endpoints            []string `json:"endpoints"`
+	VotingMembers        int      `json:"voting_members"`
+	HealthyVotingMembers int      `json:"healthy_voting_members"`
+	Warnings             []string `json:"warnings,omitempty"`
+
+	snapshot           *snapshot.SnapshotMetadata
+	podSecurityContext *corev1.PodSecurityContext
+	memberID           uint64
+}
+
+// PlanReplace resolves the member of the StatefulSet at ordinal, the snapshot to seed it
+// from and the remaining members. An empty snapshotID picks the newest usable snapshot
+// of the cluster. The plan is refused if removing the member would lose quorum.
+func (o *Orchestrator) PlanReplace(ctx context.Context, namespace, statefulSet string, ordinal int, snapshotID string) (*ReplacePlan, error) {
+	sts, err := o.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get StatefulSet %s/%s: %w", namespace, statefulSet, err)
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if ordinal < 0 || ordinal >= int(replicas) {
+		return nil, fmt.Errorf("StatefulSet %s/%s has no member %d", namespace, statefulSet, ordinal)
+	}
+
+	dataVolume, err := o.dataVolume(sts)
+	if err != nil {
+		return nil, err
+	}
+	pvcName := memberPVCName(dataVolume, statefulSet, ordinal)
+	podName := fmt.Sprintf("%s-%d", statefulSet, ordinal)
+
+	info, err := o.discovery.DiscoverCluster(ctx, namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *etcd.MemberInfo
+	var endpoints []string
+	for i, m := range info.Members {
+		if m.PodName == podName {
+			target = &info.Members[i]
+			continue
+		}
+		endpoints = append(endpoints, m.ClientURLs...)
+	}
+	if target == nil {
+		return nil, fmt.Errorf("member pod %s/%s not found; the pod must exist so its settings can be discovered", namespace, podName)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("cluster %s has no other members to rejoin; use snapshot restore instead", info.Name)
+	}
+
+	s, err := o.replacementSnapshot(ctx, namespace, info.Name, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReplacePlan{
+		SnapshotID:  s.SnapshotID,
+		ClusterName: info.Name,
+		Namespace:   namespace,
+		StatefulSet: statefulSet,
+		Member: MemberPlan{
+			Ordinal:  ordinal,
+			PodName:  podName,
+			Name:     target.Name,
+			PVCName:  pvcName,
+			PeerURLs: target.PeerURLs,
+		},
+		DataDir:            o.cfg.DataDir,
+		BackupDir:          job.MemberBackupDir(o.cfg.DataDir, s.SnapshotID),
+		RestoreID:          fmt.Sprintf("%s-%d-%d", statefulSet, ordinal, time.Now().Unix()),
+		Endpoints:          endpoints,
+		snapshot:           s,
+		podSecurityContext: sts.Spec.Template.Spec.SecurityContext,
+	}
+
+	pod, err := o.k8sClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member pod %s/%s: %w", namespace, podName, err)
+	}
+	plan.NodeName = pod.Spec.NodeName
+
+	if state := target.InitialClusterState; state != "existing" {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+			"pod %s starts etcd with initial-cluster-state %q; the seeded member only rejoins if etcd starts with existing when its data dir has no WAL", podName, state))
+	}
+
+	m, err := o.membership(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	members, err := m.ListMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targetVotes := false
+	for _, member := range members {
+		isTarget := member.Name == target.Name || overlaps(member.PeerURLs, target.PeerURLs)
+		switch {
+		case isTarget:
+			plan.memberID = member.ID
+			plan.MemberID = fmt.Sprintf("%x", member.ID)
+			plan.Member.PeerURLs = member.PeerURLs
+			targetVotes = !member.IsLearner
+		case member.IsLearner:
+			// etcd only allows one learner at a time by default
+			return nil, fmt.Errorf("cluster %s already has learner %s (%x); promote or remove it first", info.Name, member.Name, member.ID)
+		default:
+			if member.Healthy {
+				plan.HealthyVotingMembers++
+			}
+		}
+		if !member.IsLearner {
+			plan.VotingMembers++
+		}
+	}
+
+	remaining := plan.VotingMembers
+	if targetVotes {
+		remaining--
+	}
+	if remaining == 0 {
+		return nil, fmt.Errorf("cluster %s has no other voting members; use snapshot restore instead", info.Name)
+	}
+	if quorum := remaining/2 + 1; plan.HealthyVotingMembers < quorum {
+		return nil, fmt.Errorf("only %d of the %d remaining voting members of cluster %s are healthy and %d are needed for quorum; use snapshot restore instead",
+			plan.HealthyVotingMembers, remaining, info.Name, quorum)
+	}
+
+	return plan, nil
+}
+
+// Replace removes the member from the cluster, seeds its data dir from the snapshot,
+// adds it back as a learner and promotes it once it has caught up with the leader.
+// The member pod is restarted so it boots from the seeded data dir.
+func (o *Orchestrator) Replace(ctx context.Context, plan *ReplacePlan) error {
+	o.logger.Infow("Replacing etcd member",
+		"member", plan.Member.Name,
+		"snapshot_id", plan.SnapshotID,
+		"statefulset", plan.StatefulSet,
+		"namespace", plan.Namespace,
+	)
+
+	// The snapshot is made readable before the member is removed
+	seedPlan := &Plan{
+		SnapshotID:         plan.SnapshotID,
+		Namespace:          plan.Namespace,
+		DataDir:            plan.DataDir,
+		RestoreID:          plan.RestoreID,
+		snapshot:           plan.snapshot,
+		podSecurityContext: plan.podSecurityContext,
+	}
+	release, err := o.readSnapshot(ctx, seedPlan)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	m, err := o.membership(plan.Endpoints)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if plan.memberID != 0 {
+		o.logger.Infow("Removing member", "member", plan.Member.Name, "member_id", plan.MemberID)
+		if err := m.RemoveMember(ctx, plan.memberID); err != nil {
+			return err
+		}
+	}
+
+	cfg := o.jobConfig(seedPlan, plan.Member)
+	cfg.Operation = "seed"
+	cfg.NodeName = plan.NodeName
+	if err := o.runMemberJob(ctx, job.GenerateMemberSeedJob, cfg); err != nil {
+		return fmt.Errorf("member %s was removed from the cluster, but seeding its data dir failed: %w; rerun the replacement, or add it back with an empty data dir", plan.Member.Name, err)
+	}
+
+	id, err := m.AddLearner(ctx, plan.Member.PeerURLs)
+	if err != nil {
+		return fmt.Errorf("member %s was seeded but could not rejoin: %w", plan.Member.Name, err)
+	}
+
+	// The pod restarts from the seeded data dir rather than waiting out its crash loop
+	err = o.k8sClient.CoreV1().Pods(plan.Namespace).Delete(ctx, plan.Member.PodName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to restart member pod %s: %w", plan.Member.PodName, err)
+	}
+
+	// etcd refuses to promote a learner until it has caught up with the leader
+	var promoteErr error
+	err = wait.PollUntilContextTimeout(ctx, o.pollInterval, o.cfg.PromoteTimeout, true, func(ctx context.Context) (bool, error) {
+		promoteErr = m.PromoteMember(ctx, id)
+		return promoteErr == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("learner %s (%x) did not catch up to be promoted: %w (last error: %v)", plan.Member.Name, id, err, promoteErr)
+	}
+
+	o.logger.Infow("Etcd member replaced",
+		"member", plan.Member.Name,
+		"member_id", fmt.Sprintf("%x", id),
+		"snapshot_id", plan.SnapshotID,
+	)
+	return nil
+}
+
+// replacementSnapshot returns the named snapshot, or the newest ready snapshot of the
+// cluster that the driver has not seen fail a restore drill
+func (o *Orchestrator) replacementSnapshot(ctx context.Context, namespace, clusterName, snapshotID string) (*snapshot.SnapshotMetadata, error) {
+	var s *snapshot.SnapshotMetadata
+	if snapshotID != "" {
+		var err error
+		s, err = o.manager.RetrieveSnapshotMetadata(ctx, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		if s.ClusterName != "" && s.ClusterName != clusterName {
+			return nil, fmt.Errorf("snapshot %s was taken from cluster %s, not %s", s.SnapshotID, s.ClusterName, clusterName)
+		}
+		if s.Namespace != namespace {
+			return nil, fmt.Errorf("snapshot %s is stored in namespace %s, not %s", s.SnapshotID, s.Namespace, namespace)
+		}
+	} else {
+		snapshots, err := o.manager.ListSnapshotMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range snapshots {
+			if candidate.ClusterName != clusterName || candidate.Namespace != namespace || !candidate.ReadyToUse || candidate.Encryption != nil {
+				continue
+			}
+			if candidate.RestoreDrill != nil && !candidate.RestoreDrill.Passed {
+				continue
+			}
+			// Strict mode only ever seeds from signed snapshots
+			if o.cfg.Signatures != nil && o.cfg.Signatures.Strict && candidate.Signature == nil {
+				continue
+			}
+			if s == nil || candidate.CreationTime.After(s.CreationTime) {
+				s = candidate
+			}
+		}
+		if s == nil {
+			return nil, fmt.Errorf("no usable snapshot of cluster %s in namespace %s", clusterName, namespace)
+		}
+	}
+
+	// The data key of encrypted snapshots is only ever unwrapped by the driver
+	if s.Encryption != nil {
+		return nil, fmt.Errorf("snapshot %s is encrypted with key %q; seeding from encrypted snapshots is not supported yet", s.SnapshotID, s.Encryption.KeyID)
+	}
+	if err := o.cfg.Signatures.Check(ctx, o.logger, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func overlaps(a, b []string) bool {
+	for _, u := range a {
+		if slices.Contains(b, u) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeMembership records membership changes made through it
+type fakeMembership struct {
+	members       []etcd.MemberStatus
+	calls         []string
+	promoteErrors int
+}
+
+func (f *fakeMembership) ListMembers(context.Context) ([]etcd.MemberStatus, error) {
+	return slices.Clone(f.members), nil
+}
+
+func (f *fakeMembership) RemoveMember(_ context.Context, id uint64) error {
+	f.calls = append(f.calls, "remove")
+	f.members = slices.DeleteFunc(f.members, func(m etcd.MemberStatus) bool { return m.ID == id })
+	return nil
+}
+
+func (f *fakeMembership) AddLearner(_ context.Context, peerURLs []string) (uint64, error) {
+	f.calls = append(f.calls, "add-learner")
+	f.members = append(f.members, etcd.MemberStatus{ID: 99, PeerURLs: peerURLs, IsLearner: true})
+	return 99, nil
+}
+
+func (f *fakeMembership) PromoteMember(_ context.Context, id uint64) error {
+	f.calls = append(f.calls, "promote")
+	if f.promoteErrors > 0 {
+		f.promoteErrors--
+		return errors.New("etcdserver: can only promote a learner member which is in sync with leader")
+	}
+	return nil
+}
+
+func (f *fakeMembership) Close() error { return nil }
+
+func threeMembers(healthy ...bool) []etcd.MemberStatus {
+	members := make([]etcd.MemberStatus, 0, 3)
+	for i, name := range []string{"etcd-0", "etcd-1", "etcd-2"} {
+		members = append(members, etcd.MemberStatus{
+			ID:       uint64(i + 1),
+			Name:     name,
+			PeerURLs: []string{"https://" + name + ".etcd.svc.cluster.local:2380"},
+			Healthy:  healthy[i],
+		})
+	}
+	return members
+}
+
+func newTestReplace(t *testing.T, members []etcd.MemberStatus) (*Orchestrator, *fake.Clientset, *fakeMembership) {
+	t.Helper()
+
+	o, c := newTestOrchestrator(t, 3)
+	now := time.Now()
+	for _, s := range []*snapshot.SnapshotMetadata{
+		{SnapshotID: "snap-old", CreationTime: now.Add(-2 * time.Hour), ReadyToUse: true},
+		{SnapshotID: "snap-new", CreationTime: now.Add(-time.Hour), ReadyToUse: true},
+		{SnapshotID: "snap-failed-drill", CreationTime: now, ReadyToUse: true, RestoreDrill: &snapshot.RestoreDrillStatus{Passed: false}},
+		{SnapshotID: "snap-encrypted", CreationTime: now, ReadyToUse: true, Encryption: &snapshot.EncryptionInfo{KeyID: "k"}},
+	} {
+		s.ClusterName = "main"
+		s.Namespace = testNamespace
+		s.PVCName = "etcd-snapshots"
+		require.NoError(t, o.manager.StoreSnapshotMetadata(context.Background(), s))
+	}
+
+	m := &fakeMembership{members: members}
+	o.membership = func(endpoints []string) (membership, error) {
+		assert.NotContains(t, endpoints, "https://etcd-1.etcd.svc.cluster.local:2379")
+		return m, nil
+	}
+	return o, c, m
+}
+
+func TestPlanReplace(t *testing.T) {
+	o, _, _ := newTestReplace(t, threeMembers(true, false, true))
+
+	plan, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "snap-new", plan.SnapshotID)
+	assert.Equal(t, "etcd-1", plan.Member.Name)
+	assert.Equal(t, "data-etcd-1", plan.Member.PVCName)
+	assert.Equal(t, "2", plan.MemberID)
+	assert.Equal(t, 3, plan.VotingMembers)
+	assert.Equal(t, 2, plan.HealthyVotingMembers)
+	assert.Len(t, plan.Endpoints, 2)
+	assert.Len(t, plan.Warnings, 1)
+
+	_, err = o.PlanReplace(context.Background(), testNamespace, "etcd", 3, "")
+	assert.ErrorContains(t, err, "has no member 3")
+
+	_, err = o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "snap-encrypted")
+	assert.ErrorContains(t, err, "encrypted")
+}
+
+func TestPlanReplaceRefusesToLoseQuorum(t *testing.T) {
+	o, _, _ := newTestReplace(t, threeMembers(true, false, false))
+
+	_, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "")
+	assert.ErrorContains(t, err, "only 1 of the 2 remaining voting members")
+}
+
+func TestPlanReplaceRefusesSecondLearner(t *testing.T) {
+	members := threeMembers(true, false, true)
+	members = append(members, etcd.MemberStatus{ID: 7, Name: "etcd-3", IsLearner: true})
+	o, _, _ := newTestReplace(t, members)
+
+	_, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "")
+	assert.ErrorContains(t, err, "already has learner etcd-3")
+}
+
+func TestReplace(t *testing.T) {
+	o, c, m := newTestReplace(t, threeMembers(true, false, true))
+	m.promoteErrors = 2
+
+	plan, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "snap-old")
+	require.NoError(t, err)
+
+	var jobs []*batchv1.Job
+	o.runJob = func(_ context.Context, j *batchv1.Job) error {
+		// The member is seeded after it has been removed
+		assert.Equal(t, []string{"remove"}, m.calls)
+		jobs = append(jobs, j)
+		return nil
+	}
+
+	require.NoError(t, o.Replace(context.Background(), plan))
+	assert.Equal(t, []string{"remove", "add-learner", "promote", "promote", "promote"}, m.calls)
+
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "etcd-snapshot-seed-1-snap-old", jobs[0].Name)
+	assert.Equal(t, "data-etcd-1", jobs[0].Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName)
+
+	_, err = c.CoreV1().Pods(testNamespace).Get(context.Background(), "etcd-1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "member pod should be restarted")
+}
+
+func TestReplaceRestartsInterruptedReplacement(t *testing.T) {
+	// etcd-1 was re-added as a learner by an interrupted replacement, so that
+	// learner is removed and the member seeded again
+	members := threeMembers(true, false, true)
+	members[1] = etcd.MemberStatus{ID: 42, PeerURLs: members[1].PeerURLs, IsLearner: true}
+	o, _, m := newTestReplace(t, members)
+
+	plan, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "2a", plan.MemberID)
+
+	o.runJob = func(context.Context, *batchv1.Job) error { return nil }
+	require.NoError(t, o.Replace(context.Background(), plan))
+	assert.Equal(t, []string{"remove", "add-learner", "promote"}, m.calls)
+}
+
+func TestReplaceSeedFailure(t *testing.T) {
+	o, _, m := newTestReplace(t, threeMembers(true, false, true))
+	plan, err := o.PlanReplace(context.Background(), testNamespace, "etcd", 1, "")
+	require.NoError(t, err)
+
+	o.runJob = func(context.Context, *batchv1.Job) error { return errors.New("job failed after 1 attempts") }
+	err = o.Replace(context.Background(), plan)
+	assert.ErrorContains(t, err, "was removed from the cluster, but seeding its data dir failed")
+	assert.Equal(t, []string{"remove"}, m.calls)
+}
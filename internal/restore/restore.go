@@ -0,0 +1,472 @@
+// Package restore restores a snapshot into every member of an etcd StatefulSet,
+// or seeds a single replacement member from one.
+package restore
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/etcd"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Config controls how a StatefulSet is restored
+type Config struct {
+	DriverName      string
+	ClusterLabelKey string
+	// DataVolume names the StatefulSet volumeClaimTemplate holding etcd data; empty picks the only one
+	DataVolume string
+	// DataDir is the etcd data dir relative to the root of each member PVC
+	DataDir string
+	// InitialClusterToken for the restored cluster; empty derives one from the StatefulSet and snapshot
+	InitialClusterToken string
+	AgentImage          string
+	// JobTimeout bounds each restore and rollback job
+	JobTimeout time.Duration
+	// ScaleTimeout bounds how long the StatefulSet may take to scale down
+	ScaleTimeout time.Duration
+	// TLSConfig is used to reach etcd when replacing a member; nil for plain connections
+	TLSConfig *tls.Config
+	// PromoteTimeout bounds how long a replaced member may take to catch up
+	PromoteTimeout time.Duration
+	// Signatures decides whether snapshots that are unsigned or whose signature does not
+	// match may be restored; nil allows every snapshot
+	Signatures *snapshot.SignaturePolicy
+}
+
+// MemberPlan is the restore of one member
+type MemberPlan struct {
+	Ordinal  int      `json:"ordinal"`
+	PodName  string   `json:"pod"`
+	Name     string   `json:"name"`
+	PVCName  string   `json:"pvc"`
+	PeerURLs []string `json:"peer_urls"`
+}
+
+// Plan is everything Run does, resolved up front so it can be reviewed with a dry run
+type Plan struct {
+	SnapshotID          string `json:"snapshot_id"`
+	ClusterName         string `json:"cluster_name"`
+	Namespace           string `json:"namespace"`
+	StatefulSet         string `json:"statefulset"`
+	Replicas            int32  `json:"replicas"`
+	InitialCluster      string `json:"initial_cluster"`
+	InitialClusterToken string `json:"initial_cluster_token"`
+	DataDir             string `json:"data_dir"`
+	BackupDir           string `json:"backup_dir"`
+	// RestoreID tells the backups this restore makes from those of earlier restores
+	RestoreID string       `json:"restore_id"`
+	Members   []MemberPlan `json:"members"`
+
+	snapshot           *snapshot.SnapshotMetadata
+	podSecurityContext *corev1.PodSecurityContext
+	// snapshotPVCName is the PVC the snapshot file is read from while the plan runs
+	snapshotPVCName string
+}
+
+// Quorum is the number of members the restored cluster needs to make progress
+func (p *Plan) Quorum() int {
+	return len(p.Members)/2 + 1
+}
+
+// Orchestrator restores snapshots into etcd StatefulSets
+type Orchestrator struct {
+	k8sClient kubernetes.Interface
+	logger    *zap.SugaredLogger
+	cfg       Config
+	manager   *snapshot.Manager
+	discovery *etcd.Discovery
+	executor  *job.Executor
+
+	// runJob runs a job to completion and membership connects to etcd; replaced in tests
+	runJob       func(ctx context.Context, j *batchv1.Job) error
+	membership   func(endpoints []string) (membership, error)
+	pollInterval time.Duration
+}
+
+func NewOrchestrator(k8sClient kubernetes.Interface, logger *zap.SugaredLogger, manager *snapshot.Manager, cfg Config) *Orchestrator {
+	if cfg.JobTimeout <= 0 {
+		cfg.JobTimeout = 10 * time.Minute
+	}
+	if cfg.ScaleTimeout <= 0 {
+		cfg.ScaleTimeout = 5 * time.Minute
+	}
+	if cfg.PromoteTimeout <= 0 {
+		cfg.PromoteTimeout = 10 * time.Minute
+	}
+
+	o := &Orchestrator{
+		k8sClient:    k8sClient,
+		logger:       logger,
+		cfg:          cfg,
+		manager:      manager,
+		discovery:    etcd.NewDiscovery(k8sClient, logger, cfg.ClusterLabelKey),
+		executor:     job.NewExecutor(k8sClient, logger),
+		pollInterval: 2 * time.Second,
+	}
+
+	o.runJob = func(ctx context.Context, j *batchv1.Job) error {
+		if err := o.deleteStaleJob(ctx, j); err != nil {
+			return err
+		}
+		_, err := o.executor.ExecuteSnapshotJob(ctx, j, cfg.JobTimeout)
+		return err
+	}
+
+	healthValidator := etcd.NewHealthValidator(logger, cfg.TLSConfig)
+	o.membership = func(endpoints []string) (membership, error) {
+		return healthValidator.Membership(endpoints)
+	}
+
+	return o
+}
+
+// Plan resolves the members of the StatefulSet, their PVCs and the restored cluster's
+// configuration. The etcd pods must still be running so their settings can be discovered.
+func (o *Orchestrator) Plan(ctx context.Context, statefulSet, snapshotID string) (*Plan, error) {
+	s, err := o.manager.RetrieveSnapshotMetadata(ctx, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	// The data key of encrypted snapshots is only ever unwrapped by the driver
+	if s.Encryption != nil {
+		return nil, fmt.Errorf("snapshot %s is encrypted with key %q; restoring encrypted snapshots is not supported yet", s.SnapshotID, s.Encryption.KeyID)
+	}
+	if err := o.cfg.Signatures.Check(ctx, o.logger, s); err != nil {
+		return nil, err
+	}
+
+	// Restore jobs mount the snapshot PVC, so the StatefulSet must live next to it
+	namespace := s.Namespace
+	sts, err := o.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get StatefulSet %s/%s: %w", namespace, statefulSet, err)
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if replicas == 0 {
+		return nil, fmt.Errorf("StatefulSet %s/%s is scaled to 0; scale it up so its members can be discovered", namespace, statefulSet)
+	}
+
+	dataVolume, err := o.dataVolume(sts)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := o.discovery.DiscoverCluster(ctx, namespace, memberPVCName(dataVolume, statefulSet, 0))
+	if err != nil {
+		return nil, err
+	}
+	if s.ClusterName != "" && info.Name != s.ClusterName {
+		return nil, fmt.Errorf("snapshot %s was taken from cluster %s, but StatefulSet %s runs cluster %s", s.SnapshotID, s.ClusterName, statefulSet, info.Name)
+	}
+
+	byPod := make(map[string]etcd.MemberInfo, len(info.Members))
+	for _, m := range info.Members {
+		byPod[m.PodName] = m
+	}
+
+	plan := &Plan{
+		SnapshotID:          s.SnapshotID,
+		ClusterName:         info.Name,
+		Namespace:           namespace,
+		StatefulSet:         statefulSet,
+		Replicas:            replicas,
+		InitialClusterToken: o.cfg.InitialClusterToken,
+		DataDir:             o.cfg.DataDir,
+		BackupDir:           job.MemberBackupDir(o.cfg.DataDir, s.SnapshotID),
+		RestoreID:           fmt.Sprintf("%s-%d", statefulSet, time.Now().Unix()),
+		snapshot:            s,
+		podSecurityContext:  sts.Spec.Template.Spec.SecurityContext,
+	}
+	if plan.InitialClusterToken == "" {
+		plan.InitialClusterToken = fmt.Sprintf("%s-%s", statefulSet, s.SnapshotID)
+	}
+
+	members := make([]etcd.MemberInfo, 0, replicas)
+	for i := 0; i < int(replicas); i++ {
+		podName := fmt.Sprintf("%s-%d", statefulSet, i)
+		m, ok := byPod[podName]
+		if !ok {
+			return nil, fmt.Errorf("member pod %s/%s not found; every member must be running to plan a restore", namespace, podName)
+		}
+		members = append(members, m)
+		plan.Members = append(plan.Members, MemberPlan{
+			Ordinal:  i,
+			PodName:  podName,
+			Name:     m.Name,
+			PVCName:  memberPVCName(dataVolume, statefulSet, i),
+			PeerURLs: m.PeerURLs,
+		})
+	}
+	plan.InitialCluster = etcd.InitialCluster(members)
+
+	return plan, nil
+}
+
+// Run scales the StatefulSet down, restores every member in order and scales it back up.
+// When a member fails, the members restored so far are rolled back. The StatefulSet is only
+// scaled back up if a quorum of members is left with consistent data, so a partial rollback
+// never starts a cluster that mixes restored and original members.
+func (o *Orchestrator) Run(ctx context.Context, plan *Plan) error {
+	o.logger.Infow("Restoring snapshot into StatefulSet",
+		"snapshot_id", plan.SnapshotID,
+		"statefulset", plan.StatefulSet,
+		"namespace", plan.Namespace,
+		"members", len(plan.Members),
+	)
+
+	release, err := o.readSnapshot(ctx, plan)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := o.scale(ctx, plan, 0); err != nil {
+		return fmt.Errorf("failed to scale down StatefulSet: %w", err)
+	}
+	if err := o.waitForPodsGone(ctx, plan); err != nil {
+		// Nothing has been touched yet
+		if scaleErr := o.scale(context.Background(), plan, plan.Replicas); scaleErr != nil {
+			o.logger.Warnw("Failed to scale StatefulSet back up", "error", scaleErr)
+		}
+		return fmt.Errorf("StatefulSet did not scale down: %w", err)
+	}
+
+	for i, m := range plan.Members {
+		o.logger.Infow("Restoring member", "member", m.Name, "pvc", m.PVCName)
+		if err := o.runMemberJob(ctx, job.GenerateMemberRestoreJob, o.jobConfig(plan, m)); err != nil {
+			return o.rollback(plan, plan.Members[:i+1], m, err)
+		}
+	}
+
+	if err := o.scale(ctx, plan, plan.Replicas); err != nil {
+		return fmt.Errorf("members restored, but failed to scale StatefulSet back to %d replicas: %w", plan.Replicas, err)
+	}
+
+	o.logger.Infow("Snapshot restored into StatefulSet",
+		"snapshot_id", plan.SnapshotID,
+		"statefulset", plan.StatefulSet,
+	)
+	return nil
+}
+
+// rollback restores the previous data dir of every attempted member, newest first
+func (o *Orchestrator) rollback(plan *Plan, attempted []MemberPlan, failed MemberPlan, cause error) error {
+	// The restore may have failed because ctx was cancelled; rolling back must still happen
+	ctx := context.Background()
+
+	o.logger.Warnw("Member restore failed, rolling back",
+		"member", failed.Name,
+		"attempted", len(attempted),
+		"error", cause,
+	)
+
+	var stuck []string
+	for i := len(attempted) - 1; i >= 0; i-- {
+		m := attempted[i]
+		// Rollback jobs only mount the member PVC
+		cfg := o.jobConfig(plan, m)
+		cfg.SnapshotPVCName = ""
+		if err := o.runMemberJob(ctx, job.GenerateMemberRollbackJob, cfg); err != nil {
+			o.logger.Errorw("Member rollback failed", "member", m.Name, "error", err)
+			stuck = append(stuck, m.Name)
+		}
+	}
+
+	intact := len(plan.Members) - len(stuck)
+	if intact < plan.Quorum() {
+		return fmt.Errorf("restore of member %s failed: %w; rollback failed for %s, leaving StatefulSet %s scaled to 0 because only %d of %d members hold their original data (quorum is %d); move %s back to %s on those PVCs by hand",
+			failed.Name, cause, strings.Join(stuck, ", "), plan.StatefulSet, intact, len(plan.Members), plan.Quorum(), plan.BackupDir, plan.DataDir)
+	}
+
+	if err := o.scale(ctx, plan, plan.Replicas); err != nil {
+		return errors.Join(
+			fmt.Errorf("restore of member %s failed: %w; members rolled back", failed.Name, cause),
+			fmt.Errorf("failed to scale StatefulSet back to %d replicas: %w", plan.Replicas, err),
+		)
+	}
+
+	if len(stuck) > 0 {
+		return fmt.Errorf("restore of member %s failed: %w; rolled back and scaled back up with quorum, but %s could not be rolled back and must be replaced",
+			failed.Name, cause, strings.Join(stuck, ", "))
+	}
+	return fmt.Errorf("restore of member %s failed: %w; rolled back %d member(s) and scaled StatefulSet back to %d replicas",
+		failed.Name, cause, len(attempted), plan.Replicas)
+}
+
+func (o *Orchestrator) jobConfig(plan *Plan, m MemberPlan) *job.JobConfig {
+	return &job.JobConfig{
+		DriverName:            o.cfg.DriverName,
+		SnapshotID:            plan.SnapshotID,
+		Namespace:             plan.Namespace,
+		SnapshotPVCName:       plan.snapshotPVCName,
+		SnapshotPVCNamespace:  plan.Namespace,
+		BackoffLimit:          1,
+		ActiveDeadlineSeconds: int64(o.cfg.JobTimeout.Seconds()),
+		Operation:             "restore",
+		Format:                plan.snapshot.Format(),
+		ObjectStore:           plan.snapshot.ObjectStore,
+		ChecksumSHA256:        plan.snapshot.ChecksumSHA256,
+		AgentImage:            o.cfg.AgentImage,
+		MemberOrdinal:         m.Ordinal,
+		MemberName:            m.Name,
+		MemberPVCName:         m.PVCName,
+		MemberPeerURLs:        m.PeerURLs,
+		InitialCluster:        plan.InitialCluster,
+		InitialClusterToken:   plan.InitialClusterToken,
+		DataDir:               plan.DataDir,
+		RestoreID:             plan.RestoreID,
+		PodSecurityContext:    plan.podSecurityContext,
+	}
+}
+
+// runMemberJob runs a job that mounts a member PVC and, unless it only rolls back, the
+// snapshot PVC
+func (o *Orchestrator) runMemberJob(ctx context.Context, generate func(*job.JobConfig) *batchv1.Job, cfg *job.JobConfig) error {
+	release, err := o.prepareMemberJob(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return o.runJob(ctx, generate(cfg))
+}
+
+// prepareMemberJob pins a member job to the node its ReadWriteOnce PVCs are attached to,
+// if any. The snapshot PVC may be shared with the driver's jobs and admin commands, so it
+// is locked until the returned function releases it. Member PVCs are only mounted while
+// their member is stopped or being replaced and are not locked.
+func (o *Orchestrator) prepareMemberJob(ctx context.Context, cfg *job.JobConfig) (func(), error) {
+	release := func() {}
+	if cfg.SnapshotPVCName != "" {
+		unlock, err := o.executor.LockPVC(ctx, cfg.SnapshotPVCNamespace, cfg.SnapshotPVCName)
+		if err != nil {
+			return nil, err
+		}
+		release = unlock
+	}
+
+	for _, pvcName := range []string{cfg.SnapshotPVCName, cfg.MemberPVCName} {
+		if pvcName == "" {
+			continue
+		}
+		nodeName, err := o.executor.AttachmentNode(ctx, cfg.Namespace, pvcName)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if nodeName == "" || nodeName == cfg.NodeName {
+			continue
+		}
+		if cfg.NodeName != "" {
+			release()
+			return nil, fmt.Errorf("PVC %s/%s is attached to node %s, but job %s has to run on node %s",
+				cfg.Namespace, pvcName, nodeName, cfg.Operation, cfg.NodeName)
+		}
+		cfg.NodeName = nodeName
+	}
+
+	return release, nil
+}
+
+// readSnapshot resolves the PVC the plan's snapshot file is read from. Snapshots kept in a
+// VolumeSnapshot are restored into a temporary PVC first, which the returned function deletes;
+// snapshots in the object store are fetched by each job and need no PVC.
+func (o *Orchestrator) readSnapshot(ctx context.Context, plan *Plan) (func(), error) {
+	names := config.NewNames(o.cfg.DriverName)
+	pvcName, release, err := snapshot.ReadablePVC(ctx, o.k8sClient, plan.snapshot,
+		names.SnapshotVolume(plan.SnapshotID)+"-restore", map[string]string{"app": names.AppLabel()})
+	if err != nil {
+		return nil, err
+	}
+	plan.snapshotPVCName = pvcName
+	return release, nil
+}
+
+func (o *Orchestrator) dataVolume(sts *appsv1.StatefulSet) (string, error) {
+	templates := sts.Spec.VolumeClaimTemplates
+	if o.cfg.DataVolume != "" {
+		for _, t := range templates {
+			if t.Name == o.cfg.DataVolume {
+				return t.Name, nil
+			}
+		}
+		return "", fmt.Errorf("StatefulSet %s has no volumeClaimTemplate %s", sts.Name, o.cfg.DataVolume)
+	}
+
+	if len(templates) != 1 {
+		return "", fmt.Errorf("StatefulSet %s has %d volumeClaimTemplates; choose the etcd data volume explicitly", sts.Name, len(templates))
+	}
+	return templates[0].Name, nil
+}
+
+func (o *Orchestrator) scale(ctx context.Context, plan *Plan, replicas int32) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		sts, err := o.k8sClient.AppsV1().StatefulSets(plan.Namespace).Get(ctx, plan.StatefulSet, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		sts.Spec.Replicas = &replicas
+		_, err = o.k8sClient.AppsV1().StatefulSets(plan.Namespace).Update(ctx, sts, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (o *Orchestrator) waitForPodsGone(ctx context.Context, plan *Plan) error {
+	return wait.PollUntilContextTimeout(ctx, o.pollInterval, o.cfg.ScaleTimeout, true, func(ctx context.Context) (bool, error) {
+		for _, m := range plan.Members {
+			_, err := o.k8sClient.CoreV1().Pods(plan.Namespace).Get(ctx, m.PodName, metav1.GetOptions{})
+			if err == nil {
+				return false, nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// deleteStaleJob removes a finished job of the same name from an earlier restore, which
+// the executor would otherwise mistake for this one
+func (o *Orchestrator) deleteStaleJob(ctx context.Context, j *batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+	err := o.k8sClient.BatchV1().Jobs(j.Namespace).Delete(ctx, j.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete stale job %s: %w", j.Name, err)
+	}
+
+	return wait.PollUntilContextTimeout(ctx, o.pollInterval, time.Minute, true, func(ctx context.Context) (bool, error) {
+		_, err := o.k8sClient.BatchV1().Jobs(j.Namespace).Get(ctx, j.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+func memberPVCName(dataVolume, statefulSet string, ordinal int) string {
+	return fmt.Sprintf("%s-%s-%d", dataVolume, statefulSet, ordinal)
+}
@@ -0,0 +1,333 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/job"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "etcd"
+
+func newTestOrchestrator(t *testing.T, replicas int32) (*Orchestrator, *fake.Clientset) {
+	t.Helper()
+
+	objects := []runtime.Object{
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: testNamespace},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+					{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+				},
+			},
+		},
+	}
+	for i := 0; i < int(replicas); i++ {
+		objects = append(objects,
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("data-etcd-%d", i), Namespace: testNamespace, Labels: map[string]string{"etcd.io/cluster": "main"},
+			}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("etcd-%d", i), Namespace: testNamespace, Labels: map[string]string{"etcd.io/cluster": "main"},
+			}},
+		)
+	}
+	k8sClient := fake.NewSimpleClientset(objects...)
+
+	logger := zap.NewNop().Sugar()
+	manager := snapshot.NewManager(k8sClient, logger, "kube-system", config.NewNames(""))
+	require.NoError(t, manager.StoreSnapshotMetadata(context.Background(), &snapshot.SnapshotMetadata{
+		SnapshotID:  "snap-1",
+		ClusterName: "main",
+		Namespace:   testNamespace,
+		PVCName:     "etcd-snapshots",
+		Compression: snapshot.CodecGzip,
+	}))
+
+	o := NewOrchestrator(k8sClient, logger, manager, Config{
+		ClusterLabelKey: "etcd.io/cluster",
+		DataDir:         "data",
+	})
+	o.pollInterval = time.Millisecond
+	return o, k8sClient
+}
+
+// scaledDown stands in for the StatefulSet controller removing the member pods
+func scaledDown(t *testing.T, c *fake.Clientset, plan *Plan) {
+	t.Helper()
+	for _, m := range plan.Members {
+		require.NoError(t, c.CoreV1().Pods(testNamespace).Delete(context.Background(), m.PodName, metav1.DeleteOptions{}))
+	}
+}
+
+func replicasOf(t *testing.T, c *fake.Clientset) int32 {
+	t.Helper()
+	sts, err := c.AppsV1().StatefulSets(testNamespace).Get(context.Background(), "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	return *sts.Spec.Replicas
+}
+
+func TestPlan(t *testing.T) {
+	o, _ := newTestOrchestrator(t, 3)
+
+	plan, err := o.Plan(context.Background(), "etcd", "snap-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(3), plan.Replicas)
+	assert.Equal(t, 2, plan.Quorum())
+	assert.Equal(t, "etcd-snap-1", plan.InitialClusterToken)
+	assert.Equal(t, "data.pre-restore-snap-1", plan.BackupDir)
+	require.Len(t, plan.Members, 3)
+	for i, m := range plan.Members {
+		assert.Equal(t, fmt.Sprintf("etcd-%d", i), m.Name)
+		assert.Equal(t, fmt.Sprintf("data-etcd-%d", i), m.PVCName)
+	}
+	assert.Equal(t,
+		"etcd-0=https://etcd-0.etcd.svc.cluster.local:2380,etcd-1=https://etcd-1.etcd.svc.cluster.local:2380,etcd-2=https://etcd-2.etcd.svc.cluster.local:2380",
+		plan.InitialCluster)
+
+	_, err = o.Plan(context.Background(), "missing", "snap-1")
+	assert.Error(t, err)
+}
+
+func TestPlanRejectsOtherCluster(t *testing.T) {
+	o, _ := newTestOrchestrator(t, 1)
+	require.NoError(t, o.manager.StoreSnapshotMetadata(context.Background(), &snapshot.SnapshotMetadata{
+		SnapshotID:  "snap-other",
+		ClusterName: "other",
+		Namespace:   testNamespace,
+	}))
+
+	_, err := o.Plan(context.Background(), "etcd", "snap-other")
+	assert.ErrorContains(t, err, "was taken from cluster other")
+}
+
+func TestPlanChecksSignatures(t *testing.T) {
+	ctx := context.Background()
+	o, c := newTestOrchestrator(t, 1)
+	_, err := c.CoreV1().Secrets("etcd-snapshot-driver").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-snapshot-signing-key", Namespace: "etcd-snapshot-driver"},
+		Data:       map[string][]byte{signing.PrivateKeyKey: []byte(strings.Repeat("01", 32))},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	keys := signing.NewSecretKeys(c, "etcd-snapshot-driver", "etcd-snapshot-signing-key")
+
+	signed := &snapshot.SnapshotMetadata{
+		SnapshotID:     "snap-signed",
+		ClusterName:    "main",
+		Namespace:      testNamespace,
+		ChecksumSHA256: "abc123",
+	}
+	require.NoError(t, signed.Sign(ctx, keys))
+	require.NoError(t, o.manager.StoreSnapshotMetadata(ctx, signed))
+
+	// The signature of one snapshot copied onto another
+	tampered := *signed
+	tampered.SnapshotID = "snap-tampered"
+	require.NoError(t, o.manager.StoreSnapshotMetadata(ctx, &tampered))
+
+	o.cfg.Signatures = &snapshot.SignaturePolicy{Verifier: keys, Strict: true}
+	_, err = o.Plan(ctx, "etcd", "snap-signed")
+	assert.NoError(t, err)
+	_, err = o.Plan(ctx, "etcd", "snap-1")
+	assert.ErrorIs(t, err, snapshot.ErrSnapshotUnsigned)
+	_, err = o.Plan(ctx, "etcd", "snap-tampered")
+	assert.ErrorIs(t, err, snapshot.ErrSignatureMismatch)
+
+	// Outside strict mode the snapshots are restored regardless
+	o.cfg.Signatures.Strict = false
+	for _, id := range []string{"snap-1", "snap-tampered"} {
+		_, err = o.Plan(ctx, "etcd", id)
+		assert.NoError(t, err, id)
+	}
+}
+
+func TestRun(t *testing.T) {
+	o, c := newTestOrchestrator(t, 3)
+	plan, err := o.Plan(context.Background(), "etcd", "snap-1")
+	require.NoError(t, err)
+	scaledDown(t, c, plan)
+
+	var jobs []*batchv1.Job
+	o.runJob = func(_ context.Context, j *batchv1.Job) error {
+		// Every member is restored while the StatefulSet is scaled down
+		assert.Equal(t, int32(0), replicasOf(t, c))
+		jobs = append(jobs, j)
+		return nil
+	}
+
+	require.NoError(t, o.Run(context.Background(), plan))
+	assert.Equal(t, int32(3), replicasOf(t, c))
+
+	require.Len(t, jobs, 3)
+	for i, j := range jobs {
+		assert.Equal(t, fmt.Sprintf("etcd-snapshot-restore-%d-snap-1", i), j.Name)
+		assert.Equal(t, fmt.Sprintf("data-etcd-%d", i), j.Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName)
+		assert.Contains(t, j.Spec.Template.Spec.Containers[0].Command, plan.InitialCluster)
+	}
+}
+
+// mountPVC stands in for a running pod on nodeName that mounts a PVC
+func mountPVC(t *testing.T, c *fake.Clientset, podName, nodeName, pvcName string) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := c.CoreV1().Nodes().Create(ctx, &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		require.NoError(t, err)
+	}
+	_, err = c.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: testNamespace},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestRunPinsJobsToSnapshotPVC(t *testing.T) {
+	ctx := context.Background()
+	o, c := newTestOrchestrator(t, 3)
+	plan, err := o.Plan(ctx, "etcd", "snap-1")
+	require.NoError(t, err)
+	scaledDown(t, c, plan)
+	mountPVC(t, c, "snapshot-reader", "node-a", "etcd-snapshots")
+
+	o.runJob = func(ctx context.Context, j *batchv1.Job) error {
+		affinity := j.Spec.Template.Spec.Affinity
+		require.NotNil(t, affinity)
+		assert.Equal(t, []string{"node-a"},
+			affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values)
+
+		// The snapshot PVC is locked against the driver's jobs while the member is restored
+		leases, err := c.CoordinationV1().Leases(testNamespace).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, leases.Items, 1)
+		return nil
+	}
+	require.NoError(t, o.Run(ctx, plan))
+
+	leases, err := c.CoordinationV1().Leases(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, leases.Items)
+}
+
+func TestPrepareMemberJobRejectsSplitAttachments(t *testing.T) {
+	ctx := context.Background()
+	o, c := newTestOrchestrator(t, 1)
+	mountPVC(t, c, "snapshot-reader", "node-a", "etcd-snapshots")
+	mountPVC(t, c, "lingering", "node-b", "data-etcd-0")
+
+	_, err := o.prepareMemberJob(ctx, &job.JobConfig{
+		Namespace:            testNamespace,
+		SnapshotPVCName:      "etcd-snapshots",
+		SnapshotPVCNamespace: testNamespace,
+		MemberPVCName:        "data-etcd-0",
+		Operation:            "restore",
+	})
+	assert.ErrorContains(t, err, "attached to node node-b, but job restore has to run on node node-a")
+
+	// The lock is given up with the job
+	leases, err := c.CoordinationV1().Leases(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, leases.Items)
+}
+
+func TestRunRollsBack(t *testing.T) {
+	tests := []struct {
+		name             string
+		failRestore      int
+		failRollback     map[int]bool
+		expectedReplicas int32
+		expectedJobs     []string
+		expectedErr      string
+	}{
+		{
+			name:             "rolled back with quorum",
+			failRestore:      2,
+			expectedReplicas: 3,
+			expectedJobs: []string{
+				"etcd-snapshot-restore-0-snap-1",
+				"etcd-snapshot-restore-1-snap-1",
+				"etcd-snapshot-restore-2-snap-1",
+				"etcd-snapshot-rollback-2-snap-1",
+				"etcd-snapshot-rollback-1-snap-1",
+				"etcd-snapshot-rollback-0-snap-1",
+			},
+			expectedErr: "rolled back 3 member(s)",
+		},
+		{
+			name:             "one stuck member keeps quorum",
+			failRestore:      1,
+			failRollback:     map[int]bool{0: true},
+			expectedReplicas: 3,
+			expectedErr:      "etcd-0 could not be rolled back",
+		},
+		{
+			name:             "quorum lost",
+			failRestore:      2,
+			failRollback:     map[int]bool{0: true, 1: true},
+			expectedReplicas: 0,
+			expectedErr:      "only 1 of 3 members",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, c := newTestOrchestrator(t, 3)
+			plan, err := o.Plan(context.Background(), "etcd", "snap-1")
+			require.NoError(t, err)
+			scaledDown(t, c, plan)
+
+			var jobs []string
+			o.runJob = func(_ context.Context, j *batchv1.Job) error {
+				jobs = append(jobs, j.Name)
+				for i := range plan.Members {
+					if j.Name == fmt.Sprintf("etcd-snapshot-restore-%d-snap-1", i) && i == tt.failRestore {
+						return errors.New("job failed after 1 attempts")
+					}
+					if j.Name == fmt.Sprintf("etcd-snapshot-rollback-%d-snap-1", i) && tt.failRollback[i] {
+						return errors.New("job failed after 1 attempts")
+					}
+				}
+				return nil
+			}
+
+			err = o.Run(context.Background(), plan)
+			assert.ErrorContains(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedReplicas, replicasOf(t, c))
+			if tt.expectedJobs != nil {
+				assert.Equal(t, tt.expectedJobs, jobs)
+			}
+		})
+	}
+}
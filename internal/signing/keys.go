@@ -0,0 +1,174 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PrivateKeyKey is the Secret entry holding the signing key
+	PrivateKeyKey = "private-key"
+	// PublicKeySuffix marks Secret entries holding further trusted public keys, such as
+	// those of retired signing keys
+	PublicKeySuffix = ".pub"
+)
+
+// SecretKeys keeps the signing key in a Kubernetes Secret. The private-key entry is
+// an Ed25519 private key, either PEM (PKCS #8) or a 32-byte seed that is raw, hex or
+// base64 encoded. Signatures made with it or with any public key in a *.pub entry are
+// trusted, so snapshots signed before a rotation still verify. The Secret is read on
+// each use.
+type SecretKeys struct {
+	k8sClient kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretKeys creates signing keys backed by the given Secret
+func NewSecretKeys(k8sClient kubernetes.Interface, namespace, name string) *SecretKeys {
+	return &SecretKeys{
+		k8sClient: k8sClient,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (k *SecretKeys) Sign(ctx context.Context, manifest Manifest) (*Signature, error) {
+	data, err := k.secretData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := data[PrivateKeyKey]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found in secret %s/%s", PrivateKeyKey, k.namespace, k.name)
+	}
+	privateKey, err := ParsePrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key in secret %s/%s: %w", k.namespace, k.name, err)
+	}
+
+	return Sign(privateKey, manifest)
+}
+
+func (k *SecretKeys) Verify(ctx context.Context, signature *Signature) (*Manifest, error) {
+	data, err := k.secretData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted, err := trustedKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in secret %s/%s: %w", k.namespace, k.name, err)
+	}
+	return Verify(trusted, signature)
+}
+
+func (k *SecretKeys) secretData(ctx context.Context) (map[string][]byte, error) {
+	secret, err := k.k8sClient.CoreV1().Secrets(k.namespace).Get(ctx, k.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key secret %s/%s: %w", k.namespace, k.name, err)
+	}
+	return secret.Data, nil
+}
+
+// trustedKeys returns the public key of the signing key and every *.pub entry, in a
+// stable order
+func trustedKeys(data map[string][]byte) ([]ed25519.PublicKey, error) {
+	var trusted []ed25519.PublicKey
+	if raw, ok := data[PrivateKeyKey]; ok {
+		privateKey, err := ParsePrivateKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", PrivateKeyKey, err)
+		}
+		trusted = append(trusted, privateKey.Public().(ed25519.PublicKey))
+	}
+
+	var names []string
+	for name := range data {
+		if strings.HasSuffix(name, PublicKeySuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		publicKey, err := ParsePublicKey(data[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		trusted = append(trusted, publicKey)
+	}
+
+	return trusted, nil
+}
+
+// ParsePrivateKey accepts an Ed25519 private key as PEM (PKCS #8) or as a 32-byte seed
+// that is raw, hex or base64 encoded
+func ParsePrivateKey(raw []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(raw); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM private key: %w", err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key is not an Ed25519 key")
+		}
+		return privateKey, nil
+	}
+
+	seed, err := decodeKey(raw, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey accepts an Ed25519 public key as PEM (PKIX) or as 32 bytes that are
+// raw, hex or base64 encoded
+func ParsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(raw); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM public key: %w", err)
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not an Ed25519 key")
+		}
+		return publicKey, nil
+	}
+
+	key, err := decodeKey(raw, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// decodeKey accepts size bytes of key material as raw bytes or as hex or base64 text
+func decodeKey(raw []byte, size int) ([]byte, error) {
+	if len(raw) == size {
+		return raw, nil
+	}
+
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == size {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == size {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key must be PEM or %d bytes (raw, hex or base64)", size)
+}
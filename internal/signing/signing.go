@@ -0,0 +1,95 @@
+// Package signing signs the manifests of snapshots so their provenance can be checked
+// before they are restored.
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Algorithm is the signature algorithm of every signature made by this package
+const Algorithm = "ed25519"
+
+// Manifest is the provenance of a snapshot that its signature covers
+type Manifest struct {
+	SnapshotID     string `json:"snapshot_id"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	ClusterName    string `json:"cluster_name"`
+	MemberID       string `json:"member_id"`
+	Revision       int64  `json:"revision"`
+	DriverVersion  string `json:"driver_version"`
+}
+
+// Signature is a signed manifest. The manifest is kept as the exact bytes that were
+// signed, so verifying does not depend on how it is encoded again.
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Manifest  []byte `json:"manifest"`
+	Value     []byte `json:"value"`
+}
+
+// Signer signs snapshot manifests
+type Signer interface {
+	Sign(ctx context.Context, manifest Manifest) (*Signature, error)
+}
+
+// Verifier checks signatures against the keys it trusts and returns the signed manifest
+type Verifier interface {
+	Verify(ctx context.Context, signature *Signature) (*Manifest, error)
+}
+
+// Keys both signs manifests and verifies signatures
+type Keys interface {
+	Signer
+	Verifier
+}
+
+// KeyID identifies a public key by a prefix of its SHA-256 fingerprint
+func KeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign signs manifest with privateKey
+func Sign(privateKey ed25519.PrivateKey, manifest Manifest) (*Signature, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	return &Signature{
+		Algorithm: Algorithm,
+		KeyID:     KeyID(privateKey.Public().(ed25519.PublicKey)),
+		Manifest:  data,
+		Value:     ed25519.Sign(privateKey, data),
+	}, nil
+}
+
+// Verify checks signature against the trusted public keys and returns the manifest it covers
+func Verify(trusted []ed25519.PublicKey, signature *Signature) (*Manifest, error) {
+	if signature.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", signature.Algorithm)
+	}
+
+	for _, publicKey := range trusted {
+		if KeyID(publicKey) != signature.KeyID {
+			continue
+		}
+		if !ed25519.Verify(publicKey, signature.Manifest, signature.Value) {
+			return nil, fmt.Errorf("signature made with key %s does not verify", signature.KeyID)
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(signature.Manifest, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to decode signed manifest: %w", err)
+		}
+		return &manifest, nil
+	}
+
+	return nil, fmt.Errorf("signature was made with key %s, which is not trusted", signature.KeyID)
+}
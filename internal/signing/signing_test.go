@@ -0,0 +1,107 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testManifest = Manifest{
+	SnapshotID:     "snap-1",
+	ChecksumSHA256: "abc123",
+	ClusterName:    "hcp-etcd",
+	MemberID:       "8e9e05c52164694d",
+	Revision:       42,
+	DriverVersion:  "v1.2.3",
+}
+
+func TestSignVerify(t *testing.T) {
+	privateKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x02}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+
+	signature, err := Sign(privateKey, testManifest)
+	require.NoError(t, err)
+	assert.Equal(t, Algorithm, signature.Algorithm)
+	assert.Equal(t, KeyID(publicKey), signature.KeyID)
+
+	manifest, err := Verify([]ed25519.PublicKey{other, publicKey}, signature)
+	require.NoError(t, err)
+	assert.Equal(t, testManifest, *manifest)
+
+	_, err = Verify([]ed25519.PublicKey{other}, signature)
+	assert.ErrorContains(t, err, "not trusted")
+
+	tampered := *signature
+	tampered.Manifest = bytes.Replace(signature.Manifest, []byte(`"revision":42`), []byte(`"revision":43`), 1)
+	_, err = Verify([]ed25519.PublicKey{publicKey}, &tampered)
+	assert.ErrorContains(t, err, "does not verify")
+}
+
+func TestSecretKeys(t *testing.T) {
+	ctx := context.Background()
+	current := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))
+	retired := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x02}, ed25519.SeedSize))
+	unknown := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x03}, ed25519.SeedSize))
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(current)
+	require.NoError(t, err)
+	k8sClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "signing-key", Namespace: "etcd-snapshot-driver"},
+		Data: map[string][]byte{
+			PrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+			"2025.pub":    []byte(hex.EncodeToString(retired.Public().(ed25519.PublicKey))),
+		},
+	})
+	keys := NewSecretKeys(k8sClient, "etcd-snapshot-driver", "signing-key")
+
+	signature, err := keys.Sign(ctx, testManifest)
+	require.NoError(t, err)
+	manifest, err := keys.Verify(ctx, signature)
+	require.NoError(t, err)
+	assert.Equal(t, testManifest, *manifest)
+
+	// Snapshots signed with a retired key still verify
+	signature, err = Sign(retired, testManifest)
+	require.NoError(t, err)
+	_, err = keys.Verify(ctx, signature)
+	assert.NoError(t, err)
+
+	signature, err = Sign(unknown, testManifest)
+	require.NoError(t, err)
+	_, err = keys.Verify(ctx, signature)
+	assert.Error(t, err)
+
+	_, err = NewSecretKeys(k8sClient, "etcd-snapshot-driver", "missing").Sign(ctx, testManifest)
+	assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x07}, ed25519.SeedSize)
+	expected := ed25519.NewKeyFromSeed(seed)
+
+	for _, raw := range [][]byte{seed, []byte(hex.EncodeToString(seed) + "\n")} {
+		privateKey, err := ParsePrivateKey(raw)
+		require.NoError(t, err)
+		assert.Equal(t, expected, privateKey)
+	}
+
+	pkix, err := x509.MarshalPKIXPublicKey(expected.Public())
+	require.NoError(t, err)
+	publicKey, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}))
+	require.NoError(t, err)
+	assert.Equal(t, expected.Public(), publicKey)
+
+	_, err = ParsePrivateKey([]byte("too short"))
+	assert.Error(t, err)
+}
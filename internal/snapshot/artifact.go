@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+)
+
+// Format describes how a snapshot file is encoded on the snapshot PVC
+type Format struct {
+	Codec     Codec
+	Encrypted bool
+	// Bundle stores the snapshot as a bundle, whose entries are each encoded with
+	// the codec and encryption
+	Bundle bool
+}
+
+// FileName returns the name of the snapshot file on the snapshot PVC
+func (f Format) FileName(snapshotID string) string {
+	if f.Bundle {
+		return snapshotID + ".bundle.tar"
+	}
+	return snapshotID + ".db" + f.extension()
+}
+
+// extension returns the extensions appended to files encoded with the format
+func (f Format) extension() string {
+	ext := f.Codec.Extension()
+	if f.Encrypted {
+		ext += ".enc"
+	}
+	return ext
+}
+
+// IsRaw reports whether the file is the raw database written by etcd
+func (f Format) IsRaw() bool {
+	return (f.Codec == "" || f.Codec == CodecNone) && !f.Encrypted && !f.Bundle
+}
+
+// ArtifactInfo describes a snapshot file as written to the snapshot PVC
+type ArtifactInfo struct {
+	Compression      Codec  `json:"compression"`
+	Encrypted        bool   `json:"encrypted"`
+	Size             int64  `json:"size"`
+	UncompressedSize int64  `json:"uncompressed_size"`
+	ChecksumSHA256   string `json:"checksum_sha256"`
+	// Bundle is set for snapshots written as a bundle
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+}
+
+// WriteArtifact encodes the raw snapshot at src into dst: compressed with the
+// format's codec, then encrypted with dataKey when the format is encrypted.
+// dst is written under a temporary name and renamed once complete.
+func WriteArtifact(src, dst string, format Format, dataKey []byte) (*ArtifactInfo, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+
+	var sink io.WriteCloser = nopWriteCloser{counter}
+	if format.Encrypted {
+		if sink, err = encryption.NewEncryptWriter(counter, dataKey); err != nil {
+			return nil, fmt.Errorf("failed to set up encryption: %w", err)
+		}
+	}
+
+	compressor, err := format.Codec.NewWriter(sink)
+	if err != nil {
+		return nil, err
+	}
+
+	uncompressed, err := io.Copy(compressor, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if err := sink.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close output file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return nil, fmt.Errorf("failed to move output file into place: %w", err)
+	}
+
+	return &ArtifactInfo{
+		Compression:      format.Codec,
+		Encrypted:        format.Encrypted,
+		Size:             counter.n,
+		UncompressedSize: uncompressed,
+		ChecksumSHA256:   hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// ReadArtifact restores the raw snapshot database from a file written by WriteArtifact,
+// or by WriteBundle for bundle formats
+func ReadArtifact(src, dst string, format Format, dataKey []byte) error {
+	if format.Bundle {
+		return ReadBundle(src, dst, dataKey)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	return decodeArtifact(in, dst, format, dataKey)
+}
+
+// decodeArtifact decrypts and decompresses an encoded snapshot read from in into dst
+func decodeArtifact(in io.Reader, dst string, format Format, dataKey []byte) error {
+	source := in
+	if format.Encrypted {
+		var err error
+		if source, err = encryption.NewDecryptReader(in, dataKey); err != nil {
+			return err
+		}
+	}
+
+	decompressor, err := format.Codec.NewReader(source)
+	if err != nil {
+		return fmt.Errorf("failed to read compressed snapshot: %w", err)
+	}
+	defer decompressor.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, decompressor); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	return out.Close()
+}
+
+// VerifyChecksum checks a stored snapshot file against its recorded SHA-256 checksum.
+// An empty checksum, recorded for snapshots taken before checksums were, is not checked.
+func VerifyChecksum(path, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, in); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("snapshot checksum %s does not match the recorded checksum %s", actual, expected)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
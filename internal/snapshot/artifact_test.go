@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatFileName(t *testing.T) {
+	assert.Equal(t, "snap-1.db", Format{Codec: CodecNone}.FileName("snap-1"))
+	assert.Equal(t, "snap-1.db.gz", Format{Codec: CodecGzip}.FileName("snap-1"))
+	assert.Equal(t, "snap-1.db.zst", Format{Codec: CodecZstd}.FileName("snap-1"))
+	assert.Equal(t, "snap-1.db.zst.enc", Format{Codec: CodecZstd, Encrypted: true}.FileName("snap-1"))
+	assert.Equal(t, "snap-1.db.enc", Format{Encrypted: true}.FileName("snap-1"))
+	assert.Equal(t, "snap-1.bundle.tar", Format{Codec: CodecGzip, Encrypted: true, Bundle: true}.FileName("snap-1"))
+}
+
+func TestArtifactRoundTrip(t *testing.T) {
+	raw := bytes.Repeat([]byte("/registry/pods/default/etcd-0\x00"), 4096)
+	dataKey, err := encryption.GenerateDataKey()
+	require.NoError(t, err)
+
+	formats := []Format{
+		{Codec: CodecNone},
+		{Codec: CodecGzip},
+		{Codec: CodecZstd},
+		{Codec: CodecNone, Encrypted: true},
+		{Codec: CodecZstd, Encrypted: true},
+	}
+
+	for _, format := range formats {
+		t.Run(format.FileName("snap"), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "raw.db")
+			require.NoError(t, os.WriteFile(src, raw, 0o600))
+
+			dst := filepath.Join(dir, format.FileName("snap"))
+			info, err := WriteArtifact(src, dst, format, dataKey)
+			require.NoError(t, err)
+
+			assert.Equal(t, format.Codec, info.Compression)
+			assert.Equal(t, format.Encrypted, info.Encrypted)
+			assert.Equal(t, int64(len(raw)), info.UncompressedSize)
+			stat, err := os.Stat(dst)
+			require.NoError(t, err)
+			assert.Equal(t, stat.Size(), info.Size)
+			assert.Len(t, info.ChecksumSHA256, 64)
+			assert.NoError(t, VerifyChecksum(dst, info.ChecksumSHA256))
+			assert.ErrorContains(t, VerifyChecksum(dst, strings.Repeat("0", 64)), "does not match")
+			if format.Codec != CodecNone {
+				assert.Less(t, info.Size, info.UncompressedSize)
+			}
+			if format.Encrypted {
+				stored, err := os.ReadFile(dst)
+				require.NoError(t, err)
+				assert.False(t, bytes.Contains(stored, []byte("/registry/")))
+			}
+
+			restored := filepath.Join(dir, "restored.db")
+			require.NoError(t, ReadArtifact(dst, restored, format, dataKey))
+			got, err := os.ReadFile(restored)
+			require.NoError(t, err)
+			assert.Equal(t, raw, got)
+		})
+	}
+}
@@ -0,0 +1,534 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+)
+
+// BundleVersion is the version of the bundle layout WriteBundle writes. Bundles of a
+// later version are refused rather than read wrongly.
+const BundleVersion = 1
+
+const (
+	// BundleManifestName is the first entry of every bundle; it describes the others
+	BundleManifestName = "manifest.json"
+	// maxManifestSize bounds the manifest read from a bundle
+	maxManifestSize = 1 << 20
+)
+
+// BundleFileKind tells what an entry of a bundle holds
+type BundleFileKind string
+
+const (
+	// BundleFileDatabase is the etcd database; every bundle holds exactly one
+	BundleFileDatabase BundleFileKind = "database"
+	// BundleFileSecret is one key of a companion Secret captured with the snapshot
+	BundleFileSecret BundleFileKind = "secret"
+)
+
+// BundleFile is an entry of a bundle. Entries are encoded with the bundle's codec and
+// encryption; Size and ChecksumSHA256 are those of the encoded entry.
+type BundleFile struct {
+	Name             string         `json:"name"`
+	Kind             BundleFileKind `json:"kind"`
+	Size             int64          `json:"size"`
+	UncompressedSize int64          `json:"uncompressed_size"`
+	ChecksumSHA256   string         `json:"checksum_sha256"`
+}
+
+// BundleInfo records the layout of a snapshot stored as a bundle
+type BundleInfo struct {
+	Version int          `json:"version"`
+	Files   []BundleFile `json:"files"`
+}
+
+// BundleManifest is the manifest.json of a bundle. It makes a bundle readable without
+// the snapshot's metadata: entries are decoded as it says.
+type BundleManifest struct {
+	Version       int          `json:"version"`
+	SnapshotID    string       `json:"snapshot_id"`
+	ClusterName   string       `json:"cluster_name,omitempty"`
+	MemberID      string       `json:"member_id,omitempty"`
+	Revision      int64        `json:"revision,omitempty"`
+	DriverVersion string       `json:"driver_version"`
+	CreationTime  time.Time    `json:"creation_time"`
+	Compression   Codec        `json:"compression"`
+	Encrypted     bool         `json:"encrypted"`
+	Files         []BundleFile `json:"files"`
+}
+
+// BundleSource describes the snapshot a bundle is written for
+type BundleSource struct {
+	SnapshotID  string
+	ClusterName string
+	MemberID    string
+	Revision    int64
+	// CompanionsDir holds one directory per companion Secret, with a file per key as
+	// Secrets are mounted into pods. It is optional.
+	CompanionsDir string
+}
+
+// bundleEntry is an encoded entry staged for a bundle
+type bundleEntry struct {
+	file BundleFile
+	path string
+}
+
+// WriteBundle writes the raw snapshot at src, and the companion Secrets of source, into
+// a bundle at dst. Entries are encoded with the format's codec, then encrypted with
+// dataKey when the format is encrypted. They are staged next to src, and dst is written
+// under a temporary name and renamed once complete.
+func WriteBundle(src, dst string, format Format, dataKey []byte, source BundleSource) (*ArtifactInfo, error) {
+	stagingDir, err := os.MkdirTemp(filepath.Dir(src), "bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	entryFormat := Format{Codec: format.Codec, Encrypted: format.Encrypted}
+	var staged int
+	stage := func(name string, kind BundleFileKind, input string) (*bundleEntry, error) {
+		staged++
+		stagedPath := filepath.Join(stagingDir, fmt.Sprintf("entry-%d", staged))
+		info, err := WriteArtifact(input, stagedPath, entryFormat, dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("bundle entry %s: %w", name, err)
+		}
+		return &bundleEntry{
+			file: BundleFile{
+				Name:             name,
+				Kind:             kind,
+				Size:             info.Size,
+				UncompressedSize: info.UncompressedSize,
+				ChecksumSHA256:   info.ChecksumSHA256,
+			},
+			path: stagedPath,
+		}, nil
+	}
+
+	database, err := stage("snapshot.db"+entryFormat.extension(), BundleFileDatabase, src)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*bundleEntry{database}
+
+	companions, err := companionFiles(source.CompanionsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, companion := range companions {
+		entry, err := stage(companion.name+entryFormat.extension(), BundleFileSecret, companion.path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	manifest := BundleManifest{
+		Version:       BundleVersion,
+		SnapshotID:    source.SnapshotID,
+		ClusterName:   source.ClusterName,
+		MemberID:      source.MemberID,
+		Revision:      source.Revision,
+		DriverVersion: config.Version,
+		CreationTime:  time.Now().UTC(),
+		Compression:   format.Codec,
+		Encrypted:     format.Encrypted,
+	}
+	for _, entry := range entries {
+		manifest.Files = append(manifest.Files, entry.file)
+	}
+
+	size, checksum, err := writeBundleFile(dst, &manifest, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArtifactInfo{
+		Compression:      format.Codec,
+		Encrypted:        format.Encrypted,
+		Size:             size,
+		UncompressedSize: database.file.UncompressedSize,
+		ChecksumSHA256:   checksum,
+		Bundle: &BundleInfo{
+			Version: manifest.Version,
+			Files:   manifest.Files,
+		},
+	}, nil
+}
+
+// writeBundleFile writes the manifest and the staged entries as a tar to dst, and
+// returns its size and checksum
+func writeBundleFile(dst string, manifest *BundleManifest, entries []*bundleEntry) (int64, string, error) {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	tw := tar.NewWriter(counter)
+
+	header := func(name string, size int64) *tar.Header {
+		return &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o600,
+			ModTime:  manifest.CreationTime,
+			Format:   tar.FormatPAX,
+		}
+	}
+
+	if err := tw.WriteHeader(header(BundleManifestName, int64(len(manifestData)))); err != nil {
+		return 0, "", fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return 0, "", fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	for _, entry := range entries {
+		if err := copyBundleEntry(tw, header(entry.file.Name, entry.file.Size), entry.path); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, "", fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, "", fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", fmt.Errorf("failed to close output file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, "", fmt.Errorf("failed to move output file into place: %w", err)
+	}
+
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func copyBundleEntry(tw *tar.Writer, header *tar.Header, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle entry %s: %w", header.Name, err)
+	}
+	defer in.Close()
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", header.Name, err)
+	}
+	if _, err := io.Copy(tw, in); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", header.Name, err)
+	}
+	return nil
+}
+
+// companionFile is one key of a companion Secret mounted into the pod
+type companionFile struct {
+	name string
+	path string
+}
+
+// companionFiles lists the keys of the Secrets mounted under dir, named
+// secrets/<secret>/<key> in the bundle. The hidden entries Kubernetes uses to swap
+// mounted Secrets atomically are skipped.
+func companionFiles(dir string) ([]companionFile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	secrets, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list companion Secrets: %w", err)
+	}
+
+	var files []companionFile
+	for _, secret := range secrets {
+		if strings.HasPrefix(secret.Name(), ".") || !secret.IsDir() {
+			continue
+		}
+
+		keys, err := os.ReadDir(filepath.Join(dir, secret.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list companion Secret %s: %w", secret.Name(), err)
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key.Name(), ".") {
+				continue
+			}
+
+			// Keys of mounted Secrets are symlinks into the hidden data dir
+			keyPath := filepath.Join(dir, secret.Name(), key.Name())
+			stat, err := os.Stat(keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read companion Secret %s: %w", secret.Name(), err)
+			}
+			if !stat.Mode().IsRegular() {
+				continue
+			}
+			files = append(files, companionFile{
+				name: path.Join("secrets", secret.Name(), key.Name()),
+				path: keyPath,
+			})
+		}
+	}
+	return files, nil
+}
+
+// ReadBundle restores the raw snapshot database from a bundle written by WriteBundle.
+// Every entry is checked against the checksum in the manifest, and the database is
+// decoded as the manifest says. Encrypted bundles need the data key.
+func ReadBundle(src, dst string, dataKey []byte) (err error) {
+	// A database that turns out not to match its checksum is not left behind
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	var databases int
+	manifest, err := walkBundle(src, func(manifest *BundleManifest, file BundleFile, entry io.Reader) error {
+		if manifest.Encrypted && dataKey == nil {
+			return fmt.Errorf("bundle %s is encrypted but no data key was given", manifest.SnapshotID)
+		}
+		if file.Kind != BundleFileDatabase {
+			return nil
+		}
+		if databases++; databases > 1 {
+			return fmt.Errorf("bundle holds more than one database")
+		}
+		format := Format{Codec: manifest.Compression, Encrypted: manifest.Encrypted}
+		if err := decodeArtifact(entry, dst, format, dataKey); err != nil {
+			return fmt.Errorf("bundle entry %s: %w", file.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if manifest.Encrypted && dataKey == nil {
+		return fmt.Errorf("bundle %s is encrypted but no data key was given", manifest.SnapshotID)
+	}
+	if databases == 0 {
+		return fmt.Errorf("bundle holds no database")
+	}
+	return nil
+}
+
+// walkBundle reads a bundle, handing every entry to visit as it is read, and checks each
+// entry against the checksum in the manifest once it is read in full. Entries visit does
+// not read are read through for their checksum. The manifest is returned once every
+// entry it lists has been found.
+func walkBundle(src string, visit func(manifest *BundleManifest, file BundleFile, entry io.Reader) error) (*BundleManifest, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	tr := tar.NewReader(in)
+	manifest, err := readBundleManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]BundleFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Name] = file
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		file, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle entry %s is not in the manifest", header.Name)
+		}
+		delete(expected, header.Name)
+
+		hash := sha256.New()
+		entry := io.TeeReader(tr, hash)
+		if err := visit(manifest, file, entry); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(io.Discard, entry); err != nil {
+			return nil, fmt.Errorf("failed to read bundle entry %s: %w", file.Name, err)
+		}
+
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != file.ChecksumSHA256 {
+			return nil, fmt.Errorf("bundle entry %s checksum %s does not match the manifest's %s", file.Name, actual, file.ChecksumSHA256)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		if _, missing := expected[file.Name]; missing {
+			return nil, fmt.Errorf("bundle is missing entry %s", file.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// maxCompanionSize bounds a companion entry read from a bundle; Secrets hold at most 1MiB
+const maxCompanionSize = 1 << 20
+
+// BundleCompanions are the companion Secret entries of a bundle, still encoded as they
+// are stored, along with the manifest describing them. They are small enough to be
+// handed from a job to the admin CLI, which decodes them with Extract.
+type BundleCompanions struct {
+	Manifest BundleManifest    `json:"manifest"`
+	Entries  map[string][]byte `json:"entries"`
+}
+
+// ReadBundleCompanions reads the companion Secret entries of a bundle, checking every
+// entry of the bundle against the manifest. The entries are not decoded.
+func ReadBundleCompanions(src string) (*BundleCompanions, error) {
+	entries := make(map[string][]byte)
+	manifest, err := walkBundle(src, func(_ *BundleManifest, file BundleFile, entry io.Reader) error {
+		if file.Kind != BundleFileSecret {
+			return nil
+		}
+		data, err := io.ReadAll(io.LimitReader(entry, maxCompanionSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read bundle entry %s: %w", file.Name, err)
+		}
+		if len(data) > maxCompanionSize {
+			return fmt.Errorf("bundle entry %s is larger than a Secret can be", file.Name)
+		}
+		entries[file.Name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BundleCompanions{Manifest: *manifest, Entries: entries}, nil
+}
+
+// Extract decodes the companion Secrets into dstDir, one directory per Secret holding a
+// file per key, as Secrets are mounted into pods. Each entry is checked against the
+// manifest again first, as the entries may have travelled through job logs. Existing
+// files are not overwritten. The paths of the files written are returned; on error,
+// none are left behind.
+func (c *BundleCompanions) Extract(dstDir string, dataKey []byte) (paths []string, err error) {
+	manifest := c.Manifest
+	if manifest.Encrypted && dataKey == nil {
+		return nil, fmt.Errorf("bundle %s is encrypted but no data key was given", manifest.SnapshotID)
+	}
+
+	defer func() {
+		if err != nil {
+			for _, p := range paths {
+				os.Remove(p)
+			}
+			paths = nil
+		}
+	}()
+
+	format := Format{Codec: manifest.Compression, Encrypted: manifest.Encrypted}
+	listed := make(map[string]bool)
+	for _, file := range manifest.Files {
+		if file.Kind != BundleFileSecret {
+			continue
+		}
+		listed[file.Name] = true
+
+		data, ok := c.Entries[file.Name]
+		if !ok {
+			return paths, fmt.Errorf("bundle is missing entry %s", file.Name)
+		}
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); actual != file.ChecksumSHA256 {
+			return paths, fmt.Errorf("bundle entry %s checksum %s does not match the manifest's %s", file.Name, actual, file.ChecksumSHA256)
+		}
+
+		secret, key, err := companionName(file.Name, format)
+		if err != nil {
+			return paths, err
+		}
+		if err := os.MkdirAll(filepath.Join(dstDir, secret), 0o700); err != nil {
+			return paths, fmt.Errorf("failed to create directory for Secret %s: %w", secret, err)
+		}
+		dst := filepath.Join(dstDir, secret, key)
+		if err := decodeArtifact(bytes.NewReader(data), dst, format, dataKey); err != nil {
+			return paths, fmt.Errorf("bundle entry %s: %w", file.Name, err)
+		}
+		paths = append(paths, dst)
+	}
+
+	for name := range c.Entries {
+		if !listed[name] {
+			return paths, fmt.Errorf("bundle entry %s is not in the manifest", name)
+		}
+	}
+	return paths, nil
+}
+
+// companionName splits the name of a companion entry, secrets/<secret>/<key> followed
+// by the extensions of its encoding, into the Secret and key it was captured from
+func companionName(name string, format Format) (string, string, error) {
+	rest, ok := strings.CutPrefix(name, "secrets/")
+	if ok {
+		rest, ok = strings.CutSuffix(rest, format.extension())
+	}
+	secret, key, found := strings.Cut(rest, "/")
+	for _, part := range []string{secret, key} {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, "/") {
+			ok = false
+		}
+	}
+	if !ok || !found {
+		return "", "", fmt.Errorf("bundle entry %s does not name a Secret key", name)
+	}
+	return secret, key, nil
+}
+
+// readBundleManifest reads the manifest a bundle starts with and checks its version
+func readBundleManifest(tr *tar.Reader) (*BundleManifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if header.Name != BundleManifestName {
+		return nil, fmt.Errorf("bundle starts with %s rather than %s", header.Name, BundleManifestName)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle manifest: %w", err)
+	}
+
+	if manifest.Version < 1 || manifest.Version > BundleVersion {
+		return nil, fmt.Errorf("bundle version %d is not supported (supported: 1 to %d)", manifest.Version, BundleVersion)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,329 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCompanions lays out Secrets as they are mounted into pods, keys linked into a
+// hidden data dir
+func writeCompanions(t *testing.T, dir string, secrets map[string]map[string]string) {
+	t.Helper()
+
+	for name, data := range secrets {
+		dataDir := filepath.Join(dir, name, "..data")
+		require.NoError(t, os.MkdirAll(dataDir, 0o700))
+		for key, value := range data {
+			require.NoError(t, os.WriteFile(filepath.Join(dataDir, key), []byte(value), 0o600))
+			require.NoError(t, os.Symlink(filepath.Join("..data", key), filepath.Join(dir, name, key)))
+		}
+	}
+}
+
+// bundleEntries reads the names and contents of a bundle's entries in order
+func bundleEntries(t *testing.T, path string) ([]string, map[string][]byte) {
+	t.Helper()
+
+	in, err := os.Open(path)
+	require.NoError(t, err)
+	defer in.Close()
+
+	var names []string
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		contents[header.Name] = data
+	}
+	return names, contents
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	raw := bytes.Repeat([]byte("/registry/secrets/kube-system/bootstrap\x00"), 4096)
+	dataKey, err := encryption.GenerateDataKey()
+	require.NoError(t, err)
+
+	formats := []Format{
+		{Codec: CodecNone, Bundle: true},
+		{Codec: CodecZstd, Bundle: true},
+		{Codec: CodecGzip, Encrypted: true, Bundle: true},
+	}
+
+	for _, format := range formats {
+		t.Run(format.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "raw.db")
+			require.NoError(t, os.WriteFile(src, raw, 0o600))
+			companions := filepath.Join(dir, "companions")
+			writeCompanions(t, companions, map[string]map[string]string{
+				"etcd-ca":           {"ca.crt": "-----BEGIN CERTIFICATE-----"},
+				"encryption-config": {"config.yaml": "kind: EncryptionConfiguration"},
+			})
+
+			dst := filepath.Join(dir, format.FileName("snap"))
+			assert.Equal(t, "snap.bundle.tar", filepath.Base(dst))
+			info, err := WriteBundle(src, dst, format, dataKey, BundleSource{
+				SnapshotID:    "snap",
+				ClusterName:   "hcp-etcd",
+				MemberID:      "8e9e05c52164694d",
+				Revision:      42,
+				CompanionsDir: companions,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(len(raw)), info.UncompressedSize)
+			assert.NoError(t, VerifyChecksum(dst, info.ChecksumSHA256))
+			require.NotNil(t, info.Bundle)
+			assert.Equal(t, BundleVersion, info.Bundle.Version)
+
+			// The manifest comes first and describes every other entry
+			names, contents := bundleEntries(t, dst)
+			ext := Format{Codec: format.Codec, Encrypted: format.Encrypted}.extension()
+			assert.Equal(t, []string{
+				BundleManifestName,
+				"snapshot.db" + ext,
+				"secrets/encryption-config/config.yaml" + ext,
+				"secrets/etcd-ca/ca.crt" + ext,
+			}, names)
+
+			var manifest BundleManifest
+			require.NoError(t, json.Unmarshal(contents[BundleManifestName], &manifest))
+			assert.Equal(t, "hcp-etcd", manifest.ClusterName)
+			assert.Equal(t, int64(42), manifest.Revision)
+			assert.Equal(t, info.Bundle.Files, manifest.Files)
+			assert.Equal(t, BundleFileDatabase, manifest.Files[0].Kind)
+			assert.Equal(t, BundleFileSecret, manifest.Files[1].Kind)
+			if format.Encrypted {
+				assert.False(t, bytes.Contains(contents["secrets/etcd-ca/ca.crt"+ext], []byte("CERTIFICATE")))
+			}
+
+			restored := filepath.Join(dir, "restored.db")
+			require.NoError(t, ReadArtifact(dst, restored, format, dataKey))
+			got, err := os.ReadFile(restored)
+			require.NoError(t, err)
+			assert.Equal(t, raw, got)
+		})
+	}
+}
+
+func TestReadBundleRejectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "raw.db")
+	require.NoError(t, os.WriteFile(src, []byte("etcd database"), 0o600))
+	companions := filepath.Join(dir, "companions")
+	writeCompanions(t, companions, map[string]map[string]string{"etcd-ca": {"ca.crt": "original"}})
+
+	bundle := filepath.Join(dir, "snap.bundle.tar")
+	_, err := WriteBundle(src, bundle, Format{Bundle: true}, nil, BundleSource{SnapshotID: "snap", CompanionsDir: companions})
+	require.NoError(t, err)
+
+	rewrite := func(t *testing.T, edit func(names []string, contents map[string][]byte) []string) string {
+		t.Helper()
+
+		names, contents := bundleEntries(t, bundle)
+		names = edit(names, contents)
+
+		path := filepath.Join(t.TempDir(), "tampered.bundle.tar")
+		out, err := os.Create(path)
+		require.NoError(t, err)
+		defer out.Close()
+		tw := tar.NewWriter(out)
+		for _, name := range names {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(contents[name])), Mode: 0o600}))
+			_, err := tw.Write(contents[name])
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		edit     func(names []string, contents map[string][]byte) []string
+		expected string
+	}{
+		{
+			name: "companion changed",
+			edit: func(names []string, contents map[string][]byte) []string {
+				contents["secrets/etcd-ca/ca.crt"] = []byte("replaced")
+				return names
+			},
+			expected: "does not match the manifest",
+		},
+		{
+			name: "entry removed",
+			edit: func(names []string, contents map[string][]byte) []string {
+				return names[:2]
+			},
+			expected: "missing entry secrets/etcd-ca/ca.crt",
+		},
+		{
+			name: "entry added",
+			edit: func(names []string, contents map[string][]byte) []string {
+				contents["extra"] = []byte("extra")
+				return append(names, "extra")
+			},
+			expected: "not in the manifest",
+		},
+		{
+			name: "newer version",
+			edit: func(names []string, contents map[string][]byte) []string {
+				contents[BundleManifestName] = bytes.Replace(contents[BundleManifestName], []byte(`"version": 1`), []byte(`"version": 2`), 1)
+				return names
+			},
+			expected: "version 2 is not supported",
+		},
+		{
+			name: "manifest not first",
+			edit: func(names []string, contents map[string][]byte) []string {
+				return append(names[1:], names[0])
+			},
+			expected: "rather than manifest.json",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			restored := filepath.Join(t.TempDir(), "restored.db")
+			err := ReadBundle(rewrite(t, tc.edit), restored, nil)
+			assert.ErrorContains(t, err, tc.expected)
+			assert.NoFileExists(t, restored)
+		})
+	}
+
+	// Raw databases are still read as they always were
+	restored := filepath.Join(dir, "restored.db")
+	require.NoError(t, ReadArtifact(src, restored, Format{}, nil))
+}
+
+func TestBundleCompanionsExtract(t *testing.T) {
+	dataKey, err := encryption.GenerateDataKey()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "raw.db")
+	require.NoError(t, os.WriteFile(src, []byte("etcd database"), 0o600))
+	companions := filepath.Join(dir, "companions")
+	writeCompanions(t, companions, map[string]map[string]string{
+		"etcd-ca":           {"ca.crt": "-----BEGIN CERTIFICATE-----"},
+		"encryption-config": {"config.yaml": "kind: EncryptionConfiguration"},
+	})
+
+	format := Format{Codec: CodecZstd, Encrypted: true, Bundle: true}
+	bundle := filepath.Join(dir, format.FileName("snap"))
+	_, err = WriteBundle(src, bundle, format, dataKey, BundleSource{SnapshotID: "snap", CompanionsDir: companions})
+	require.NoError(t, err)
+
+	read, err := ReadBundleCompanions(bundle)
+	require.NoError(t, err)
+	assert.Len(t, read.Entries, 2)
+	for _, entry := range read.Entries {
+		assert.False(t, bytes.Contains(entry, []byte("CERTIFICATE")))
+	}
+
+	// The entries survive being passed around as JSON
+	data, err := json.Marshal(read)
+	require.NoError(t, err)
+	var decoded BundleCompanions
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	out := filepath.Join(dir, "out")
+	paths, err := decoded.Extract(out, dataKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(out, "encryption-config", "config.yaml"),
+		filepath.Join(out, "etcd-ca", "ca.crt"),
+	}, paths)
+	got, err := os.ReadFile(filepath.Join(out, "etcd-ca", "ca.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----", string(got))
+
+	// Existing files are not overwritten, and nothing is left half written
+	_, err = decoded.Extract(out, dataKey)
+	assert.Error(t, err)
+
+	_, err = decoded.Extract(t.TempDir(), nil)
+	assert.ErrorContains(t, err, "no data key was given")
+}
+
+func TestBundleCompanionsExtractRejectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "raw.db")
+	require.NoError(t, os.WriteFile(src, []byte("etcd database"), 0o600))
+	companions := filepath.Join(dir, "companions")
+	writeCompanions(t, companions, map[string]map[string]string{"etcd-ca": {"ca.crt": "original", "ca.key": "key"}})
+
+	bundle := filepath.Join(dir, "snap.bundle.tar")
+	_, err := WriteBundle(src, bundle, Format{Bundle: true}, nil, BundleSource{SnapshotID: "snap", CompanionsDir: companions})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		edit     func(c *BundleCompanions)
+		expected string
+	}{
+		{
+			name: "entry changed",
+			edit: func(c *BundleCompanions) {
+				c.Entries["secrets/etcd-ca/ca.key"] = []byte("replaced")
+			},
+			expected: "does not match the manifest",
+		},
+		{
+			name: "entry removed",
+			edit: func(c *BundleCompanions) {
+				delete(c.Entries, "secrets/etcd-ca/ca.key")
+			},
+			expected: "missing entry secrets/etcd-ca/ca.key",
+		},
+		{
+			name: "entry added",
+			edit: func(c *BundleCompanions) {
+				c.Entries["secrets/etcd-ca/extra"] = []byte("extra")
+			},
+			expected: "not in the manifest",
+		},
+		{
+			name: "path outside the directory",
+			edit: func(c *BundleCompanions) {
+				c.Manifest.Files[1].Name = "secrets/../ca.crt"
+				c.Entries["secrets/../ca.crt"] = c.Entries["secrets/etcd-ca/ca.crt"]
+				delete(c.Entries, "secrets/etcd-ca/ca.crt")
+			},
+			expected: "does not name a Secret key",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			read, err := ReadBundleCompanions(bundle)
+			require.NoError(t, err)
+			tc.edit(read)
+
+			out := t.TempDir()
+			_, err = read.Extract(out, nil)
+			assert.ErrorContains(t, err, tc.expected)
+
+			// Whatever was written before the failure is removed again
+			left, err := filepath.Glob(filepath.Join(out, "*", "*"))
+			require.NoError(t, err)
+			assert.Empty(t, left)
+		})
+	}
+}
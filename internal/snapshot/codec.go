@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies the compression applied to a stored snapshot
+type Codec string
+
+const (
+	CodecNone Codec = "none"
+	CodecGzip Codec = "gzip"
+	CodecZstd Codec = "zstd"
+)
+
+// ParseCodec validates a codec name; an empty name means no compression
+func ParseCodec(name string) (Codec, error) {
+	switch Codec(name) {
+	case "", CodecNone:
+		return CodecNone, nil
+	case CodecGzip, CodecZstd:
+		return Codec(name), nil
+	default:
+		return "", fmt.Errorf("unsupported snapshot compression %q (supported: none, gzip, zstd)", name)
+	}
+}
+
+// Extension returns the file extension appended to compressed snapshots
+func (c Codec) Extension() string {
+	switch c {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// NewWriter wraps w so that data written to it is compressed with the codec
+func (c Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		return zstd.NewWriter(w)
+	case "", CodecNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported snapshot compression %q", c)
+	}
+}
+
+// NewReader wraps r so that data read from it is decompressed with the codec
+func (c Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case "", CodecNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported snapshot compression %q", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Codec
+		wantErr bool
+	}{
+		{name: "", want: CodecNone},
+		{name: "none", want: CodecNone},
+		{name: "gzip", want: CodecGzip},
+		{name: "zstd", want: CodecZstd},
+		{name: "lz4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCodec(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
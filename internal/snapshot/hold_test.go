@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckDeletable(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	assert.NoError(t, (&SnapshotMetadata{SnapshotID: "free"}).CheckDeletable(now))
+	assert.NoError(t, (&SnapshotMetadata{SnapshotID: "expired", LockedUntil: &earlier}).CheckDeletable(now))
+
+	err := (&SnapshotMetadata{SnapshotID: "held", LegalHold: true}).CheckDeletable(now)
+	assert.ErrorIs(t, err, ErrSnapshotHeld)
+	assert.ErrorContains(t, err, "under legal hold")
+
+	err = (&SnapshotMetadata{SnapshotID: "locked", LockedUntil: &later}).CheckDeletable(now)
+	assert.ErrorIs(t, err, ErrSnapshotHeld)
+	assert.ErrorContains(t, err, "locked until 2025-06-02T13:00:00Z")
+}
+
+func TestParseHold(t *testing.T) {
+	hold, err := ParseHold("", "")
+	require.NoError(t, err)
+	assert.True(t, hold.IsZero())
+
+	hold, err = ParseHold("true", "2030-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.NotNil(t, hold.LegalHold)
+	assert.True(t, *hold.LegalHold)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), hold.LockedUntil)
+
+	_, err = ParseHold("yes", "")
+	assert.Error(t, err)
+	_, err = ParseHold("", "next year")
+	assert.Error(t, err)
+}
+
+func TestSetSnapshotHold(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(fake.NewSimpleClientset(), zap.NewNop().Sugar(), "kube-system", config.NewNames(config.DefaultDriverName))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &SnapshotMetadata{SnapshotID: "snap-1"}))
+
+	lockedUntil := time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+	legalHold := true
+	updated, err := manager.SetSnapshotHold(ctx, "snap-1", Hold{LegalHold: &legalHold, LockedUntil: lockedUntil})
+	require.NoError(t, err)
+	assert.True(t, updated.LegalHold)
+	assert.Equal(t, lockedUntil, *updated.LockedUntil)
+
+	// Releasing the legal hold keeps the lock
+	legalHold = false
+	updated, err = manager.SetSnapshotHold(ctx, "snap-1", Hold{LegalHold: &legalHold})
+	require.NoError(t, err)
+	assert.False(t, updated.LegalHold)
+	assert.Equal(t, lockedUntil, *updated.LockedUntil)
+
+	// The lock can be extended but not shortened
+	_, err = manager.SetSnapshotHold(ctx, "snap-1", Hold{LockedUntil: lockedUntil.Add(-time.Hour)})
+	assert.ErrorContains(t, err, "cannot be shortened")
+	updated, err = manager.SetSnapshotHold(ctx, "snap-1", Hold{LockedUntil: lockedUntil.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, lockedUntil.Add(time.Hour), *updated.LockedUntil)
+
+	_, err = manager.SetSnapshotHold(ctx, "missing", Hold{LegalHold: &legalHold})
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+}
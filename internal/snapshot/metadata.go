@@ -3,15 +3,26 @@ package snapshot
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
+// ErrSnapshotNotFound is returned for snapshots that have no stored metadata
+var ErrSnapshotNotFound = errors.New("snapshot metadata not found")
+
 type SnapshotMetadata struct {
 	SnapshotID     string    `json:"snapshot_id"`
 	SourceVolumeID string    `json:"source_volume_id"`
@@ -22,30 +33,202 @@ type SnapshotMetadata struct {
 	ReadyToUse     bool      `json:"ready_to_use"`
 	PVCName        string    `json:"pvc_name"`
 	Namespace      string    `json:"namespace"`
+
+	// VolumeSnapshot is set for snapshots kept in a native VolumeSnapshot rather than on
+	// the snapshot PVC, which PVCName then does not name
+	VolumeSnapshot *VolumeSnapshotInfo `json:"volume_snapshot,omitempty"`
+
+	// ObjectStore is set for snapshots moved to the object store tier. Their file is read
+	// from there; PVCName is only set until the copy on the snapshot PVC has been removed.
+	ObjectStore *ObjectLocation `json:"object_store,omitempty"`
+
+	// Replicas are the copies of the snapshot on the configured replication targets
+	Replicas []Replica `json:"replicas,omitempty"`
+
+	// Compression is the codec the stored file was written with; Size is the
+	// stored (compressed) size and UncompressedSize the size of the raw database
+	Compression      Codec `json:"compression,omitempty"`
+	UncompressedSize int64 `json:"uncompressed_size,omitempty"`
+
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+
+	// Bundle is set for snapshots stored as a bundle of the database and its companions
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+
+	// Signature signs the snapshot's manifest: its checksum and where it was taken from
+	Signature *signing.Signature `json:"signature,omitempty"`
+
+	RestoreDrill *RestoreDrillStatus `json:"restore_drill,omitempty"`
+
+	// MemberID (hex) and MemberName identify the member the snapshot was streamed from.
+	// Revision is that member's revision when it was chosen; the snapshot holds at least it.
+	MemberID   string `json:"member_id,omitempty"`
+	MemberName string `json:"member_name,omitempty"`
+	Revision   int64  `json:"revision,omitempty"`
+	// ETCDVersion is the server version of that member
+	ETCDVersion string `json:"etcd_version,omitempty"`
+
+	// LegalHold keeps the snapshot from being deleted until the hold is released.
+	// LockedUntil keeps it from being deleted before then; it can only be extended.
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+
+	// References are the member snapshots of group snapshots that share this snapshot;
+	// its file is only removed once the last of them is deleted
+	References []string `json:"references,omitempty"`
+}
+
+// Format returns how the snapshot file is encoded on the snapshot PVC
+func (m *SnapshotMetadata) Format() Format {
+	return Format{
+		Codec:     m.Compression,
+		Encrypted: m.Encryption != nil,
+		Bundle:    m.Bundle != nil,
+	}
+}
+
+// Tier names where the file of a snapshot is kept
+type Tier string
+
+const (
+	TierPVC            Tier = "pvc"
+	TierVolumeSnapshot Tier = "volume-snapshot"
+	TierObjectStore    Tier = "object-store"
+)
+
+// Tier returns where the snapshot's file is read from
+func (m *SnapshotMetadata) Tier() Tier {
+	switch {
+	case m.ObjectStore != nil:
+		return TierObjectStore
+	case m.VolumeSnapshot != nil:
+		return TierVolumeSnapshot
+	default:
+		return TierPVC
+	}
+}
+
+// ObjectLocation is where the file of a snapshot in the object store tier is kept
+type ObjectLocation struct {
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	// Insecure is set for endpoints reached over plain HTTP
+	Insecure bool `json:"insecure,omitempty"`
+	// SecretName names the Secret, in the snapshot's namespace, holding the credentials
+	SecretName string `json:"secret_name"`
+}
+
+// MemberSnapshotID returns the ID of the snapshot of one source volume of a group
+// snapshot. The source volumes of a cluster all share the cluster's snapshot.
+func MemberSnapshotID(snapshotID, volumeID string) string {
+	return snapshotID + "/" + volumeID
+}
+
+// ParseMemberSnapshotID splits a member snapshot ID into the shared snapshot ID and the
+// source volume ID; volumeID is empty for IDs that refer to a whole snapshot
+func ParseMemberSnapshotID(id string) (snapshotID, volumeID string) {
+	snapshotID, volumeID, _ = strings.Cut(id, "/")
+	return snapshotID, volumeID
+}
+
+// EncryptionInfo records the wrapped data key a snapshot was encrypted with
+type EncryptionInfo struct {
+	Algorithm      string `json:"algorithm"`
+	KeyID          string `json:"key_id"`
+	WrappedDataKey []byte `json:"wrapped_data_key"`
+}
+
+// RestoreDrillStatus records the outcome of the most recent restore drill for a snapshot
+type RestoreDrillStatus struct {
+	Passed    bool      `json:"passed"`
+	CheckedAt time.Time `json:"checked_at"`
+	TotalKeys int64     `json:"total_keys"`
+	Revision  int64     `json:"revision"`
+	Message   string    `json:"message,omitempty"`
 }
 
 type GroupSnapshotMetadata struct {
-	GroupSnapshotID      string    `json:"group_snapshot_id"`
-	SnapshotID           string    `json:"snapshot_id"`
-	SourceVolumeIDs      []string  `json:"source_volume_ids"`
-	ClusterName          string    `json:"cluster_name"`
-	SnapshotPVCName      string    `json:"snapshot_pvc_name"`
-	SnapshotPVCNamespace string    `json:"snapshot_pvc_namespace"`
-	CreationTime         time.Time `json:"creation_time"`
-	ReadyToUse           bool      `json:"ready_to_use"`
+	GroupSnapshotID string   `json:"group_snapshot_id"`
+	SourceVolumeIDs []string `json:"source_volume_ids"`
+
+	// SnapshotID, ClusterName and the snapshot PVC describe the snapshot of the first
+	// cluster in the group; groups recorded before Clusters was added only have these
+	SnapshotID           string `json:"snapshot_id"`
+	ClusterName          string `json:"cluster_name"`
+	SnapshotPVCName      string `json:"snapshot_pvc_name"`
+	SnapshotPVCNamespace string `json:"snapshot_pvc_namespace"`
+
+	// Clusters holds the snapshot taken of each etcd cluster the source volumes belong to
+	Clusters []GroupClusterSnapshot `json:"clusters,omitempty"`
+
+	ContentName  string    `json:"content_name,omitempty"`
+	CreationTime time.Time `json:"creation_time"`
+	ReadyToUse   bool      `json:"ready_to_use"`
+}
+
+// GroupClusterSnapshot is the snapshot a group snapshot took of one etcd cluster
+type GroupClusterSnapshot struct {
+	SnapshotID           string   `json:"snapshot_id"`
+	ClusterName          string   `json:"cluster_name"`
+	SourceVolumeIDs      []string `json:"source_volume_ids"`
+	SnapshotPVCName      string   `json:"snapshot_pvc_name"`
+	SnapshotPVCNamespace string   `json:"snapshot_pvc_namespace"`
+}
+
+// ClusterSnapshots returns the snapshot of every cluster in the group, including groups
+// recorded with a single snapshot only
+func (g *GroupSnapshotMetadata) ClusterSnapshots() []GroupClusterSnapshot {
+	if len(g.Clusters) > 0 {
+		return g.Clusters
+	}
+	if g.SnapshotID == "" {
+		return nil
+	}
+
+	return []GroupClusterSnapshot{{
+		SnapshotID:           g.SnapshotID,
+		ClusterName:          g.ClusterName,
+		SourceVolumeIDs:      g.SourceVolumeIDs,
+		SnapshotPVCName:      g.SnapshotPVCName,
+		SnapshotPVCNamespace: g.SnapshotPVCNamespace,
+	}}
+}
+
+// HasSnapshot reports whether the snapshot of any cluster in the group is snapshotID
+func (g *GroupSnapshotMetadata) HasSnapshot(snapshotID string) bool {
+	for _, s := range g.ClusterSnapshots() {
+		if s.SnapshotID == snapshotID {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotForVolume returns the snapshot of the cluster the source volume belongs to
+func (g *GroupSnapshotMetadata) SnapshotForVolume(volumeID string) (GroupClusterSnapshot, bool) {
+	for _, s := range g.ClusterSnapshots() {
+		if slices.Contains(s.SourceVolumeIDs, volumeID) {
+			return s, true
+		}
+	}
+	return GroupClusterSnapshot{}, false
 }
 
 type Manager struct {
 	k8sClient kubernetes.Interface
 	logger    *zap.SugaredLogger
 	namespace string // kube-system
+	names     config.Names
 }
 
-func NewManager(k8sClient kubernetes.Interface, logger *zap.SugaredLogger, namespace string) *Manager {
+func NewManager(k8sClient kubernetes.Interface, logger *zap.SugaredLogger, namespace string, names config.Names) *Manager {
 	return &Manager{
 		k8sClient: k8sClient,
 		logger:    logger,
 		namespace: namespace,
+		names:     names,
 	}
 }
 
@@ -60,7 +243,7 @@ func (m *Manager) StoreSnapshotMetadata(ctx context.Context, metadata *SnapshotM
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	configMapName := "etcd-snapshot-metadata"
+	configMapName := m.names.SnapshotMetadataConfigMap()
 
 	// Get or create ConfigMap
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
@@ -71,7 +254,7 @@ func (m *Manager) StoreSnapshotMetadata(ctx context.Context, metadata *SnapshotM
 				Name:      configMapName,
 				Namespace: m.namespace,
 				Labels: map[string]string{
-					"app": "etcd-snapshot-driver",
+					"app": m.names.AppLabel(),
 				},
 			},
 			Data: map[string]string{
@@ -105,13 +288,62 @@ func (m *Manager) StoreSnapshotMetadata(ctx context.Context, metadata *SnapshotM
 	return nil
 }
 
+// UpdateSnapshotMetadata applies update to the stored metadata of a snapshot and saves it.
+// The metadata is read afresh, so changes other writers made in the meantime are kept. The
+// updated metadata is returned; a snapshot that has been deleted is left alone and nil is
+// returned for it.
+func (m *Manager) UpdateSnapshotMetadata(ctx context.Context, snapshotID string, update func(*SnapshotMetadata)) (*SnapshotMetadata, error) {
+	m.logger.Debugw("Updating snapshot metadata",
+		"snapshot_id", snapshotID,
+	)
+
+	configMapName := m.names.SnapshotMetadataConfigMap()
+	var updated *SnapshotMetadata
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		updated = nil
+		cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get ConfigMap: %w", err)
+		}
+
+		data, ok := cm.Data[snapshotID]
+		if !ok {
+			return nil
+		}
+		var metadata SnapshotMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+
+		update(&metadata)
+		encoded, err := json.Marshal(&metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		cm.Data[snapshotID] = string(encoded)
+
+		if _, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		updated = &metadata
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update metadata of snapshot %s: %w", snapshotID, err)
+	}
+	return updated, nil
+}
+
 // RetrieveSnapshotMetadata retrieves snapshot metadata from ConfigMap
 func (m *Manager) RetrieveSnapshotMetadata(ctx context.Context, snapshotID string) (*SnapshotMetadata, error) {
 	m.logger.Debugw("Retrieving snapshot metadata",
 		"snapshot_id", snapshotID,
 	)
 
-	configMapName := "etcd-snapshot-metadata"
+	configMapName := m.names.SnapshotMetadataConfigMap()
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
@@ -125,7 +357,34 @@ func (m *Manager) RetrieveSnapshotMetadata(ctx context.Context, snapshotID strin
 		return &metadata, nil
 	}
 
-	return nil, fmt.Errorf("snapshot metadata not found: %s", snapshotID)
+	return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotID)
+}
+
+// ListSnapshotMetadata returns the metadata of every stored snapshot
+func (m *Manager) ListSnapshotMetadata(ctx context.Context) ([]*SnapshotMetadata, error) {
+	configMapName := m.names.SnapshotMetadataConfigMap()
+	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	snapshots := make([]*SnapshotMetadata, 0, len(cm.Data))
+	for snapshotID, data := range cm.Data {
+		var metadata SnapshotMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata for %s: %w", snapshotID, err)
+		}
+		snapshots = append(snapshots, &metadata)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].SnapshotID < snapshots[j].SnapshotID
+	})
+
+	return snapshots, nil
 }
 
 // DeleteSnapshotMetadata removes snapshot metadata from ConfigMap
@@ -134,7 +393,7 @@ func (m *Manager) DeleteSnapshotMetadata(ctx context.Context, snapshotID string)
 		"snapshot_id", snapshotID,
 	)
 
-	configMapName := "etcd-snapshot-metadata"
+	configMapName := m.names.SnapshotMetadataConfigMap()
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		// ConfigMap doesn't exist, which is fine for deletion
@@ -155,6 +414,66 @@ func (m *Manager) DeleteSnapshotMetadata(ctx context.Context, snapshotID string)
 	return nil
 }
 
+// ReleaseSnapshotReference drops reference from the references of a shared snapshot and
+// returns how many remain. The snapshot's file should only be removed once none remain.
+// Snapshots recorded without references, and snapshots already deleted, have none.
+func (m *Manager) ReleaseSnapshotReference(ctx context.Context, snapshotID, reference string) (int, error) {
+	m.logger.Debugw("Releasing snapshot reference",
+		"snapshot_id", snapshotID,
+		"reference", reference,
+	)
+
+	configMapName := m.names.SnapshotMetadataConfigMap()
+	var remaining int
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		remaining = 0
+		cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get ConfigMap: %w", err)
+		}
+
+		data, ok := cm.Data[snapshotID]
+		if !ok {
+			return nil
+		}
+		var metadata SnapshotMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+
+		references := slices.DeleteFunc(slices.Clone(metadata.References), func(r string) bool {
+			return r == reference
+		})
+		remaining = len(references)
+		if remaining == len(metadata.References) {
+			return nil
+		}
+
+		metadata.References = references
+		updated, err := json.Marshal(&metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		cm.Data[snapshotID] = string(updated)
+
+		_, err = m.k8sClient.CoreV1().ConfigMaps(m.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to release reference %s of snapshot %s: %w", reference, snapshotID, err)
+	}
+
+	m.logger.Infow("Released snapshot reference",
+		"snapshot_id", snapshotID,
+		"reference", reference,
+		"remaining", remaining,
+	)
+	return remaining, nil
+}
+
 // StoreGroupSnapshotMetadata saves group snapshot metadata to a ConfigMap
 func (m *Manager) StoreGroupSnapshotMetadata(ctx context.Context, metadata *GroupSnapshotMetadata) error {
 	m.logger.Debugw("Storing group snapshot metadata",
@@ -166,7 +485,7 @@ func (m *Manager) StoreGroupSnapshotMetadata(ctx context.Context, metadata *Grou
 		return fmt.Errorf("failed to marshal group metadata: %w", err)
 	}
 
-	configMapName := "etcd-group-snapshot-metadata"
+	configMapName := m.names.GroupSnapshotMetadataConfigMap()
 
 	// Get or create ConfigMap
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
@@ -177,7 +496,7 @@ func (m *Manager) StoreGroupSnapshotMetadata(ctx context.Context, metadata *Grou
 				Name:      configMapName,
 				Namespace: m.namespace,
 				Labels: map[string]string{
-					"app": "etcd-snapshot-driver",
+					"app": m.names.AppLabel(),
 				},
 			},
 			Data: map[string]string{
@@ -217,7 +536,7 @@ func (m *Manager) RetrieveGroupSnapshotMetadata(ctx context.Context, groupSnapsh
 		"group_snapshot_id", groupSnapshotID,
 	)
 
-	configMapName := "etcd-group-snapshot-metadata"
+	configMapName := m.names.GroupSnapshotMetadataConfigMap()
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get group snapshot ConfigMap: %w", err)
@@ -234,13 +553,40 @@ func (m *Manager) RetrieveGroupSnapshotMetadata(ctx context.Context, groupSnapsh
 	return nil, fmt.Errorf("group snapshot metadata not found: %s", groupSnapshotID)
 }
 
+// ListGroupSnapshotMetadata returns the metadata of every stored group snapshot
+func (m *Manager) ListGroupSnapshotMetadata(ctx context.Context) ([]*GroupSnapshotMetadata, error) {
+	configMapName := m.names.GroupSnapshotMetadataConfigMap()
+	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group snapshot ConfigMap: %w", err)
+	}
+
+	groups := make([]*GroupSnapshotMetadata, 0, len(cm.Data))
+	for groupSnapshotID, data := range cm.Data {
+		var metadata GroupSnapshotMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal group snapshot metadata for %s: %w", groupSnapshotID, err)
+		}
+		groups = append(groups, &metadata)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].GroupSnapshotID < groups[j].GroupSnapshotID
+	})
+
+	return groups, nil
+}
+
 // DeleteGroupSnapshotMetadata removes group snapshot metadata from ConfigMap
 func (m *Manager) DeleteGroupSnapshotMetadata(ctx context.Context, groupSnapshotID string) error {
 	m.logger.Debugw("Deleting group snapshot metadata",
 		"group_snapshot_id", groupSnapshotID,
 	)
 
-	configMapName := "etcd-group-snapshot-metadata"
+	configMapName := m.names.GroupSnapshotMetadataConfigMap()
 	cm, err := m.k8sClient.CoreV1().ConfigMaps(m.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		// ConfigMap doesn't exist, which is fine for deletion
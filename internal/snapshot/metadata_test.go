@@ -0,0 +1,123 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGroupClusterSnapshots(t *testing.T) {
+	// Groups recorded before they could span clusters only carry one snapshot
+	legacy := &GroupSnapshotMetadata{
+		GroupSnapshotID:      "group-1",
+		SnapshotID:           "snap-1",
+		SourceVolumeIDs:      []string{"ns/data-0", "ns/data-1"},
+		ClusterName:          "etcd",
+		SnapshotPVCName:      "etcd-snapshots",
+		SnapshotPVCNamespace: "ns",
+	}
+	clusters := legacy.ClusterSnapshots()
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "snap-1", clusters[0].SnapshotID)
+	assert.Equal(t, legacy.SourceVolumeIDs, clusters[0].SourceVolumeIDs)
+
+	s, ok := legacy.SnapshotForVolume("ns/data-1")
+	require.True(t, ok)
+	assert.Equal(t, "snap-1", s.SnapshotID)
+
+	group := &GroupSnapshotMetadata{
+		GroupSnapshotID: "group-2",
+		SnapshotID:      "snap-2-0",
+		SourceVolumeIDs: []string{"ns/data-0", "ns/events-0"},
+		Clusters: []GroupClusterSnapshot{
+			{SnapshotID: "snap-2-0", ClusterName: "etcd", SourceVolumeIDs: []string{"ns/data-0"}},
+			{SnapshotID: "snap-2-1", ClusterName: "etcd-events", SourceVolumeIDs: []string{"ns/events-0"}},
+		},
+	}
+	s, ok = group.SnapshotForVolume("ns/events-0")
+	require.True(t, ok)
+	assert.Equal(t, "snap-2-1", s.SnapshotID)
+	assert.True(t, group.HasSnapshot("snap-2-1"))
+	assert.False(t, group.HasSnapshot("snap-1"))
+
+	_, ok = group.SnapshotForVolume("ns/other")
+	assert.False(t, ok)
+	assert.Empty(t, (&GroupSnapshotMetadata{}).ClusterSnapshots())
+}
+
+func TestMemberSnapshotID(t *testing.T) {
+	id := MemberSnapshotID("group-1-100", "hcp/data-etcd-0")
+	snapshotID, volumeID := ParseMemberSnapshotID(id)
+	assert.Equal(t, "group-1-100", snapshotID)
+	assert.Equal(t, "hcp/data-etcd-0", volumeID)
+
+	snapshotID, volumeID = ParseMemberSnapshotID("group-1-100")
+	assert.Equal(t, "group-1-100", snapshotID)
+	assert.Empty(t, volumeID)
+}
+
+func TestReleaseSnapshotReference(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(fake.NewSimpleClientset(), zap.NewNop().Sugar(), "kube-system", config.NewNames(config.DefaultDriverName))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &SnapshotMetadata{
+		SnapshotID: "snap-1",
+		References: []string{"snap-1/ns/data-0", "snap-1/ns/data-1"},
+	}))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &SnapshotMetadata{SnapshotID: "snap-legacy"}))
+
+	remaining, err := manager.ReleaseSnapshotReference(ctx, "snap-1", "snap-1/ns/data-0")
+	require.NoError(t, err)
+	assert.Equal(t, 1, remaining)
+
+	// Releasing a reference again changes nothing
+	remaining, err = manager.ReleaseSnapshotReference(ctx, "snap-1", "snap-1/ns/data-0")
+	require.NoError(t, err)
+	assert.Equal(t, 1, remaining)
+
+	remaining, err = manager.ReleaseSnapshotReference(ctx, "snap-1", "snap-1/ns/data-1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+
+	metadata, err := manager.RetrieveSnapshotMetadata(ctx, "snap-1")
+	require.NoError(t, err)
+	assert.Empty(t, metadata.References)
+
+	// Snapshots without references, or already deleted, have none left
+	remaining, err = manager.ReleaseSnapshotReference(ctx, "snap-legacy", "snap-legacy/ns/data-0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+	remaining, err = manager.ReleaseSnapshotReference(ctx, "missing", "missing/ns/data-0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+}
+
+func TestUpdateSnapshotMetadata(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(fake.NewSimpleClientset(), zap.NewNop().Sugar(), "kube-system", config.NewNames(config.DefaultDriverName))
+	require.NoError(t, manager.StoreSnapshotMetadata(ctx, &SnapshotMetadata{SnapshotID: "snap-1", PVCName: "snapshots"}))
+
+	updated, err := manager.UpdateSnapshotMetadata(ctx, "snap-1", func(m *SnapshotMetadata) {
+		m.SetReplica(Replica{Target: "dr", State: ReplicaReplicated})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots", updated.PVCName)
+
+	stored, err := manager.RetrieveSnapshotMetadata(ctx, "snap-1")
+	require.NoError(t, err)
+	assert.Equal(t, updated, stored)
+
+	// Snapshots that have been deleted are left alone
+	updated, err = manager.UpdateSnapshotMetadata(ctx, "missing", func(m *SnapshotMetadata) {
+		t.Error("update called for a missing snapshot")
+	})
+	require.NoError(t, err)
+	assert.Nil(t, updated)
+
+	_, err = manager.RetrieveSnapshotMetadata(ctx, "missing")
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+}
@@ -0,0 +1,167 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SnapshotPVCProvisioner ensures a dedicated PVC exists for storing etcd snapshots.
+type SnapshotPVCProvisioner struct {
+	k8sClient    kubernetes.Interface
+	names        config.Names
+	storageClass string
+	pvcSize      string
+	logger       *zap.SugaredLogger
+}
+
+// NewSnapshotPVCProvisioner creates a new SnapshotPVCProvisioner.
+func NewSnapshotPVCProvisioner(k8sClient kubernetes.Interface, names config.Names, storageClass, pvcSize string, logger *zap.SugaredLogger) *SnapshotPVCProvisioner {
+	return &SnapshotPVCProvisioner{
+		k8sClient:    k8sClient,
+		names:        names,
+		storageClass: storageClass,
+		pvcSize:      pvcSize,
+		logger:       logger,
+	}
+}
+
+// EnsureSnapshotPVC ensures a dedicated snapshot PVC exists in the given namespace,
+// creating it if necessary. Returns the PVC name.
+func (p *SnapshotPVCProvisioner) EnsureSnapshotPVC(ctx context.Context, namespace string) (string, error) {
+	pvc, err := p.ensurePVC(ctx, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.names.SnapshotPVC(),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app": p.names.AppLabel(),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+		},
+	}, "snapshot PVC")
+	if err != nil {
+		return "", err
+	}
+	return pvc.Name, nil
+}
+
+// EnsurePVC creates pvc with the provisioner's size and storage class unless a PVC
+// of the same name already exists, in which case the existing PVC is returned.
+func (p *SnapshotPVCProvisioner) EnsurePVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	return p.ensurePVC(ctx, pvc, "PVC")
+}
+
+// ensurePVC implements EnsurePVC; kind names the PVC in logs and errors
+func (p *SnapshotPVCProvisioner) ensurePVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, kind string) (*corev1.PersistentVolumeClaim, error) {
+	// Try to get existing PVC
+	existing, err := p.k8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+	if err == nil {
+		p.logger.Debugw("PVC already exists",
+			"kind", kind,
+			"pvc_name", pvc.Name,
+			"namespace", pvc.Namespace,
+		)
+		return existing, nil
+	}
+
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to check for existing %s: %w", kind, err)
+	}
+
+	// Parse the requested size
+	quantity, err := resource.ParseQuantity(p.pvcSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s size %q: %w", kind, p.pvcSize, err)
+	}
+
+	pvc = pvc.DeepCopy()
+	pvc.Spec.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: quantity,
+	}
+
+	// Set storage class if specified (empty string means use cluster default)
+	if p.storageClass != "" {
+		pvc.Spec.StorageClassName = &p.storageClass
+	}
+
+	p.logger.Infow("Creating PVC",
+		"kind", kind,
+		"pvc_name", pvc.Name,
+		"namespace", pvc.Namespace,
+		"size", p.pvcSize,
+		"storage_class", p.storageClass,
+	)
+
+	created, err := p.k8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		// Handle race condition where another request created the PVC concurrently
+		if errors.IsAlreadyExists(err) {
+			p.logger.Debugw("PVC created concurrently by another request",
+				"kind", kind,
+				"pvc_name", pvc.Name,
+				"namespace", pvc.Namespace,
+			)
+			return p.k8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+		}
+		return nil, fmt.Errorf("failed to create %s: %w", kind, err)
+	}
+
+	p.logger.Infow("PVC created successfully",
+		"kind", kind,
+		"pvc_name", pvc.Name,
+		"namespace", pvc.Namespace,
+	)
+
+	return created, nil
+}
+
+// ExpandPVC requests that pvc grows to size when its storage class allows volume
+// expansion, and reports whether it does. The storage backend resizes the volume
+// asynchronously; file systems grow when the volume is next mounted.
+func (p *SnapshotPVCProvisioner) ExpandPVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, size resource.Quantity) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+
+	storageClass, err := p.k8sClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get storage class %s: %w", *pvc.Spec.StorageClassName, err)
+	}
+	if storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+		return false, nil
+	}
+
+	if requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(requested) <= 0 {
+		// An expansion to at least this size is already under way
+		return true, nil
+	}
+
+	pvc = pvc.DeepCopy()
+	if pvc.Spec.Resources.Requests == nil {
+		pvc.Spec.Resources.Requests = corev1.ResourceList{}
+	}
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+
+	p.logger.Infow("Expanding PVC",
+		"pvc_name", pvc.Name,
+		"namespace", pvc.Namespace,
+		"size", size.String(),
+		"storage_class", storageClass.Name,
+	)
+
+	if _, err := p.k8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update PVC size: %w", err)
+	}
+	return true, nil
+}
@@ -1,9 +1,10 @@
-package driver
+package snapshot
 
 import (
 	"context"
 	"testing"
 
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -13,11 +14,14 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 )
 
+// snapshotPVCName is the snapshot PVC of the default driver instance
+var snapshotPVCName = config.NewNames("").SnapshotPVC()
+
 func TestEnsureSnapshotPVC_CreatesNew(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset()
 	logger := zap.NewNop().Sugar()
 
-	provisioner := NewSnapshotPVCProvisioner(fakeClient, "gp2", "10Gi", logger)
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "gp2", "10Gi", logger)
 
 	ctx := context.Background()
 	name, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
@@ -53,7 +57,7 @@ func TestEnsureSnapshotPVC_ReusesExisting(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset(existingPVC)
 	logger := zap.NewNop().Sugar()
 
-	provisioner := NewSnapshotPVCProvisioner(fakeClient, "gp2", "10Gi", logger)
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "gp2", "10Gi", logger)
 
 	ctx := context.Background()
 	name, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
@@ -75,7 +79,7 @@ func TestEnsureSnapshotPVC_PendingPVC(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset(existingPVC)
 	logger := zap.NewNop().Sugar()
 
-	provisioner := NewSnapshotPVCProvisioner(fakeClient, "gp2", "10Gi", logger)
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "gp2", "10Gi", logger)
 
 	ctx := context.Background()
 	name, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
@@ -87,7 +91,7 @@ func TestEnsureSnapshotPVC_EmptyStorageClass(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset()
 	logger := zap.NewNop().Sugar()
 
-	provisioner := NewSnapshotPVCProvisioner(fakeClient, "", "10Gi", logger)
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "", "10Gi", logger)
 
 	ctx := context.Background()
 	name, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
@@ -104,10 +108,54 @@ func TestEnsureSnapshotPVC_InvalidSize(t *testing.T) {
 	fakeClient := fake.NewSimpleClientset()
 	logger := zap.NewNop().Sugar()
 
-	provisioner := NewSnapshotPVCProvisioner(fakeClient, "gp2", "not-a-size", logger)
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "gp2", "not-a-size", logger)
 
 	ctx := context.Background()
 	_, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid snapshot PVC size")
 }
+
+func TestEnsureSnapshotPVC_NamedAfterDriver(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	logger := zap.NewNop().Sugar()
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames("hypershift-etcd"), "gp2", "10Gi", logger)
+
+	ctx := context.Background()
+	name, err := provisioner.EnsureSnapshotPVC(ctx, "test-ns")
+	require.NoError(t, err)
+	assert.Equal(t, "hypershift-etcd-snapshots", name)
+
+	pvc, err := fakeClient.CoreV1().PersistentVolumeClaims("test-ns").Get(ctx, name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "hypershift-etcd", pvc.Labels["app"])
+}
+
+func TestEnsurePVC_KeepsClaimSpec(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	provisioner := NewSnapshotPVCProvisioner(fakeClient, config.NewNames(""), "fast", "20Gi", zap.NewNop().Sugar())
+
+	ctx := context.Background()
+	pvc, err := provisioner.EnsurePVC(ctx, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data-fast-etcd-0",
+			Namespace: "test-ns",
+			Labels:    map[string]string{"etcd.io/cluster": "main"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "main", pvc.Labels["etcd.io/cluster"])
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}, pvc.Spec.AccessModes)
+	assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+	size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.Equal(t, "20Gi", size.String())
+
+	// A second call returns the existing PVC
+	again, err := provisioner.EnsurePVC(ctx, pvc)
+	require.NoError(t, err)
+	assert.Equal(t, pvc.UID, again.UID)
+}
@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ReplicationTarget is a secondary object store bucket every snapshot is copied to
+type ReplicationTarget struct {
+	Name     string
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+	Insecure bool
+	// SecretName names the Secret, in each snapshot's namespace, holding the credentials
+	SecretName string
+}
+
+// Location returns where the replica of a snapshot is kept on the target
+func (t ReplicationTarget) Location(metadata *SnapshotMetadata) *ObjectLocation {
+	return &ObjectLocation{
+		Endpoint:   t.Endpoint,
+		Region:     t.Region,
+		Bucket:     t.Bucket,
+		Key:        path.Join(t.Prefix, metadata.Namespace, metadata.Format().FileName(metadata.SnapshotID)),
+		Insecure:   t.Insecure,
+		SecretName: t.SecretName,
+	}
+}
+
+// ParseReplicationTargets parses replication targets of the form
+// NAME=SCHEME://ENDPOINT/BUCKET[/PREFIX][?region=REGION&secret=SECRET]. An http scheme
+// reaches the endpoint over plain HTTP; the Secret defaults to etcd-snapshot-replica-NAME.
+func ParseReplicationTargets(specs []string) ([]ReplicationTarget, error) {
+	targets := make([]ReplicationTarget, 0, len(specs))
+	for _, spec := range specs {
+		name, rawURL, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid replication target %q, expected NAME=URL", spec)
+		}
+		if slices.ContainsFunc(targets, func(t ReplicationTarget) bool { return t.Name == name }) {
+			return nil, fmt.Errorf("replication target %q is listed twice", name)
+		}
+
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of replication target %q: %w", name, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("replication target %q must use an http or https URL, got %q", name, rawURL)
+		}
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		if u.Host == "" || bucket == "" {
+			return nil, fmt.Errorf("replication target %q must name an endpoint and a bucket, got %q", name, rawURL)
+		}
+
+		query := u.Query()
+		secretName := query.Get("secret")
+		if secretName == "" {
+			secretName = "etcd-snapshot-replica-" + name
+		}
+
+		targets = append(targets, ReplicationTarget{
+			Name:       name,
+			Endpoint:   u.Host,
+			Region:     query.Get("region"),
+			Bucket:     bucket,
+			Prefix:     strings.TrimSuffix(prefix, "/"),
+			Insecure:   u.Scheme == "http",
+			SecretName: secretName,
+		})
+	}
+	return targets, nil
+}
+
+// ReplicaState is how far copying a snapshot to one replication target got
+type ReplicaState string
+
+const (
+	ReplicaPending    ReplicaState = "pending"
+	ReplicaReplicated ReplicaState = "replicated"
+	ReplicaFailed     ReplicaState = "failed"
+)
+
+// Replica records the copy of a snapshot on one replication target
+type Replica struct {
+	Target   string          `json:"target"`
+	State    ReplicaState    `json:"state"`
+	Location *ObjectLocation `json:"location,omitempty"`
+	// ChecksumSHA256 is the checksum the copy was verified against
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+	// Attempts counts the failed attempts since the last success
+	Attempts        int       `json:"attempts,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	LastAttemptTime time.Time `json:"last_attempt_time,omitempty"`
+	ReplicatedTime  time.Time `json:"replicated_time,omitempty"`
+}
+
+// Replica returns the snapshot's replica on target, if one has been recorded
+func (m *SnapshotMetadata) Replica(target string) (*Replica, bool) {
+	for i := range m.Replicas {
+		if m.Replicas[i].Target == target {
+			return &m.Replicas[i], true
+		}
+	}
+	return nil, false
+}
+
+// Replicated reports whether the snapshot has been copied to target
+func (m *SnapshotMetadata) Replicated(target string) bool {
+	replica, ok := m.Replica(target)
+	return ok && replica.State == ReplicaReplicated
+}
+
+// ReplicationReadiness selects when a snapshot counts as ready to use
+type ReplicationReadiness string
+
+const (
+	// ReadyWhenStored snapshots are ready as soon as they are stored locally
+	ReadyWhenStored ReplicationReadiness = "stored"
+	// ReadyWhenAnyReplicated snapshots are ready once copied to at least one target
+	ReadyWhenAnyReplicated ReplicationReadiness = "any-replica"
+	// ReadyWhenAllReplicated snapshots are ready once copied to every target
+	ReadyWhenAllReplicated ReplicationReadiness = "all-replicas"
+)
+
+// ParseReplicationReadiness parses a readiness policy; empty means ReadyWhenStored
+func ParseReplicationReadiness(value string) (ReplicationReadiness, error) {
+	switch policy := ReplicationReadiness(value); policy {
+	case "":
+		return ReadyWhenStored, nil
+	case ReadyWhenStored, ReadyWhenAnyReplicated, ReadyWhenAllReplicated:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported replication readiness %q (supported: %s, %s, %s)",
+			value, ReadyWhenStored, ReadyWhenAnyReplicated, ReadyWhenAllReplicated)
+	}
+}
+
+// ReplicationReady reports whether the snapshot's replicas satisfy policy for targets.
+// With no targets configured there is nothing to wait for.
+func (m *SnapshotMetadata) ReplicationReady(targets []ReplicationTarget, policy ReplicationReadiness) bool {
+	if len(targets) == 0 {
+		return true
+	}
+
+	switch policy {
+	case ReadyWhenAnyReplicated:
+		return slices.ContainsFunc(targets, func(t ReplicationTarget) bool { return m.Replicated(t.Name) })
+	case ReadyWhenAllReplicated:
+		return !slices.ContainsFunc(targets, func(t ReplicationTarget) bool { return !m.Replicated(t.Name) })
+	default:
+		return true
+	}
+}
+
+// SetReplica records the state of the snapshot's replica, replacing any earlier record for
+// the same target
+func (m *SnapshotMetadata) SetReplica(replica Replica) {
+	if existing, ok := m.Replica(replica.Target); ok {
+		*existing = replica
+		return
+	}
+	m.Replicas = append(m.Replicas, replica)
+}
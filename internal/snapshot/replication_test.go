@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplicationTargets(t *testing.T) {
+	targets, err := ParseReplicationTargets([]string{
+		"dr=https://s3.us-west-2.amazonaws.com/dr-snapshots/etcd?region=us-west-2&secret=dr-credentials",
+		"lab=http://minio.lab:9000/snapshots",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []ReplicationTarget{
+		{
+			Name:       "dr",
+			Endpoint:   "s3.us-west-2.amazonaws.com",
+			Region:     "us-west-2",
+			Bucket:     "dr-snapshots",
+			Prefix:     "etcd",
+			SecretName: "dr-credentials",
+		},
+		{
+			Name:       "lab",
+			Endpoint:   "minio.lab:9000",
+			Bucket:     "snapshots",
+			Insecure:   true,
+			SecretName: "etcd-snapshot-replica-lab",
+		},
+	}, targets)
+
+	location := targets[0].Location(&SnapshotMetadata{SnapshotID: "snap-1", Namespace: "hcp", Compression: CodecGzip})
+	assert.Equal(t, "etcd/hcp/snap-1.db.gz", location.Key)
+
+	for _, specs := range [][]string{
+		{"https://s3.example.com/bucket"},
+		{"dr=s3.example.com/bucket"},
+		{"dr=https://s3.example.com"},
+		{"dr=https://s3.example.com/a", "dr=https://s3.example.com/b"},
+	} {
+		_, err := ParseReplicationTargets(specs)
+		assert.Error(t, err, specs)
+	}
+}
+
+func TestReplicationReady(t *testing.T) {
+	targets := []ReplicationTarget{{Name: "dr"}, {Name: "lab"}}
+	none := &SnapshotMetadata{Replicas: []Replica{{Target: "dr", State: ReplicaPending}, {Target: "lab", State: ReplicaFailed}}}
+	one := &SnapshotMetadata{Replicas: []Replica{{Target: "dr", State: ReplicaReplicated}, {Target: "lab", State: ReplicaFailed}}}
+	all := &SnapshotMetadata{Replicas: []Replica{{Target: "dr", State: ReplicaReplicated}, {Target: "lab", State: ReplicaReplicated}}}
+
+	for _, tt := range []struct {
+		policy                 ReplicationReadiness
+		wantNone, wantOne, all bool
+	}{
+		{policy: ReadyWhenStored, wantNone: true, wantOne: true, all: true},
+		{policy: ReadyWhenAnyReplicated, wantOne: true, all: true},
+		{policy: ReadyWhenAllReplicated, all: true},
+	} {
+		assert.Equal(t, tt.wantNone, none.ReplicationReady(targets, tt.policy), tt.policy)
+		assert.Equal(t, tt.wantOne, one.ReplicationReady(targets, tt.policy), tt.policy)
+		assert.Equal(t, tt.all, all.ReplicationReady(targets, tt.policy), tt.policy)
+	}
+
+	// Without targets there is nothing to wait for
+	assert.True(t, none.ReplicationReady(nil, ReadyWhenAllReplicated))
+
+	_, err := ParseReplicationReadiness("eventually")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/config"
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrSnapshotUnsigned is returned for snapshots that carry no signature
+	ErrSnapshotUnsigned = errors.New("snapshot is not signed")
+	// ErrSignatureMismatch is returned for snapshots whose signature does not verify or
+	// covers a manifest other than the snapshot's
+	ErrSignatureMismatch = errors.New("snapshot signature does not match")
+)
+
+// SignatureSuffix is appended to the name of a snapshot file, and to the keys of its
+// copies in object stores, to name the copy of its signature stored next to it
+const SignatureSuffix = ".sig"
+
+// SignatureFileName returns the name of the signature file next to a snapshot file
+func (f Format) SignatureFileName(snapshotID string) string {
+	return f.FileName(snapshotID) + SignatureSuffix
+}
+
+// Manifest returns the manifest the snapshot's signature covers, naming the running
+// driver version as the one that produced it
+func (m *SnapshotMetadata) Manifest() signing.Manifest {
+	return signing.Manifest{
+		SnapshotID:     m.SnapshotID,
+		ChecksumSHA256: m.ChecksumSHA256,
+		ClusterName:    m.ClusterName,
+		MemberID:       m.MemberID,
+		Revision:       m.Revision,
+		DriverVersion:  config.Version,
+	}
+}
+
+// Sign signs the snapshot's manifest and records the signature. Snapshots without a
+// checksum cannot be signed, as nothing would tie the signature to the file.
+func (m *SnapshotMetadata) Sign(ctx context.Context, signer signing.Signer) error {
+	if m.ChecksumSHA256 == "" {
+		return fmt.Errorf("snapshot %s has no checksum to sign", m.SnapshotID)
+	}
+
+	signature, err := signer.Sign(ctx, m.Manifest())
+	if err != nil {
+		return fmt.Errorf("failed to sign snapshot %s: %w", m.SnapshotID, err)
+	}
+	m.Signature = signature
+	return nil
+}
+
+// SignatureFile encodes the snapshot's signature as it is stored next to its file, so a
+// copy of the file found without its metadata can still be checked. Unsigned snapshots
+// have none.
+func (m *SnapshotMetadata) SignatureFile() ([]byte, error) {
+	if m.Signature == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(m.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature of snapshot %s: %w", m.SnapshotID, err)
+	}
+	return data, nil
+}
+
+// VerifySignature checks that the snapshot is signed with a trusted key and that the
+// signed manifest matches its metadata. The driver version is provenance only and not
+// compared.
+func (m *SnapshotMetadata) VerifySignature(ctx context.Context, verifier signing.Verifier) error {
+	if m.Signature == nil {
+		return fmt.Errorf("%w: %s", ErrSnapshotUnsigned, m.SnapshotID)
+	}
+
+	signed, err := verifier.Verify(ctx, m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrSignatureMismatch, m.SnapshotID, err)
+	}
+
+	expected := m.Manifest()
+	expected.DriverVersion = signed.DriverVersion
+	if *signed != expected {
+		return fmt.Errorf("%w: %s: signed manifest %+v differs from the snapshot's %+v", ErrSignatureMismatch, m.SnapshotID, *signed, expected)
+	}
+	return nil
+}
+
+// SignaturePolicy decides what happens to snapshots about to be restored or verified
+// whose signature cannot be verified
+type SignaturePolicy struct {
+	// Verifier checks signatures; without one, signatures are only required in strict mode
+	Verifier signing.Verifier
+	// Strict rejects unsigned snapshots and snapshots whose signature does not match.
+	// Otherwise only a mismatched signature is reported, and the snapshot is allowed.
+	Strict bool
+}
+
+// Check applies the policy to a snapshot. A nil policy allows every snapshot.
+func (p *SignaturePolicy) Check(ctx context.Context, logger *zap.SugaredLogger, m *SnapshotMetadata) error {
+	if p == nil || (p.Verifier == nil && !p.Strict) {
+		return nil
+	}
+	if p.Verifier == nil {
+		return fmt.Errorf("snapshot signatures are required but no signing keys are configured")
+	}
+
+	err := m.VerifySignature(ctx, p.Verifier)
+	switch {
+	case err == nil:
+		return nil
+	case p.Strict:
+		return err
+	case errors.Is(err, ErrSignatureMismatch):
+		logger.Warnw("Snapshot signature does not verify, continuing as strict mode is off",
+			"snapshot_id", m.SnapshotID,
+			"error", err,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/Ajpantuso/etcd-snapshot-driver/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// staticKeys signs with a fixed key and trusts only it
+type staticKeys struct {
+	privateKey ed25519.PrivateKey
+}
+
+func (k staticKeys) Sign(ctx context.Context, manifest signing.Manifest) (*signing.Signature, error) {
+	return signing.Sign(k.privateKey, manifest)
+}
+
+func (k staticKeys) Verify(ctx context.Context, signature *signing.Signature) (*signing.Manifest, error) {
+	return signing.Verify([]ed25519.PublicKey{k.privateKey.Public().(ed25519.PublicKey)}, signature)
+}
+
+func TestSnapshotSignature(t *testing.T) {
+	ctx := context.Background()
+	keys := staticKeys{ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))}
+	other := staticKeys{ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x02}, ed25519.SeedSize))}
+
+	newSnapshot := func() *SnapshotMetadata {
+		return &SnapshotMetadata{
+			SnapshotID:     "snap-1",
+			ClusterName:    "hcp-etcd",
+			ChecksumSHA256: "abc123",
+			MemberID:       "8e9e05c52164694d",
+			Revision:       42,
+		}
+	}
+
+	unchecksummed := newSnapshot()
+	unchecksummed.ChecksumSHA256 = ""
+	assert.ErrorContains(t, unchecksummed.Sign(ctx, keys), "no checksum")
+
+	signed := newSnapshot()
+	require.NoError(t, signed.Sign(ctx, keys))
+	assert.NoError(t, signed.VerifySignature(ctx, keys))
+	assert.ErrorIs(t, signed.VerifySignature(ctx, other), ErrSignatureMismatch)
+
+	// A signature copied onto other metadata does not match it
+	tampered := newSnapshot()
+	tampered.ChecksumSHA256 = "def456"
+	tampered.Signature = signed.Signature
+	assert.ErrorIs(t, tampered.VerifySignature(ctx, keys), ErrSignatureMismatch)
+
+	assert.ErrorIs(t, newSnapshot().VerifySignature(ctx, keys), ErrSnapshotUnsigned)
+
+	// The signature file next to a copy of the file verifies on its own
+	data, err := signed.SignatureFile()
+	require.NoError(t, err)
+	detached := newSnapshot()
+	detached.Signature = &signing.Signature{}
+	require.NoError(t, json.Unmarshal(data, detached.Signature))
+	assert.NoError(t, detached.VerifySignature(ctx, keys))
+	assert.Equal(t, "snap-1.db.gz.sig", Format{Codec: CodecGzip}.SignatureFileName("snap-1"))
+
+	data, err = newSnapshot().SignatureFile()
+	require.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	ctx := context.Background()
+	logger := zap.NewNop().Sugar()
+	keys := staticKeys{ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))}
+
+	signed := &SnapshotMetadata{SnapshotID: "signed", ChecksumSHA256: "abc123"}
+	require.NoError(t, signed.Sign(ctx, keys))
+	unsigned := &SnapshotMetadata{SnapshotID: "unsigned", ChecksumSHA256: "abc123"}
+	mismatched := &SnapshotMetadata{SnapshotID: "mismatched", ChecksumSHA256: "def456", Signature: signed.Signature}
+
+	strict := &SignaturePolicy{Verifier: keys, Strict: true}
+	assert.NoError(t, strict.Check(ctx, logger, signed))
+	assert.ErrorIs(t, strict.Check(ctx, logger, unsigned), ErrSnapshotUnsigned)
+	assert.ErrorIs(t, strict.Check(ctx, logger, mismatched), ErrSignatureMismatch)
+
+	// Outside strict mode problems are only reported
+	lenient := &SignaturePolicy{Verifier: keys}
+	for _, s := range []*SnapshotMetadata{signed, unsigned, mismatched} {
+		assert.NoError(t, lenient.Check(ctx, logger, s), s.SnapshotID)
+	}
+
+	var none *SignaturePolicy
+	assert.NoError(t, none.Check(ctx, logger, unsigned))
+	assert.Error(t, (&SignaturePolicy{Strict: true}).Check(ctx, logger, signed))
+}
@@ -0,0 +1,204 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StorageMode is where snapshot files are kept
+type StorageMode string
+
+const (
+	// StorageSharedPVC keeps every snapshot of a namespace on one shared snapshot PVC
+	StorageSharedPVC StorageMode = "shared-pvc"
+	// StorageVolumeSnapshot writes every snapshot to a PVC of its own, keeps it in a native
+	// VolumeSnapshot of that PVC and deletes the PVC
+	StorageVolumeSnapshot StorageMode = "volume-snapshot"
+)
+
+// ParseStorageMode validates a storage mode; an empty mode means the shared snapshot PVC
+func ParseStorageMode(mode string) (StorageMode, error) {
+	switch StorageMode(mode) {
+	case "", StorageSharedPVC:
+		return StorageSharedPVC, nil
+	case StorageVolumeSnapshot:
+		return StorageVolumeSnapshot, nil
+	default:
+		return "", fmt.Errorf("unsupported snapshot storage mode %q (supported: shared-pvc, volume-snapshot)", mode)
+	}
+}
+
+// VolumeSnapshotResource is the resource of native CSI VolumeSnapshots
+var VolumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// VolumeSnapshotInfo records the native VolumeSnapshot a snapshot file is kept in when
+// every snapshot is written to a PVC of its own
+type VolumeSnapshotInfo struct {
+	Name      string `json:"name"`
+	ClassName string `json:"class_name,omitempty"`
+	// StorageClass of the PVC the VolumeSnapshot was taken of; PVCs restored from it use it too
+	StorageClass string `json:"storage_class,omitempty"`
+	// RestoreSize is the smallest PVC the VolumeSnapshot can be restored into
+	RestoreSize string `json:"restore_size"`
+}
+
+// VolumeSnapshotter takes native VolumeSnapshots of the PVCs snapshots are written to
+type VolumeSnapshotter struct {
+	client       dynamic.Interface
+	logger       *zap.SugaredLogger
+	pollInterval time.Duration
+}
+
+func NewVolumeSnapshotter(client dynamic.Interface, logger *zap.SugaredLogger) *VolumeSnapshotter {
+	return &VolumeSnapshotter{
+		client:       client,
+		logger:       logger,
+		pollInterval: 2 * time.Second,
+	}
+}
+
+// Snapshot takes a VolumeSnapshot of a PVC and waits until it is ready to use. An empty
+// className uses the default VolumeSnapshotClass of the PVC's CSI driver.
+func (v *VolumeSnapshotter) Snapshot(ctx context.Context, namespace, name, pvcName, className string, labels map[string]string, timeout time.Duration) (*VolumeSnapshotInfo, error) {
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvcName,
+		},
+	}
+	if className != "" {
+		spec["volumeSnapshotClassName"] = className
+	}
+	volumeSnapshot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": VolumeSnapshotResource.GroupVersion().String(),
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": spec,
+	}}
+	volumeSnapshot.SetLabels(labels)
+
+	v.logger.Infow("Creating VolumeSnapshot",
+		"volume_snapshot", name,
+		"namespace", namespace,
+		"pvc_name", pvcName,
+		"class", className,
+	)
+
+	client := v.client.Resource(VolumeSnapshotResource).Namespace(namespace)
+	if _, err := client.Create(ctx, volumeSnapshot, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create VolumeSnapshot %s: %w", name, err)
+	}
+
+	info := &VolumeSnapshotInfo{Name: name, ClassName: className}
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, v.pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		if message, found, _ := unstructured.NestedString(current.Object, "status", "error", "message"); found && message != "" {
+			return false, fmt.Errorf("VolumeSnapshot %s failed: %s", name, message)
+		}
+		if ready, _, _ := unstructured.NestedBool(current.Object, "status", "readyToUse"); !ready {
+			return false, nil
+		}
+		info.RestoreSize, _, _ = unstructured.NestedString(current.Object, "status", "restoreSize")
+		if class, found, _ := unstructured.NestedString(current.Object, "spec", "volumeSnapshotClassName"); found {
+			info.ClassName = class
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			err = fmt.Errorf("%w (last error: %v)", err, lastErr)
+		}
+		return nil, fmt.Errorf("VolumeSnapshot %s did not become ready: %w", name, err)
+	}
+
+	v.logger.Infow("VolumeSnapshot ready",
+		"volume_snapshot", name,
+		"namespace", namespace,
+		"restore_size", info.RestoreSize,
+	)
+	return info, nil
+}
+
+// Delete deletes a VolumeSnapshot; one that no longer exists is not an error
+func (v *VolumeSnapshotter) Delete(ctx context.Context, namespace, name string) error {
+	err := v.client.Resource(VolumeSnapshotResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete VolumeSnapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReadablePVC returns a PVC holding the file of a snapshot. Snapshots kept in a
+// VolumeSnapshot are first restored into a new PVC with the given name, which release
+// deletes again; snapshots on a snapshot PVC are read from it and release does nothing.
+// Snapshots in the object store tier are on no PVC, so no name is returned for them.
+func ReadablePVC(ctx context.Context, k8sClient kubernetes.Interface, metadata *SnapshotMetadata, name string, labels map[string]string) (string, func(), error) {
+	switch metadata.Tier() {
+	case TierObjectStore:
+		return "", func() {}, nil
+	case TierPVC:
+		return metadata.PVCName, func() {}, nil
+	}
+
+	size, err := resource.ParseQuantity(metadata.VolumeSnapshot.RestoreSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid restore size %q of VolumeSnapshot %s: %w", metadata.VolumeSnapshot.RestoreSize, metadata.VolumeSnapshot.Name, err)
+	}
+
+	apiGroup := VolumeSnapshotResource.Group
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metadata.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     metadata.VolumeSnapshot.Name,
+			},
+		},
+	}
+	if metadata.VolumeSnapshot.StorageClass != "" {
+		pvc.Spec.StorageClassName = &metadata.VolumeSnapshot.StorageClass
+	}
+
+	pvcs := k8sClient.CoreV1().PersistentVolumeClaims(metadata.Namespace)
+	if _, err := pvcs.Create(ctx, pvc, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", nil, fmt.Errorf("failed to restore VolumeSnapshot %s into PVC %s: %w", metadata.VolumeSnapshot.Name, name, err)
+	}
+
+	release := func() {
+		// The PVC is removed even when the caller's context is done
+		_ = pvcs.Delete(context.Background(), name, metav1.DeleteOptions{})
+	}
+	return name, release, nil
+}
@@ -0,0 +1,142 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseStorageMode(t *testing.T) {
+	for value, want := range map[string]StorageMode{
+		"":                StorageSharedPVC,
+		"shared-pvc":      StorageSharedPVC,
+		"volume-snapshot": StorageVolumeSnapshot,
+	} {
+		mode, err := ParseStorageMode(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, mode)
+	}
+
+	_, err := ParseStorageMode("per-snapshot")
+	assert.Error(t, err)
+}
+
+// newFakeSnapshotter returns a VolumeSnapshotter whose VolumeSnapshots get status when created
+func newFakeSnapshotter(status map[string]interface{}) (*VolumeSnapshotter, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{VolumeSnapshotResource: "VolumeSnapshotList"})
+	client.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		obj.Object["status"] = status
+		return false, nil, nil
+	})
+
+	snapshotter := NewVolumeSnapshotter(client, zap.NewNop().Sugar())
+	snapshotter.pollInterval = time.Millisecond
+	return snapshotter, client
+}
+
+func TestVolumeSnapshotterSnapshot(t *testing.T) {
+	ctx := context.Background()
+	snapshotter, client := newFakeSnapshotter(map[string]interface{}{
+		"readyToUse":  true,
+		"restoreSize": "1Gi",
+	})
+
+	info, err := snapshotter.Snapshot(ctx, "hcp", "snap-1", "pvc-1", "csi-snapclass", map[string]string{"app": "test"}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, &VolumeSnapshotInfo{Name: "snap-1", ClassName: "csi-snapclass", RestoreSize: "1Gi"}, info)
+
+	created, err := client.Resource(VolumeSnapshotResource).Namespace("hcp").Get(ctx, "snap-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	source, _, _ := unstructured.NestedString(created.Object, "spec", "source", "persistentVolumeClaimName")
+	assert.Equal(t, "pvc-1", source)
+	assert.Equal(t, map[string]string{"app": "test"}, created.GetLabels())
+
+	require.NoError(t, snapshotter.Delete(ctx, "hcp", "snap-1"))
+	_, err = client.Resource(VolumeSnapshotResource).Namespace("hcp").Get(ctx, "snap-1", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	// Deleting a VolumeSnapshot that is gone is not an error
+	assert.NoError(t, snapshotter.Delete(ctx, "hcp", "snap-1"))
+}
+
+func TestVolumeSnapshotterSnapshotFails(t *testing.T) {
+	ctx := context.Background()
+
+	failing, _ := newFakeSnapshotter(map[string]interface{}{
+		"readyToUse": false,
+		"error":      map[string]interface{}{"message": "snapshot class not found"},
+	})
+	_, err := failing.Snapshot(ctx, "hcp", "snap-1", "pvc-1", "", nil, time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "snapshot class not found")
+
+	pending, _ := newFakeSnapshotter(map[string]interface{}{"readyToUse": false})
+	_, err = pending.Snapshot(ctx, "hcp", "snap-1", "pvc-1", "", nil, 20*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestReadablePVC(t *testing.T) {
+	ctx := context.Background()
+	k8sClient := fake.NewSimpleClientset()
+
+	// Snapshots on a snapshot PVC are read from it
+	onPVC := &SnapshotMetadata{SnapshotID: "a", Namespace: "hcp", PVCName: "snapshots"}
+	name, release, err := ReadablePVC(ctx, k8sClient, onPVC, "restore-a", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots", name)
+	release()
+
+	// Snapshots in the object store are fetched by the jobs reading them and need no PVC,
+	// even while their copy on the snapshot PVC is still to be removed
+	inObjectStore := &SnapshotMetadata{SnapshotID: "o", Namespace: "hcp", PVCName: "snapshots",
+		ObjectStore: &ObjectLocation{Bucket: "snapshots", Key: "hcp/o.db"}}
+	name, release, err = ReadablePVC(ctx, k8sClient, inObjectStore, "restore-o", nil)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+	release()
+
+	// Snapshots kept in a VolumeSnapshot are restored into a new PVC
+	inVolumeSnapshot := &SnapshotMetadata{
+		SnapshotID: "b",
+		Namespace:  "hcp",
+		VolumeSnapshot: &VolumeSnapshotInfo{
+			Name:         "snapshots-b",
+			StorageClass: "fast",
+			RestoreSize:  "2Gi",
+		},
+	}
+	name, release, err = ReadablePVC(ctx, k8sClient, inVolumeSnapshot, "restore-b", map[string]string{"app": "test"})
+	require.NoError(t, err)
+	assert.Equal(t, "restore-b", name)
+
+	pvc, err := k8sClient.CoreV1().PersistentVolumeClaims("hcp").Get(ctx, "restore-b", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, pvc.Spec.DataSource)
+	assert.Equal(t, "VolumeSnapshot", pvc.Spec.DataSource.Kind)
+	assert.Equal(t, "snapshots-b", pvc.Spec.DataSource.Name)
+	assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+	assert.True(t, resource.MustParse("2Gi").Equal(pvc.Spec.Resources.Requests["storage"]))
+
+	release()
+	_, err = k8sClient.CoreV1().PersistentVolumeClaims("hcp").Get(ctx, "restore-b", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	inVolumeSnapshot.VolumeSnapshot.RestoreSize = ""
+	_, _, err = ReadablePVC(ctx, k8sClient, inVolumeSnapshot, "restore-c", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,17 @@
+package util
+
+import "fmt"
+
+// HumanBytes formats a byte count with a binary unit, e.g. 512B or 1.5Gi
+func HumanBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(bytes)/float64(div), "KMGTPE"[exp])
+}